@@ -33,6 +33,7 @@ import (
 	"github.com/pachyderm/pachyderm/src/client/pkg/grpcutil"
 	"github.com/pachyderm/pachyderm/src/client/pps"
 	"github.com/pachyderm/pachyderm/src/client/version/versionpb"
+	"github.com/pachyderm/pachyderm/src/client/webhook"
 )
 
 const (
@@ -75,6 +76,9 @@ type AdminAPIClient admin.APIClient
 // DebugClient is an alias of debug.DebugClient
 type DebugClient debug.DebugClient
 
+// WebhookAPIClient is an alias of webhook.APIClient
+type WebhookAPIClient webhook.APIClient
+
 // An APIClient is a wrapper around pfs, pps and block APIClients.
 type APIClient struct {
 	PfsAPIClient
@@ -85,6 +89,7 @@ type APIClient struct {
 	VersionAPIClient
 	AdminAPIClient
 	DebugClient
+	WebhookAPIClient
 	Enterprise enterprise.APIClient // not embedded--method name conflicts with AuthAPIClient
 
 	// addr is a "host:port" string pointing at a pachd endpoint
@@ -461,6 +466,7 @@ func (c *APIClient) connect(timeout time.Duration) error {
 	c.VersionAPIClient = versionpb.NewAPIClient(clientConn)
 	c.AdminAPIClient = admin.NewAPIClient(clientConn)
 	c.DebugClient = debug.NewDebugClient(clientConn)
+	c.WebhookAPIClient = webhook.NewAPIClient(clientConn)
 	c.clientConn = clientConn
 	c.healthClient = health.NewHealthClient(clientConn)
 	return nil
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/gogo/protobuf/types"
 	"github.com/pachyderm/pachyderm/src/client/pfs"
@@ -34,6 +35,14 @@ func NewCommit(repoName string, commitID string) *pfs.Commit {
 	}
 }
 
+// NewCommitTag creates a pfs.CommitTag.
+func NewCommitTag(repoName string, tagName string) *pfs.CommitTag {
+	return &pfs.CommitTag{
+		Repo: NewRepo(repoName),
+		Name: tagName,
+	}
+}
+
 // NewFile creates a pfs.File.
 func NewFile(repoName string, commitID string, path string) *pfs.File {
 	return &pfs.File{
@@ -269,9 +278,17 @@ func (c APIClient) ListCommit(repoName string, to string, from string, number ui
 // `number` determines how many commits are returned.  If `number` is 0,
 // all commits that match the aforementioned criteria are returned.
 func (c APIClient) ListCommitF(repoName string, to string, from string, number uint64, f func(*pfs.CommitInfo) error) error {
+	return c.ListCommitSelectorF(repoName, to, from, number, "", f)
+}
+
+// ListCommitSelectorF is like ListCommitF, but only returns commits whose
+// labels match `selector`, a Kubernetes-style label selector such as
+// "env=prod,tier in (a,b)". `number` counts only the matching commits.
+func (c APIClient) ListCommitSelectorF(repoName string, to string, from string, number uint64, selector string, f func(*pfs.CommitInfo) error) error {
 	req := &pfs.ListCommitRequest{
-		Repo:   NewRepo(repoName),
-		Number: number,
+		Repo:     NewRepo(repoName),
+		Number:   number,
+		Selector: selector,
 	}
 	if from != "" {
 		req.From = NewCommit(repoName, from)
@@ -305,6 +322,21 @@ func (c APIClient) ListCommitByRepo(repoName string) ([]*pfs.CommitInfo, error)
 	return c.ListCommit(repoName, "", "", 0)
 }
 
+// SetCommitLabels adds 'labels' to the labels of a commit, overwriting any
+// existing labels with the same keys, and removes the labels whose keys are in
+// 'remove'.
+func (c APIClient) SetCommitLabels(repoName string, commitID string, labels map[string]string, remove []string) error {
+	_, err := c.PfsAPIClient.SetCommitLabels(
+		c.Ctx(),
+		&pfs.SetCommitLabelsRequest{
+			Commit: NewCommit(repoName, commitID),
+			Labels: labels,
+			Remove: remove,
+		},
+	)
+	return grpcutil.ScrubGRPC(err)
+}
+
 // CreateBranch creates a new branch
 func (c APIClient) CreateBranch(repoName string, branch string, commit string, provenance []*pfs.Branch) error {
 	var head *pfs.Commit
@@ -367,6 +399,180 @@ func (c APIClient) DeleteBranch(repoName string, branch string, force bool) erro
 	return grpcutil.ScrubGRPC(err)
 }
 
+// ProtectBranch sets the protection rules of a branch, replacing any existing
+// rules. 'allowedPrincipals' are the only users that may advance the
+// branch's head (if empty, any user with WRITER scope on the repo may),
+// 'noDelete' prevents the branch from being deleted and 'mergeOnly' prevents
+// commits from being made on the branch directly, so that it can only be
+// advanced with MergeBranch or CreateBranch.
+func (c APIClient) ProtectBranch(repoName string, branch string, allowedPrincipals []string, noDelete bool, mergeOnly bool) error {
+	_, err := c.PfsAPIClient.ProtectBranch(
+		c.Ctx(),
+		&pfs.ProtectBranchRequest{
+			Branch: NewBranch(repoName, branch),
+			Protection: &pfs.BranchProtection{
+				AllowedPrincipals: allowedPrincipals,
+				NoDelete:          noDelete,
+				MergeOnly:         mergeOnly,
+			},
+		},
+	)
+	return grpcutil.ScrubGRPC(err)
+}
+
+// UnprotectBranch removes the protection rules of a branch.
+func (c APIClient) UnprotectBranch(repoName string, branch string) error {
+	_, err := c.PfsAPIClient.UnprotectBranch(
+		c.Ctx(),
+		&pfs.UnprotectBranchRequest{
+			Branch: NewBranch(repoName, branch),
+		},
+	)
+	return grpcutil.ScrubGRPC(err)
+}
+
+// MergeBranch merges the changes made on branch 'from' into branch 'to',
+// resolving any conflicting changes according to 'strategy'. The response
+// contains the new head of 'to' and any conflicts that were encountered; if
+// 'strategy' is pfs.MergeStrategy_FAIL and there were conflicts, 'to' is left
+// unchanged and the response's Commit is nil.
+func (c APIClient) MergeBranch(repoName string, from string, to string, strategy pfs.MergeStrategy, description string) (*pfs.MergeBranchResponse, error) {
+	resp, err := c.PfsAPIClient.MergeBranch(
+		c.Ctx(),
+		&pfs.MergeBranchRequest{
+			From:        NewBranch(repoName, from),
+			To:          NewBranch(repoName, to),
+			Strategy:    strategy,
+			Description: description,
+		},
+	)
+	if err != nil {
+		return nil, grpcutil.ScrubGRPC(err)
+	}
+	return resp, nil
+}
+
+// CreateCommitTag creates an immutable tag named 'tag' pointing at
+// 'commitID'. The tag can then be used anywhere a commit ID is accepted, and
+// the commit can't be deleted until the tag is deleted.
+func (c APIClient) CreateCommitTag(repoName string, commitID string, tag string) error {
+	_, err := c.PfsAPIClient.CreateCommitTag(
+		c.Ctx(),
+		&pfs.CreateCommitTagRequest{
+			Tag:    NewCommitTag(repoName, tag),
+			Commit: NewCommit(repoName, commitID),
+		},
+	)
+	return grpcutil.ScrubGRPC(err)
+}
+
+// ListCommitTag lists the commit tags in a Repo.
+func (c APIClient) ListCommitTag(repoName string) ([]*pfs.CommitTagInfo, error) {
+	commitTagInfos, err := c.PfsAPIClient.ListCommitTag(
+		c.Ctx(),
+		&pfs.ListCommitTagRequest{
+			Repo: NewRepo(repoName),
+		},
+	)
+	if err != nil {
+		return nil, grpcutil.ScrubGRPC(err)
+	}
+	return commitTagInfos.CommitTagInfo, nil
+}
+
+// DeleteCommitTag deletes a commit tag, but leaves the commit it points at
+// intact.
+func (c APIClient) DeleteCommitTag(repoName string, tag string) error {
+	_, err := c.PfsAPIClient.DeleteCommitTag(
+		c.Ctx(),
+		&pfs.DeleteCommitTagRequest{
+			Tag: NewCommitTag(repoName, tag),
+		},
+	)
+	return grpcutil.ScrubGRPC(err)
+}
+
+// SetRetentionPolicy sets the retention policy of a repo, or of a single
+// branch if branch is non-empty. The last keepLast commits are kept, as are
+// the commits finished within keepWithin of the present; either may be 0 to
+// not keep commits by that criterion, but not both. Older commits are pruned
+// by PFS in the background.
+func (c APIClient) SetRetentionPolicy(repoName string, branch string, keepLast uint64, keepWithin time.Duration) error {
+	policy := &pfs.RetentionPolicy{KeepLast: keepLast}
+	if keepWithin != 0 {
+		policy.KeepWithin = types.DurationProto(keepWithin)
+	}
+	_, err := c.PfsAPIClient.SetRetentionPolicy(
+		c.Ctx(),
+		&pfs.SetRetentionPolicyRequest{
+			Repo:   NewRepo(repoName),
+			Branch: branch,
+			Policy: policy,
+		},
+	)
+	return grpcutil.ScrubGRPC(err)
+}
+
+// DeleteRetentionPolicy removes the retention policy of a repo, or of a
+// single branch if branch is non-empty.
+func (c APIClient) DeleteRetentionPolicy(repoName string, branch string) error {
+	_, err := c.PfsAPIClient.SetRetentionPolicy(
+		c.Ctx(),
+		&pfs.SetRetentionPolicyRequest{
+			Repo:   NewRepo(repoName),
+			Branch: branch,
+		},
+	)
+	return grpcutil.ScrubGRPC(err)
+}
+
+// PruneCommits applies the retention policies of a repo immediately, and
+// returns the commits that were removed.
+func (c APIClient) PruneCommits(repoName string) ([]*pfs.PrunedCommitInfo, error) {
+	prunedCommitInfos, err := c.PfsAPIClient.PruneCommits(
+		c.Ctx(),
+		&pfs.PruneCommitsRequest{
+			Repo: NewRepo(repoName),
+		},
+	)
+	if err != nil {
+		return nil, grpcutil.ScrubGRPC(err)
+	}
+	return prunedCommitInfos.PrunedCommitInfo, nil
+}
+
+// ListPrunedCommits returns the commits in a repo that have been removed by
+// retention policies in the last 30 days.
+func (c APIClient) ListPrunedCommits(repoName string) ([]*pfs.PrunedCommitInfo, error) {
+	prunedCommitInfos, err := c.PfsAPIClient.ListPrunedCommits(
+		c.Ctx(),
+		&pfs.ListPrunedCommitsRequest{
+			Repo: NewRepo(repoName),
+		},
+	)
+	if err != nil {
+		return nil, grpcutil.ScrubGRPC(err)
+	}
+	return prunedCommitInfos.PrunedCommitInfo, nil
+}
+
+// SetRetentionLock sets the retention lock of a repo, which prevents each
+// commit in the repo from being deleted (by anyone) until period after it
+// was started. If branches is empty, every branch in the repo is locked as
+// well; otherwise only the named branches are. An existing lock can be
+// extended, but not shortened or removed.
+func (c APIClient) SetRetentionLock(repoName string, period time.Duration, branches ...string) error {
+	_, err := c.PfsAPIClient.SetRetentionLock(
+		c.Ctx(),
+		&pfs.SetRetentionLockRequest{
+			Repo:     NewRepo(repoName),
+			Period:   types.DurationProto(period),
+			Branches: branches,
+		},
+	)
+	return grpcutil.ScrubGRPC(err)
+}
+
 // DeleteCommit deletes a commit.
 // Note it is currently not implemented.
 func (c APIClient) DeleteCommit(repoName string, commitID string) error {
@@ -379,6 +585,42 @@ func (c APIClient) DeleteCommit(repoName string, commitID string) error {
 	return grpcutil.ScrubGRPC(err)
 }
 
+// SquashCommit squashes the commits from fromCommitID to toCommitID
+// (inclusive) into a single commit, toCommitID, which replaces fromCommitID in
+// its branch's history. If downstream commits were derived from any of the
+// squashed commits, the squash fails unless force is set, in which case those
+// downstream commits are deleted.
+func (c APIClient) SquashCommit(repoName string, fromCommitID string, toCommitID string, force bool) error {
+	_, err := c.PfsAPIClient.SquashCommit(
+		c.Ctx(),
+		&pfs.SquashCommitRequest{
+			Range: &pfs.CommitRange{
+				Lower: NewCommit(repoName, fromCommitID),
+				Upper: NewCommit(repoName, toCommitID),
+			},
+			Force: force,
+		},
+	)
+	return grpcutil.ScrubGRPC(err)
+}
+
+// RevertCommit creates a new commit on 'branch' that undoes the changes made
+// by commitID, and returns it. 'branch' may be "" if commitID is relative to
+// a branch (e.g. "master^"), in which case that branch is used.
+func (c APIClient) RevertCommit(repoName string, commitID string, branch string) (*pfs.Commit, error) {
+	request := &pfs.RevertCommitRequest{
+		Commit: NewCommit(repoName, commitID),
+	}
+	if branch != "" {
+		request.Branch = NewBranch(repoName, branch)
+	}
+	commit, err := c.PfsAPIClient.RevertCommit(c.Ctx(), request)
+	if err != nil {
+		return nil, grpcutil.ScrubGRPC(err)
+	}
+	return commit, nil
+}
+
 // FlushCommit returns an iterator that returns commits that have the
 // specified `commits` as provenance.  Note that the iterator can block if
 // jobs have not successfully completed. This in effect waits for all of the
@@ -472,11 +714,18 @@ func (c *commitInfoIterator) Close() {
 // SubscribeCommit is like ListCommit but it keeps listening for commits as
 // they come in.
 func (c APIClient) SubscribeCommit(repo string, branch string, from string, state pfs.CommitState) (CommitInfoIterator, error) {
+	return c.SubscribeCommitSelector(repo, branch, from, state, "")
+}
+
+// SubscribeCommitSelector is like SubscribeCommit, but only returns commits
+// whose labels match `selector`, a Kubernetes-style label selector.
+func (c APIClient) SubscribeCommitSelector(repo string, branch string, from string, state pfs.CommitState, selector string) (CommitInfoIterator, error) {
 	ctx, cancel := context.WithCancel(c.Ctx())
 	req := &pfs.SubscribeCommitRequest{
-		Repo:   NewRepo(repo),
-		Branch: branch,
-		State:  state,
+		Repo:     NewRepo(repo),
+		Branch:   branch,
+		State:    state,
+		Selector: selector,
 	}
 	if from != "" {
 		req.From = NewCommit(repo, from)
@@ -637,6 +886,27 @@ func (c APIClient) GetObjects(hashes []string, offset uint64, size uint64, total
 	return nil
 }
 
+// GetBlocks gets the content referenced by several block refs out of the
+// object store.
+func (c APIClient) GetBlocks(blockRefs []*pfs.BlockRef, offset uint64, size uint64, totalSize uint64, writer io.Writer) error {
+	getBlocksClient, err := c.ObjectAPIClient.GetBlocks(
+		c.Ctx(),
+		&pfs.GetBlocksRequest{
+			BlockRefs:   blockRefs,
+			OffsetBytes: offset,
+			SizeBytes:   size,
+			TotalSize:   totalSize,
+		},
+	)
+	if err != nil {
+		return grpcutil.ScrubGRPC(err)
+	}
+	if err := grpcutil.WriteFromStreamingBytesClient(getBlocksClient, writer); err != nil {
+		return grpcutil.ScrubGRPC(err)
+	}
+	return nil
+}
+
 // ReadObjects gets  several objects by hash and returns them directly as []byte.
 func (c APIClient) ReadObjects(hashes []string, offset uint64, size uint64) ([]byte, error) {
 	var buffer bytes.Buffer
@@ -795,6 +1065,23 @@ type PutFileClient interface {
 	// recursive allows for recursive scraping of some types URLs. For example on s3:// urls.
 	PutFileURL(repoName string, commitID string, path string, url string, recursive bool, overwrite bool) error
 
+	// PutFileWithMetadata is like PutFile (or PutFileOverwrite with an index
+	// of 0, if overwrite is set), but also sets metadata on the file. See
+	// pfs.PutFileRequest.Metadata.
+	PutFileWithMetadata(repoName string, commitID string, path string, reader io.Reader, overwrite bool, metadata map[string]string) (int, error)
+
+	// PutFileURLWithMetadata is like PutFileURL, but also sets metadata on
+	// the file(s) that are put.
+	PutFileURLWithMetadata(repoName string, commitID string, path string, url string, recursive bool, overwrite bool, metadata map[string]string) error
+
+	// PutFileWithChecksum is like PutFileWithMetadata, but fails unless the
+	// data's SHA-256 checksum is the hex string expectedSha256.
+	PutFileWithChecksum(repoName string, commitID string, path string, reader io.Reader, overwrite bool, metadata map[string]string, expectedSha256 string) (int, error)
+
+	// PutSymlink creates a symlink at path pointing to target, replacing
+	// anything already there.
+	PutSymlink(repoName string, commitID string, path string, target string) error
+
 	// Close must be called after you're done using a PutFileClient.
 	// Further requests will throw errors.
 	Close() error
@@ -867,6 +1154,36 @@ func (c *putFileClient) PutFileOverwrite(repoName string, commitID string, path
 	return int(written), grpcutil.ScrubGRPC(err)
 }
 
+// PutFileWithMetadata is like PutFile (or PutFileOverwrite with an index of
+// 0, if overwrite is set), but also sets metadata on the file.
+func (c *putFileClient) PutFileWithMetadata(repoName string, commitID string, path string, reader io.Reader, overwrite bool, metadata map[string]string) (_ int, retErr error) {
+	return c.PutFileWithChecksum(repoName, commitID, path, reader, overwrite, metadata, "")
+}
+
+// PutFileWithChecksum is like PutFileWithMetadata, but fails unless the
+// data's SHA-256 checksum is the hex string expectedSha256.
+func (c *putFileClient) PutFileWithChecksum(repoName string, commitID string, path string, reader io.Reader, overwrite bool, metadata map[string]string, expectedSha256 string) (_ int, retErr error) {
+	var overwriteIndex *pfs.OverwriteIndex
+	if overwrite {
+		overwriteIndex = &pfs.OverwriteIndex{}
+	}
+	writer, err := c.newPutFileWriteCloser(repoName, commitID, path, pfs.Delimiter_NONE, 0, 0, 0, overwriteIndex)
+	if err != nil {
+		return 0, grpcutil.ScrubGRPC(err)
+	}
+	writer.request.Metadata = metadata
+	writer.request.ExpectedSha256 = expectedSha256
+	defer func() {
+		if err := writer.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	buf := grpcutil.GetBuffer()
+	defer grpcutil.PutBuffer(buf)
+	written, err := io.CopyBuffer(writer, reader, buf)
+	return int(written), grpcutil.ScrubGRPC(err)
+}
+
 //PutFileSplit writes a file to PFS from a reader
 // delimiter is used to tell PFS how to break the input into blocks
 func (c *putFileClient) PutFileSplit(repoName string, commitID string, path string, delimiter pfs.Delimiter, targetFileDatums int64, targetFileBytes int64, headerRecords int64, overwrite bool, reader io.Reader) (_ int, retErr error) {
@@ -889,6 +1206,12 @@ func (c *putFileClient) PutFileSplit(repoName string, commitID string, path stri
 // The URL is sent to the server which performs the request.
 // recursive allow for recursive scraping of some types URLs for example on s3:// urls.
 func (c *putFileClient) PutFileURL(repoName string, commitID string, path string, url string, recursive bool, overwrite bool) (retErr error) {
+	return c.PutFileURLWithMetadata(repoName, commitID, path, url, recursive, overwrite, nil)
+}
+
+// PutFileURLWithMetadata is like PutFileURL, but also sets metadata on the
+// file(s) that are put.
+func (c *putFileClient) PutFileURLWithMetadata(repoName string, commitID string, path string, url string, recursive bool, overwrite bool, metadata map[string]string) (retErr error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	var overwriteIndex *pfs.OverwriteIndex
@@ -907,6 +1230,29 @@ func (c *putFileClient) PutFileURL(repoName string, commitID string, path string
 		Url:            url,
 		Recursive:      recursive,
 		OverwriteIndex: overwriteIndex,
+		Metadata:       metadata,
+	}); err != nil {
+		return grpcutil.ScrubGRPC(err)
+	}
+	return nil
+}
+
+// PutSymlink creates a symlink at path pointing to target. Absolute targets
+// are relative to the root of the commit, and relative targets to the
+// symlink's directory.
+func (c *putFileClient) PutSymlink(repoName string, commitID string, path string, target string) (retErr error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.oneoff {
+		defer func() {
+			if err := grpcutil.ScrubGRPC(c.Close()); err != nil && retErr == nil {
+				retErr = err
+			}
+		}()
+	}
+	if err := c.c.Send(&pfs.PutFileRequest{
+		File:          NewFile(repoName, commitID, path),
+		SymlinkTarget: target,
 	}); err != nil {
 		return grpcutil.ScrubGRPC(err)
 	}
@@ -987,6 +1333,50 @@ func (c APIClient) PutFileURL(repoName string, commitID string, path string, url
 	return pfc.PutFileURL(repoName, commitID, path, url, recursive, overwrite)
 }
 
+// PutFileWithMetadata is like PutFile (or PutFileOverwrite with an index of
+// 0, if overwrite is set), but also sets metadata on the file, such as its
+// content type (see pfs.ContentTypeKey). Metadata is returned in FileInfo and
+// doesn't affect the file's hash.
+func (c APIClient) PutFileWithMetadata(repoName string, commitID string, path string, reader io.Reader, overwrite bool, metadata map[string]string) (_ int, retErr error) {
+	pfc, err := c.newOneoffPutFileClient()
+	if err != nil {
+		return 0, err
+	}
+	return pfc.PutFileWithMetadata(repoName, commitID, path, reader, overwrite, metadata)
+}
+
+// PutFileWithChecksum is like PutFileWithMetadata, but fails with an error
+// satisfying pfsserver.IsChecksumMismatchErr unless the data's SHA-256
+// checksum is the hex string expectedSha256, in which case nothing is written.
+func (c APIClient) PutFileWithChecksum(repoName string, commitID string, path string, reader io.Reader, overwrite bool, metadata map[string]string, expectedSha256 string) (_ int, retErr error) {
+	pfc, err := c.newOneoffPutFileClient()
+	if err != nil {
+		return 0, err
+	}
+	return pfc.PutFileWithChecksum(repoName, commitID, path, reader, overwrite, metadata, expectedSha256)
+}
+
+// PutFileURLWithMetadata is like PutFileURL, but also sets metadata on the
+// file(s) that are put.
+func (c APIClient) PutFileURLWithMetadata(repoName string, commitID string, path string, url string, recursive bool, overwrite bool, metadata map[string]string) (retErr error) {
+	pfc, err := c.newOneoffPutFileClient()
+	if err != nil {
+		return err
+	}
+	return pfc.PutFileURLWithMetadata(repoName, commitID, path, url, recursive, overwrite, metadata)
+}
+
+// PutSymlink creates a symlink at path pointing to target. Absolute targets
+// are relative to the root of the commit, and relative targets to the
+// symlink's directory.
+func (c APIClient) PutSymlink(repoName string, commitID string, path string, target string) error {
+	pfc, err := c.newOneoffPutFileClient()
+	if err != nil {
+		return err
+	}
+	return pfc.PutSymlink(repoName, commitID, path, target)
+}
+
 // CopyFile copys a file from one pfs location to another. It can be used on
 // directories or regular files.
 func (c APIClient) CopyFile(srcRepo, srcCommit, srcPath, dstRepo, dstCommit, dstPath string, overwrite bool) error {
@@ -1136,6 +1526,17 @@ func (c APIClient) ListFileF(repoName string, commitID string, path string, hist
 	}
 }
 
+// ListFilePage returns one page of the files described by 'request', which
+// sets the page size, sort order and filters (see pfs.ListFileRequest), along
+// with the token for the next page ("" if this is the last page).
+func (c APIClient) ListFilePage(request *pfs.ListFileRequest) ([]*pfs.FileInfo, string, error) {
+	resp, err := c.PfsAPIClient.ListFile(c.Ctx(), request)
+	if err != nil {
+		return nil, "", grpcutil.ScrubGRPC(err)
+	}
+	return resp.FileInfo, resp.NextPageToken, nil
+}
+
 // GlobFile returns files that match a given glob pattern in a given commit.
 // The pattern is documented here:
 // https://golang.org/pkg/path/filepath/#Match
@@ -1163,6 +1564,59 @@ func (c APIClient) GlobFile(repoName string, commitID string, pattern string) ([
 	return result, nil
 }
 
+// GrepFile searches the files in a commit that match the glob 'pattern' for
+// lines that match 'regex', returning the matching lines. maxBytesScanned and
+// maxResults limit the search if they're nonzero; 'truncated' is true if the
+// search was stopped early by one of them.
+func (c APIClient) GrepFile(repoName string, commitID string, pattern string, regex string, maxBytesScanned int64, maxResults int64) (_ []*pfs.GrepFileResponse, truncated bool, _ error) {
+	var result []*pfs.GrepFileResponse
+	if err := c.GrepFileF(repoName, commitID, pattern, regex, maxBytesScanned, maxResults, func(resp *pfs.GrepFileResponse) error {
+		if resp.Truncated {
+			truncated = true
+			return nil
+		}
+		result = append(result, resp)
+		return nil
+	}); err != nil {
+		return nil, false, err
+	}
+	return result, truncated, nil
+}
+
+// GrepFileF is like GrepFile, but calls 'f' with each matching line as it's
+// found, and with a final response that only sets Truncated if the search
+// was stopped early.
+func (c APIClient) GrepFileF(repoName string, commitID string, pattern string, regex string, maxBytesScanned int64, maxResults int64, f func(*pfs.GrepFileResponse) error) error {
+	stream, err := c.PfsAPIClient.GrepFile(
+		c.Ctx(),
+		&pfs.GrepFileRequest{
+			Commit:          NewCommit(repoName, commitID),
+			Pattern:         pattern,
+			Regex:           regex,
+			MaxBytesScanned: maxBytesScanned,
+			MaxResults:      maxResults,
+		},
+	)
+	if err != nil {
+		return grpcutil.ScrubGRPC(err)
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return grpcutil.ScrubGRPC(err)
+		}
+		if err := f(resp); err != nil {
+			if err == errutil.ErrBreak {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 // DiffFile returns the difference between 2 paths, old path may be omitted in
 // which case the parent of the new path will be used. DiffFile return 2 values
 // (unless it returns an error) the first value is files present under new
@@ -1188,6 +1642,81 @@ func (c APIClient) DiffFile(newRepoName, newCommitID, newPath, oldRepoName,
 	return resp.NewFiles, resp.OldFiles, nil
 }
 
+// DiffFileContent is like DiffFile, but calls 'f' with a line-level unified
+// diff of each file that differs between the two paths (see pfs.FileDiff).
+// contextLines is the number of unchanged lines shown around each change, and
+// files larger than maxContentBytes (10MB if it's 0) aren't diffed.
+func (c APIClient) DiffFileContent(newRepoName, newCommitID, newPath, oldRepoName,
+	oldCommitID, oldPath string, contextLines int64, maxContentBytes int64, f func(*pfs.FileDiff) error) error {
+	var oldFile *pfs.File
+	if oldRepoName != "" {
+		oldFile = NewFile(oldRepoName, oldCommitID, oldPath)
+	}
+	stream, err := c.PfsAPIClient.DiffFileStream(
+		c.Ctx(),
+		&pfs.DiffFileRequest{
+			NewFile:         NewFile(newRepoName, newCommitID, newPath),
+			OldFile:         oldFile,
+			Content:         true,
+			ContextLines:    contextLines,
+			MaxContentBytes: maxContentBytes,
+		},
+	)
+	if err != nil {
+		return grpcutil.ScrubGRPC(err)
+	}
+	for {
+		diff, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return grpcutil.ScrubGRPC(err)
+		}
+		if err := f(diff); err != nil {
+			if err == errutil.ErrBreak {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// SubscribeFile calls 'f' with each change to the files that match 'pattern'
+// (or that are under a directory that does) in each new commit on 'branch',
+// once it's finished. If 'from' is set, only changes made by commits created
+// since then are returned, otherwise changes are returned from the first
+// commit on the branch. It keeps listening for new commits until 'f' returns
+// an error (errutil.ErrBreak stops it without an error).
+func (c APIClient) SubscribeFile(repo, branch, pattern, from string, f func(*pfs.FileEvent) error) error {
+	req := &pfs.SubscribeFileRequest{
+		Repo:    NewRepo(repo),
+		Branch:  branch,
+		Pattern: pattern,
+	}
+	if from != "" {
+		req.From = NewCommit(repo, from)
+	}
+	ctx, cancel := context.WithCancel(c.Ctx())
+	defer cancel()
+	stream, err := c.PfsAPIClient.SubscribeFile(ctx, req)
+	if err != nil {
+		return grpcutil.ScrubGRPC(err)
+	}
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return grpcutil.ScrubGRPC(err)
+		}
+		if err := f(event); err != nil {
+			if err == errutil.ErrBreak {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
 // WalkFn is the type of the function called for each file in Walk.
 // Returning a non-nil error from WalkFn will result in Walk aborting and
 // returning said error.
@@ -1233,6 +1762,38 @@ func (c APIClient) DeleteFile(repoName string, commitID string, path string) err
 	return err
 }
 
+// PurgeFile removes a file (or directory) from every commit in a repo, and
+// deletes the downstream commits derived from those commits so that they're
+// reprocessed. The purged data is removed from object storage by the next
+// GarbageCollect. Only cluster admins may purge files. Output repos can't be
+// purged; purge the pipeline's input repos instead.
+func (c APIClient) PurgeFile(repoName string, path string) (*pfs.PurgeRecord, error) {
+	purgeRecord, err := c.PfsAPIClient.PurgeFile(
+		c.Ctx(),
+		&pfs.PurgeFileRequest{
+			Repo: NewRepo(repoName),
+			Path: path,
+		},
+	)
+	if err != nil {
+		return nil, grpcutil.ScrubGRPC(err)
+	}
+	return purgeRecord, nil
+}
+
+// ListPurgeRecords returns the records of every PurgeFile operation, in
+// order.
+func (c APIClient) ListPurgeRecords() ([]*pfs.PurgeRecord, error) {
+	purgeRecords, err := c.PfsAPIClient.ListPurgeRecords(
+		c.Ctx(),
+		&pfs.ListPurgeRecordsRequest{},
+	)
+	if err != nil {
+		return nil, grpcutil.ScrubGRPC(err)
+	}
+	return purgeRecords.PurgeRecord, nil
+}
+
 type putFileWriteCloser struct {
 	request *pfs.PutFileRequest
 	sent    bool
@@ -1278,6 +1839,8 @@ func (w *putFileWriteCloser) Write(p []byte) (int, error) {
 		// that path
 		// TODO(msteffen): can other fields be zeroed as well?
 		w.request.File = nil
+		w.request.Metadata = nil
+		w.request.ExpectedSha256 = ""
 		bytesWritten += len(actualP)
 	}
 	return bytesWritten, nil
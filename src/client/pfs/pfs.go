@@ -13,6 +13,10 @@ var (
 	ChunkSize = int64(512 * 1024 * 1024) // 512 MB
 )
 
+// ContentTypeKey is the well-known file metadata key that holds a file's MIME
+// type (see PutFileRequest.Metadata)
+const ContentTypeKey = "content-type"
+
 // FullID prints repoName/CommitID
 func (c *Commit) FullID() string {
 	return fmt.Sprintf("%s/%s", c.Repo.Name, c.ID)
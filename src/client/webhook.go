@@ -0,0 +1,79 @@
+package client
+
+import (
+	"github.com/pachyderm/pachyderm/src/client/pkg/grpcutil"
+	"github.com/pachyderm/pachyderm/src/client/webhook"
+)
+
+// NewWebhook creates a webhook.Webhook.
+func NewWebhook(name string) *webhook.Webhook {
+	return &webhook.Webhook{Name: name}
+}
+
+// CreateWebhook registers 'url' to be notified about the lifecycle events
+// that match 'filter' (which may be nil, to match every event). Deliveries
+// are signed with 'secret'. If 'update' is set, an existing webhook called
+// 'name' is replaced.
+func (c APIClient) CreateWebhook(name string, url string, secret string, filter *webhook.Filter, update bool) error {
+	_, err := c.WebhookAPIClient.CreateWebhook(
+		c.Ctx(),
+		&webhook.CreateWebhookRequest{
+			Webhook: NewWebhook(name),
+			URL:     url,
+			Secret:  secret,
+			Filter:  filter,
+			Update:  update,
+		},
+	)
+	return grpcutil.ScrubGRPC(err)
+}
+
+// InspectWebhook returns info about a specific webhook.
+func (c APIClient) InspectWebhook(name string) (*webhook.WebhookInfo, error) {
+	webhookInfo, err := c.WebhookAPIClient.InspectWebhook(
+		c.Ctx(),
+		&webhook.InspectWebhookRequest{
+			Webhook: NewWebhook(name),
+		},
+	)
+	return webhookInfo, grpcutil.ScrubGRPC(err)
+}
+
+// ListWebhook returns info about all webhooks.
+func (c APIClient) ListWebhook() ([]*webhook.WebhookInfo, error) {
+	webhookInfos, err := c.WebhookAPIClient.ListWebhook(
+		c.Ctx(),
+		&webhook.ListWebhookRequest{},
+	)
+	if err != nil {
+		return nil, grpcutil.ScrubGRPC(err)
+	}
+	return webhookInfos.WebhookInfo, nil
+}
+
+// DeleteWebhook deletes a webhook, along with the record of its deliveries.
+func (c APIClient) DeleteWebhook(name string) error {
+	_, err := c.WebhookAPIClient.DeleteWebhook(
+		c.Ctx(),
+		&webhook.DeleteWebhookRequest{
+			Webhook: NewWebhook(name),
+		},
+	)
+	return grpcutil.ScrubGRPC(err)
+}
+
+// ListDelivery returns the most recent deliveries of a webhook (at most
+// 'limit' of them, or all of them if 'limit' is 0), newest first.
+func (c APIClient) ListDelivery(name string, limit int64) ([]*webhook.Delivery, error) {
+	deliveries, err := c.WebhookAPIClient.ListDelivery(
+		c.Ctx(),
+		&webhook.ListDeliveryRequest{
+			Webhook: NewWebhook(name),
+			Limit:   limit,
+		},
+	)
+	if err != nil {
+		return nil, grpcutil.ScrubGRPC(err)
+	}
+	return deliveries.Delivery, nil
+}
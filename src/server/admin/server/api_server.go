@@ -109,6 +109,7 @@ func (a *apiServer) Extract(request *admin.ExtractRequest, extractServer admin.A
 				Repo: &pfs.CreateRepoRequest{
 					Repo:        ri.Repo,
 					Description: ri.Description,
+					QuotaBytes:  ri.QuotaBytes,
 				}},
 			}); err != nil {
 				return err
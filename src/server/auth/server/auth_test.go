@@ -18,6 +18,7 @@ import (
 	"github.com/pachyderm/pachyderm/src/client/pfs"
 	"github.com/pachyderm/pachyderm/src/client/pkg/require"
 	"github.com/pachyderm/pachyderm/src/client/pps"
+	pfsserver "github.com/pachyderm/pachyderm/src/server/pfs"
 	"github.com/pachyderm/pachyderm/src/server/pkg/backoff"
 	tu "github.com/pachyderm/pachyderm/src/server/pkg/testutil"
 )
@@ -1447,6 +1448,165 @@ func TestUnprivilegedUserCannotMakeSelfOwner(t *testing.T) {
 	require.ElementsEqual(t, entries(alice, "owner"), getACL(t, aliceClient, repo))
 }
 
+// TestProtectedBranch tests that only the principals allowed by a branch's
+// protection rules can advance it, even if other users are WRITERs of the repo
+func TestProtectedBranch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+	deleteAll(t)
+	alice, bob := tu.UniqueString("alice"), tu.UniqueString("bob")
+	aliceClient, bobClient := getPachClient(t, alice), getPachClient(t, bob)
+
+	// alice creates a repo and makes bob a writer
+	repo := tu.UniqueString("TestProtectedBranch")
+	require.NoError(t, aliceClient.CreateRepo(repo))
+	_, err := aliceClient.SetScope(aliceClient.Ctx(), &auth.SetScopeRequest{
+		Repo:     repo,
+		Scope:    auth.Scope_WRITER,
+		Username: bob,
+	})
+	require.NoError(t, err)
+	_, err = bobClient.PutFile(repo, "master", "/file", strings.NewReader("1"))
+	require.NoError(t, err)
+
+	// bob can't protect the branch, as he isn't an owner
+	require.YesError(t, bobClient.ProtectBranch(repo, "master", []string{bob}, false, false))
+
+	// once alice protects master, bob can no longer write to it or move it...
+	require.NoError(t, aliceClient.ProtectBranch(repo, "master", []string{alice}, false, false))
+	_, err = bobClient.PutFile(repo, "master", "/file", strings.NewReader("2"))
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsBranchProtectedErr(err))
+	_, err = bobClient.StartCommit(repo, "master")
+	require.YesError(t, err)
+	require.YesError(t, bobClient.CreateBranch(repo, "master", "master^", nil))
+	// ...but he can still write to other branches
+	_, err = bobClient.PutFile(repo, "feature", "/file", strings.NewReader("3"))
+	require.NoError(t, err)
+	_, err = bobClient.MergeBranch(repo, "feature", "master", pfs.MergeStrategy_THEIRS, "")
+	require.YesError(t, err)
+	// ...or rewrite its history, even through another branch
+	err = bobClient.DeleteCommit(repo, "master")
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsBranchProtectedErr(err))
+	require.NoError(t, aliceClient.CreateBranch(repo, "shared", "master", nil))
+	_, err = bobClient.PutFile(repo, "shared", "/file", strings.NewReader("3"))
+	require.NoError(t, err)
+	err = bobClient.SquashCommit(repo, "master", "shared", false)
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsBranchProtectedErr(err))
+	// ...but he can still rewrite the commits that are only on other branches
+	require.NoError(t, bobClient.DeleteCommit(repo, "shared"))
+
+	// alice can still write to it
+	_, err = aliceClient.PutFile(repo, "master", "/file", strings.NewReader("4"))
+	require.NoError(t, err)
+
+	// and after it's unprotected, so can bob
+	require.NoError(t, aliceClient.UnprotectBranch(repo, "master"))
+	_, err = bobClient.PutFile(repo, "master", "/file", strings.NewReader("5"))
+	require.NoError(t, err)
+}
+
+// TestPutSymlinkRequiresWriter tests that a user who can't write to a repo
+// can't create symlinks in it either
+func TestPutSymlinkRequiresWriter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+	deleteAll(t)
+	alice, bob := tu.UniqueString("alice"), tu.UniqueString("bob")
+	aliceClient, bobClient := getPachClient(t, alice), getPachClient(t, bob)
+
+	// alice creates a repo and puts a file in it
+	repo := tu.UniqueString("TestPutSymlinkRequiresWriter")
+	require.NoError(t, aliceClient.CreateRepo(repo))
+	commit, err := aliceClient.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = aliceClient.PutFile(repo, commit.ID, "/file", strings.NewReader("1"))
+	require.NoError(t, err)
+
+	// bob can't add a symlink to alice's open commit, or to her branch
+	err = bobClient.PutSymlink(repo, commit.ID, "/link", "/file")
+	require.YesError(t, err)
+	require.True(t, auth.IsErrNotAuthorized(err), err.Error())
+	require.NoError(t, aliceClient.FinishCommit(repo, commit.ID))
+	err = bobClient.PutSymlink(repo, "master", "/link", "/file")
+	require.YesError(t, err)
+	require.True(t, auth.IsErrNotAuthorized(err), err.Error())
+	_, err = aliceClient.InspectFile(repo, "master", "/link")
+	require.YesError(t, err)
+
+	// once bob is a reader he still can't, but as a writer he can
+	_, err = aliceClient.SetScope(aliceClient.Ctx(), &auth.SetScopeRequest{
+		Repo:     repo,
+		Scope:    auth.Scope_READER,
+		Username: bob,
+	})
+	require.NoError(t, err)
+	require.YesError(t, bobClient.PutSymlink(repo, "master", "/link", "/file"))
+	_, err = aliceClient.SetScope(aliceClient.Ctx(), &auth.SetScopeRequest{
+		Repo:     repo,
+		Scope:    auth.Scope_WRITER,
+		Username: bob,
+	})
+	require.NoError(t, err)
+	require.NoError(t, bobClient.PutSymlink(repo, "master", "/link", "/file"))
+}
+
+func TestRepoQuotaRequiresOwner(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+	deleteAll(t)
+	alice, bob := tu.UniqueString("alice"), tu.UniqueString("bob")
+	aliceClient, bobClient := getPachClient(t, alice), getPachClient(t, bob)
+
+	// alice creates a repo with a quota and makes bob a writer
+	repo := tu.UniqueString("TestRepoQuotaRequiresOwner")
+	_, err := aliceClient.PfsAPIClient.CreateRepo(aliceClient.Ctx(), &pfs.CreateRepoRequest{
+		Repo:       client.NewRepo(repo),
+		QuotaBytes: 10,
+	})
+	require.NoError(t, err)
+	_, err = aliceClient.SetScope(aliceClient.Ctx(), &auth.SetScopeRequest{
+		Repo:     repo,
+		Scope:    auth.Scope_WRITER,
+		Username: bob,
+	})
+	require.NoError(t, err)
+
+	// bob can update the repo's description, but not its quota
+	_, err = bobClient.PfsAPIClient.CreateRepo(bobClient.Ctx(), &pfs.CreateRepoRequest{
+		Repo:        client.NewRepo(repo),
+		Description: "bob's repo",
+		Update:      true,
+	})
+	require.NoError(t, err)
+	_, err = bobClient.PfsAPIClient.CreateRepo(bobClient.Ctx(), &pfs.CreateRepoRequest{
+		Repo:     client.NewRepo(repo),
+		SetQuota: true,
+		Update:   true,
+	})
+	require.YesError(t, err)
+	require.True(t, auth.IsErrNotAuthorized(err), err.Error())
+	repoInfo, err := aliceClient.InspectRepo(repo)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), repoInfo.QuotaBytes)
+
+	// alice, as an owner, can
+	_, err = aliceClient.PfsAPIClient.CreateRepo(aliceClient.Ctx(), &pfs.CreateRepoRequest{
+		Repo:     client.NewRepo(repo),
+		SetQuota: true,
+		Update:   true,
+	})
+	require.NoError(t, err)
+	repoInfo, err = aliceClient.InspectRepo(repo)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), repoInfo.QuotaBytes)
+}
+
 func TestGetScopeRequiresReader(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping integration tests in short mode")
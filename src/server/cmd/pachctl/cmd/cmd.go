@@ -32,6 +32,7 @@ import (
 	deploycmds "github.com/pachyderm/pachyderm/src/server/pkg/deploy/cmds"
 	"github.com/pachyderm/pachyderm/src/server/pkg/metrics"
 	ppscmds "github.com/pachyderm/pachyderm/src/server/pps/cmds"
+	webhookcmds "github.com/pachyderm/pachyderm/src/server/webhook/cmds"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -217,6 +218,10 @@ Environment variables:
 	for _, cmd := range debugCmds {
 		rootCmd.AddCommand(cmd)
 	}
+	webhookCmds := webhookcmds.Cmds(&noMetrics)
+	for _, cmd := range webhookCmds {
+		rootCmd.AddCommand(cmd)
+	}
 
 	var clientOnly bool
 	var timeoutFlag string
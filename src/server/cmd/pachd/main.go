@@ -27,6 +27,7 @@ import (
 	ppsclient "github.com/pachyderm/pachyderm/src/client/pps"
 	"github.com/pachyderm/pachyderm/src/client/version"
 	"github.com/pachyderm/pachyderm/src/client/version/versionpb"
+	webhookclient "github.com/pachyderm/pachyderm/src/client/webhook"
 	adminserver "github.com/pachyderm/pachyderm/src/server/admin/server"
 	authserver "github.com/pachyderm/pachyderm/src/server/auth/server"
 	debugserver "github.com/pachyderm/pachyderm/src/server/debug/server"
@@ -46,6 +47,8 @@ import (
 	"github.com/pachyderm/pachyderm/src/server/pkg/uuid"
 	pps_server "github.com/pachyderm/pachyderm/src/server/pps/server"
 	"github.com/pachyderm/pachyderm/src/server/pps/server/githook"
+	"github.com/pachyderm/pachyderm/src/server/s3"
+	webhookserver "github.com/pachyderm/pachyderm/src/server/webhook/server"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
@@ -78,6 +81,8 @@ type appEnv struct {
 	PProfPort uint16 `env:"PPROF_PORT,default=651"`
 	HTTPPort  uint16 `env:"HTTP_PORT,default=652"`
 	PeerPort  uint16 `env:"PEER_PORT,default=653"`
+	// S3GatewayPort is the port on which pachd serves the S3-compatible API
+	S3GatewayPort uint16 `env:"S3GATEWAY_PORT,default=600"`
 
 	NumShards             uint64 `env:"NUM_SHARDS,default=32"`
 	StorageRoot           string `env:"PACH_ROOT,default=/pach"`
@@ -88,6 +93,7 @@ type appEnv struct {
 	PFSEtcdPrefix         string `env:"PFS_ETCD_PREFIX,default=pachyderm_pfs"`
 	AuthEtcdPrefix        string `env:"PACHYDERM_AUTH_ETCD_PREFIX,default=pachyderm_auth"`
 	EnterpriseEtcdPrefix  string `env:"PACHYDERM_ENTERPRISE_ETCD_PREFIX,default=pachyderm_enterprise"`
+	WebhookEtcdPrefix     string `env:"PACHYDERM_WEBHOOK_ETCD_PREFIX,default=pachyderm_webhook"`
 	KubeAddress           string `env:"KUBERNETES_PORT_443_TCP_ADDR,required"`
 	EtcdAddress           string `env:"ETCD_PORT_2379_TCP_ADDR,required"`
 	Namespace             string `env:"NAMESPACE,default=default"`
@@ -218,9 +224,9 @@ func doSidecarMode(appEnvObj interface{}) (retErr error) {
 				if err != nil {
 					return err
 				}
-				pfsAPIServer, err := pfs_server.NewAPIServer(address, []string{etcdAddress}, path.Join(appEnv.EtcdPrefix, appEnv.PFSEtcdPrefix), treeCache, appEnv.StorageRoot, memoryRequestBytes)
+				pfsAPIServer, err := pfs_server.NewSidecarAPIServer(address, []string{etcdAddress}, path.Join(appEnv.EtcdPrefix, appEnv.PFSEtcdPrefix), treeCache, appEnv.StorageRoot, memoryRequestBytes)
 				if err != nil {
-					return fmt.Errorf("pfs.NewAPIServer: %v", err)
+					return fmt.Errorf("pfs.NewSidecarAPIServer: %v", err)
 				}
 				pfsclient.RegisterAPIServer(s, pfsAPIServer)
 
@@ -361,6 +367,17 @@ func doFullMode(appEnvObj interface{}) (retErr error) {
 		}
 		return fmt.Errorf("ListenAndServe: %v", err)
 	})
+	eg.Go(func() error {
+		s3Server, err := s3.NewS3Server(address)
+		if err != nil {
+			return err
+		}
+		err = http.ListenAndServe(fmt.Sprintf(":%v", appEnv.S3GatewayPort), s3Server)
+		if err != nil {
+			log.Printf("error starting s3 gateway %v\n", err)
+		}
+		return fmt.Errorf("ListenAndServe: %v", err)
+	})
 	eg.Go(func() error {
 		err := githook.RunGitHookServer(address, etcdAddress, path.Join(appEnv.EtcdPrefix, appEnv.PPSEtcdPrefix))
 		if err != nil {
@@ -452,6 +469,17 @@ func doFullMode(appEnvObj interface{}) (retErr error) {
 						etcdClientV3,
 						path.Join(appEnv.EtcdPrefix, appEnv.PPSEtcdPrefix),
 					))
+					// The webhook server watches PFS and PPS for events, so it's only
+					// served on the public port, to avoid running two sets of watches
+					webhookAPIServer, err := webhookserver.NewAPIServer(
+						address, etcdAddress,
+						path.Join(appEnv.EtcdPrefix, appEnv.WebhookEtcdPrefix),
+						path.Join(appEnv.EtcdPrefix, appEnv.PFSEtcdPrefix),
+						path.Join(appEnv.EtcdPrefix, appEnv.PPSEtcdPrefix))
+					if err != nil {
+						return fmt.Errorf("webhook.NewAPIServer: %v", err)
+					}
+					webhookclient.RegisterAPIServer(s, webhookAPIServer)
 					return nil
 				},
 			},
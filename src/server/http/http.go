@@ -11,6 +11,7 @@ import (
 
 	"github.com/pachyderm/pachyderm/src/client"
 	"github.com/pachyderm/pachyderm/src/client/auth"
+	"github.com/pachyderm/pachyderm/src/client/pfs"
 	"github.com/pachyderm/pachyderm/src/server/pkg/errutil"
 
 	"github.com/gogo/protobuf/types"
@@ -85,6 +86,16 @@ func (s *server) getFileHandler(w http.ResponseWriter, r *http.Request, ps httpr
 		httpError(w, err)
 		return
 	}
+	fileInfo, err := c.InspectFile(ps.ByName("repoName"), ps.ByName("commitID"), ps.ByName("filePath"))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	// If the file has no content type, ServeContent infers one from its name
+	// and contents
+	if contentType := fileInfo.Metadata[pfs.ContentTypeKey]; contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
 	content, err := c.GetFileReadSeeker(ps.ByName("repoName"), ps.ByName("commitID"), ps.ByName("filePath"))
 	if err != nil {
 		httpError(w, err)
@@ -4369,6 +4369,123 @@ func TestGarbageCollection(t *testing.T) {
 	require.Equal(t, "barbar\n", buf.String())
 }
 
+func TestPurgeFileGarbageCollection(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+
+	c := getPachClient(t)
+	require.NoError(t, c.DeleteAll())
+	require.NoError(t, c.GarbageCollect(0))
+
+	dataRepo := tu.UniqueString("TestPurgeFileGarbageCollection_data")
+	require.NoError(t, c.CreateRepo(dataRepo))
+	commit, err := c.StartCommit(dataRepo, "master")
+	require.NoError(t, err)
+	_, err = c.PutFile(dataRepo, commit.ID, "public", strings.NewReader("public\n"))
+	require.NoError(t, err)
+	_, err = c.PutFile(dataRepo, commit.ID, "secret", strings.NewReader("secret\n"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit(dataRepo, commit.ID))
+	secretInfo, err := c.InspectFile(dataRepo, commit.ID, "secret")
+	require.NoError(t, err)
+
+	// Each file is its own datum, so each one's output has its own tag
+	pipeline := tu.UniqueString("TestPurgeFileGarbageCollection")
+	require.NoError(t, c.CreatePipeline(
+		pipeline,
+		"",
+		[]string{"bash"},
+		[]string{fmt.Sprintf("cp /pfs/%s/* /pfs/out/", dataRepo)},
+		nil,
+		client.NewPFSInput(dataRepo, "/*"),
+		"",
+		false,
+	))
+	jobInfos, err := c.FlushJobAll([]*pfs.Commit{commit}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(jobInfos))
+	require.Equal(t, pps.JobState_JOB_SUCCESS, jobInfos[0].State)
+	tagObjects := func() map[string]string {
+		tagsClient, err := c.ListTags(context.Background(), &pfs.ListTagsRequest{IncludeObject: true})
+		require.NoError(t, err)
+		result := make(map[string]string)
+		for resp, err := tagsClient.Recv(); err != io.EOF; resp, err = tagsClient.Recv() {
+			require.NoError(t, err)
+			result[resp.Tag.Name] = resp.Object.Hash
+		}
+		return result
+	}
+	requireNoObjects := func(hashes ...string) {
+		for _, object := range getAllObjects(t, c) {
+			for _, hash := range hashes {
+				require.NotEqual(t, hash, object.Hash)
+			}
+		}
+	}
+	tagsBefore := tagObjects()
+	require.Equal(t, 2, len(tagsBefore))
+
+	// Purging the input file deletes the output commit derived from it, and
+	// the pipeline reprocesses the purged input
+	_, err = c.PurgeFile(dataRepo, "secret")
+	require.NoError(t, err)
+	_, err = c.FlushJobAll([]*pfs.Commit{client.NewCommit(dataRepo, "master")}, nil)
+	require.NoError(t, err)
+	_, err = c.InspectFile(pipeline, "master", "secret")
+	require.YesError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, c.GetFile(pipeline, "master", "public", 0, 0, &buf))
+	require.Equal(t, "public\n", buf.String())
+
+	// GC deletes the purged file, and the tag and output of the datum that
+	// processed it
+	require.NoError(t, c.StopPipeline(pipeline))
+	require.NoError(t, backoff.Retry(func() error { return c.GarbageCollect(0) }, backoff.NewTestingBackOff()))
+	tagsAfter := tagObjects()
+	require.Equal(t, 1, len(tagsAfter))
+	var secretTagObject string
+	for tag, object := range tagsBefore {
+		if _, ok := tagsAfter[tag]; !ok {
+			secretTagObject = object
+		}
+	}
+	require.NotEqual(t, "", secretTagObject)
+	var secretObjects []string
+	for _, object := range secretInfo.Objects {
+		secretObjects = append(secretObjects, object.Hash)
+	}
+	requireNoObjects(append(secretObjects, secretTagObject)...)
+
+	// Purging a file from the output repo drops the tag of the datum that
+	// produced it, and GC then deletes the datum's output
+	_, err = c.PurgeFile(pipeline, "public")
+	require.NoError(t, err)
+	_, err = c.InspectFile(pipeline, "master", "public")
+	require.YesError(t, err)
+	require.NoError(t, c.GarbageCollect(0))
+	require.Equal(t, 0, len(tagObjects()))
+	for _, object := range tagsAfter {
+		requireNoObjects(object)
+	}
+
+	// The pipeline's next job reprocesses the datum
+	require.NoError(t, c.StartPipeline(pipeline))
+	commit, err = c.StartCommit(dataRepo, "master")
+	require.NoError(t, err)
+	_, err = c.PutFile(dataRepo, commit.ID, "other", strings.NewReader("other\n"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit(dataRepo, commit.ID))
+	jobInfos, err = c.FlushJobAll([]*pfs.Commit{commit}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(jobInfos))
+	require.Equal(t, pps.JobState_JOB_SUCCESS, jobInfos[0].State)
+	require.Equal(t, int64(2), jobInfos[0].DataProcessed)
+	buf.Reset()
+	require.NoError(t, c.GetFile(pipeline, "master", "public", 0, 0, &buf))
+	require.Equal(t, "public\n", buf.String())
+}
+
 func TestPipelineWithStats(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping integration tests in short mode")
@@ -10,21 +10,28 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	gosync "sync"
 	"syscall"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 
+	"github.com/docker/go-units"
 	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/types"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
 	"github.com/pachyderm/pachyderm/src/client"
 	"github.com/pachyderm/pachyderm/src/client/limit"
 	pfsclient "github.com/pachyderm/pachyderm/src/client/pfs"
 	"github.com/pachyderm/pachyderm/src/client/pkg/grpcutil"
+	pfsserver "github.com/pachyderm/pachyderm/src/server/pfs"
 	"github.com/pachyderm/pachyderm/src/server/pfs/fuse"
 	"github.com/pachyderm/pachyderm/src/server/pfs/pretty"
+	"github.com/pachyderm/pachyderm/src/server/pkg/ancestry"
 	"github.com/pachyderm/pachyderm/src/server/pkg/cmdutil"
+	"github.com/pachyderm/pachyderm/src/server/pkg/localcache"
 	"github.com/pachyderm/pachyderm/src/server/pkg/sync"
 	"github.com/pachyderm/pachyderm/src/server/pkg/tabwriter"
 
@@ -61,11 +68,16 @@ func Cmds(noMetrics *bool) []*cobra.Command {
 	}
 
 	var description string
+	var quota string
 	createRepo := &cobra.Command{
 		Use:   "create-repo repo-name",
 		Short: "Create a new repo.",
 		Long:  "Create a new repo.",
 		Run: cmdutil.RunFixedArgs(1, func(args []string) error {
+			quotaBytes, err := parseQuota(quota)
+			if err != nil {
+				return err
+			}
 			c, err := client.NewOnUserMachine(metrics, "user")
 			if err != nil {
 				return err
@@ -75,18 +87,24 @@ func Cmds(noMetrics *bool) []*cobra.Command {
 				&pfsclient.CreateRepoRequest{
 					Repo:        client.NewRepo(args[0]),
 					Description: description,
+					QuotaBytes:  quotaBytes,
 				},
 			)
 			return grpcutil.ScrubGRPC(err)
 		}),
 	}
 	createRepo.Flags().StringVarP(&description, "description", "d", "", "A description of the repo.")
+	createRepo.Flags().StringVarP(&quota, "quota", "q", "", "The maximum size of the repo (e.g. 10GB), unlimited by default.")
 
 	updateRepo := &cobra.Command{
 		Use:   "update-repo repo-name",
 		Short: "Update a repo.",
 		Long:  "Update a repo.",
 		Run: cmdutil.RunFixedArgs(1, func(args []string) error {
+			quotaBytes, err := parseQuota(quota)
+			if err != nil {
+				return err
+			}
 			c, err := client.NewOnUserMachine(metrics, "user")
 			if err != nil {
 				return err
@@ -96,6 +114,8 @@ func Cmds(noMetrics *bool) []*cobra.Command {
 				&pfsclient.CreateRepoRequest{
 					Repo:        client.NewRepo(args[0]),
 					Description: description,
+					QuotaBytes:  quotaBytes,
+					SetQuota:    quota != "",
 					Update:      true,
 				},
 			)
@@ -103,6 +123,7 @@ func Cmds(noMetrics *bool) []*cobra.Command {
 		}),
 	}
 	updateRepo.Flags().StringVarP(&description, "description", "d", "", "A description of the repo.")
+	updateRepo.Flags().StringVarP(&quota, "quota", "q", "", "The new maximum size of the repo (e.g. 10GB, or 0 to remove the quota). The quota is unchanged if unset.")
 
 	inspectRepo := &cobra.Command{
 		Use:   "inspect-repo repo-name",
@@ -213,6 +234,16 @@ Commits become reliable (and immutable) when they are finished.
 
 Commits can be created with another commit as a parent.
 This layers the data in the commit over the data in the parent.
+
+Anywhere a commit ID is accepted, a branch name can be used to refer to the
+branch's head, and commits can be referred to relative to a branch or commit:
+- "master^" or "master~1" is the parent of master's head, "master~3" its
+  great-grandparent
+- "master@{2006-01-02T15:04:05Z}" or "master@{2006-01-02}" is the latest commit
+  on master that finished at or before that time (in UTC if no zone is given)
+- "master@{2.days.ago}" is the latest commit on master that finished at least
+  two days ago (units may be seconds, minutes, hours, days, weeks, months or
+  years)
 `,
 		Run: cmdutil.RunFixedArgs(0, func(args []string) error {
 			return nil
@@ -220,6 +251,7 @@ This layers the data in the commit over the data in the parent.
 	}
 
 	var parent string
+	var commitLabels cmdutil.RepeatedStringArg
 	startCommit := &cobra.Command{
 		Use:   "start-commit repo-name [branch]",
 		Short: "Start a new commit.",
@@ -238,8 +270,15 @@ $ pachctl start-commit test patch -p master
 
 # Start a commit with XXX as the parent in repo "test", not on any branch
 $ pachctl start-commit test -p XXX
+
+# Start a commit in repo "test" on branch "master", labelled with its source
+$ pachctl start-commit test master -l source=crm -l ingest-job=1234
 ` + codeend,
 		Run: cmdutil.RunBoundedArgs(1, 2, func(args []string) error {
+			labels, err := cmdutil.ParseLabels(commitLabels)
+			if err != nil {
+				return err
+			}
 			cli, err := client.NewOnUserMachine(metrics, "user")
 			if err != nil {
 				return err
@@ -253,6 +292,7 @@ $ pachctl start-commit test -p XXX
 					Branch:      branch,
 					Parent:      client.NewCommit(args[0], parent),
 					Description: description,
+					Labels:      labels,
 				})
 			if err != nil {
 				return grpcutil.ScrubGRPC(err)
@@ -264,21 +304,27 @@ $ pachctl start-commit test -p XXX
 	startCommit.Flags().StringVarP(&parent, "parent", "p", "", "The parent of the new commit, unneeded if branch is specified and you want to use the previous head of the branch as the parent.")
 	startCommit.Flags().StringVarP(&description, "message", "m", "", "A description of this commit's contents")
 	startCommit.Flags().StringVar(&description, "description", "", "A description of this commit's contents (synonym for --message)")
+	startCommit.Flags().VarP(&commitLabels, "label", "l", "A label (key=value) to set on the commit; can be specified multiple times")
 
 	finishCommit := &cobra.Command{
 		Use:   "finish-commit repo-name commit-id",
 		Short: "Finish a started commit.",
 		Long:  "Finish a started commit. Commit-id must be a writeable commit.",
 		Run: cmdutil.RunFixedArgs(2, func(args []string) error {
+			labels, err := cmdutil.ParseLabels(commitLabels)
+			if err != nil {
+				return err
+			}
 			cli, err := client.NewOnUserMachine(metrics, "user")
 			if err != nil {
 				return err
 			}
-			if description != "" {
+			if description != "" || len(labels) > 0 {
 				_, err := cli.PfsAPIClient.FinishCommit(cli.Ctx(),
 					&pfsclient.FinishCommitRequest{
 						Commit:      client.NewCommit(args[0], args[1]),
 						Description: description,
+						Labels:      labels,
 					})
 				return grpcutil.ScrubGRPC(err)
 			}
@@ -287,6 +333,47 @@ $ pachctl start-commit test -p XXX
 	}
 	finishCommit.Flags().StringVarP(&description, "message", "m", "", "A description of this commit's contents (overwrites any existing commit description)")
 	finishCommit.Flags().StringVar(&description, "description", "", "A description of this commit's contents (synonym for --message)")
+	finishCommit.Flags().VarP(&commitLabels, "label", "l", "A label (key=value) to set on the commit, overwriting any existing label with the same key; can be specified multiple times")
+
+	labelCommit := &cobra.Command{
+		Use:   "label-commit repo-name commit-id key=value... [key-]...",
+		Short: "Add, change or remove a commit's labels.",
+		Long: `Add, change or remove a commit's labels. Arguments of the form "key=value"
+set a label, overwriting any existing label with the same key, while arguments
+of the form "key-" remove the label with that key.
+
+Examples:
+
+` + codestart + `# label the head of "master" in repo "test" as validated
+$ pachctl label-commit test master quality=validated
+
+# remove the "quality" label from commit XXX
+$ pachctl label-commit test XXX quality-
+` + codeend,
+		Run: cmdutil.Run(func(args []string) error {
+			if len(args) < 3 {
+				return fmt.Errorf("expected a repo, a commit and at least one label, got %d arguments", len(args))
+			}
+			var set []string
+			var remove []string
+			for _, arg := range args[2:] {
+				if strings.HasSuffix(arg, "-") && !strings.Contains(arg, "=") {
+					remove = append(remove, strings.TrimSuffix(arg, "-"))
+				} else {
+					set = append(set, arg)
+				}
+			}
+			labels, err := cmdutil.ParseLabels(set)
+			if err != nil {
+				return err
+			}
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			return client.SetCommitLabels(args[0], args[1], labels, remove)
+		}),
+	}
 
 	inspectCommit := &cobra.Command{
 		Use:   "inspect-commit repo-name commit-id",
@@ -314,6 +401,7 @@ $ pachctl start-commit test -p XXX
 
 	var from string
 	var number int
+	var selector string
 	listCommit := &cobra.Command{
 		Use:   "list-commit repo-name",
 		Short: "Return all commits on a set of repos.",
@@ -335,6 +423,13 @@ $ pachctl list-commit foo XXX
 
 # return commits in repo "foo" since commit XXX
 $ pachctl list-commit foo master --from XXX
+
+# return commits in repo "foo" labelled with "env=prod" that aren't labelled
+# "wip"
+$ pachctl list-commit foo -l 'env=prod,!wip'
+
+# return commits in repo "foo" whose "source" label is "crm" or "erp"
+$ pachctl list-commit foo -l 'source in (crm,erp)'
 ` + codeend,
 		Run: cmdutil.RunBoundedArgs(1, 2, func(args []string) (retErr error) {
 			c, err := client.NewOnUserMachine(metrics, "user")
@@ -347,12 +442,12 @@ $ pachctl list-commit foo master --from XXX
 				to = args[1]
 			}
 			if raw {
-				return c.ListCommitF(args[0], to, from, uint64(number), func(ci *pfsclient.CommitInfo) error {
+				return c.ListCommitSelectorF(args[0], to, from, uint64(number), selector, func(ci *pfsclient.CommitInfo) error {
 					return marshaller.Marshal(os.Stdout, ci)
 				})
 			}
 			writer := tabwriter.NewWriter(os.Stdout, pretty.CommitHeader)
-			if err := c.ListCommitF(args[0], to, from, uint64(number), func(ci *pfsclient.CommitInfo) error {
+			if err := c.ListCommitSelectorF(args[0], to, from, uint64(number), selector, func(ci *pfsclient.CommitInfo) error {
 				pretty.PrintCommitInfo(writer, ci)
 				return nil
 			}); err != nil {
@@ -363,6 +458,7 @@ $ pachctl list-commit foo master --from XXX
 	}
 	listCommit.Flags().StringVarP(&from, "from", "f", "", "list all commits since this commit")
 	listCommit.Flags().IntVarP(&number, "number", "n", 0, "list only this many commits; if set to zero, list all commits")
+	listCommit.Flags().StringVarP(&selector, "selector", "l", "", "list only commits whose labels match this selector (e.g. 'key=value', 'key!=value', 'key in (a,b)', 'key', '!key')")
 	rawFlag(listCommit)
 
 	printCommitIter := func(commitIter client.CommitInfoIterator) error {
@@ -471,7 +567,7 @@ $ pachctl subscribe-commit test master --new
 				from = branch
 			}
 
-			commitIter, err := c.SubscribeCommit(repo, branch, from, pfsclient.CommitState_STARTED)
+			commitIter, err := c.SubscribeCommitSelector(repo, branch, from, pfsclient.CommitState_STARTED, selector)
 			if err != nil {
 				return err
 			}
@@ -481,8 +577,51 @@ $ pachctl subscribe-commit test master --new
 	}
 	subscribeCommit.Flags().StringVar(&from, "from", "", "subscribe to all commits since this commit")
 	subscribeCommit.Flags().BoolVar(&new, "new", false, "subscribe to only new commits created from now on")
+	subscribeCommit.Flags().StringVarP(&selector, "selector", "l", "", "subscribe only to commits whose labels match this selector")
 	rawFlag(subscribeCommit)
 
+	var newFiles bool
+	subscribeFile := &cobra.Command{
+		Use:   "subscribe-file repo branch pattern",
+		Short: "Print changes to files as commits that make them are finished.",
+		Long: `Print the files under a glob pattern that are added, modified or deleted by
+each commit on a branch, as the commits are finished. By default, the changes
+made by all existing commits on the branch are returned first. Each line shows
+the commit, the type of change and the path of the file.
+
+Examples:
+
+` + codestart + `# print changes under "models/prod" in repo "test" on branch "master"
+$ pachctl subscribe-file test master "models/prod"
+
+# print changes to ".json" files at any depth, made by commits since commit XXX
+$ pachctl subscribe-file test master "**/*.json" --from XXX
+` + codeend,
+		Run: cmdutil.RunFixedArgs(3, func(args []string) error {
+			repo, branch, pattern := args[0], args[1], args[2]
+			c, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			if newFiles && from != "" {
+				return fmt.Errorf("--new and --from cannot both be provided")
+			}
+			if newFiles {
+				from = branch
+			}
+			return c.SubscribeFile(repo, branch, pattern, from, func(event *pfsclient.FileEvent) error {
+				if raw {
+					return marshaller.Marshal(os.Stdout, event)
+				}
+				_, err := fmt.Printf("%s\t%s\t%s\n", event.Commit.ID, strings.ToLower(event.Type.String()), event.File.File.Path)
+				return err
+			})
+		}),
+	}
+	subscribeFile.Flags().StringVar(&from, "from", "", "only print changes made by commits since this commit")
+	subscribeFile.Flags().BoolVar(&newFiles, "new", false, "only print changes made by new commits created from now on")
+	rawFlag(subscribeFile)
+
 	deleteCommit := &cobra.Command{
 		Use:   "delete-commit repo-name commit-id",
 		Short: "Delete an input commit.",
@@ -496,6 +635,73 @@ $ pachctl subscribe-commit test master --new
 		}),
 	}
 
+	squashCommit := &cobra.Command{
+		Use:   "squash-commit repo-name from-commit-id to-commit-id",
+		Short: "Squash a range of commits into a single commit.",
+		Long: `Squash a range of commits into a single commit.
+
+The commits from from-commit-id through to-commit-id (inclusive) are replaced
+by to-commit-id, whose contents are unchanged. to-commit-id becomes the child
+of from-commit-id's parent, and the other commits in the range are deleted.
+
+If pipeline output commits were derived from any of the commits being squashed
+away, squash-commit fails unless --force is passed, in which case those output
+commits are deleted as well.
+
+Examples:
+
+` + codestart + `# squash the last 5 commits on "master" into its head
+$ pachctl squash-commit foo master~4 master
+
+# squash commits XXX through YYY, deleting any output commits derived from
+# them
+$ pachctl squash-commit foo XXX YYY --force
+` + codeend,
+		Run: cmdutil.RunFixedArgs(3, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			return client.SquashCommit(args[0], args[1], args[2], force)
+		}),
+	}
+	squashCommit.Flags().BoolVarP(&force, "force", "f", false, "squash the commits even if downstream commits were derived from them, deleting those downstream commits")
+
+	var revertBranch string
+	revertCommit := &cobra.Command{
+		Use:   "revert-commit repo-name commit-id",
+		Short: "Undo the changes made by a commit.",
+		Long: `Undo the changes made by a commit, by creating a new commit on top of a
+branch's head that restores every file the commit changed to its previous
+state. The reverted commit is left in the branch's history, and downstream
+pipelines process the new commit as usual.
+
+The commit must be an ancestor of the branch's head, and the revert fails if
+any of the files it changed have been changed again since.
+
+Examples:
+
+` + codestart + `# undo the changes made by the parent of master's head
+$ pachctl revert-commit foo master^
+
+# undo the changes made by commit XXX on branch "master"
+$ pachctl revert-commit foo XXX --branch master
+` + codeend,
+		Run: cmdutil.RunFixedArgs(2, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			commit, err := client.RevertCommit(args[0], args[1], revertBranch)
+			if err != nil {
+				return err
+			}
+			fmt.Println(commit.ID)
+			return nil
+		}),
+	}
+	revertCommit.Flags().StringVarP(&revertBranch, "branch", "b", "", "the branch to create the revert commit on; defaults to the branch commit-id is relative to")
+
 	var branchProvenance cmdutil.RepeatedStringArg
 	var head string
 	createBranch := &cobra.Command{
@@ -585,6 +791,321 @@ $ pachctl set-branch foo test master` + codeend,
 	}
 	deleteBranch.Flags().BoolVarP(&force, "force", "f", false, "remove the branch regardless of errors; use with care")
 
+	var strategy string
+	var mergeMessage string
+	mergeBranch := &cobra.Command{
+		Use:   "merge-branch repo-name from-branch to-branch",
+		Short: "Merge one branch into another.",
+		Long: `Merge the changes made on one branch since it diverged from another into
+the other branch, creating a new commit on it. If the destination branch is an
+ancestor of the source branch it's simply fast-forwarded.
+
+Files changed on both branches are conflicts, and are resolved according to
+--strategy:
+  fail:        report the conflicts and don't merge (the default)
+  ours:        keep the destination branch's version
+  theirs:      take the source branch's version
+  concatenate: append the source branch's version to the destination's
+
+Examples:
+
+` + codestart + `# merge branch "feature" into "master" in repo "foo"
+$ pachctl merge-branch foo feature master
+
+# merge, preferring the changes made on "feature"
+$ pachctl merge-branch foo feature master --strategy theirs
+` + codeend,
+		Run: cmdutil.RunFixedArgs(3, func(args []string) error {
+			mergeStrategy, ok := pfsclient.MergeStrategy_value[strings.ToUpper(strategy)]
+			if !ok {
+				return fmt.Errorf("unrecognized merge strategy %q, must be one of fail, ours, theirs or concatenate", strategy)
+			}
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			response, err := client.MergeBranch(args[0], args[1], args[2], pfsclient.MergeStrategy(mergeStrategy), mergeMessage)
+			if err != nil {
+				return err
+			}
+			if len(response.Conflicts) > 0 {
+				writer := tabwriter.NewWriter(os.Stdout, pretty.MergeConflictHeader)
+				for _, conflict := range response.Conflicts {
+					pretty.PrintMergeConflict(writer, conflict)
+				}
+				if err := writer.Flush(); err != nil {
+					return err
+				}
+			}
+			if response.Commit == nil {
+				return fmt.Errorf("merge failed with %d conflicts", len(response.Conflicts))
+			}
+			fmt.Println(response.Commit.ID)
+			return nil
+		}),
+	}
+	mergeBranch.Flags().StringVarP(&strategy, "strategy", "s", "fail", "how to resolve conflicts: fail, ours, theirs or concatenate")
+	mergeBranch.Flags().StringVarP(&mergeMessage, "message", "m", "", "a description of the merge commit")
+
+	var allowedPrincipals cmdutil.RepeatedStringArg
+	var noDelete bool
+	var mergeOnly bool
+	protectBranch := &cobra.Command{
+		Use:   "protect-branch repo-name branch-name",
+		Short: "Protect a branch from being changed.",
+		Long: `Set the protection rules of a branch, replacing any existing rules.
+
+Protection rules are enforced in addition to the repo's ACL, and can only be
+changed by the repo's owners:
+  --allow:      only these users may advance the branch's head (repeatable)
+  --no-delete:  the branch can't be deleted
+  --merge-only: commits can't be made on the branch directly (with
+                start-commit, put-file, etc), it can only be advanced with
+                merge-branch or create-branch --head
+
+Examples:
+
+` + codestart + `# only allow "alice" and "bob" to change branch "master" in repo "foo"
+$ pachctl protect-branch foo master --allow alice --allow bob
+
+# require that all changes to "master" are merged in from other branches
+$ pachctl protect-branch foo master --merge-only --no-delete
+` + codeend,
+		Run: cmdutil.RunFixedArgs(2, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			return client.ProtectBranch(args[0], args[1], allowedPrincipals, noDelete, mergeOnly)
+		}),
+	}
+	protectBranch.Flags().VarP(&allowedPrincipals, "allow", "a", "A user that may advance the branch's head.")
+	protectBranch.Flags().BoolVar(&noDelete, "no-delete", false, "Prevent the branch from being deleted.")
+	protectBranch.Flags().BoolVar(&mergeOnly, "merge-only", false, "Only allow the branch to be advanced by merging or by pointing it at an existing commit.")
+
+	unprotectBranch := &cobra.Command{
+		Use:   "unprotect-branch repo-name branch-name",
+		Short: "Remove the protection rules of a branch.",
+		Long:  "Remove the protection rules of a branch.",
+		Run: cmdutil.RunFixedArgs(2, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			return client.UnprotectBranch(args[0], args[1])
+		}),
+	}
+
+	tagCommit := &cobra.Command{
+		Use:   "tag-commit repo-name commit-id tag-name",
+		Short: "Tag a commit.",
+		Long: `Create an immutable tag pointing at a commit.
+
+Unlike a branch, a tag never moves. It can be used anywhere a commit ID is
+accepted, and the commit it points at can't be deleted until the tag is
+deleted with delete-commit-tag.
+
+Examples:
+
+` + codestart + `# tag the head of branch "master" in repo "foo" as "v1.0"
+$ pachctl tag-commit foo master v1.0
+
+# read a file from the tagged commit
+$ pachctl get-file foo v1.0 /path/to/file
+` + codeend,
+		Run: cmdutil.RunFixedArgs(3, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			return client.CreateCommitTag(args[0], args[1], args[2])
+		}),
+	}
+
+	listCommitTag := &cobra.Command{
+		Use:   "list-commit-tag repo-name",
+		Short: "Return all commit tags on a repo.",
+		Long:  "Return all commit tags on a repo.",
+		Run: cmdutil.RunFixedArgs(1, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			commitTags, err := client.ListCommitTag(args[0])
+			if err != nil {
+				return err
+			}
+			if raw {
+				for _, commitTag := range commitTags {
+					if err := marshaller.Marshal(os.Stdout, commitTag); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			writer := tabwriter.NewWriter(os.Stdout, pretty.CommitTagHeader)
+			for _, commitTag := range commitTags {
+				pretty.PrintCommitTagInfo(writer, commitTag)
+			}
+			return writer.Flush()
+		}),
+	}
+	rawFlag(listCommitTag)
+
+	deleteCommitTag := &cobra.Command{
+		Use:   "delete-commit-tag repo-name tag-name",
+		Short: "Delete a commit tag",
+		Long:  "Delete a commit tag, while leaving the commit intact",
+		Run: cmdutil.RunFixedArgs(2, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			return client.DeleteCommitTag(args[0], args[1])
+		}),
+	}
+
+	printPrunedCommits := func(prunedCommits []*pfsclient.PrunedCommitInfo) error {
+		if raw {
+			for _, prunedCommit := range prunedCommits {
+				if err := marshaller.Marshal(os.Stdout, prunedCommit); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		writer := tabwriter.NewWriter(os.Stdout, pretty.PrunedCommitHeader)
+		for _, prunedCommit := range prunedCommits {
+			pretty.PrintPrunedCommitInfo(writer, prunedCommit)
+		}
+		return writer.Flush()
+	}
+
+	var keepLast uint64
+	var keepWithin string
+	var deletePolicy bool
+	setRetention := &cobra.Command{
+		Use:   "set-retention repo-name [branch-name]",
+		Short: "Set how much of a repo's or branch's history is kept.",
+		Long: `Set the retention policy of a repo, or of a single branch if one is given.
+A branch's own policy takes precedence over its repo's.
+
+PFS periodically prunes the commits that a policy doesn't keep, by squashing
+them into the oldest commit that is kept (so no data visible in a surviving
+commit is lost). A branch's head is never pruned, and neither are commits that
+are tagged, are the head of another branch, or have downstream commits
+derived from them. Pruned commits can be listed with list-pruned-commits.
+
+Examples:
+
+` + codestart + `# keep the last 100 commits on every branch of repo "foo"
+$ pachctl set-retention foo --keep-last 100
+
+# on branch "master", also keep every commit from the last 90 days
+$ pachctl set-retention foo master --keep-last 100 --keep-within 90d
+
+# remove the retention policy of branch "master"
+$ pachctl set-retention foo master --delete
+` + codeend,
+		Run: cmdutil.RunBoundedArgs(1, 2, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			var branch string
+			if len(args) > 1 {
+				branch = args[1]
+			}
+			if deletePolicy {
+				return client.DeleteRetentionPolicy(args[0], branch)
+			}
+			keepWithinDuration, err := parseRetentionDuration(keepWithin)
+			if err != nil {
+				return err
+			}
+			return client.SetRetentionPolicy(args[0], branch, keepLast, keepWithinDuration)
+		}),
+	}
+	setRetention.Flags().Uint64Var(&keepLast, "keep-last", 0, "Keep the last N commits on each branch.")
+	setRetention.Flags().StringVar(&keepWithin, "keep-within", "", "Keep the commits finished within this long of the present (e.g. 90d or 12h).")
+	setRetention.Flags().BoolVar(&deletePolicy, "delete", false, "Remove the retention policy instead of setting it.")
+
+	pruneCommits := &cobra.Command{
+		Use:   "prune-commits repo-name",
+		Short: "Apply a repo's retention policies now.",
+		Long:  "Apply a repo's retention policies now, rather than waiting for PFS to apply them in the background, and print the commits that were pruned.",
+		Run: cmdutil.RunFixedArgs(1, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			prunedCommits, err := client.PruneCommits(args[0])
+			if err != nil {
+				return err
+			}
+			return printPrunedCommits(prunedCommits)
+		}),
+	}
+	rawFlag(pruneCommits)
+
+	listPrunedCommits := &cobra.Command{
+		Use:   "list-pruned-commits repo-name",
+		Short: "Return the commits removed from a repo by retention policies.",
+		Long:  "Return the commits removed from a repo by retention policies. Pruned commits are listed for 30 days.",
+		Run: cmdutil.RunFixedArgs(1, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			prunedCommits, err := client.ListPrunedCommits(args[0])
+			if err != nil {
+				return err
+			}
+			return printPrunedCommits(prunedCommits)
+		}),
+	}
+	rawFlag(listPrunedCommits)
+
+	var lockPeriod string
+	var lockBranches []string
+	lockRepo := &cobra.Command{
+		Use:   "lock-repo repo-name",
+		Short: "Prevent a repo's data from being deleted for a fixed period.",
+		Long: `Set the retention lock of a repo. Each commit in a locked repo is retained
+until the lock's period has passed since it was started. While a commit is
+retained, nobody (not even a cluster admin) can delete it, squash it, prune
+it or purge files from it, and the repo itself can't be deleted. Locked
+branches can't be deleted while their head is retained.
+
+A lock can be extended, with a longer period or more branches, but it can't be
+shortened or removed.
+
+Examples:
+
+` + codestart + `# retain every commit in repo "foo" for seven years
+$ pachctl lock-repo foo --period 7y
+
+# only lock the "master" branch of repo "foo" (commits are still retained)
+$ pachctl lock-repo foo --period 7y --branch master
+` + codeend,
+		Run: cmdutil.RunFixedArgs(1, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			period, err := parseRetentionDuration(lockPeriod)
+			if err != nil {
+				return err
+			}
+			if period == 0 {
+				return fmt.Errorf("--period must be set")
+			}
+			return client.SetRetentionLock(args[0], period, lockBranches...)
+		}),
+	}
+	lockRepo.Flags().StringVar(&lockPeriod, "period", "", "How long to retain each commit after it's started (e.g. 7y, 90d or 12h).")
+	lockRepo.Flags().StringSliceVarP(&lockBranches, "branch", "b", nil, "A branch to lock; may be repeated. If unset, every branch is locked.")
+
 	file := &cobra.Command{
 		Use:   "file",
 		Short: "Docs for files.",
@@ -607,6 +1128,9 @@ $ pachctl set-branch foo test master` + codeend,
 	var headerRecords uint
 	var putFileCommit bool
 	var overwrite bool
+	var fileMetadata cmdutil.RepeatedStringArg
+	var contentType string
+	var expectedSha256 string
 	putFile := &cobra.Command{
 		Use:   "put-file repo-name branch [path/to/file/in/pfs]",
 		Short: "Put a file into the filesystem.",
@@ -635,6 +1159,12 @@ $ pachctl put-file -r repo branch / -f dir
 # Put the data from a URL as repo/branch/path:
 $ pachctl put-file repo branch path -f http://host/path
 
+# Put a file with a content type and other metadata:
+$ pachctl put-file repo branch path -f file --content-type text/csv -m source=http://host/path
+
+# Put a file, failing if its SHA-256 checksum isn't the expected one:
+$ pachctl put-file repo branch path -f file --sha256 $(sha256sum file | cut -d' ' -f1)
+
 # Put the data from a URL as repo/branch/path:
 $ pachctl put-file repo branch -f http://host/path
 
@@ -681,6 +1211,19 @@ want to consider using commit IDs directly.
 			if putFileCommit {
 				fmt.Fprintf(os.Stderr, "flag --commit / -c is deprecated; as of 1.7.2, you will get the same behavior without it\n")
 			}
+			metadata, err := cmdutil.ParseLabels(fileMetadata)
+			if err != nil {
+				return err
+			}
+			if contentType != "" {
+				metadata[pfsclient.ContentTypeKey] = contentType
+			}
+			if len(metadata) > 0 && split != "" {
+				return fmt.Errorf("cannot set metadata with --split")
+			}
+			if expectedSha256 != "" && (split != "" || recursive || inputFile != "" || len(filePaths) != 1) {
+				return fmt.Errorf("--sha256 can only be used to put a single file, without --split or -r")
+			}
 
 			limiter := limit.New(int(parallelism))
 			var sources []string
@@ -735,19 +1278,19 @@ want to consider using commit IDs directly.
 						return fmt.Errorf("must specify filename when reading data from stdin")
 					}
 					eg.Go(func() error {
-						return putFileHelper(c, pfc, repoName, branch, joinPaths("", source), source, recursive, overwrite, limiter, split, targetFileDatums, targetFileBytes, headerRecords, filesPut)
+						return putFileHelper(c, pfc, repoName, branch, joinPaths("", source), source, recursive, overwrite, metadata, expectedSha256, limiter, split, targetFileDatums, targetFileBytes, headerRecords, filesPut)
 					})
 				} else if len(sources) == 1 && len(args) == 3 {
 					// We have a single source and the user has specified a path,
 					// we use the path and ignore source (in terms of naming the file).
 					eg.Go(func() error {
-						return putFileHelper(c, pfc, repoName, branch, path, source, recursive, overwrite, limiter, split, targetFileDatums, targetFileBytes, headerRecords, filesPut)
+						return putFileHelper(c, pfc, repoName, branch, path, source, recursive, overwrite, metadata, expectedSha256, limiter, split, targetFileDatums, targetFileBytes, headerRecords, filesPut)
 					})
 				} else if len(sources) > 1 && len(args) == 3 {
 					// We have multiple sources and the user has specified a path,
 					// we use that path as a prefix for the filepaths.
 					eg.Go(func() error {
-						return putFileHelper(c, pfc, repoName, branch, joinPaths(path, source), source, recursive, overwrite, limiter, split, targetFileDatums, targetFileBytes, headerRecords, filesPut)
+						return putFileHelper(c, pfc, repoName, branch, joinPaths(path, source), source, recursive, overwrite, metadata, expectedSha256, limiter, split, targetFileDatums, targetFileBytes, headerRecords, filesPut)
 					})
 				}
 			}
@@ -764,6 +1307,9 @@ want to consider using commit IDs directly.
 	putFile.Flags().UintVar(&headerRecords, "header-records", 0, "the number of records that will be converted to a PFS 'header', and prepended to future retrievals of any subset of data from PFS; needs to be used with --split=(json|line|csv)")
 	putFile.Flags().BoolVarP(&putFileCommit, "commit", "c", false, "DEPRECATED: Put file(s) in a new commit.")
 	putFile.Flags().BoolVarP(&overwrite, "overwrite", "o", false, "Overwrite the existing content of the file, either from previous commits or previous calls to put-file within this commit.")
+	putFile.Flags().VarP(&fileMetadata, "metadata", "m", "Metadata (key=value) to set on the file(s), overwriting any existing metadata with the same key; can be specified multiple times")
+	putFile.Flags().StringVar(&contentType, "content-type", "", "The MIME type of the file(s), stored as their \"content-type\" metadata.")
+	putFile.Flags().StringVar(&expectedSha256, "sha256", "", "The expected SHA-256 checksum (in hex) of the file; the put fails if the data doesn't match it.")
 
 	copyFile := &cobra.Command{
 		Use:   "copy-file src-repo src-commit src-path dst-repo dst-commit dst-path",
@@ -779,6 +1325,11 @@ want to consider using commit IDs directly.
 	}
 	copyFile.Flags().BoolVarP(&overwrite, "overwrite", "o", false, "Overwrite the existing content of the file, either from previous commits or previous calls to put-file within this commit.")
 
+	var useCache bool
+	cacheFlag := func(cmd *cobra.Command) {
+		cmd.Flags().BoolVar(&useCache, "cache", false, "Cache the content of files on local disk, in $PACH_CACHE_DIR (~/.pachyderm/cache by default). The cache holds up to $PACH_CACHE_SIZE (10GB by default) and is shared with other pachctl commands.")
+	}
+
 	var outputPath string
 	getFile := &cobra.Command{
 		Use:   "get-file repo-name commit-id path/to/file",
@@ -800,11 +1351,17 @@ $ pachctl get-file foo master^2 XXX
 			if err != nil {
 				return err
 			}
+			var cache *localcache.Cache
+			if useCache {
+				if cache, err = localcache.NewOnUserMachine(); err != nil {
+					return err
+				}
+			}
 			if recursive {
 				if outputPath == "" {
 					return fmt.Errorf("an output path needs to be specified when using the --recursive flag")
 				}
-				puller := sync.NewPuller()
+				puller := sync.NewCachedPuller(cache)
 				return puller.Pull(client, outputPath, args[0], args[1], args[2], false, false, parallelism, nil, "")
 			}
 			var w io.Writer
@@ -819,12 +1376,109 @@ $ pachctl get-file foo master^2 XXX
 				defer f.Close()
 				w = f
 			}
+			if cache != nil {
+				return localcache.GetFile(client, cache, args[0], args[1], args[2], w)
+			}
 			return client.GetFile(args[0], args[1], args[2], 0, 0, w)
 		}),
 	}
 	getFile.Flags().BoolVarP(&recursive, "recursive", "r", false, "Recursively download a directory.")
 	getFile.Flags().StringVarP(&outputPath, "output", "o", "", "The path where data will be downloaded.")
 	getFile.Flags().IntVarP(&parallelism, "parallelism", "p", DefaultParallelism, "The maximum number of files that can be downloaded in parallel")
+	cacheFlag(getFile)
+
+	var mirrorOpts sync.MirrorOptions
+	var includes cmdutil.RepeatedStringArg
+	var excludes cmdutil.RepeatedStringArg
+	mirrorFlags := func(cmd *cobra.Command, verb string) {
+		cmd.Flags().BoolVar(&mirrorOpts.Delete, "delete", false, "Delete files that don't exist in the source from the destination.")
+		cmd.Flags().BoolVarP(&mirrorOpts.DryRun, "dry-run", "n", false, "Print the changes that would be made, without making them.")
+		cmd.Flags().VarP(&includes, "include", "i", "Only consider files matching this glob; can be specified multiple times.")
+		cmd.Flags().VarP(&excludes, "exclude", "x", "Ignore files matching this glob, or under a directory matching it; can be specified multiple times.")
+		cmd.Flags().IntVarP(&mirrorOpts.Parallelism, "parallelism", "p", DefaultParallelism, fmt.Sprintf("The maximum number of files that can be %s in parallel.", verb))
+	}
+	printChanges := func(changes []sync.Change) {
+		if len(changes) == 0 {
+			fmt.Println("Already up to date.")
+			return
+		}
+		for _, change := range changes {
+			fmt.Printf("%s\t%s\n", change.Type, change.Path)
+		}
+	}
+
+	push := &cobra.Command{
+		Use:   "push path/to/dir repo-name[@branch]",
+		Short: "Upload the changes in a local directory to a branch.",
+		Long: `Upload the changes in a local directory to a branch, in a single commit.
+
+Only files whose content differs from the head of the branch are uploaded,
+and no commit is made if there are no changes. The commit that the directory
+was last pushed to or pulled from is recorded in the file ".pachsync" at its
+root, so that later pushes only compare the files that changed since. The
+branch defaults to "master".
+` + codestart + `# make "master" in repo "foo" match the directory "data"
+$ pachctl push data foo --delete
+
+# see what would be uploaded to branch "dev", ignoring log files
+$ pachctl push data foo@dev -n -x "**.log"
+` + codeend,
+		Run: cmdutil.RunFixedArgs(2, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			repo, branch := parseRepoBranch(args[1])
+			mirrorOpts.Include, mirrorOpts.Exclude = includes, excludes
+			changes, err := sync.PushDir(client, args[0], repo, branch, &mirrorOpts)
+			if err != nil {
+				return err
+			}
+			printChanges(changes)
+			return nil
+		}),
+	}
+	mirrorFlags(push, "uploaded")
+
+	pull := &cobra.Command{
+		Use:   "pull repo-name[@branch] path/to/dir",
+		Short: "Download the changes on a branch to a local directory.",
+		Long: `Download the changes on a branch to a local directory.
+
+Only files whose content differs from the local copy are downloaded. The
+commit that the directory was last pulled from or pushed to is recorded in the
+file ".pachsync" at its root, so that later pulls only compare the files that
+changed since. The branch defaults to "master".
+` + codestart + `# make the directory "data" match "master" in repo "foo"
+$ pachctl pull foo data --delete
+
+# download only the CSV files on branch "dev"
+$ pachctl pull foo@dev data -i "**.csv"
+` + codeend,
+		Run: cmdutil.RunFixedArgs(2, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			var cache *localcache.Cache
+			if useCache {
+				if cache, err = localcache.NewOnUserMachine(); err != nil {
+					return err
+				}
+			}
+			repo, branch := parseRepoBranch(args[0])
+			mirrorOpts.Include, mirrorOpts.Exclude = includes, excludes
+			puller := sync.NewCachedPuller(cache)
+			changes, err := puller.PullDir(client, args[1], repo, branch, &mirrorOpts)
+			if err != nil {
+				return err
+			}
+			printChanges(changes)
+			return nil
+		}),
+	}
+	mirrorFlags(pull, "downloaded")
+	cacheFlag(pull)
 
 	inspectFile := &cobra.Command{
 		Use:   "inspect-file repo-name commit-id path/to/file",
@@ -851,6 +1505,14 @@ $ pachctl get-file foo master^2 XXX
 	rawFlag(inspectFile)
 
 	var history int64
+	var pageSize int64
+	var pageToken string
+	var listSort string
+	var reverse bool
+	var minSize string
+	var maxSize string
+	var committedAfter string
+	var fileType string
 	listFile := &cobra.Command{
 		Use:   "list-file repo-name commit-id path/to/dir",
 		Short: "Return the files in a directory.",
@@ -877,6 +1539,14 @@ $ pachctl list-file foo master --history n
 
 # list all versions of top-level files on branch "master" in repo "foo"
 $ pachctl list-file foo master --history -1
+
+# list the 100 largest files under "dir", then the next 100 (the token for
+# the next page is printed after each page)
+$ pachctl list-file foo master dir --sort size --reverse --page-size 100
+$ pachctl list-file foo master dir --sort size --reverse --page-size 100 --page-token <token>
+
+# list files under "dir" that are at least 1MB
+$ pachctl list-file foo master dir --type file --min-size 1MB
 ` + codeend,
 		Run: cmdutil.RunBoundedArgs(2, 3, func(args []string) error {
 			client, err := client.NewOnUserMachine(metrics, "user")
@@ -887,6 +1557,76 @@ $ pachctl list-file foo master --history -1
 			if len(args) == 3 {
 				path = args[2]
 			}
+			if pageSize != 0 || pageToken != "" || listSort != "name" || reverse || minSize != "" || maxSize != "" || committedAfter != "" || fileType != "" {
+				request := &pfsclient.ListFileRequest{
+					File: &pfsclient.File{
+						Commit: &pfsclient.Commit{Repo: &pfsclient.Repo{Name: args[0]}, ID: args[1]},
+						Path:   path,
+					},
+					History:    history,
+					PageSize:   pageSize,
+					PageToken:  pageToken,
+					Descending: reverse,
+				}
+				sortValue, ok := pfsclient.ListFileSort_value[strings.ToUpper(listSort)]
+				if !ok {
+					return fmt.Errorf("invalid sort %q, must be one of name, size or committed", listSort)
+				}
+				request.Sort = pfsclient.ListFileSort(sortValue)
+				if minSize != "" {
+					size, err := units.RAMInBytes(minSize)
+					if err != nil {
+						return fmt.Errorf("invalid size %q: %v", minSize, err)
+					}
+					request.MinSizeBytes = uint64(size)
+				}
+				if maxSize != "" {
+					size, err := units.RAMInBytes(maxSize)
+					if err != nil {
+						return fmt.Errorf("invalid size %q: %v", maxSize, err)
+					}
+					request.MaxSizeBytes = uint64(size)
+				}
+				if committedAfter != "" {
+					t, err := ancestry.ParseTimeSpec(committedAfter, time.Now())
+					if err != nil {
+						return err
+					}
+					if request.CommittedAfter, err = types.TimestampProto(t); err != nil {
+						return err
+					}
+				}
+				if fileType != "" {
+					typeValue, ok := pfsclient.FileType_value[strings.ToUpper(fileType)]
+					if !ok || typeValue == int32(pfsclient.FileType_RESERVED) {
+						return fmt.Errorf("invalid file type %q, must be one of file, dir or symlink", fileType)
+					}
+					request.FileType = pfsclient.FileType(typeValue)
+				}
+				fileInfos, nextPageToken, err := client.ListFilePage(request)
+				if err != nil {
+					return err
+				}
+				if raw {
+					for _, fi := range fileInfos {
+						if err := marshaller.Marshal(os.Stdout, fi); err != nil {
+							return err
+						}
+					}
+				} else {
+					writer := tabwriter.NewWriter(os.Stdout, pretty.FileHeader)
+					for _, fi := range fileInfos {
+						pretty.PrintFileInfo(writer, fi)
+					}
+					if err := writer.Flush(); err != nil {
+						return err
+					}
+				}
+				if nextPageToken != "" {
+					fmt.Fprintf(os.Stderr, "Next page token: %s\n", nextPageToken)
+				}
+				return nil
+			}
 			if raw {
 				return client.ListFileF(args[0], args[1], path, history, func(fi *pfsclient.FileInfo) error {
 					return marshaller.Marshal(os.Stdout, fi)
@@ -904,13 +1644,24 @@ $ pachctl list-file foo master --history -1
 	}
 	rawFlag(listFile)
 	listFile.Flags().Int64Var(&history, "history", 0, "Return revision history for files.")
+	listFile.Flags().Int64Var(&pageSize, "page-size", 0, "Return at most this many files, and print a token for the next page if there are more.")
+	listFile.Flags().StringVar(&pageToken, "page-token", "", "Return the page of files after the one that printed this token.")
+	listFile.Flags().StringVar(&listSort, "sort", "name", "Sort files by 'name', 'size' or 'committed' (the time that they were last changed).")
+	listFile.Flags().BoolVar(&reverse, "reverse", false, "Sort files in descending order.")
+	listFile.Flags().StringVar(&minSize, "min-size", "", "Only return files of at least this size (e.g. 10MB).")
+	listFile.Flags().StringVar(&maxSize, "max-size", "", "Only return files of at most this size (e.g. 1GB).")
+	listFile.Flags().StringVar(&committedAfter, "committed-after", "", "Only return files that were last changed after this time (e.g. 2006-01-02 or 2.days.ago).")
+	listFile.Flags().StringVar(&fileType, "type", "", "Only return files of this type: 'file', 'dir' or 'symlink'.")
 
 	globFile := &cobra.Command{
 		Use:   "glob-file repo-name commit-id pattern",
 		Short: "Return files that match a glob pattern in a commit.",
 		Long: `Return files that match a glob pattern in a commit (that is, match a glob pattern
 in a repo at the state represented by a commit). Glob patterns are
-documented [here](https://golang.org/pkg/path/filepath/#Match).
+documented [here](https://golang.org/pkg/path/filepath/#Match). In addition,
+"**" matches any number of directories, braces match any of several
+alternatives (e.g. "{logs,metrics}"), and "!" starts an exclusion: files under
+a directory that matches an exclusion aren't returned.
 
 Examples:
 
@@ -921,6 +1672,10 @@ $ pachctl glob-file foo master "A*"
 
 # Return files in repo "foo" on branch "master" under directory "data".
 $ pachctl glob-file foo master "data/*"
+
+# Return every ".parquet" file in repo "foo" on branch "master", at any
+# depth, except for those under directories called "_tmp".
+$ pachctl glob-file foo master "**/*.parquet!**/_tmp"
 ` + codeend,
 		Run: cmdutil.RunFixedArgs(3, func(args []string) error {
 			client, err := client.NewOnUserMachine(metrics, "user")
@@ -947,7 +1702,57 @@ $ pachctl glob-file foo master "data/*"
 	}
 	rawFlag(globFile)
 
+	var maxBytes string
+	var maxResults int64
+	grepFile := &cobra.Command{
+		Use:   "grep-file repo-name commit-id pattern regex",
+		Short: "Search the files that match a glob pattern for lines matching a regex.",
+		Long: `Search the files that match a glob pattern in a commit for lines that match a
+regular expression. The files are searched by pachd, in parallel, and each
+matching line is printed as "path:line-number:line". The regex syntax is
+documented [here](https://golang.org/pkg/regexp/syntax/).
+
+Examples:
+
+` + codestart + `# Find the lines containing a request ID in the logs in repo "logs" on branch
+# "master"
+$ pachctl grep-file logs master "/**.log" "req-[0-9a-f]{8}"
+
+# Stop after finding 10 lines or searching 1GB of files
+$ pachctl grep-file logs master "/**.log" "ERROR" --max-results 10 --max-bytes 1GB
+` + codeend,
+		Run: cmdutil.RunFixedArgs(4, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			var maxBytesScanned int64
+			if maxBytes != "" {
+				if maxBytesScanned, err = units.RAMInBytes(maxBytes); err != nil {
+					return fmt.Errorf("invalid --max-bytes %q: %v", maxBytes, err)
+				}
+			}
+			return client.GrepFileF(args[0], args[1], args[2], args[3], maxBytesScanned, maxResults, func(resp *pfsclient.GrepFileResponse) error {
+				if raw {
+					return marshaller.Marshal(os.Stdout, resp)
+				}
+				if resp.Truncated {
+					fmt.Fprintln(os.Stderr, "search stopped early because of --max-bytes or --max-results")
+					return nil
+				}
+				fmt.Printf("%s:%d:%s\n", resp.File.Path, resp.LineNumber, resp.Line)
+				return nil
+			})
+		}),
+	}
+	grepFile.Flags().StringVar(&maxBytes, "max-bytes", "", "The maximum total size of the files to search (e.g. 10GB), unlimited by default.")
+	grepFile.Flags().Int64Var(&maxResults, "max-results", 0, "The maximum number of matching lines to return, unlimited by default.")
+	rawFlag(grepFile)
+
 	var shallow bool
+	var diffContent bool
+	var contextLines int64
+	var diffMaxBytes string
 	diffFile := &cobra.Command{
 		Use:   "diff-file new-repo-name new-commit-id new-path [old-repo-name old-commit-id old-path]",
 		Short: "Return a diff of two file trees.",
@@ -960,12 +1765,49 @@ $ pachctl diff-file foo master path
 
 # Return the diff between foo master path1 and bar master path2.
 $ pachctl diff-file foo master path1 bar master path2
+
+# Show the changes to the contents of the files under foo master path, like
+# 'git diff'.
+$ pachctl diff-file foo master path --content
 ` + codeend,
 		Run: cmdutil.RunBoundedArgs(3, 6, func(args []string) error {
 			client, err := client.NewOnUserMachine(metrics, "user")
 			if err != nil {
 				return err
 			}
+			if diffContent {
+				if shallow {
+					return fmt.Errorf("--content cannot be used with --shallow")
+				}
+				var maxContentBytes int64
+				if diffMaxBytes != "" {
+					if maxContentBytes, err = units.RAMInBytes(diffMaxBytes); err != nil {
+						return fmt.Errorf("invalid --max-bytes %q: %v", diffMaxBytes, err)
+					}
+				}
+				printDiff := func(diff *pfsclient.FileDiff) error {
+					oldName, newName := "/dev/null", "/dev/null"
+					if diff.OldFile != nil {
+						oldName = "a" + diff.OldFile.File.Path
+					}
+					if diff.NewFile != nil {
+						newName = "b" + diff.NewFile.File.Path
+					}
+					switch {
+					case diff.TooLarge:
+						fmt.Printf("Files %s and %s are too large to diff\n", oldName, newName)
+					case diff.Binary:
+						fmt.Printf("Binary files %s and %s differ\n", oldName, newName)
+					default:
+						fmt.Print(diff.Diff)
+					}
+					return nil
+				}
+				if len(args) == 6 {
+					return client.DiffFileContent(args[0], args[1], args[2], args[3], args[4], args[5], contextLines, maxContentBytes, printDiff)
+				}
+				return client.DiffFileContent(args[0], args[1], args[2], "", "", "", contextLines, maxContentBytes, printDiff)
+			}
 			var newFiles []*pfsclient.FileInfo
 			var oldFiles []*pfsclient.FileInfo
 			switch {
@@ -1003,6 +1845,9 @@ $ pachctl diff-file foo master path1 bar master path2
 		}),
 	}
 	diffFile.Flags().BoolVarP(&shallow, "shallow", "s", false, "Specifies whether or not to diff subdirectories")
+	diffFile.Flags().BoolVar(&diffContent, "content", false, "Show unified diffs of the contents of the files that differ.")
+	diffFile.Flags().Int64VarP(&contextLines, "unified", "U", 3, "The number of lines of context shown around each change; needs to be used with --content.")
+	diffFile.Flags().StringVar(&diffMaxBytes, "max-bytes", "", "The size (e.g. 100MB) above which files aren't diffed (10MB by default); needs to be used with --content.")
 
 	deleteFile := &cobra.Command{
 		Use:   "delete-file repo-name commit-id path/to/file",
@@ -1017,6 +1862,77 @@ $ pachctl diff-file foo master path1 bar master path2
 		}),
 	}
 
+	purgeFile := &cobra.Command{
+		Use:   "purge-file repo-name path/to/file",
+		Short: "Remove a file from every commit in a repo.",
+		Long: `Remove a file (or directory) from every commit in a repo, for use when data
+must be forgotten. Unlike delete-file, which only removes a file from new
+commits, purge-file rewrites the repo's history.
+
+Downstream commits that were derived from the rewritten commits are deleted,
+and their pipelines reprocess the current heads of their inputs. The purged
+data stays in object storage until the next garbage-collect. When a file is
+purged from a pipeline's output repo, the datums that produced it are
+reprocessed by the pipeline's next job.
+
+Each purge is recorded in a tamper-evident log, which can be read with
+list-purge-records. Only cluster admins may purge files.`,
+		Run: cmdutil.RunFixedArgs(2, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			purgeRecord, err := client.PurgeFile(args[0], args[1])
+			if err != nil {
+				return err
+			}
+			if raw {
+				return marshaller.Marshal(os.Stdout, purgeRecord)
+			}
+			writer := tabwriter.NewWriter(os.Stdout, pretty.PurgeRecordHeader)
+			pretty.PrintPurgeRecord(writer, purgeRecord)
+			return writer.Flush()
+		}),
+	}
+	rawFlag(purgeFile)
+
+	listPurgeRecords := &cobra.Command{
+		Use:   "list-purge-records",
+		Short: "Return the records of every purge-file operation.",
+		Long: `Return the records of every purge-file operation, and check that none of
+them have been altered or removed.`,
+		Run: cmdutil.RunFixedArgs(0, func(args []string) error {
+			client, err := client.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			purgeRecords, err := client.ListPurgeRecords()
+			if err != nil {
+				return err
+			}
+			if raw {
+				for _, purgeRecord := range purgeRecords {
+					if err := marshaller.Marshal(os.Stdout, purgeRecord); err != nil {
+						return err
+					}
+				}
+			} else {
+				writer := tabwriter.NewWriter(os.Stdout, pretty.PurgeRecordHeader)
+				for _, purgeRecord := range purgeRecords {
+					pretty.PrintPurgeRecord(writer, purgeRecord)
+				}
+				if err := writer.Flush(); err != nil {
+					return err
+				}
+			}
+			if err := pfsserver.VerifyPurgeRecords(purgeRecords); err != nil {
+				return fmt.Errorf("purge records failed verification: %v", err)
+			}
+			return nil
+		}),
+	}
+	rawFlag(listPurgeRecords)
+
 	getObject := &cobra.Command{
 		Use:   "get-object hash",
 		Short: "Return the contents of an object",
@@ -1044,11 +1960,40 @@ $ pachctl diff-file foo master path1 bar master path2
 	}
 
 	var debug bool
+	var write bool
 	var commits cmdutil.RepeatedStringArg
 	mount := &cobra.Command{
 		Use:   "mount path/to/mount/point",
 		Short: "Mount pfs locally. This command blocks.",
-		Long:  "Mount pfs locally. This command blocks.",
+		Long: `Mount pfs locally. This command blocks.
+
+By default the mount is read-only. With --write, files can be created,
+written, renamed and deleted, as can directories. The first write to a repo
+starts a commit on the branch mounted for it (master unless --commits says
+otherwise), written files are uploaded when they're closed, and the commits are
+finished when the mount is unmounted, or when pachctl receives SIGUSR1.
+Repos mounted at a specific commit remain read-only.
+
+Each repo also contains hidden, read-only directories exposing its history:
+repo/.commits/<commit>, repo/.branches/<branch> and repo/.tags/<tag>. These
+names are reserved: files or directories named .commits, .branches or .tags at
+the root of a repo aren't listed, read or written through the mount (use pachctl
+get-file and put-file instead). Files and directories in pfs have the extended
+attributes user.pfs.hash, user.pfs.commit and user.pfs.size.
+
+Examples:
+
+` + codestart + `# mount pfs read-only
+$ pachctl mount ~/pfs
+
+# mount pfs read-write, and finish the open commits without unmounting
+$ pachctl mount ~/pfs --write &
+$ cp data.csv ~/pfs/foo/data.csv
+$ kill -USR1 %1
+
+# compare two versions of a dataset
+$ diff -r ~/pfs/foo/.tags/v1 ~/pfs/foo/.branches/master
+` + codeend,
 		Run: cmdutil.RunFixedArgs(1, func(args []string) error {
 			client, err := client.NewOnUserMachine(metrics, "fuse")
 			if err != nil {
@@ -1064,11 +2009,19 @@ $ pachctl diff-file foo master path1 bar master path2
 					Debug: debug,
 				},
 				Commits: commits,
+				Write:   write,
+			}
+			if useCache {
+				if opts.Cache, err = localcache.NewOnUserMachine(); err != nil {
+					return err
+				}
 			}
 			return fuse.Mount(client, mountPoint, opts)
 		}),
 	}
 	mount.Flags().BoolVarP(&debug, "debug", "d", false, "Turn on debug messages.")
+	mount.Flags().BoolVarP(&write, "write", "w", false, "Make the mount writable, writes to each repo go to a commit on its mounted branch.")
+	cacheFlag(mount)
 	mount.Flags().VarP(&commits, "commits", "c", "Commits to mount for repos, arguments should be of the form \"repo:commit\"")
 
 	unmount := &cobra.Command{
@@ -1133,24 +2086,43 @@ $ pachctl diff-file foo master path1 bar master path2
 	result = append(result, commit)
 	result = append(result, startCommit)
 	result = append(result, finishCommit)
+	result = append(result, labelCommit)
 	result = append(result, inspectCommit)
 	result = append(result, listCommit)
 	result = append(result, flushCommit)
 	result = append(result, subscribeCommit)
+	result = append(result, subscribeFile)
 	result = append(result, deleteCommit)
+	result = append(result, squashCommit)
+	result = append(result, revertCommit)
 	result = append(result, createBranch)
 	result = append(result, listBranch)
 	result = append(result, setBranch)
 	result = append(result, deleteBranch)
+	result = append(result, mergeBranch)
+	result = append(result, protectBranch)
+	result = append(result, unprotectBranch)
+	result = append(result, tagCommit)
+	result = append(result, listCommitTag)
+	result = append(result, deleteCommitTag)
+	result = append(result, setRetention)
+	result = append(result, pruneCommits)
+	result = append(result, listPrunedCommits)
+	result = append(result, lockRepo)
 	result = append(result, file)
 	result = append(result, putFile)
 	result = append(result, copyFile)
 	result = append(result, getFile)
+	result = append(result, push)
+	result = append(result, pull)
 	result = append(result, inspectFile)
 	result = append(result, listFile)
 	result = append(result, globFile)
+	result = append(result, grepFile)
 	result = append(result, diffFile)
 	result = append(result, deleteFile)
+	result = append(result, purgeFile)
+	result = append(result, listPurgeRecords)
 	result = append(result, getObject)
 	result = append(result, getTag)
 	result = append(result, mount)
@@ -1161,7 +2133,8 @@ $ pachctl diff-file foo master path1 bar master path2
 func parseCommits(args []string) (map[string]string, error) {
 	result := make(map[string]string)
 	for _, arg := range args {
-		split := strings.Split(arg, ":")
+		// commits may contain ':' (e.g. "master@{2006-01-02T15:04:05Z}")
+		split := strings.SplitN(arg, ":", 2)
 		if len(split) != 2 {
 			return nil, fmt.Errorf("malformed input %s, must be of the form repo:commit", args)
 		}
@@ -1170,8 +2143,60 @@ func parseCommits(args []string) (map[string]string, error) {
 	return result, nil
 }
 
+// parseRepoBranch parses an argument of the form "repo@branch" as used by push
+// and pull. The branch defaults to "master".
+func parseRepoBranch(arg string) (string, string) {
+	split := strings.SplitN(arg, "@", 2)
+	if len(split) == 1 || split[1] == "" {
+		return split[0], "master"
+	}
+	return split[0], split[1]
+}
+
+// parseQuota parses a human-readable repo quota, such as "10GB". The empty
+// string means no quota.
+func parseQuota(quota string) (uint64, error) {
+	if quota == "" {
+		return 0, nil
+	}
+	quotaBytes, err := units.RAMInBytes(quota)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quota %q: %v", quota, err)
+	}
+	return uint64(quotaBytes), nil
+}
+
+// parseRetentionDuration parses the age limit of a retention policy or the
+// period of a retention lock. In addition to the units understood by
+// time.ParseDuration, it accepts a number of days or (365-day) years, such as
+// "90d" or "7y". The empty string means no limit.
+func parseRetentionDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseUint(strings.TrimSuffix(s, "d"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %v", s, err)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	if strings.HasSuffix(s, "y") {
+		years, err := strconv.ParseUint(strings.TrimSuffix(s, "y"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %v", s, err)
+		}
+		return time.Duration(years) * 365 * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %v", s, err)
+	}
+	return d, nil
+}
+
 func putFileHelper(c *client.APIClient, pfc client.PutFileClient,
-	repo, commit, path, source string, recursive, overwrite bool, // destination
+	repo, commit, path, source string, recursive, overwrite bool, metadata map[string]string, expectedSha256 string, // destination
 	limiter limit.ConcurrencyLimiter,
 	split string, targetFileDatums, targetFileBytes, headerRecords uint, // split
 	filesPut *gosync.Map) (retErr error) {
@@ -1182,6 +2207,10 @@ func putFileHelper(c *client.APIClient, pfc client.PutFileClient,
 	}
 	putFile := func(reader io.ReadSeeker) error {
 		if split == "" {
+			if len(metadata) > 0 || expectedSha256 != "" {
+				_, err := pfc.PutFileWithChecksum(repo, commit, path, reader, overwrite, metadata, expectedSha256)
+				return err
+			}
 			if overwrite {
 				return sync.PushFile(c, pfc, client.NewFile(repo, commit, path), reader)
 			}
@@ -1218,9 +2247,12 @@ func putFileHelper(c *client.APIClient, pfc client.PutFileClient,
 	}
 	// try parsing the filename as a url, if it is one do a PutFileURL
 	if url, err := url.Parse(source); err == nil && url.Scheme != "" {
+		if expectedSha256 != "" {
+			return fmt.Errorf("--sha256 cannot be used when putting a URL")
+		}
 		limiter.Acquire()
 		defer limiter.Release()
-		return pfc.PutFileURL(repo, commit, path, url.String(), recursive, overwrite)
+		return pfc.PutFileURLWithMetadata(repo, commit, path, url.String(), recursive, overwrite, metadata)
 	}
 	if recursive {
 		var eg errgroup.Group
@@ -1238,7 +2270,7 @@ func putFileHelper(c *client.APIClient, pfc client.PutFileClient,
 				// filePath into childDest, and then this walk loop will go on to the
 				// next one
 				return putFileHelper(c, pfc, repo, commit, childDest, filePath, false,
-					overwrite, limiter, split, targetFileDatums, targetFileBytes,
+					overwrite, metadata, expectedSha256, limiter, split, targetFileDatums, targetFileBytes,
 					headerRecords, filesPut)
 			})
 			return nil
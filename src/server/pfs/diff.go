@@ -0,0 +1,140 @@
+package pfs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// binarySniffBytes is the number of bytes at the start of a file that
+// IsBinary looks at (the same as git)
+const binarySniffBytes = 8000
+
+// IsBinary returns true if 'data' looks like binary data rather than text,
+// i.e. if it contains a NUL byte near its start.
+func IsBinary(data []byte) bool {
+	if len(data) > binarySniffBytes {
+		data = data[:binarySniffBytes]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// diffLine is a single line of a unified diff
+type diffLine struct {
+	// op is ' ' for unchanged lines, '-' for deleted ones and '+' for
+	// inserted ones
+	op   byte
+	text string
+}
+
+// diffLines returns the line-level diff from 'oldText' to 'newText'.
+func diffLines(oldText, newText string) []diffLine {
+	dmp := diffmatchpatch.New()
+	oldRunes, newRunes, lineArray := dmp.DiffLinesToRunes(oldText, newText)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMainRunes(oldRunes, newRunes, false), lineArray)
+	var result []diffLine
+	for _, diff := range diffs {
+		op := byte(' ')
+		switch diff.Type {
+		case diffmatchpatch.DiffDelete:
+			op = '-'
+		case diffmatchpatch.DiffInsert:
+			op = '+'
+		}
+		for _, line := range strings.SplitAfter(diff.Text, "\n") {
+			if line != "" {
+				result = append(result, diffLine{op: op, text: line})
+			}
+		}
+	}
+	return result
+}
+
+// UnifiedDiff returns a unified diff (in the format of 'diff -u') from
+// 'oldText' to 'newText', with 'contextLines' unchanged lines around each
+// change. 'oldName' and 'newName' are used in the diff's header (e.g.
+// "a/file" or "/dev/null"). If the texts are the same, "" is returned.
+func UnifiedDiff(oldName, newName, oldText, newText string, contextLines int) string {
+	if contextLines < 0 {
+		contextLines = 0
+	}
+	lines := diffLines(oldText, newText)
+	// oldLine[i] and newLine[i] are the (1-based) numbers that lines[i] has,
+	// or would have, in each text
+	oldLine, newLine := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	oldLine[0], newLine[0] = 1, 1
+	for i, line := range lines {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if line.op != '+' {
+			oldLine[i+1]++
+		}
+		if line.op != '-' {
+			newLine[i+1]++
+		}
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+		// lines[i] is the first change in a new hunk, which extends until
+		// there are enough unchanged lines to separate it from the next one
+		start := i - contextLines
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(lines) {
+			if lines[end].op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].op == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*contextLines {
+				break
+			}
+			end = next
+		}
+		end += contextLines
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n",
+			hunkRange(oldLine[start], oldLine[end]-oldLine[start]),
+			hunkRange(newLine[start], newLine[end]-newLine[start]))
+		for _, line := range lines[start:end] {
+			buf.WriteByte(line.op)
+			buf.WriteString(line.text)
+			if !strings.HasSuffix(line.text, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return buf.String()
+}
+
+// hunkRange formats the range of lines covered by a hunk in one of the
+// texts, in the same way as GNU diff.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		// an empty range is given as the line before it
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprintf("%d", start)
+	default:
+		return fmt.Sprintf("%d,%d", start, count)
+	}
+}
@@ -12,26 +12,52 @@ import (
 
 	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/pachyderm/pachyderm/src/client"
 	"github.com/pachyderm/pachyderm/src/client/pfs"
+	"github.com/pachyderm/pachyderm/src/server/pkg/localcache"
+	"github.com/sirupsen/logrus"
 )
 
 type file struct {
+	fs      *filesystem
 	name    string
 	attr    *fuse.Attr
 	cancel  func()
 	pfsFile *pfs.File
 	file    *os.File
 	counter *counter
-	err     error
+	// size is the number of bytes that are downloaded into file
+	size int64
+	err  error
+
+	// mu protects the fields below, as well as attr, name and pfsFile once
+	// the file is open.
+	mu sync.Mutex
+	// dirty is true if file has been written to since it was last uploaded
+	dirty bool
+	// unlinked is true if the file has been deleted while open, in which
+	// case it's not uploaded
+	unlinked bool
 }
 
 func newFile(fs *filesystem, name string) (*file, fuse.Status) {
+	return openFile(fs, name, true)
+}
+
+// openFile opens the file called 'name', if 'download' is false the file
+// starts out empty rather than containing the content in pfs.
+func openFile(fs *filesystem, name string, download bool) (*file, fuse.Status) {
 	attr, status := fs.getAttr(name)
+	if status == fuse.ENOENT && !download {
+		// the file is being created
+		attr, status = &fuse.Attr{Mode: fs.mode(modeFile)}, fuse.OK
+	}
 	if status != fuse.OK {
 		return nil, status
 	}
-	ctx, cancel := context.WithCancel(fs.c.Ctx())
-	c := fs.c.WithCtx(ctx)
+	if attr.Mode&fuse.S_IFDIR == fuse.S_IFDIR {
+		return nil, fuse.Status(syscall.EISDIR)
+	}
 	f, err := ioutil.TempFile("", "pfs-fuse")
 	if err != nil {
 		return nil, fuse.ToStatus(err)
@@ -53,14 +79,26 @@ func newFile(fs *filesystem, name string) (*file, fuse.Status) {
 	// bytes has been written to f.
 	w := io.MultiWriter(f, counter)
 	result := &file{
+		fs:      fs,
+		name:    name,
 		attr:    attr,
-		cancel:  cancel,
+		cancel:  func() {},
 		pfsFile: pfsFile,
 		file:    f,
 		counter: counter,
+		size:    int64(attr.Size),
+	}
+	if !download {
+		result.size = 0
+		result.attr.Size = 0
+		counter.cancel()
+		return result, fuse.OK
 	}
+	ctx, cancel := context.WithCancel(fs.c.Ctx())
+	c := fs.c.WithCtx(ctx)
+	result.cancel = cancel
 	go func() {
-		if err := c.GetFile(pfsFile.Commit.Repo.Name, pfsFile.Commit.ID, pfsFile.Path, 0, 0, w); err != nil {
+		if err := fs.getFile(c, pfsFile, w); err != nil {
 			result.err = err
 			counter.cancel()
 		}
@@ -68,8 +106,43 @@ func newFile(fs *filesystem, name string) (*file, fuse.Status) {
 	return result, fuse.OK
 }
 
+// getFile writes the content of 'pfsFile' to 'w', through the cache if the
+// mount has one.
+func (fs *filesystem) getFile(c *client.APIClient, pfsFile *pfs.File, w io.Writer) error {
+	if fs.cache != nil {
+		return localcache.GetFile(c, fs.cache, pfsFile.Commit.Repo.Name, pfsFile.Commit.ID, pfsFile.Path, w)
+	}
+	return c.GetFile(pfsFile.Commit.Repo.Name, pfsFile.Commit.ID, pfsFile.Path, 0, 0, w)
+}
+
+// waitDownload waits for the file's content to be fully downloaded, it must
+// be called before the file is modified.
+func (f *file) waitDownload() fuse.Status {
+	f.counter.wait(f.size)
+	if f.err != nil {
+		return toStatus(f.err)
+	}
+	return fuse.OK
+}
+
 func (f *file) Write(data []byte, off int64) (written uint32, code fuse.Status) {
-	return 0, fuse.EROFS
+	if !f.fs.write {
+		return 0, fuse.EROFS
+	}
+	if status := f.waitDownload(); status != fuse.OK {
+		return 0, status
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.file.WriteAt(data, off)
+	if err != nil {
+		return uint32(n), fuse.ToStatus(err)
+	}
+	if end := uint64(off) + uint64(n); end > f.attr.Size {
+		f.attr.Size = end
+	}
+	f.dirty = true
+	return uint32(n), fuse.OK
 }
 
 func (f *file) SetInode(*nodefs.Inode) {}
@@ -84,8 +157,8 @@ func (f *file) InnerFile() nodefs.File {
 
 func (f *file) Read(dest []byte, offset int64) (fuse.ReadResult, fuse.Status) {
 	waitn := offset + int64(len(dest))
-	if waitn > int64(f.attr.Size) {
-		waitn = int64(f.attr.Size)
+	if waitn > f.size {
+		waitn = f.size
 	}
 	f.counter.wait(waitn)
 	// check if there was an error reading the file
@@ -104,22 +177,45 @@ func (f *file) Flock(flags int) fuse.Status {
 
 func (f *file) Flush() fuse.Status {
 	// For reasons I don't understand Flush gets called when reading files.
-	return fuse.OK
+	return f.upload()
 }
 
 func (f *file) Release() {
+	if status := f.upload(); status != fuse.OK {
+		logrus.Errorf("error uploading %s: %v", f.name, status)
+	}
+	f.fs.releaseFile(f)
 	f.cancel()
+	f.file.Close()
 }
 
 func (f *file) Fsync(flags int) (code fuse.Status) {
-	return fuse.EROFS
+	if !f.fs.write {
+		return fuse.EROFS
+	}
+	return f.upload()
 }
 
 func (f *file) Truncate(size uint64) fuse.Status {
-	return fuse.EROFS
+	if !f.fs.write {
+		return fuse.EROFS
+	}
+	if status := f.waitDownload(); status != fuse.OK {
+		return status
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.file.Truncate(int64(size)); err != nil {
+		return fuse.ToStatus(err)
+	}
+	f.attr.Size = size
+	f.dirty = true
+	return fuse.OK
 }
 
 func (f *file) GetAttr(out *fuse.Attr) fuse.Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	*out = *f.attr
 	return fuse.OK
 }
@@ -133,11 +229,51 @@ func (f *file) Chmod(perms uint32) fuse.Status {
 }
 
 func (f *file) Utimens(atime *time.Time, mtime *time.Time) fuse.Status {
+	// pfs doesn't store times, but tools like touch expect to be able to
+	// set them on writable files
+	if f.fs.write {
+		return fuse.OK
+	}
 	return fuse.EROFS
 }
 
 func (f *file) Allocate(off uint64, size uint64, mode uint32) fuse.Status {
-	return fuse.EROFS
+	if !f.fs.write {
+		return fuse.EROFS
+	}
+	if status := f.waitDownload(); status != fuse.OK {
+		return status
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if end := off + size; end > f.attr.Size {
+		if err := f.file.Truncate(int64(end)); err != nil {
+			return fuse.ToStatus(err)
+		}
+		f.attr.Size = end
+		f.dirty = true
+	}
+	return fuse.OK
+}
+
+// upload uploads the file's content to pfs if it has been written to, the
+// content goes to the commit that the mount has open in the file's repo.
+func (f *file) upload() fuse.Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.dirty || f.unlinked {
+		return fuse.OK
+	}
+	commit, err := f.fs.startCommit(f.pfsFile.Commit.Repo.Name)
+	if err != nil {
+		return toStatus(err)
+	}
+	r := io.NewSectionReader(f.file, 0, int64(f.attr.Size))
+	if _, err := f.fs.c.PutFileOverwrite(commit.Repo.Name, commit.ID, f.pfsFile.Path, r, 0); err != nil {
+		return toStatus(err)
+	}
+	f.dirty = false
+	return fuse.OK
 }
 
 type counter struct {
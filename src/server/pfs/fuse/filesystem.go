@@ -5,40 +5,66 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 
 	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
 	"github.com/hanwen/go-fuse/fuse/pathfs"
 	"github.com/pachyderm/pachyderm/src/client"
 	"github.com/pachyderm/pachyderm/src/client/pfs"
+	pfsserver "github.com/pachyderm/pachyderm/src/server/pfs"
+	"github.com/pachyderm/pachyderm/src/server/pkg/localcache"
 	"github.com/pachyderm/pachyderm/src/server/pkg/uuid"
+	"github.com/sirupsen/logrus"
 )
 
 const (
-	modeFile = fuse.S_IFREG | 0444 // everyone can read, no one can do anything else
-	modeDir  = fuse.S_IFDIR | 0555 // everyone can read and execute, no one can do anything else (execute permission is required to list a dir)
+	modeFile    = fuse.S_IFREG | 0444 // everyone can read, no one can do anything else
+	modeDir     = fuse.S_IFDIR | 0555 // everyone can read and execute, no one can do anything else (execute permission is required to list a dir)
+	modeSymlink = fuse.S_IFLNK | 0777 // symlink permissions are ignored, the target's apply
 )
 
 // Mount pfs to mountPoint, opts may be left nil.
 func Mount(c *client.APIClient, mountPoint string, opts *Options) error {
-	nfs := pathfs.NewPathNodeFs(newFileSystem(c, opts.getCommits()), nil)
+	fs := newFileSystem(c, opts.getCommits(), opts.getWrite())
+	fs.cache = opts.getCache()
+	nfs := pathfs.NewPathNodeFs(fs, nil)
 	server, _, err := nodefs.MountRoot(mountPoint, nfs.Root(), opts.getFuse())
 	if err != nil {
 		return fmt.Errorf("nodefs.MountRoot: %v", err)
 	}
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
+	syncChan := make(chan os.Signal, 1)
+	if opts.getWrite() {
+		signal.Notify(syncChan, syscall.SIGUSR1)
+	}
 	go func() {
-		select {
-		case <-sigChan:
-		case <-opts.getUnmount():
+		for {
+			select {
+			case <-sigChan:
+			case <-opts.getUnmount():
+			case <-syncChan:
+				fs.logSync()
+				continue
+			case <-opts.getSync():
+				fs.logSync()
+				continue
+			}
+			server.Unmount()
+			return
 		}
-		server.Unmount()
 	}()
 	server.Serve()
-	return nil
+	if fs.cache != nil {
+		stats := fs.cache.Stats()
+		logrus.Infof("cache: %d hits (%d bytes), %d misses (%d bytes)", stats.Hits, stats.HitBytes, stats.Misses, stats.MissBytes)
+	}
+	// Finish the commits started by the mount
+	return fs.sync()
 }
 
 type filesystem struct {
@@ -46,16 +72,47 @@ type filesystem struct {
 	c         *client.APIClient
 	commits   map[string]string
 	commitsMu sync.RWMutex
+	// cache, if set, caches the content of files that are read
+	cache *localcache.Cache
+	// refs maps the refs in views (see views.go) to the commits they're
+	// resolved to, it's protected by commitsMu
+	refs map[string]string
+
+	// write is true for writable mounts. The remaining fields are only used
+	// by them (see write.go).
+	write bool
+	// branches maps repos to the branches or commits that were requested
+	// for them (i.e. 'commits' before it was resolved)
+	branches map[string]string
+	// writeMu protects the fields below it
+	writeMu sync.Mutex
+	// open maps repos to the commits that the mount has started in them
+	open map[string]*pfs.Commit
+	// files maps names to the files that are open for writing
+	files map[string]*file
+	// dirs is the set of directories created by Mkdir. PFS doesn't store
+	// empty directories, so they're kept locally.
+	dirs map[string]bool
 }
 
-func newFileSystem(c *client.APIClient, commits map[string]string) pathfs.FileSystem {
+func newFileSystem(c *client.APIClient, commits map[string]string, write bool) *filesystem {
 	if commits == nil {
 		commits = make(map[string]string)
 	}
+	branches := make(map[string]string)
+	for repo, commit := range commits {
+		branches[repo] = commit
+	}
 	return &filesystem{
 		FileSystem: pathfs.NewDefaultFileSystem(),
 		c:          c,
 		commits:    commits,
+		refs:       make(map[string]string),
+		write:      write,
+		branches:   branches,
+		open:       make(map[string]*pfs.Commit),
+		files:      make(map[string]*file),
+		dirs:       make(map[string]bool),
 	}
 }
 
@@ -64,6 +121,9 @@ func (fs *filesystem) GetAttr(name string, context *fuse.Context) (*fuse.Attr, f
 }
 
 func (fs *filesystem) OpenDir(name string, context *fuse.Context) ([]fuse.DirEntry, fuse.Status) {
+	if v := parseView(name); v != nil {
+		return fs.viewDir(v)
+	}
 	var result []fuse.DirEntry
 	r, f, err := fs.parsePath(name)
 	if err != nil {
@@ -75,26 +135,29 @@ func (fs *filesystem) OpenDir(name string, context *fuse.Context) ([]fuse.DirEnt
 		if err != nil {
 			return nil, toStatus(err)
 		}
-		if commit == "" {
-			// master branch has no head, so we report an empty dir
-			return result, fuse.OK
-		}
-		if err := fs.c.ListFileF(r.Name, commit, "", 0, func(fi *pfs.FileInfo) error {
-			result = append(result, fileDirEntry(fi))
-			return nil
-		}); err != nil {
-			return nil, toStatus(err)
+		// if the master branch has no head, we report an empty dir
+		if commit != "" {
+			if err := fs.c.ListFileF(r.Name, commit, "", 0, func(fi *pfs.FileInfo) error {
+				// files with the names of views are unreachable (see
+				// commitsView), so they aren't listed either
+				if parseView(path.Join(r.Name, fi.File.Path)) != nil {
+					return nil
+				}
+				result = append(result, fs.fileDirEntry(fi))
+				return nil
+			}); err != nil {
+				return nil, toStatus(err)
+			}
 		}
 	case f != nil:
-		if f.Commit.ID == "" {
-			// master branch has no head, so we report an empty dir
-			return result, fuse.OK
-		}
-		if err := fs.c.ListFileF(f.Commit.Repo.Name, f.Commit.ID, f.Path, 0, func(fi *pfs.FileInfo) error {
-			result = append(result, fileDirEntry(fi))
-			return nil
-		}); err != nil {
-			return nil, toStatus(err)
+		// if the master branch has no head, we report an empty dir
+		if f.Commit.ID != "" {
+			if err := fs.c.ListFileF(f.Commit.Repo.Name, f.Commit.ID, f.Path, 0, func(fi *pfs.FileInfo) error {
+				result = append(result, fs.fileDirEntry(fi))
+				return nil
+			}); err != nil && !(isNotFound(err) && fs.isLocalDir(name)) {
+				return nil, toStatus(err)
+			}
 		}
 	default:
 		ris, err := fs.c.ListRepo()
@@ -104,19 +167,54 @@ func (fs *filesystem) OpenDir(name string, context *fuse.Context) ([]fuse.DirEnt
 		for _, ri := range ris {
 			result = append(result, repoDirEntry(ri))
 		}
+		return result, fuse.OK
 	}
-	return result, fuse.OK
+	return fs.addLocalEntries(name, result), fuse.OK
 }
 
 func (fs *filesystem) Open(name string, flags uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
 	f := int(flags)
 	writeFlags := os.O_WRONLY | os.O_RDWR
 	if f&writeFlags != 0 {
-		return nil, fuse.EROFS
+		if !fs.write || parseView(name) != nil {
+			return nil, fuse.EROFS
+		}
+		return fs.openForWrite(name, f&os.O_TRUNC != 0)
+	}
+	// upload pending writes, so that they're visible to the reader
+	if status := fs.flush(name); status != fuse.OK {
+		return nil, status
 	}
 	return newFile(fs, name)
 }
 
+func (fs *filesystem) Readlink(name string, context *fuse.Context) (string, fuse.Status) {
+	_, f, err := fs.parsePath(name)
+	if err != nil {
+		return "", toStatus(err)
+	}
+	if f == nil {
+		return "", fuse.EINVAL
+	}
+	fi, err := fs.c.InspectFile(f.Commit.Repo.Name, f.Commit.ID, f.Path)
+	if err != nil {
+		return "", toStatus(err)
+	}
+	if fi.FileType != pfs.FileType_SYMLINK {
+		return "", fuse.EINVAL
+	}
+	target := fi.SymlinkTarget
+	if path.IsAbs(target) {
+		// absolute targets are relative to the root of the repo, which is
+		// not the root of the mount
+		target, err = filepath.Rel(path.Dir(path.Join("/", f.Path)), target)
+		if err != nil {
+			return "", toStatus(err)
+		}
+	}
+	return target, fuse.OK
+}
+
 func (fs *filesystem) commit(repo string) (string, error) {
 	commitOrBranch := func() string {
 		fs.commitsMu.RLock()
@@ -127,19 +225,20 @@ func (fs *filesystem) commit(repo string) (string, error) {
 		// it's a commit, return it
 		return commitOrBranch, nil
 	}
-	// it's a branch, resolve the head and return that
+	// it's a branch (or a reference relative to one, like "master^" or
+	// "master@{2.days.ago}"), resolve it and return that
 	branch := commitOrBranch
 	if branch == "" {
 		branch = "master"
 	}
-	bi, err := fs.c.InspectBranch(repo, branch)
-	if err != nil {
+	ci, err := fs.c.InspectCommit(repo, branch)
+	if err != nil && !pfsserver.IsNoHeadErr(err) {
 		return "", err
 	}
 	fs.commitsMu.Lock()
 	defer fs.commitsMu.Unlock()
-	if bi.Head != nil {
-		fs.commits[repo] = bi.Head.ID
+	if ci != nil {
+		fs.commits[repo] = ci.Commit.ID
 	} else {
 		fs.commits[repo] = ""
 	}
@@ -154,6 +253,13 @@ func (fs *filesystem) parsePath(name string) (*pfs.Repo, *pfs.File, error) {
 	case len(components) == 1:
 		return client.NewRepo(components[0]), nil, nil
 	default:
+		if v := parseView(name); v != nil {
+			if v.ref == "" {
+				return nil, nil, nil
+			}
+			f, err := fs.viewFile(v)
+			return nil, f, err
+		}
 		commit, err := fs.commit(components[0])
 		if err != nil {
 			return nil, nil, err
@@ -163,6 +269,12 @@ func (fs *filesystem) parsePath(name string) (*pfs.Repo, *pfs.File, error) {
 }
 
 func (fs *filesystem) getAttr(name string) (*fuse.Attr, fuse.Status) {
+	if v := parseView(name); v != nil {
+		return fs.viewAttr(v)
+	}
+	if attr, ok := fs.localAttr(name); ok {
+		return attr, fuse.OK
+	}
 	r, f, err := fs.parsePath(name)
 	if err != nil {
 		return nil, toStatus(err)
@@ -185,7 +297,7 @@ func (fs *filesystem) repoAttr(r *pfs.Repo) (*fuse.Attr, fuse.Status) {
 		return nil, toStatus(err)
 	}
 	return &fuse.Attr{
-		Mode:      modeDir,
+		Mode:      fs.mode(modeDir),
 		Ctime:     uint64(ri.Created.Seconds),
 		Ctimensec: uint32(ri.Created.Nanos),
 		Mtime:     uint64(ri.Created.Seconds),
@@ -206,6 +318,8 @@ func fileMode(fi *pfs.FileInfo) uint32 {
 		return modeFile
 	case pfs.FileType_DIR:
 		return modeDir
+	case pfs.FileType_SYMLINK:
+		return modeSymlink
 	default:
 		return 0
 	}
@@ -216,21 +330,40 @@ func (fs *filesystem) fileAttr(f *pfs.File) (*fuse.Attr, fuse.Status) {
 	if err != nil {
 		return nil, toStatus(err)
 	}
+	size := fi.SizeBytes
+	if fi.FileType == pfs.FileType_SYMLINK {
+		size = uint64(len(fi.SymlinkTarget))
+	}
 	return &fuse.Attr{
-		Mode: fileMode(fi),
-		Size: fi.SizeBytes,
+		Mode: fs.mode(fileMode(fi)),
+		Size: size,
 	}, fuse.OK
 }
 
-func fileDirEntry(fi *pfs.FileInfo) fuse.DirEntry {
+func (fs *filesystem) fileDirEntry(fi *pfs.FileInfo) fuse.DirEntry {
 	return fuse.DirEntry{
-		Mode: fileMode(fi),
+		Mode: fs.mode(fileMode(fi)),
 		Name: path.Base(fi.File.Path),
 	}
 }
 
+// mode returns 'mode', made writable by its owner if the mount is writable.
+func (fs *filesystem) mode(mode uint32) uint32 {
+	if fs.write && mode&fuse.S_IFLNK != fuse.S_IFLNK {
+		return mode | 0200
+	}
+	return mode
+}
+
+func isNotFound(err error) bool {
+	return strings.Contains(err.Error(), "not found")
+}
+
 func toStatus(err error) fuse.Status {
-	if strings.Contains(err.Error(), "not found") {
+	if err == errReadOnly {
+		return fuse.EROFS
+	}
+	if isNotFound(err) {
 		return fuse.ENOENT
 	}
 	return fuse.EIO
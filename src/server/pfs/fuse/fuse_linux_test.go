@@ -0,0 +1,44 @@
+package fuse
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/pachyderm/pachyderm/src/client/pkg/require"
+	"github.com/pachyderm/pachyderm/src/server/pfs/server"
+)
+
+func getXAttr(t *testing.T, path string, attr string) string {
+	buf := make([]byte, 256)
+	n, err := syscall.Getxattr(path, attr, buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestXAttr(t *testing.T) {
+	c := server.GetPachClient(t)
+	require.NoError(t, c.CreateRepo("repo"))
+	commit, err := c.StartCommit("repo", "master")
+	require.NoError(t, err)
+	_, err = c.PutFile("repo", commit.ID, "dir/file", strings.NewReader("foo"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit("repo", commit.ID))
+	fi, err := c.InspectFile("repo", commit.ID, "dir/file")
+	require.NoError(t, err)
+	mount(t, c, nil, func(mountPoint string) {
+		for _, path := range []string{
+			filepath.Join(mountPoint, "repo", "dir", "file"),
+			filepath.Join(mountPoint, "repo", ".commits", commit.ID, "dir", "file"),
+		} {
+			require.Equal(t, hex.EncodeToString(fi.Hash), getXAttr(t, path, "user.pfs.hash"))
+			require.Equal(t, commit.ID, getXAttr(t, path, "user.pfs.commit"))
+			require.Equal(t, "3", getXAttr(t, path, "user.pfs.size"))
+			_, err := syscall.Getxattr(path, "user.other", make([]byte, 256))
+			require.YesError(t, err)
+		}
+		require.Equal(t, "3", getXAttr(t, filepath.Join(mountPoint, "repo", "dir"), "user.pfs.size"))
+	})
+}
@@ -1,6 +1,7 @@
 package fuse
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"io/ioutil"
 	"math/rand"
@@ -14,6 +15,8 @@ import (
 	"github.com/pachyderm/pachyderm/src/client/pfs"
 	"github.com/pachyderm/pachyderm/src/client/pkg/require"
 	"github.com/pachyderm/pachyderm/src/server/pfs/server"
+	"github.com/pachyderm/pachyderm/src/server/pkg/localcache"
+	"github.com/pachyderm/pachyderm/src/server/pkg/uuid"
 	"github.com/pachyderm/pachyderm/src/server/pkg/workload"
 )
 
@@ -133,18 +136,233 @@ func TestHeadlessBranch(t *testing.T) {
 	})
 }
 
+func TestWrite(t *testing.T) {
+	c := server.GetPachClient(t)
+	require.NoError(t, c.CreateRepo("repo"))
+	_, err := c.PutFile("repo", "master", "file", strings.NewReader("foo"))
+	require.NoError(t, err)
+	sync := make(chan struct{})
+	mountOpts(t, c, &Options{Write: true, Sync: sync}, func(mountPoint string) {
+		// overwrite an existing file and create a new one
+		require.NoError(t, ioutil.WriteFile(filepath.Join(mountPoint, "repo", "file"), []byte("bar"), 0644))
+		require.NoError(t, os.Mkdir(filepath.Join(mountPoint, "repo", "dir"), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(mountPoint, "repo", "dir", "new"), []byte("baz"), 0644))
+		data, err := ioutil.ReadFile(filepath.Join(mountPoint, "repo", "file"))
+		require.NoError(t, err)
+		require.Equal(t, "bar", string(data))
+
+		// append to a file
+		f, err := os.OpenFile(filepath.Join(mountPoint, "repo", "file"), os.O_WRONLY|os.O_APPEND, 0644)
+		require.NoError(t, err)
+		_, err = f.Write([]byte("buzz"))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		// the writes go to a single open commit
+		commitInfos, err := c.ListCommit("repo", "master", "", 0)
+		require.NoError(t, err)
+		require.Equal(t, 2, len(commitInfos))
+		require.Nil(t, commitInfos[0].Finished)
+
+		sync <- struct{}{}
+		require.NoErrorWithinT(t, 30*time.Second, func() error {
+			_, err := c.BlockCommit("repo", commitInfos[0].Commit.ID)
+			return err
+		})
+		var buffer bytes.Buffer
+		require.NoError(t, c.GetFile("repo", "master", "file", 0, 0, &buffer))
+		require.Equal(t, "barbuzz", buffer.String())
+		buffer.Reset()
+		require.NoError(t, c.GetFile("repo", "master", "dir/new", 0, 0, &buffer))
+		require.Equal(t, "baz", buffer.String())
+	})
+}
+
+func TestWriteRenameUnlinkMkdir(t *testing.T) {
+	c := server.GetPachClient(t)
+	require.NoError(t, c.CreateRepo("repo"))
+	_, err := c.PutFile("repo", "master", "a", strings.NewReader("foo"))
+	require.NoError(t, err)
+	_, err = c.PutFile("repo", "master", "b", strings.NewReader("bar"))
+	require.NoError(t, err)
+	mountOpts(t, c, &Options{Write: true}, func(mountPoint string) {
+		require.NoError(t, os.Mkdir(filepath.Join(mountPoint, "repo", "dir"), 0755))
+		files, err := ioutil.ReadDir(filepath.Join(mountPoint, "repo", "dir"))
+		require.NoError(t, err)
+		require.Equal(t, 0, len(files))
+		require.NoError(t, os.Rename(filepath.Join(mountPoint, "repo", "a"), filepath.Join(mountPoint, "repo", "dir", "a")))
+		require.NoError(t, os.Remove(filepath.Join(mountPoint, "repo", "b")))
+
+		files, err = ioutil.ReadDir(filepath.Join(mountPoint, "repo"))
+		require.NoError(t, err)
+		require.Equal(t, 1, len(files))
+		require.Equal(t, "dir", files[0].Name())
+		data, err := ioutil.ReadFile(filepath.Join(mountPoint, "repo", "dir", "a"))
+		require.NoError(t, err)
+		require.Equal(t, "foo", string(data))
+
+		// repos can't be created through the mount
+		require.YesError(t, os.Mkdir(filepath.Join(mountPoint, "repo2"), 0755))
+	})
+	// the commit is finished on unmount
+	require.NoErrorWithinT(t, 30*time.Second, func() error {
+		_, err := c.BlockCommit("repo", "master")
+		return err
+	})
+	fileInfos, err := c.ListFile("repo", "master", "")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(fileInfos))
+	require.Equal(t, "/dir", fileInfos[0].File.Path)
+}
+
+func TestReadOnly(t *testing.T) {
+	c := server.GetPachClient(t)
+	require.NoError(t, c.CreateRepo("repo"))
+	commit, err := c.StartCommit("repo", "master")
+	require.NoError(t, err)
+	_, err = c.PutFile("repo", commit.ID, "file", strings.NewReader("foo"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit("repo", commit.ID))
+	// without --write the mount is read-only
+	mount(t, c, nil, func(mountPoint string) {
+		require.YesError(t, ioutil.WriteFile(filepath.Join(mountPoint, "repo", "file"), []byte("bar"), 0644))
+	})
+	// repos mounted at a commit are read-only
+	mountOpts(t, c, &Options{Write: true, Commits: map[string]string{"repo": commit.ID}}, func(mountPoint string) {
+		require.YesError(t, ioutil.WriteFile(filepath.Join(mountPoint, "repo", "file"), []byte("bar"), 0644))
+	})
+}
+
+func TestWriteBranch(t *testing.T) {
+	fs := newFileSystem(nil, map[string]string{
+		"branch":   "staging",
+		"commit":   uuid.NewWithoutDashes(),
+		"ancestor": "master^",
+		"time":     "master@{1.day.ago}",
+	}, true)
+	for repo, expected := range map[string]string{
+		"unmounted": "master",
+		"branch":    "staging",
+	} {
+		branch, err := fs.writeBranch(repo)
+		require.NoError(t, err)
+		require.Equal(t, expected, branch)
+	}
+	for _, repo := range []string{"commit", "ancestor", "time"} {
+		_, err := fs.writeBranch(repo)
+		require.Equal(t, errReadOnly, err)
+	}
+	_, err := newFileSystem(nil, nil, false).writeBranch("repo")
+	require.Equal(t, errReadOnly, err)
+}
+
+func TestViews(t *testing.T) {
+	c := server.GetPachClient(t)
+	require.NoError(t, c.CreateRepo("repo"))
+	commit1, err := c.StartCommit("repo", "master")
+	require.NoError(t, err)
+	_, err = c.PutFile("repo", commit1.ID, "file", strings.NewReader("foo"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit("repo", commit1.ID))
+	require.NoError(t, c.CreateCommitTag("repo", commit1.ID, "v1"))
+	commit2, err := c.StartCommit("repo", "master")
+	require.NoError(t, err)
+	_, err = c.PutFileOverwrite("repo", commit2.ID, "file", strings.NewReader("bar"), 0)
+	require.NoError(t, err)
+	_, err = c.PutFile("repo", commit2.ID, ".tags", strings.NewReader("reserved"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit("repo", commit2.ID))
+	require.NoError(t, c.CreateBranch("repo", "old", commit1.ID, nil))
+	mount(t, c, nil, func(mountPoint string) {
+		// the views are hidden, as are the files that they shadow
+		files, err := ioutil.ReadDir(filepath.Join(mountPoint, "repo"))
+		require.NoError(t, err)
+		require.Equal(t, 1, len(files))
+
+		files, err = ioutil.ReadDir(filepath.Join(mountPoint, "repo", ".commits"))
+		require.NoError(t, err)
+		require.Equal(t, 2, len(files))
+		files, err = ioutil.ReadDir(filepath.Join(mountPoint, "repo", ".branches"))
+		require.NoError(t, err)
+		require.Equal(t, 2, len(files))
+		files, err = ioutil.ReadDir(filepath.Join(mountPoint, "repo", ".tags"))
+		require.NoError(t, err)
+		require.Equal(t, 1, len(files))
+		require.Equal(t, "v1", files[0].Name())
+
+		for _, test := range []struct {
+			path     string
+			expected string
+		}{
+			{filepath.Join(".commits", commit1.ID, "file"), "foo"},
+			{filepath.Join(".commits", commit2.ID, "file"), "bar"},
+			{filepath.Join(".branches", "old", "file"), "foo"},
+			{filepath.Join(".branches", "master", "file"), "bar"},
+			{filepath.Join(".tags", "v1", "file"), "foo"},
+		} {
+			data, err := ioutil.ReadFile(filepath.Join(mountPoint, "repo", test.path))
+			require.NoError(t, err)
+			require.Equal(t, test.expected, string(data))
+		}
+
+		_, err = os.Stat(filepath.Join(mountPoint, "repo", ".tags", "v2"))
+		require.YesError(t, err)
+	})
+	// the views are read-only, even in writable mounts
+	mountOpts(t, c, &Options{Write: true}, func(mountPoint string) {
+		require.YesError(t, ioutil.WriteFile(filepath.Join(mountPoint, "repo", ".branches", "master", "file"), []byte("buzz"), 0644))
+		require.YesError(t, os.Remove(filepath.Join(mountPoint, "repo", ".tags", "v1", "file")))
+	})
+}
+
+func TestCache(t *testing.T) {
+	c := server.GetPachClient(t)
+	require.NoError(t, c.CreateRepo("repo"))
+	_, err := c.PutFile("repo", "master", "file", strings.NewReader("foo"))
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cache, err := localcache.New(dir, MB)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		mountOpts(t, c, &Options{Cache: cache}, func(mountPoint string) {
+			data, err := ioutil.ReadFile(filepath.Join(mountPoint, "repo", "file"))
+			require.NoError(t, err)
+			require.Equal(t, "foo", string(data))
+		})
+	}
+	stats := cache.Stats()
+	require.Equal(t, int64(1), stats.Misses)
+	require.Equal(t, int64(1), stats.Hits)
+}
+
+func TestParseView(t *testing.T) {
+	require.Nil(t, parseView("repo"))
+	require.Nil(t, parseView("repo/dir/.commits"))
+	require.Equal(t, &view{repo: "repo", kind: commitsView}, parseView("repo/.commits"))
+	require.Equal(t, &view{repo: "repo", kind: branchesView, ref: "master"}, parseView("repo/.branches/master"))
+	require.Equal(t, &view{repo: "repo", kind: tagsView, ref: "v1", path: "dir/file"}, parseView("repo/.tags/v1/dir/file"))
+}
+
 func mount(tb testing.TB, c *client.APIClient, commits map[string]string, f func(mountPoint string)) {
+	mountOpts(tb, c, &Options{Commits: commits}, f)
+}
+
+// mountOpts mounts pfs with 'opts' for the duration of 'f', opts.Unmount is
+// set by mountOpts.
+func mountOpts(tb testing.TB, c *client.APIClient, opts *Options, f func(mountPoint string)) {
 	dir, err := ioutil.TempDir("", "pfs")
 	require.NoError(tb, err)
 	defer os.RemoveAll(dir)
-	opts := &Options{
-		Commits: commits,
-		Unmount: make(chan struct{}),
-	}
+	opts.Unmount = make(chan struct{})
+	done := make(chan struct{})
 	defer func() {
 		close(opts.Unmount)
+		<-done
 	}()
 	go func() {
+		defer close(done)
 		Mount(c, dir, opts)
 	}()
 	// Gotta give the fuse mount time to come up.
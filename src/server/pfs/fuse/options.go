@@ -1,6 +1,9 @@
 package fuse
 
-import "github.com/hanwen/go-fuse/fuse/nodefs"
+import (
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/pachyderm/pachyderm/src/server/pkg/localcache"
+)
 
 // Options is for configuring fuse mounts. Any of the fields may be left nil
 // and `nil` itself is a valid set of Options which uses the default for
@@ -12,6 +15,20 @@ type Options struct {
 	// will be used.
 	Commits map[string]string
 
+	// Write makes the mount writable. Writes to a repo go to a commit on the
+	// branch mounted for it (master by default), which is started on the
+	// first write and finished when the mount is synced or unmounted. Repos
+	// mounted at a specific commit remain read-only.
+	Write bool
+
+	// Sync, if set, causes the mount to finish the commits it has started
+	// each time it receives a value.
+	Sync chan struct{}
+
+	// Cache, if set, is used to cache the content of files read through the
+	// mount on local disk.
+	Cache *localcache.Cache
+
 	Unmount chan struct{}
 }
 
@@ -35,3 +52,24 @@ func (o *Options) getUnmount() chan struct{} {
 	}
 	return o.Unmount
 }
+
+func (o *Options) getWrite() bool {
+	if o == nil {
+		return false
+	}
+	return o.Write
+}
+
+func (o *Options) getSync() chan struct{} {
+	if o == nil {
+		return nil
+	}
+	return o.Sync
+}
+
+func (o *Options) getCache() *localcache.Cache {
+	if o == nil {
+		return nil
+	}
+	return o.Cache
+}
@@ -0,0 +1,237 @@
+package fuse
+
+import (
+	"encoding/hex"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/pachyderm/pachyderm/src/client"
+	"github.com/pachyderm/pachyderm/src/client/pfs"
+	pfsserver "github.com/pachyderm/pachyderm/src/server/pfs"
+)
+
+// Each repo in the mount contains hidden, read-only directories which expose
+// its history, independent of the commit mounted for it:
+//
+//	repo/.commits/<commit>/... is the content of a commit
+//	repo/.branches/<branch>/... is the content of the head of a branch
+//	repo/.tags/<tag>/...       is the content of the commit a tag points at
+//
+// They aren't returned when listing the repo (so that walking the mount
+// doesn't walk every commit), but can be listed and traversed directly, e.g.
+// `diff -r repo/.commits/<a> repo/.commits/<b>`. Like the repo itself,
+// branches are resolved to commits the first time they're accessed.
+//
+// The view names are reserved, so files with those names at the root of a
+// repo aren't listed or reachable through the mount (the mount's help text
+// says so). Checking for such files would cost a request to pfs on every
+// access to a view.
+const (
+	commitsView  = ".commits"
+	branchesView = ".branches"
+	tagsView     = ".tags"
+)
+
+// Extended attributes of files and directories in pfs.
+const (
+	xattrHash   = "user.pfs.hash"
+	xattrCommit = "user.pfs.commit"
+	xattrSize   = "user.pfs.size"
+)
+
+var xattrs = []string{xattrHash, xattrCommit, xattrSize}
+
+// view is a parsed path within one of the views.
+type view struct {
+	repo string
+	// kind is the name of the view, e.g. ".commits"
+	kind string
+	// ref is the commit, branch or tag, it's empty for the view itself.
+	ref string
+	// path is the path within ref.
+	path string
+}
+
+// parseView parses 'name' if it's in a view, returning nil if it's not.
+func parseView(name string) *view {
+	components := strings.SplitN(name, "/", 4)
+	if len(components) < 2 {
+		return nil
+	}
+	switch components[1] {
+	case commitsView, branchesView, tagsView:
+	default:
+		return nil
+	}
+	result := &view{
+		repo: components[0],
+		kind: components[1],
+	}
+	if len(components) > 2 {
+		result.ref = components[2]
+	}
+	if len(components) > 3 {
+		result.path = components[3]
+	}
+	return result
+}
+
+// resolve returns the commit that v's ref refers to, or "" if it's a branch
+// with no head.
+func (fs *filesystem) resolve(v *view) (string, error) {
+	key := path.Join(v.repo, v.kind, v.ref)
+	fs.commitsMu.RLock()
+	commit, ok := fs.refs[key]
+	fs.commitsMu.RUnlock()
+	if ok {
+		return commit, nil
+	}
+	ci, err := fs.c.InspectCommit(v.repo, v.ref)
+	if err != nil && !(v.kind == branchesView && pfsserver.IsNoHeadErr(err)) {
+		return "", err
+	}
+	if ci != nil {
+		commit = ci.Commit.ID
+	}
+	fs.commitsMu.Lock()
+	defer fs.commitsMu.Unlock()
+	fs.refs[key] = commit
+	return commit, nil
+}
+
+// viewFile returns the pfs file that v refers to.
+func (fs *filesystem) viewFile(v *view) (*pfs.File, error) {
+	commit, err := fs.resolve(v)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewFile(v.repo, commit, v.path), nil
+}
+
+func (fs *filesystem) viewAttr(v *view) (*fuse.Attr, fuse.Status) {
+	if v.ref == "" {
+		attr, status := fs.repoAttr(client.NewRepo(v.repo))
+		if status != fuse.OK {
+			return nil, status
+		}
+		attr.Mode = modeDir
+		return attr, fuse.OK
+	}
+	f, err := fs.viewFile(v)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if f.Path == "" {
+		return &fuse.Attr{Mode: modeDir}, fuse.OK
+	}
+	if f.Commit.ID == "" {
+		return nil, fuse.ENOENT
+	}
+	attr, status := fs.fileAttr(f)
+	if status != fuse.OK {
+		return nil, status
+	}
+	attr.Mode = readOnly(attr.Mode)
+	return attr, fuse.OK
+}
+
+func (fs *filesystem) viewDir(v *view) ([]fuse.DirEntry, fuse.Status) {
+	var result []fuse.DirEntry
+	var err error
+	switch {
+	case v.ref == "" && v.kind == commitsView:
+		err = fs.c.ListCommitF(v.repo, "", "", 0, func(ci *pfs.CommitInfo) error {
+			result = append(result, fuse.DirEntry{Name: ci.Commit.ID, Mode: modeDir})
+			return nil
+		})
+	case v.ref == "" && v.kind == branchesView:
+		var bis []*pfs.BranchInfo
+		bis, err = fs.c.ListBranch(v.repo)
+		for _, bi := range bis {
+			result = append(result, fuse.DirEntry{Name: bi.Name, Mode: modeDir})
+		}
+	case v.ref == "" && v.kind == tagsView:
+		var tis []*pfs.CommitTagInfo
+		tis, err = fs.c.ListCommitTag(v.repo)
+		for _, ti := range tis {
+			result = append(result, fuse.DirEntry{Name: ti.Tag.Name, Mode: modeDir})
+		}
+	default:
+		var f *pfs.File
+		f, err = fs.viewFile(v)
+		// if the branch has no head, we report an empty dir
+		if err == nil && f.Commit.ID != "" {
+			err = fs.c.ListFileF(v.repo, f.Commit.ID, f.Path, 0, func(fi *pfs.FileInfo) error {
+				entry := fs.fileDirEntry(fi)
+				entry.Mode = readOnly(entry.Mode)
+				result = append(result, entry)
+				return nil
+			})
+		}
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return result, fuse.OK
+}
+
+func (fs *filesystem) GetXAttr(name string, attribute string, context *fuse.Context) ([]byte, fuse.Status) {
+	// the kernel asks for attributes such as security.capability often, don't
+	// make a round trip to pachd for them
+	if !strings.HasPrefix(attribute, "user.pfs.") {
+		return nil, fuse.ENOATTR
+	}
+	fi, status := fs.inspectFile(name)
+	if status != fuse.OK {
+		return nil, status
+	}
+	switch attribute {
+	case xattrHash:
+		return []byte(hex.EncodeToString(fi.Hash)), fuse.OK
+	case xattrCommit:
+		return []byte(fi.File.Commit.ID), fuse.OK
+	case xattrSize:
+		return []byte(strconv.FormatUint(fi.SizeBytes, 10)), fuse.OK
+	}
+	return nil, fuse.ENOATTR
+}
+
+func (fs *filesystem) ListXAttr(name string, context *fuse.Context) ([]string, fuse.Status) {
+	if _, status := fs.inspectFile(name); status != fuse.OK {
+		return nil, status
+	}
+	return xattrs, fuse.OK
+}
+
+// inspectFile returns the pfs FileInfo for 'name', only files and directories
+// in pfs have extended attributes.
+func (fs *filesystem) inspectFile(name string) (*pfs.FileInfo, fuse.Status) {
+	_, f, err := fs.parsePath(name)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if f == nil || f.Commit.ID == "" {
+		return nil, fuse.ENOATTR
+	}
+	fi, err := fs.c.InspectFile(f.Commit.Repo.Name, f.Commit.ID, f.Path)
+	if err != nil {
+		if isNotFound(err) {
+			// the file may only exist locally
+			if _, status := fs.getAttr(name); status == fuse.OK {
+				return nil, fuse.ENOATTR
+			}
+		}
+		return nil, toStatus(err)
+	}
+	return fi, fuse.OK
+}
+
+// readOnly strips the write permissions from 'mode'.
+func readOnly(mode uint32) uint32 {
+	if mode&fuse.S_IFLNK == fuse.S_IFLNK {
+		return mode
+	}
+	return mode &^ 0222
+}
@@ -0,0 +1,483 @@
+package fuse
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/go-fuse/fuse/nodefs"
+	"github.com/pachyderm/pachyderm/src/client/pfs"
+	"github.com/pachyderm/pachyderm/src/server/pkg/ancestry"
+	"github.com/pachyderm/pachyderm/src/server/pkg/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// errReadOnly is returned when writing to a mount (or a repo within a mount)
+// that isn't writable.
+var errReadOnly = errors.New("read-only")
+
+// writeBranch returns the branch that writes to 'repo' go to, or errReadOnly
+// if the repo isn't writable because the mount isn't writable or because the
+// repo is mounted at a commit rather than a branch.
+func (fs *filesystem) writeBranch(repo string) (string, error) {
+	if !fs.write {
+		return "", errReadOnly
+	}
+	branch := fs.branches[repo]
+	if branch == "" {
+		return "master", nil
+	}
+	if uuid.IsUUIDWithoutDashes(branch) {
+		return "", errReadOnly
+	}
+	if _, n := ancestry.Parse(branch); n != 0 {
+		return "", errReadOnly
+	}
+	if _, _, ok, err := ancestry.ParseTime(branch, time.Now()); ok || err != nil {
+		return "", errReadOnly
+	}
+	return branch, nil
+}
+
+// startCommit returns the commit that the mount has open in 'repo', starting
+// it if necessary. Once a commit is started, reads from the repo go to it so
+// that writes are visible through the mount.
+func (fs *filesystem) startCommit(repo string) (*pfs.Commit, error) {
+	branch, err := fs.writeBranch(repo)
+	if err != nil {
+		return nil, err
+	}
+	fs.writeMu.Lock()
+	defer fs.writeMu.Unlock()
+	if commit, ok := fs.open[repo]; ok {
+		return commit, nil
+	}
+	commit, err := fs.c.StartCommit(repo, branch)
+	if err != nil {
+		return nil, err
+	}
+	fs.open[repo] = commit
+	fs.commitsMu.Lock()
+	defer fs.commitsMu.Unlock()
+	fs.commits[repo] = commit.ID
+	return commit, nil
+}
+
+// sync uploads the files that have been written to and finishes the commits
+// that the mount has started.
+func (fs *filesystem) sync() error {
+	var files []*file
+	func() {
+		fs.writeMu.Lock()
+		defer fs.writeMu.Unlock()
+		for _, f := range fs.files {
+			files = append(files, f)
+		}
+	}()
+	for _, f := range files {
+		if status := f.upload(); status != fuse.OK {
+			return fmt.Errorf("error uploading %s: %v", f.name, status)
+		}
+	}
+	fs.writeMu.Lock()
+	defer fs.writeMu.Unlock()
+	for repo, commit := range fs.open {
+		if err := fs.c.FinishCommit(commit.Repo.Name, commit.ID); err != nil {
+			return fmt.Errorf("error finishing commit %s@%s: %v", repo, commit.ID, err)
+		}
+		delete(fs.open, repo)
+	}
+	return nil
+}
+
+// logSync syncs the mount, logging the result.
+func (fs *filesystem) logSync() {
+	if err := fs.sync(); err != nil {
+		logrus.Errorf("error syncing mount: %v", err)
+		return
+	}
+	logrus.Infof("synced mount")
+}
+
+// openForWrite opens 'name' for writing, if 'truncate' is true its existing
+// content isn't downloaded.
+func (fs *filesystem) openForWrite(name string, truncate bool) (nodefs.File, fuse.Status) {
+	if parseView(name) != nil {
+		return nil, fuse.EROFS
+	}
+	if _, err := fs.writeBranch(strings.Split(name, "/")[0]); err != nil {
+		return nil, toStatus(err)
+	}
+	if !strings.Contains(name, "/") {
+		return nil, fuse.Status(syscall.EISDIR)
+	}
+	// upload pending writes from other handles, so that they're downloaded
+	if status := fs.flush(name); status != fuse.OK {
+		return nil, status
+	}
+	f, status := openFile(fs, name, !truncate)
+	if status != fuse.OK {
+		return nil, status
+	}
+	if truncate {
+		// the file's truncation needs to be uploaded even if it's never
+		// written to
+		f.dirty = true
+	}
+	fs.writeMu.Lock()
+	defer fs.writeMu.Unlock()
+	fs.files[name] = f
+	return f, fuse.OK
+}
+
+// flush uploads the files open for writing at 'name' or beneath it.
+func (fs *filesystem) flush(name string) fuse.Status {
+	for _, f := range fs.openFiles(name) {
+		if status := f.upload(); status != fuse.OK {
+			return status
+		}
+	}
+	return fuse.OK
+}
+
+// openFiles returns the files open for writing at 'name' or beneath it.
+func (fs *filesystem) openFiles(name string) []*file {
+	fs.writeMu.Lock()
+	defer fs.writeMu.Unlock()
+	var result []*file
+	for fileName, f := range fs.files {
+		if isBeneath(fileName, name) {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// releaseFile forgets 'f' once it's closed.
+func (fs *filesystem) releaseFile(f *file) {
+	fs.writeMu.Lock()
+	defer fs.writeMu.Unlock()
+	for name, file := range fs.files {
+		if file == f {
+			delete(fs.files, name)
+		}
+	}
+}
+
+// localAttr returns the attributes of 'name' if it's a file open for writing
+// or a directory created by the mount.
+func (fs *filesystem) localAttr(name string) (*fuse.Attr, bool) {
+	fs.writeMu.Lock()
+	f, ok := fs.files[name]
+	dir := fs.dirs[name]
+	fs.writeMu.Unlock()
+	if ok {
+		attr := &fuse.Attr{}
+		f.GetAttr(attr)
+		return attr, true
+	}
+	if dir {
+		return &fuse.Attr{Mode: fs.mode(modeDir)}, true
+	}
+	return nil, false
+}
+
+// isLocalDir returns true if 'name' is a directory that only exists locally.
+func (fs *filesystem) isLocalDir(name string) bool {
+	fs.writeMu.Lock()
+	defer fs.writeMu.Unlock()
+	if fs.dirs[name] {
+		return true
+	}
+	for fileName := range fs.files {
+		if isBeneath(fileName, name) && fileName != name {
+			return true
+		}
+	}
+	return false
+}
+
+// addLocalEntries adds the files and directories that only exist locally to
+// the entries of directory 'name'.
+func (fs *filesystem) addLocalEntries(name string, entries []fuse.DirEntry) []fuse.DirEntry {
+	fs.writeMu.Lock()
+	defer fs.writeMu.Unlock()
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		seen[entry.Name] = true
+	}
+	add := func(entryName string, mode uint32) {
+		if path.Dir(entryName) != name || seen[path.Base(entryName)] {
+			return
+		}
+		seen[path.Base(entryName)] = true
+		entries = append(entries, fuse.DirEntry{
+			Name: path.Base(entryName),
+			Mode: fs.mode(mode),
+		})
+	}
+	for fileName := range fs.files {
+		add(fileName, modeFile)
+		// parent directories of new files might not exist in pfs either
+		for dir := path.Dir(fileName); dir != "."; dir = path.Dir(dir) {
+			add(dir, modeDir)
+		}
+	}
+	for dir := range fs.dirs {
+		add(dir, modeDir)
+	}
+	return entries
+}
+
+// exists returns whether 'name' exists in pfs, and whether it's a directory.
+func (fs *filesystem) exists(name string) (bool, bool, error) {
+	_, f, err := fs.parsePath(name)
+	if err != nil {
+		return false, false, err
+	}
+	if f.Commit.ID == "" {
+		return false, false, nil
+	}
+	fi, err := fs.c.InspectFile(f.Commit.Repo.Name, f.Commit.ID, f.Path)
+	if err != nil {
+		if isNotFound(err) {
+			return false, false, nil
+		}
+		return false, false, err
+	}
+	return true, fi.FileType == pfs.FileType_DIR, nil
+}
+
+// checkWritable returns an error status if 'name' can't be created, deleted
+// or renamed by the mount.
+func (fs *filesystem) checkWritable(name string) fuse.Status {
+	if parseView(name) != nil {
+		return fuse.EROFS
+	}
+	components := strings.Split(name, "/")
+	if _, err := fs.writeBranch(components[0]); err != nil {
+		return toStatus(err)
+	}
+	if len(components) == 1 {
+		// repos can't be created, deleted or renamed through the mount
+		return fuse.EPERM
+	}
+	return fuse.OK
+}
+
+func (fs *filesystem) Create(name string, flags uint32, mode uint32, context *fuse.Context) (nodefs.File, fuse.Status) {
+	if status := fs.checkWritable(name); status != fuse.OK {
+		return nil, status
+	}
+	return fs.openForWrite(name, true)
+}
+
+func (fs *filesystem) Mkdir(name string, mode uint32, context *fuse.Context) fuse.Status {
+	if status := fs.checkWritable(name); status != fuse.OK {
+		return status
+	}
+	if _, status := fs.getAttr(name); status == fuse.OK {
+		return fuse.Status(syscall.EEXIST)
+	}
+	fs.writeMu.Lock()
+	defer fs.writeMu.Unlock()
+	fs.dirs[name] = true
+	return fuse.OK
+}
+
+func (fs *filesystem) Unlink(name string, context *fuse.Context) fuse.Status {
+	if status := fs.checkWritable(name); status != fuse.OK {
+		return status
+	}
+	exists, isDir, err := fs.exists(name)
+	if err != nil {
+		return toStatus(err)
+	}
+	if isDir {
+		return fuse.Status(syscall.EISDIR)
+	}
+	local := fs.forget(name)
+	if !exists {
+		if !local {
+			return fuse.ENOENT
+		}
+		return fuse.OK
+	}
+	return fs.deleteFile(name)
+}
+
+func (fs *filesystem) Rmdir(name string, context *fuse.Context) fuse.Status {
+	if status := fs.checkWritable(name); status != fuse.OK {
+		return status
+	}
+	entries, status := fs.OpenDir(name, nil)
+	if status != fuse.OK {
+		return status
+	}
+	if len(entries) > 0 {
+		return fuse.Status(syscall.ENOTEMPTY)
+	}
+	exists, _, err := fs.exists(name)
+	if err != nil {
+		return toStatus(err)
+	}
+	fs.writeMu.Lock()
+	delete(fs.dirs, name)
+	fs.writeMu.Unlock()
+	if exists {
+		return fs.deleteFile(name)
+	}
+	return fuse.OK
+}
+
+func (fs *filesystem) Rename(oldName string, newName string, context *fuse.Context) fuse.Status {
+	if status := fs.checkWritable(oldName); status != fuse.OK {
+		return status
+	}
+	if status := fs.checkWritable(newName); status != fuse.OK {
+		return status
+	}
+	if isBeneath(newName, oldName) {
+		return fuse.EINVAL
+	}
+	// upload pending writes, so that they're copied along with the rest
+	if status := fs.flush(oldName); status != fuse.OK {
+		return status
+	}
+	exists, _, err := fs.exists(oldName)
+	if err != nil {
+		return toStatus(err)
+	}
+	if exists {
+		oldRepo, oldPath := splitName(oldName)
+		newRepo, newPath := splitName(newName)
+		oldCommit, err := fs.startCommit(oldRepo)
+		if err != nil {
+			return toStatus(err)
+		}
+		newCommit, err := fs.startCommit(newRepo)
+		if err != nil {
+			return toStatus(err)
+		}
+		if err := fs.c.CopyFile(oldRepo, oldCommit.ID, oldPath, newRepo, newCommit.ID, newPath, true); err != nil {
+			return toStatus(err)
+		}
+		if err := fs.c.DeleteFile(oldRepo, oldCommit.ID, oldPath); err != nil {
+			return toStatus(err)
+		}
+	} else if !fs.isLocalDir(oldName) && len(fs.openFiles(oldName)) == 0 {
+		return fuse.ENOENT
+	}
+	fs.move(oldName, newName)
+	return fuse.OK
+}
+
+func (fs *filesystem) Truncate(name string, size uint64, context *fuse.Context) fuse.Status {
+	if !fs.write {
+		return fuse.EROFS
+	}
+	for _, f := range fs.openFiles(name) {
+		if f.name == name {
+			return f.Truncate(size)
+		}
+	}
+	f, status := fs.openForWrite(name, size == 0)
+	if status != fuse.OK {
+		return status
+	}
+	defer f.Release()
+	return f.Truncate(size)
+}
+
+func (fs *filesystem) Utimens(name string, atime *time.Time, mtime *time.Time, context *fuse.Context) fuse.Status {
+	// pfs doesn't store times, but tools like touch expect to be able to set
+	// them on writable files
+	if fs.write && parseView(name) == nil {
+		return fuse.OK
+	}
+	return fuse.EROFS
+}
+
+// deleteFile deletes 'name' from pfs, in the commit that the mount has open
+// in its repo.
+func (fs *filesystem) deleteFile(name string) fuse.Status {
+	repo, filePath := splitName(name)
+	commit, err := fs.startCommit(repo)
+	if err != nil {
+		return toStatus(err)
+	}
+	if err := fs.c.DeleteFile(repo, commit.ID, filePath); err != nil {
+		return toStatus(err)
+	}
+	return fuse.OK
+}
+
+// forget forgets the local state for 'name', open files stop being uploaded.
+// It returns true if there was any.
+func (fs *filesystem) forget(name string) bool {
+	files := fs.openFiles(name)
+	for _, f := range files {
+		f.mu.Lock()
+		f.unlinked = true
+		f.mu.Unlock()
+	}
+	fs.writeMu.Lock()
+	defer fs.writeMu.Unlock()
+	for _, f := range files {
+		delete(fs.files, f.name)
+	}
+	_, dir := fs.dirs[name]
+	delete(fs.dirs, name)
+	return len(files) > 0 || dir
+}
+
+// move moves the local state for 'oldName' (and anything beneath it) to
+// 'newName'.
+func (fs *filesystem) move(oldName string, newName string) {
+	files := fs.openFiles(oldName)
+	newRepo, _ := splitName(newName)
+	for _, f := range files {
+		f.mu.Lock()
+		f.name = newName + strings.TrimPrefix(f.name, oldName)
+		_, filePath := splitName(f.name)
+		f.pfsFile = &pfs.File{
+			Commit: &pfs.Commit{Repo: &pfs.Repo{Name: newRepo}, ID: f.pfsFile.Commit.ID},
+			Path:   filePath,
+		}
+		f.mu.Unlock()
+	}
+	fs.writeMu.Lock()
+	defer fs.writeMu.Unlock()
+	for fileName := range fs.files {
+		if isBeneath(fileName, oldName) {
+			delete(fs.files, fileName)
+		}
+	}
+	for _, f := range files {
+		fs.files[f.name] = f
+	}
+	for dir := range fs.dirs {
+		if isBeneath(dir, oldName) {
+			delete(fs.dirs, dir)
+			fs.dirs[newName+strings.TrimPrefix(dir, oldName)] = true
+		}
+	}
+}
+
+// splitName splits a name in the mount into a repo and a path within it.
+func splitName(name string) (string, string) {
+	components := strings.SplitN(name, "/", 2)
+	if len(components) == 1 {
+		return components[0], ""
+	}
+	return components[0], components[1]
+}
+
+// isBeneath returns true if 'name' is 'dir' or is beneath it.
+func isBeneath(name string, dir string) bool {
+	return name == dir || strings.HasPrefix(name, dir+"/")
+}
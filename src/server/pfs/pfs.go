@@ -3,6 +3,7 @@ package pfs
 import (
 	"fmt"
 	"regexp"
+	"time"
 
 	"github.com/pachyderm/pachyderm/src/client/pfs"
 	"github.com/pachyderm/pachyderm/src/client/pkg/grpcutil"
@@ -28,6 +29,11 @@ type ErrCommitNotFound struct {
 	Commit *pfs.Commit
 }
 
+// ErrBranchNotFound represents a branch-not-found error.
+type ErrBranchNotFound struct {
+	Branch *pfs.Branch
+}
+
 // ErrNoHead represents an error encountered because a branch has no head (e.g.
 // inspectCommit(master) when 'master' has no commits)
 type ErrNoHead struct {
@@ -56,6 +62,45 @@ type ErrParentCommitNotFound struct {
 	Commit *pfs.Commit
 }
 
+// ErrCommitTagged represents an error where a commit can't be deleted because
+// a commit tag points at it (e.g. from DeleteCommit)
+type ErrCommitTagged struct {
+	Commit *pfs.Commit
+	Tag    *pfs.CommitTag
+}
+
+// ErrBranchProtected represents an error where an operation is disallowed by
+// a branch's protection rules (e.g. from DeleteBranch)
+type ErrBranchProtected struct {
+	Branch *pfs.Branch
+	Reason string
+}
+
+// ErrRepoQuotaExceeded represents an error where a write would make a repo
+// larger than its quota (e.g. from PutFile or FinishCommit)
+type ErrRepoQuotaExceeded struct {
+	Repo       *pfs.Repo
+	QuotaBytes uint64
+	SizeBytes  uint64
+}
+
+// ErrRetentionLocked represents an error where data can't be deleted because
+// it's protected by its repo's retention lock (e.g. from DeleteRepo). Commit
+// is nil if the whole repo is protected.
+type ErrRetentionLocked struct {
+	Repo          *pfs.Repo
+	Commit        *pfs.Commit
+	RetainedUntil time.Time
+}
+
+// ErrChecksumMismatch represents an error where the data written by PutFile
+// doesn't have the SHA-256 checksum that the caller expected.
+type ErrChecksumMismatch struct {
+	File     *pfs.File
+	Expected string
+	Actual   string
+}
+
 func (e ErrFileNotFound) Error() string {
 	return fmt.Sprintf("file %v not found in repo %v at commit %v", e.File.Path, e.File.Commit.Repo.Name, e.File.Commit.ID)
 }
@@ -72,6 +117,10 @@ func (e ErrCommitNotFound) Error() string {
 	return fmt.Sprintf("commit %v not found in repo %v", e.Commit.ID, e.Commit.Repo.Name)
 }
 
+func (e ErrBranchNotFound) Error() string {
+	return fmt.Sprintf("branch \"%s\" not found in repo %v", e.Branch.Name, e.Branch.Repo.Name)
+}
+
 func (e ErrNoHead) Error() string {
 	// the dashboard is matching on this message in stats. Please open an issue on the dash before changing this
 	return fmt.Sprintf("the branch \"%s\" has no head (create one with start-commit)", e.Branch.Name)
@@ -93,15 +142,44 @@ func (e ErrParentCommitNotFound) Error() string {
 	return fmt.Sprintf("parent commit %v not found in repo %v", e.Commit.ID, e.Commit.Repo.Name)
 }
 
+func (e ErrCommitTagged) Error() string {
+	return fmt.Sprintf("commit %v in repo %v is tagged \"%s\" (delete the tag first)", e.Commit.ID, e.Commit.Repo.Name, e.Tag.Name)
+}
+
+func (e ErrBranchProtected) Error() string {
+	return fmt.Sprintf("branch \"%s\" in repo %v is protected: %s", e.Branch.Name, e.Branch.Repo.Name, e.Reason)
+}
+
+func (e ErrRepoQuotaExceeded) Error() string {
+	return fmt.Sprintf("repo %v would exceed its quota of %d bytes (its size would be %d bytes)", e.Repo.Name, e.QuotaBytes, e.SizeBytes)
+}
+
+func (e ErrRetentionLocked) Error() string {
+	if e.Commit != nil {
+		return fmt.Sprintf("commit %v in repo %v is retention-locked until %s", e.Commit.ID, e.Repo.Name, e.RetainedUntil.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("repo %v is retention-locked until %s", e.Repo.Name, e.RetainedUntil.Format(time.RFC3339))
+}
+
+func (e ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("checksum mismatch for file %v in repo %v: expected sha256 %s but got %s", e.File.Path, e.File.Commit.Repo.Name, e.Expected, e.Actual)
+}
+
 // ByteRangeSize returns byteRange.Upper - byteRange.Lower.
 func ByteRangeSize(byteRange *pfs.ByteRange) uint64 {
 	return byteRange.Upper - byteRange.Lower
 }
 
 var (
-	commitNotFoundRe = regexp.MustCompile("commit [^ ]+ not found in repo [^ ]+")
-	commitDeletedRe  = regexp.MustCompile("commit [^ ]+/[^ ]+ was deleted")
-	commitFinishedRe = regexp.MustCompile("commit [^ ]+ in repo [^ ]+ has already finished")
+	commitNotFoundRe   = regexp.MustCompile("commit [^ ]+ not found in repo [^ ]+")
+	commitDeletedRe    = regexp.MustCompile("commit [^ ]+/[^ ]+ was deleted")
+	commitFinishedRe   = regexp.MustCompile("commit [^ ]+ in repo [^ ]+ has already finished")
+	branchNotFoundRe   = regexp.MustCompile("branch \"[^\"]+\" not found in repo [^ ]+")
+	noHeadRe           = regexp.MustCompile("the branch \"[^\"]+\" has no head")
+	branchProtectedRe  = regexp.MustCompile("branch \"[^\"]+\" in repo [^ ]+ is protected")
+	quotaExceededRe    = regexp.MustCompile("repo [^ ]+ would exceed its quota")
+	retentionLockedRe  = regexp.MustCompile("repo [^ ]+ is retention-locked until")
+	checksumMismatchRe = regexp.MustCompile("checksum mismatch for file [^ ]+ in repo [^ ]+")
 )
 
 // IsCommitNotFoundErr returns true if 'err' has an error message that matches
@@ -130,3 +208,57 @@ func IsCommitFinishedErr(err error) bool {
 	}
 	return commitFinishedRe.MatchString(grpcutil.ScrubGRPC(err).Error())
 }
+
+// IsBranchNotFoundErr returns true if 'err' has an error message that matches
+// ErrBranchNotFound
+func IsBranchNotFoundErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	return branchNotFoundRe.MatchString(grpcutil.ScrubGRPC(err).Error())
+}
+
+// IsNoHeadErr returns true if 'err' has an error message that matches
+// ErrNoHead
+func IsNoHeadErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	return noHeadRe.MatchString(grpcutil.ScrubGRPC(err).Error())
+}
+
+// IsBranchProtectedErr returns true if 'err' has an error message that matches
+// ErrBranchProtected
+func IsBranchProtectedErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	return branchProtectedRe.MatchString(grpcutil.ScrubGRPC(err).Error())
+}
+
+// IsRepoQuotaExceededErr returns true if 'err' has an error message that
+// matches ErrRepoQuotaExceeded
+func IsRepoQuotaExceededErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	return quotaExceededRe.MatchString(grpcutil.ScrubGRPC(err).Error())
+}
+
+// IsRetentionLockedErr returns true if 'err' has an error message that
+// matches ErrRetentionLocked
+func IsRetentionLockedErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	return retentionLockedRe.MatchString(grpcutil.ScrubGRPC(err).Error())
+}
+
+// IsChecksumMismatchErr returns true if 'err' has an error message that
+// matches ErrChecksumMismatch
+func IsChecksumMismatchErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	return checksumMismatchRe.MatchString(grpcutil.ScrubGRPC(err).Error())
+}
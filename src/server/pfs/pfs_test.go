@@ -1,9 +1,12 @@
 package pfs
 
 import (
+	"bytes"
 	"testing"
+	"time"
 
 	"github.com/pachyderm/pachyderm/src/client"
+	"github.com/pachyderm/pachyderm/src/client/pfs"
 	"github.com/pachyderm/pachyderm/src/client/pkg/require"
 )
 
@@ -20,4 +23,113 @@ func TestErrorMatching(t *testing.T) {
 	require.False(t, IsCommitFinishedErr(ErrCommitNotFound{c}))
 	require.False(t, IsCommitFinishedErr(ErrCommitDeleted{c}))
 	require.True(t, IsCommitFinishedErr(ErrCommitFinished{c}))
+
+	require.True(t, IsNoHeadErr(ErrNoHead{client.NewBranch("foo", "bar")}))
+	require.False(t, IsNoHeadErr(ErrCommitNotFound{c}))
+
+	require.True(t, IsRetentionLockedErr(ErrRetentionLocked{Repo: c.Repo, RetainedUntil: time.Now()}))
+	require.True(t, IsRetentionLockedErr(ErrRetentionLocked{Repo: c.Repo, Commit: c, RetainedUntil: time.Now()}))
+	require.False(t, IsRetentionLockedErr(ErrCommitNotFound{c}))
+
+	f := client.NewFile("foo", "bar", "/dir/file")
+	require.True(t, IsChecksumMismatchErr(ErrChecksumMismatch{File: f, Expected: "ab", Actual: "cd"}))
+	require.False(t, IsChecksumMismatchErr(ErrFileNotFound{f}))
+}
+
+func TestVerifyPurgeRecords(t *testing.T) {
+	var records []*pfs.PurgeRecord
+	var previousHash string
+	for i := 0; i < 3; i++ {
+		record := &pfs.PurgeRecord{
+			Index:        uint64(i),
+			Repo:         client.NewRepo("foo"),
+			PathSha256:   "abc",
+			PurgedBytes:  uint64(i * 10),
+			PreviousHash: previousHash,
+		}
+		var err error
+		record.Hash, err = HashPurgeRecord(record)
+		require.NoError(t, err)
+		records = append(records, record)
+		previousHash = record.Hash
+	}
+	require.NoError(t, VerifyPurgeRecords(records))
+	require.NoError(t, VerifyPurgeRecords(nil))
+
+	// A removed record breaks the chain
+	require.YesError(t, VerifyPurgeRecords([]*pfs.PurgeRecord{records[0], records[2]}))
+	require.YesError(t, VerifyPurgeRecords(records[1:]))
+
+	// An altered record no longer matches its hash
+	records[1].PurgedBytes = 0
+	require.YesError(t, VerifyPurgeRecords(records))
+
+	// Rehashing the altered record breaks the record after it
+	var err error
+	records[1].Hash, err = HashPurgeRecord(records[1])
+	require.NoError(t, err)
+	require.YesError(t, VerifyPurgeRecords(records))
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	require.Equal(t, "", UnifiedDiff("a/f", "b/f", "same\n", "same\n", 3))
+
+	require.Equal(t, `--- a/f
++++ b/f
+@@ -1,3 +1,3 @@
+ 1
+-2
++two
+ 3
+`, UnifiedDiff("a/f", "b/f", "1\n2\n3\n", "1\ntwo\n3\n", 3))
+
+	// Changes far enough apart are separate hunks, with limited context
+	old := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	require.Equal(t, `--- a/f
++++ b/f
+@@ -1,2 +1,2 @@
+-1
++one
+ 2
+@@ -8,2 +8,2 @@
+ 8
+-9
++nine
+`, UnifiedDiff("a/f", "b/f", old, "one\n2\n3\n4\n5\n6\n7\n8\nnine\n", 1))
+	// but they're merged if their context would overlap
+	require.Equal(t, `--- a/f
++++ b/f
+@@ -1,5 +1,5 @@
+ 1
+-2
++two
+ 3
+-4
++four
+ 5
+`, UnifiedDiff("a/f", "b/f", old, "1\ntwo\n3\nfour\n5\n6\n7\n8\n9\n", 1))
+
+	// Added and deleted files
+	require.Equal(t, `--- /dev/null
++++ b/f
+@@ -0,0 +1,2 @@
++1
++2
+`, UnifiedDiff("/dev/null", "b/f", "", "1\n2\n", 3))
+	require.Equal(t, `--- a/f
++++ b/f
+@@ -1 +1 @@
+-1
++1
+\ No newline at end of file
+`, UnifiedDiff("a/f", "b/f", "1\n", "1", 3))
+}
+
+func TestIsBinary(t *testing.T) {
+	require.False(t, IsBinary([]byte("text\n")))
+	require.True(t, IsBinary([]byte("bin\x00ary")))
+	// Only the start of the data is checked
+	data := bytes.Repeat([]byte("a"), binarySniffBytes+1)
+	data[binarySniffBytes] = 0
+	require.False(t, IsBinary(data))
 }
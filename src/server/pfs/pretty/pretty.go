@@ -1,12 +1,17 @@
 package pretty
 
 import (
+	"encoding/hex"
 	"fmt"
 	"html/template"
 	"io"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/docker/go-units"
+	"github.com/gogo/protobuf/types"
 	"github.com/pachyderm/pachyderm/src/client/pfs"
 	"github.com/pachyderm/pachyderm/src/server/pkg/pretty"
 )
@@ -20,8 +25,16 @@ const (
 	CommitHeader = "REPO\tCOMMIT\tPARENT\tSTARTED\tDURATION\tSIZE\t\n"
 	// BranchHeader is the header for branches.
 	BranchHeader = "BRANCH\tHEAD\t\n"
+	// CommitTagHeader is the header for commit tags.
+	CommitTagHeader = "TAG\tCOMMIT\tCREATED\t\n"
+	// PurgeRecordHeader is the header for purge records.
+	PurgeRecordHeader = "INDEX\tREPO\tPATH SHA256\tREWRITTEN COMMITS\tDELETED COMMITS\tSIZE\tPRINCIPAL\tPURGED\t\n"
+	// PrunedCommitHeader is the header for pruned commits.
+	PrunedCommitHeader = "COMMIT\tBRANCH\tSQUASHED INTO\tFINISHED\tPRUNED\t\n"
 	// FileHeader is the header for files.
 	FileHeader = "COMMIT\tNAME\tTYPE\tCOMMITTED\tSIZE\t\n"
+	// MergeConflictHeader is the header for merge conflicts.
+	MergeConflictHeader = "PATH\tCONFLICT\tOURS\tTHEIRS\t\n"
 )
 
 // PrintRepoHeader prints a repo header.
@@ -54,8 +67,12 @@ func PrintDetailedRepoInfo(repoInfo *pfs.RepoInfo) error {
 		`Name: {{.Repo.Name}}{{if .Description}}
 Description: {{.Description}}{{end}}
 Created: {{prettyAgo .Created}}
-Size: {{prettySize .SizeBytes}}{{if .AuthInfo}}
-Access level: {{ .AuthInfo.AccessLevel.String }}{{end}}
+Size: {{prettySize .SizeBytes}}{{if .QuotaBytes}}
+Quota: {{prettySize .QuotaBytes}}{{end}}{{if .Retention}}
+Retention: {{retention .Retention}}{{end}}{{if .RetentionLock}}
+Retention lock: {{retentionLock .RetentionLock}}{{end}}{{if .AuthInfo}}
+Access level: {{ .AuthInfo.AccessLevel.String }}{{end}}{{if .QuotaWarning}}
+Warning: {{.QuotaWarning}}{{end}}
 `)
 	if err != nil {
 		return err
@@ -82,6 +99,48 @@ func PrintBranch(w io.Writer, branchInfo *pfs.BranchInfo) {
 	}
 }
 
+// PrintCommitTagHeader prints a commit tag header.
+func PrintCommitTagHeader(w io.Writer) {
+	fmt.Fprint(w, CommitTagHeader)
+}
+
+// PrintCommitTagInfo pretty-prints commit tag info.
+func PrintCommitTagInfo(w io.Writer, commitTagInfo *pfs.CommitTagInfo) {
+	fmt.Fprintf(w, "%s\t", commitTagInfo.Tag.Name)
+	fmt.Fprintf(w, "%s\t", commitTagInfo.Commit.ID)
+	fmt.Fprintf(w, "%s\t\n", pretty.Ago(commitTagInfo.Created))
+}
+
+// PrintPrunedCommitHeader prints a pruned commit header.
+func PrintPrunedCommitHeader(w io.Writer) {
+	fmt.Fprint(w, PrunedCommitHeader)
+}
+
+// PrintPrunedCommitInfo pretty-prints pruned commit info.
+func PrintPrunedCommitInfo(w io.Writer, prunedCommitInfo *pfs.PrunedCommitInfo) {
+	fmt.Fprintf(w, "%s\t", prunedCommitInfo.Commit.ID)
+	fmt.Fprintf(w, "%s\t", prunedCommitInfo.Branch.Name)
+	fmt.Fprintf(w, "%s\t", prunedCommitInfo.SquashedInto.ID)
+	fmt.Fprintf(w, "%s\t", pretty.Ago(prunedCommitInfo.Finished))
+	fmt.Fprintf(w, "%s\t\n", pretty.Ago(prunedCommitInfo.Pruned))
+}
+
+// PrintPurgeRecord pretty-prints a purge record.
+func PrintPurgeRecord(w io.Writer, purgeRecord *pfs.PurgeRecord) {
+	fmt.Fprintf(w, "%d\t", purgeRecord.Index)
+	fmt.Fprintf(w, "%s\t", purgeRecord.Repo.Name)
+	fmt.Fprintf(w, "%s\t", purgeRecord.PathSha256)
+	fmt.Fprintf(w, "%d\t", len(purgeRecord.RewrittenCommits))
+	fmt.Fprintf(w, "%d\t", len(purgeRecord.DeletedCommits))
+	fmt.Fprintf(w, "%s\t", units.BytesSize(float64(purgeRecord.PurgedBytes)))
+	if purgeRecord.Principal != "" {
+		fmt.Fprintf(w, "%s\t", purgeRecord.Principal)
+	} else {
+		fmt.Fprintf(w, "-\t")
+	}
+	fmt.Fprintf(w, "%s\t\n", pretty.Ago(purgeRecord.Purged))
+}
+
 // PrintCommitInfoHeader prints a commit info header.
 func PrintCommitInfoHeader(w io.Writer) {
 	fmt.Fprint(w, CommitHeader)
@@ -112,10 +171,13 @@ func PrintDetailedCommitInfo(commitInfo *pfs.CommitInfo) error {
 	template, err := template.New("CommitInfo").Funcs(funcMap).Parse(
 		`Commit: {{.Commit.Repo.Name}}/{{.Commit.ID}}{{if .Description}}
 Description: {{.Description}}{{end}}{{if .ParentCommit}}
-Parent: {{.ParentCommit.ID}}{{end}}
+Parent: {{.ParentCommit.ID}}{{end}}{{if .MergeParent}}
+Merge Parent: {{.MergeParent.ID}}{{end}}
 Started: {{prettyAgo .Started}}{{if .Finished}}
 Finished: {{prettyAgo .Finished}} {{end}}
-Size: {{prettySize .SizeBytes}}{{if .Provenance}}
+Size: {{prettySize .SizeBytes}}{{if .Labels}}
+Labels: {{labels .Labels}}{{end}}{{if .Tags}}
+Tags: {{range .Tags}} {{.Name}} {{end}}{{end}}{{if .Provenance}}
 Provenance: {{range .Provenance}} {{.Repo.Name}}/{{.ID}} {{end}} {{end}}
 `)
 	if err != nil {
@@ -138,22 +200,40 @@ func PrintFileInfoHeader(w io.Writer) {
 // If fast is true and file size is 0, display "-" instead
 func PrintFileInfo(w io.Writer, fileInfo *pfs.FileInfo) {
 	fmt.Fprintf(w, "%s\t", fileInfo.File.Commit.ID)
-	fmt.Fprintf(w, "%s\t", fileInfo.File.Path)
-	if fileInfo.FileType == pfs.FileType_FILE {
-		fmt.Fprint(w, "file\t")
+	if fileInfo.FileType == pfs.FileType_SYMLINK {
+		fmt.Fprintf(w, "%s -> %s\t", fileInfo.File.Path, fileInfo.SymlinkTarget)
 	} else {
-		fmt.Fprint(w, "dir\t")
+		fmt.Fprintf(w, "%s\t", fileInfo.File.Path)
 	}
+	fmt.Fprintf(w, "%s\t", fileType(fileInfo.FileType))
 	fmt.Fprintf(w, "%s\t", pretty.Ago(fileInfo.Committed))
 	fmt.Fprintf(w, "%s\t\n", units.BytesSize(float64(fileInfo.SizeBytes)))
 }
 
+// PrintMergeConflict pretty-prints a merge conflict.
+func PrintMergeConflict(w io.Writer, conflict *pfs.MergeConflict) {
+	fmt.Fprintf(w, "%s\t", conflict.Path)
+	fmt.Fprintf(w, "%s\t", strings.ToLower(strings.Replace(conflict.Type.String(), "_", " ", -1)))
+	for _, fileInfo := range []*pfs.FileInfo{conflict.Ours, conflict.Theirs} {
+		if fileInfo == nil {
+			fmt.Fprint(w, "deleted\t")
+			continue
+		}
+		fmt.Fprintf(w, "%s\t", units.BytesSize(float64(fileInfo.SizeBytes)))
+	}
+	fmt.Fprintln(w)
+}
+
 // PrintDetailedFileInfo pretty-prints detailed file info.
 func PrintDetailedFileInfo(fileInfo *pfs.FileInfo) error {
 	template, err := template.New("FileInfo").Funcs(funcMap).Parse(
 		`Path: {{.File.Path}}
-Type: {{fileType .FileType}}
-Size: {{prettySize .SizeBytes}}
+Type: {{fileType .FileType}}{{if .SymlinkTarget}}
+Target: {{.SymlinkTarget}}{{end}}
+Size: {{prettySize .SizeBytes}}{{if .Metadata}}
+Metadata: {{labels .Metadata}}{{end}}{{if .Sha256}}
+SHA256: {{hex .Sha256}}{{end}}{{if .Md5}}
+MD5: {{hex .Md5}}{{end}}
 Children: {{range .Children}} {{.}} {{end}}
 `)
 	if err != nil {
@@ -169,14 +249,58 @@ func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
 
 func fileType(fileType pfs.FileType) string {
-	if fileType == pfs.FileType_FILE {
+	switch fileType {
+	case pfs.FileType_FILE:
 		return "file"
+	case pfs.FileType_SYMLINK:
+		return "symlink"
+	default:
+		return "dir"
+	}
+}
+
+func prettyLabels(labels map[string]string) string {
+	var pairs []string
+	for key, value := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+// RetentionPolicy pretty-prints a retention policy.
+func RetentionPolicy(policy *pfs.RetentionPolicy) string {
+	var limits []string
+	if policy.KeepLast > 0 {
+		limits = append(limits, fmt.Sprintf("last %d commits", policy.KeepLast))
+	}
+	if policy.KeepWithin != nil {
+		limits = append(limits, fmt.Sprintf("commits from the last %s", pretty.Duration(policy.KeepWithin)))
+	}
+	return "keep " + strings.Join(limits, " and ")
+}
+
+// RetentionLock pretty-prints a retention lock.
+func RetentionLock(lock *pfs.RetentionLock) string {
+	result := fmt.Sprintf("retain each commit for %s", pretty.Duration(lock.Period))
+	if len(lock.Branches) > 0 {
+		result += fmt.Sprintf(", lock branches %s", strings.Join(lock.Branches, ", "))
+	} else {
+		result += ", lock every branch"
+	}
+	if lock.RetainedUntil != nil {
+		until, _ := types.TimestampFromProto(lock.RetainedUntil)
+		result += fmt.Sprintf(" (the repo is retained until %s)", until.Format(time.RFC3339))
 	}
-	return "dir"
+	return result
 }
 
 var funcMap = template.FuncMap{
-	"prettyAgo":  pretty.Ago,
-	"prettySize": pretty.Size,
-	"fileType":   fileType,
+	"prettyAgo":     pretty.Ago,
+	"prettySize":    pretty.Size,
+	"fileType":      fileType,
+	"hex":           hex.EncodeToString,
+	"labels":        prettyLabels,
+	"retention":     RetentionPolicy,
+	"retentionLock": RetentionLock,
 }
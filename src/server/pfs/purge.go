@@ -0,0 +1,48 @@
+package pfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pachyderm/pachyderm/src/client/pfs"
+)
+
+// HashPurgeRecord returns the hash of 'record', which covers all of its
+// fields except Hash itself (including PreviousHash, which chains it to the
+// record before it).
+func HashPurgeRecord(record *pfs.PurgeRecord) (string, error) {
+	unhashed := proto.Clone(record).(*pfs.PurgeRecord)
+	unhashed.Hash = ""
+	data, err := unhashed.Marshal()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// VerifyPurgeRecords returns an error if 'records', which must be every purge
+// record in index order, don't form an unbroken hash chain, i.e. if any
+// record has been altered or removed.
+func VerifyPurgeRecords(records []*pfs.PurgeRecord) error {
+	var previousHash string
+	for i, record := range records {
+		if record.Index != uint64(i) {
+			return fmt.Errorf("purge record %d is missing", i)
+		}
+		if record.PreviousHash != previousHash {
+			return fmt.Errorf("purge record %d doesn't follow the record before it", i)
+		}
+		hash, err := HashPurgeRecord(record)
+		if err != nil {
+			return err
+		}
+		if hash != record.Hash {
+			return fmt.Errorf("purge record %d has been altered", i)
+		}
+		previousHash = record.Hash
+	}
+	return nil
+}
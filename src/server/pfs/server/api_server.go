@@ -71,7 +71,7 @@ func (a *apiServer) CreateRepo(ctx context.Context, request *pfs.CreateRepoReque
 	func() { a.Log(request, nil, nil, 0) }()
 	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
 
-	if err := a.driver.createRepo(a.getPachClient(ctx), request.Repo, request.Description, request.Update); err != nil {
+	if err := a.driver.createRepo(a.getPachClient(ctx), request.Repo, request.Description, request.QuotaBytes, request.SetQuota, request.Update); err != nil {
 		return nil, err
 	}
 	return &types.Empty{}, nil
@@ -113,7 +113,7 @@ func (a *apiServer) StartCommit(ctx context.Context, request *pfs.StartCommitReq
 	func() { a.Log(request, nil, nil, 0) }()
 	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
 
-	commit, err := a.driver.startCommit(a.getPachClient(ctx), request.Parent, request.Branch, request.Provenance, request.Description)
+	commit, err := a.driver.startCommit(a.getPachClient(ctx), request.Parent, request.Branch, request.Provenance, request.Description, request.Labels)
 	if err != nil {
 		return nil, err
 	}
@@ -138,7 +138,7 @@ func (a *apiServer) FinishCommit(ctx context.Context, request *pfs.FinishCommitR
 		if err := a.driver.finishOutputCommit(a.getPachClient(ctx), request.Commit, request.Trees, request.Datums, request.SizeBytes); err != nil {
 			return nil, err
 		}
-	} else if err := a.driver.finishCommit(a.getPachClient(ctx), request.Commit, request.Tree, request.Empty, request.Description); err != nil {
+	} else if err := a.driver.finishCommit(a.getPachClient(ctx), request.Commit, request.Tree, request.Empty, request.Description, request.Labels); err != nil {
 		return nil, err
 	}
 	return &types.Empty{}, nil
@@ -155,7 +155,7 @@ func (a *apiServer) ListCommit(ctx context.Context, request *pfs.ListCommitReque
 	func() { a.Log(request, nil, nil, 0) }()
 	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
 
-	commitInfos, err := a.driver.listCommit(a.getPachClient(ctx), request.Repo, request.To, request.From, request.Number)
+	commitInfos, err := a.driver.listCommit(a.getPachClient(ctx), request.Repo, request.To, request.From, request.Number, request.Selector)
 	if err != nil {
 		return nil, err
 	}
@@ -170,7 +170,7 @@ func (a *apiServer) ListCommitStream(req *pfs.ListCommitRequest, respServer pfs.
 	defer func(start time.Time) {
 		a.Log(req, fmt.Sprintf("stream containing %d commits", sent), retErr, time.Since(start))
 	}(time.Now())
-	return a.driver.listCommitF(a.getPachClient(respServer.Context()), req.Repo, req.To, req.From, req.Number, func(ci *pfs.CommitInfo) error {
+	return a.driver.listCommitF(a.getPachClient(respServer.Context()), req.Repo, req.To, req.From, req.Number, req.Selector, func(ci *pfs.CommitInfo) error {
 		sent++
 		return respServer.Send(ci)
 	})
@@ -213,6 +213,109 @@ func (a *apiServer) DeleteBranch(ctx context.Context, request *pfs.DeleteBranchR
 	return &types.Empty{}, nil
 }
 
+func (a *apiServer) MergeBranch(ctx context.Context, request *pfs.MergeBranchRequest) (response *pfs.MergeBranchResponse, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	return a.driver.mergeBranch(a.getPachClient(ctx), request.From, request.To, request.Strategy, request.Description)
+}
+
+func (a *apiServer) ProtectBranch(ctx context.Context, request *pfs.ProtectBranchRequest) (response *types.Empty, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	if request.Protection == nil {
+		return nil, fmt.Errorf("protection cannot be nil")
+	}
+	if err := a.driver.protectBranch(a.getPachClient(ctx), request.Branch, request.Protection); err != nil {
+		return nil, err
+	}
+	return &types.Empty{}, nil
+}
+
+func (a *apiServer) UnprotectBranch(ctx context.Context, request *pfs.UnprotectBranchRequest) (response *types.Empty, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	if err := a.driver.unprotectBranch(a.getPachClient(ctx), request.Branch); err != nil {
+		return nil, err
+	}
+	return &types.Empty{}, nil
+}
+
+func (a *apiServer) CreateCommitTag(ctx context.Context, request *pfs.CreateCommitTagRequest) (response *types.Empty, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	if err := a.driver.createCommitTag(a.getPachClient(ctx), request.Tag, request.Commit); err != nil {
+		return nil, err
+	}
+	return &types.Empty{}, nil
+}
+
+func (a *apiServer) ListCommitTag(ctx context.Context, request *pfs.ListCommitTagRequest) (response *pfs.CommitTagInfos, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	commitTags, err := a.driver.listCommitTag(a.getPachClient(ctx), request.Repo)
+	if err != nil {
+		return nil, err
+	}
+	return &pfs.CommitTagInfos{CommitTagInfo: commitTags}, nil
+}
+
+func (a *apiServer) DeleteCommitTag(ctx context.Context, request *pfs.DeleteCommitTagRequest) (response *types.Empty, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	if err := a.driver.deleteCommitTag(a.getPachClient(ctx), request.Tag); err != nil {
+		return nil, err
+	}
+	return &types.Empty{}, nil
+}
+
+func (a *apiServer) SetRetentionPolicy(ctx context.Context, request *pfs.SetRetentionPolicyRequest) (response *types.Empty, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	if err := a.driver.setRetentionPolicy(a.getPachClient(ctx), request.Repo, request.Branch, request.Policy); err != nil {
+		return nil, err
+	}
+	return &types.Empty{}, nil
+}
+
+func (a *apiServer) PruneCommits(ctx context.Context, request *pfs.PruneCommitsRequest) (response *pfs.PrunedCommitInfos, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	prunedCommits, err := a.driver.pruneCommits(a.getPachClient(ctx), request.Repo)
+	if err != nil {
+		return nil, err
+	}
+	return &pfs.PrunedCommitInfos{PrunedCommitInfo: prunedCommits}, nil
+}
+
+func (a *apiServer) ListPrunedCommits(ctx context.Context, request *pfs.ListPrunedCommitsRequest) (response *pfs.PrunedCommitInfos, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	prunedCommits, err := a.driver.listPrunedCommits(a.getPachClient(ctx), request.Repo)
+	if err != nil {
+		return nil, err
+	}
+	return &pfs.PrunedCommitInfos{PrunedCommitInfo: prunedCommits}, nil
+}
+
+func (a *apiServer) SetRetentionLock(ctx context.Context, request *pfs.SetRetentionLockRequest) (response *types.Empty, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	if err := a.driver.setRetentionLock(a.getPachClient(ctx), request.Repo, request.Period, request.Branches); err != nil {
+		return nil, err
+	}
+	return &types.Empty{}, nil
+}
+
 func (a *apiServer) DeleteCommit(ctx context.Context, request *pfs.DeleteCommitRequest) (response *types.Empty, retErr error) {
 	func() { a.Log(request, nil, nil, 0) }()
 	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
@@ -223,6 +326,33 @@ func (a *apiServer) DeleteCommit(ctx context.Context, request *pfs.DeleteCommitR
 	return &types.Empty{}, nil
 }
 
+func (a *apiServer) SquashCommit(ctx context.Context, request *pfs.SquashCommitRequest) (response *types.Empty, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	if err := a.driver.squashCommit(a.getPachClient(ctx), request.Range, request.Force); err != nil {
+		return nil, err
+	}
+	return &types.Empty{}, nil
+}
+
+func (a *apiServer) SetCommitLabels(ctx context.Context, request *pfs.SetCommitLabelsRequest) (response *types.Empty, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	if err := a.driver.setCommitLabels(a.getPachClient(ctx), request.Commit, request.Labels, request.Remove); err != nil {
+		return nil, err
+	}
+	return &types.Empty{}, nil
+}
+
+func (a *apiServer) RevertCommit(ctx context.Context, request *pfs.RevertCommitRequest) (response *pfs.Commit, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	return a.driver.revertCommit(a.getPachClient(ctx), request.Commit, request.Branch, request.Description)
+}
+
 func (a *apiServer) FlushCommit(request *pfs.FlushCommitRequest, stream pfs.API_FlushCommitServer) (retErr error) {
 	func() { a.Log(request, nil, nil, 0) }()
 	defer func(start time.Time) { a.Log(request, nil, retErr, time.Since(start)) }(time.Now())
@@ -234,7 +364,7 @@ func (a *apiServer) SubscribeCommit(request *pfs.SubscribeCommitRequest, stream
 	func() { a.Log(request, nil, nil, 0) }()
 	defer func(start time.Time) { a.Log(request, nil, retErr, time.Since(start)) }(time.Now())
 
-	return a.driver.subscribeCommit(a.getPachClient(stream.Context()), request.Repo, request.Branch, request.From, request.State, stream.Send)
+	return a.driver.subscribeCommit(a.getPachClient(stream.Context()), request.Repo, request.Branch, request.From, request.State, request.Selector, stream.Send)
 }
 
 func (a *apiServer) PutFile(putFileServer pfs.API_PutFileServer) (retErr error) {
@@ -296,14 +426,16 @@ func (a *apiServer) ListFile(ctx context.Context, request *pfs.ListFileRequest)
 	}(time.Now())
 
 	var fileInfos []*pfs.FileInfo
-	if err := a.driver.listFile(a.getPachClient(ctx), request.File, request.Full, request.History, func(fi *pfs.FileInfo) error {
+	nextPageToken, err := a.driver.listFilePage(a.getPachClient(ctx), request, func(fi *pfs.FileInfo) error {
 		fileInfos = append(fileInfos, fi)
 		return nil
-	}); err != nil {
+	})
+	if err != nil {
 		return nil, err
 	}
 	return &pfs.FileInfos{
-		FileInfo: fileInfos,
+		FileInfo:      fileInfos,
+		NextPageToken: nextPageToken,
 	}, nil
 }
 
@@ -313,10 +445,13 @@ func (a *apiServer) ListFileStream(request *pfs.ListFileRequest, respServer pfs.
 	defer func(start time.Time) {
 		a.Log(request, fmt.Sprintf("response stream with %d objects", sent), retErr, time.Since(start))
 	}(time.Now())
-	return a.driver.listFile(a.getPachClient(respServer.Context()), request.File, request.Full, request.History, func(fi *pfs.FileInfo) error {
+	// The stream can't return the next page token, so callers that page
+	// through a listing should use ListFile
+	_, err := a.driver.listFilePage(a.getPachClient(respServer.Context()), request, func(fi *pfs.FileInfo) error {
 		sent++
 		return respServer.Send(fi)
 	})
+	return err
 }
 
 func (a *apiServer) WalkFile(request *pfs.WalkFileRequest, server pfs.API_WalkFileServer) (retErr error) {
@@ -343,7 +478,7 @@ func (a *apiServer) GlobFile(ctx context.Context, request *pfs.GlobFileRequest)
 	}(time.Now())
 
 	var fileInfos []*pfs.FileInfo
-	if err := a.driver.globFile(a.getPachClient(ctx), request.Commit, request.Pattern, func(fi *pfs.FileInfo) error {
+	if err := a.driver.globFile(a.getPachClient(ctx), request.Commit, request.Pattern, false, func(fi *pfs.FileInfo) error {
 		fileInfos = append(fileInfos, fi)
 		return nil
 	}); err != nil {
@@ -360,12 +495,24 @@ func (a *apiServer) GlobFileStream(request *pfs.GlobFileRequest, respServer pfs.
 	defer func(start time.Time) {
 		a.Log(request, fmt.Sprintf("response stream with %d objects", sent), retErr, time.Since(start))
 	}(time.Now())
-	return a.driver.globFile(a.getPachClient(respServer.Context()), request.Commit, request.Pattern, func(fi *pfs.FileInfo) error {
+	return a.driver.globFile(a.getPachClient(respServer.Context()), request.Commit, request.Pattern, false, func(fi *pfs.FileInfo) error {
 		sent++
 		return respServer.Send(fi)
 	})
 }
 
+func (a *apiServer) GrepFile(request *pfs.GrepFileRequest, respServer pfs.API_GrepFileServer) (retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	var sent int
+	defer func(start time.Time) {
+		a.Log(request, fmt.Sprintf("response stream with %d matches", sent), retErr, time.Since(start))
+	}(time.Now())
+	return a.driver.grepFile(a.getPachClient(respServer.Context()), request, func(resp *pfs.GrepFileResponse) error {
+		sent++
+		return respServer.Send(resp)
+	})
+}
+
 func (a *apiServer) DiffFile(ctx context.Context, request *pfs.DiffFileRequest) (response *pfs.DiffFileResponse, retErr error) {
 	func() { a.Log(request, nil, nil, 0) }()
 	defer func(start time.Time) {
@@ -379,7 +526,10 @@ func (a *apiServer) DiffFile(ctx context.Context, request *pfs.DiffFileRequest)
 			a.Log(request, response, retErr, time.Since(start))
 		}
 	}(time.Now())
-	newFileInfos, oldFileInfos, err := a.driver.diffFile(a.getPachClient(ctx), request.NewFile, request.OldFile, request.Shallow)
+	if request.Content {
+		return nil, fmt.Errorf("content diffs are only returned by DiffFileStream")
+	}
+	newFileInfos, oldFileInfos, err := a.driver.diffFile(a.getPachClient(ctx), request.NewFile, request.OldFile, request.Shallow, false)
 	if err != nil {
 		return nil, err
 	}
@@ -389,6 +539,30 @@ func (a *apiServer) DiffFile(ctx context.Context, request *pfs.DiffFileRequest)
 	}, nil
 }
 
+func (a *apiServer) DiffFileStream(request *pfs.DiffFileRequest, respServer pfs.API_DiffFileStreamServer) (retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	var sent int
+	defer func(start time.Time) {
+		a.Log(request, fmt.Sprintf("response stream with %d objects", sent), retErr, time.Since(start))
+	}(time.Now())
+	return a.driver.diffFileStream(a.getPachClient(respServer.Context()), request, func(diff *pfs.FileDiff) error {
+		sent++
+		return respServer.Send(diff)
+	})
+}
+
+func (a *apiServer) SubscribeFile(request *pfs.SubscribeFileRequest, respServer pfs.API_SubscribeFileServer) (retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	var sent int
+	defer func(start time.Time) {
+		a.Log(request, fmt.Sprintf("response stream with %d objects", sent), retErr, time.Since(start))
+	}(time.Now())
+	return a.driver.subscribeFile(a.getPachClient(respServer.Context()), request, func(event *pfs.FileEvent) error {
+		sent++
+		return respServer.Send(event)
+	})
+}
+
 func (a *apiServer) DeleteFile(ctx context.Context, request *pfs.DeleteFileRequest) (response *types.Empty, retErr error) {
 	func() { a.Log(request, nil, nil, 0) }()
 	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
@@ -400,6 +574,24 @@ func (a *apiServer) DeleteFile(ctx context.Context, request *pfs.DeleteFileReque
 	return &types.Empty{}, nil
 }
 
+func (a *apiServer) PurgeFile(ctx context.Context, request *pfs.PurgeFileRequest) (response *pfs.PurgeRecord, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	return a.driver.purgeFile(a.getPachClient(ctx), request.Repo, request.Path)
+}
+
+func (a *apiServer) ListPurgeRecords(ctx context.Context, request *pfs.ListPurgeRecordsRequest) (response *pfs.PurgeRecords, retErr error) {
+	func() { a.Log(request, nil, nil, 0) }()
+	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	purgeRecords, err := a.driver.listPurgeRecords(a.getPachClient(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &pfs.PurgeRecords{PurgeRecord: purgeRecords}, nil
+}
+
 func (a *apiServer) DeleteAll(ctx context.Context, request *types.Empty) (response *types.Empty, retErr error) {
 	func() { a.Log(request, nil, nil, 0) }()
 	defer func(start time.Time) { a.Log(request, response, retErr, time.Since(start)) }(time.Now())
@@ -3,7 +3,11 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -21,15 +25,16 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/net/context"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
 
-	globlib "github.com/gobwas/glob"
 	"github.com/pachyderm/pachyderm/src/client"
 	"github.com/pachyderm/pachyderm/src/client/auth"
 	"github.com/pachyderm/pachyderm/src/client/limit"
 	"github.com/pachyderm/pachyderm/src/client/pfs"
 	"github.com/pachyderm/pachyderm/src/client/pkg/grpcutil"
+	"github.com/pachyderm/pachyderm/src/client/pkg/pbutil"
 	pfsserver "github.com/pachyderm/pachyderm/src/server/pfs"
 	"github.com/pachyderm/pachyderm/src/server/pkg/ancestry"
 	col "github.com/pachyderm/pachyderm/src/server/pkg/collection"
@@ -46,6 +51,8 @@ import (
 	etcd "github.com/coreos/etcd/clientv3"
 	"github.com/gogo/protobuf/proto"
 	"github.com/gogo/protobuf/types"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 const (
@@ -58,6 +65,19 @@ const (
 
 	// maxInt is the maximum value for 'int' (system-dependent). Not in 'math'!
 	maxInt = int(^uint(0) >> 1)
+
+	// quotaWarningFraction is the fraction of a repo's quota above which
+	// InspectRepo warns that the repo is nearly full
+	quotaWarningFraction = 0.9
+
+	// defaultGrepParallelism is the number of files that GrepFile searches at
+	// once if the request doesn't say
+	defaultGrepParallelism = 20
+	// maxGrepLineBytes is the longest line that GrepFile can search
+	maxGrepLineBytes = 16 * 1024 * 1024
+	// defaultMaxDiffBytes is the largest file whose contents DiffFileStream
+	// diffs if the request doesn't say
+	defaultMaxDiffBytes = 10 * 1024 * 1024
 )
 
 var (
@@ -74,6 +94,16 @@ func validateRepoName(name string) error {
 	return nil
 }
 
+// validateCommitTagName determines if a commit tag name is valid. Tag names
+// can't contain the characters used in ancestry and time references.
+func validateCommitTagName(name string) error {
+	match, _ := regexp.MatchString("^[a-zA-Z0-9_.-]+$", name)
+	if !match {
+		return fmt.Errorf("commit tag name (%v) invalid: only alphanumeric characters, underscores, dots, and dashes are allowed", name)
+	}
+	return nil
+}
+
 // IsPermissionError returns true if a given error is a permission error.
 func IsPermissionError(err error) bool {
 	return strings.Contains(err.Error(), "has already finished")
@@ -103,7 +133,10 @@ type driver struct {
 	putFileRecords col.Collection
 	commits        collectionFactory
 	branches       collectionFactory
+	commitTags     collectionFactory
+	prunedCommits  collectionFactory
 	openCommits    col.Collection
+	purgeRecords   col.Collection
 
 	// a cache for hashtrees
 	treeCache *hashtree.Cache
@@ -142,9 +175,16 @@ func newDriver(etcdAddresses []string, etcdPrefix string, treeCache *hashtree.Ca
 		branches: func(repo string) col.Collection {
 			return pfsdb.Branches(etcdClient, etcdPrefix, repo)
 		},
-		openCommits: pfsdb.OpenCommits(etcdClient, etcdPrefix),
-		treeCache:   treeCache,
-		storageRoot: storageRoot,
+		commitTags: func(repo string) col.Collection {
+			return pfsdb.CommitTags(etcdClient, etcdPrefix, repo)
+		},
+		prunedCommits: func(repo string) col.Collection {
+			return pfsdb.PrunedCommits(etcdClient, etcdPrefix, repo)
+		},
+		openCommits:  pfsdb.OpenCommits(etcdClient, etcdPrefix),
+		purgeRecords: pfsdb.PurgeRecords(etcdClient, etcdPrefix),
+		treeCache:    treeCache,
+		storageRoot:  storageRoot,
 		// Allow up to a third of the requested memory to be used for memory intensive operations
 		memoryLimiter: semaphore.NewWeighted(memoryRequest / 3),
 	}
@@ -173,6 +213,158 @@ func (d *driver) checkIsAuthorized(pachClient *client.APIClient, r *pfs.Repo, s
 	return nil
 }
 
+// branchOp is an operation that changes a branch, which may be disallowed by
+// the branch's protection rules
+type branchOp int
+
+const (
+	// branchOpCommit makes a new commit on a branch directly (e.g.
+	// StartCommit or PutFile)
+	branchOpCommit branchOp = iota
+	// branchOpMove advances a branch's head to a commit that already exists or
+	// that is created by merging another branch (e.g. CreateBranch or
+	// MergeBranch)
+	branchOpMove
+	// branchOpDelete deletes a branch
+	branchOpDelete
+	// branchOpRewrite deletes or rewrites commits in a branch's history,
+	// possibly including its head (e.g. DeleteCommit, SquashCommit or
+	// PurgeFile)
+	branchOpRewrite
+)
+
+// checkIsAuthorizedForBranch is like checkIsAuthorized, but additionally
+// returns an error if the protection rules of 'b' (if any) disallow the
+// current user from performing 'op' on it.
+func (d *driver) checkIsAuthorizedForBranch(pachClient *client.APIClient, b *pfs.Branch, s auth.Scope, op branchOp) error {
+	if err := d.checkIsAuthorized(pachClient, b.Repo, s); err != nil {
+		return err
+	}
+	branchInfo := &pfs.BranchInfo{}
+	if err := d.branches(b.Repo.Name).ReadOnly(pachClient.Ctx()).Get(b.Name, branchInfo); err != nil {
+		if col.IsErrNotFound(err) {
+			return nil // new branches aren't protected
+		}
+		return err
+	}
+	protection := branchInfo.Protection
+	if protection == nil {
+		return nil
+	}
+	switch op {
+	case branchOpDelete:
+		if protection.NoDelete {
+			return pfsserver.ErrBranchProtected{Branch: b, Reason: "it can't be deleted"}
+		}
+		return nil
+	case branchOpCommit:
+		if protection.MergeOnly {
+			return pfsserver.ErrBranchProtected{Branch: b, Reason: "commits can only be made on it by merging or by pointing it at an existing commit"}
+		}
+	case branchOpRewrite:
+		if protection.MergeOnly {
+			return pfsserver.ErrBranchProtected{Branch: b, Reason: "its history can't be rewritten"}
+		}
+	}
+	if len(protection.AllowedPrincipals) == 0 {
+		return nil
+	}
+	me, err := pachClient.WhoAmI(pachClient.Ctx(), &auth.WhoAmIRequest{})
+	if auth.IsErrNotActivated(err) {
+		return nil // without auth, there are no principals to restrict
+	}
+	if err != nil {
+		return fmt.Errorf("error during authorization check for operation on \"%s\": %v",
+			b.Repo.Name, grpcutil.ScrubGRPC(err))
+	}
+	if me.IsAdmin {
+		return nil
+	}
+	for _, principal := range protection.AllowedPrincipals {
+		if principal == me.Username {
+			return nil
+		}
+	}
+	return pfsserver.ErrBranchProtected{Branch: b, Reason: fmt.Sprintf("%s may not advance its head", me.Username)}
+}
+
+// checkIsAuthorizedToRewrite returns an error if any of the commits in
+// 'rewritten' (which span the repos in 'affectedRepos') is in the history of
+// a protected branch that the current user may not rewrite. A nil
+// 'pachClient' skips the check, for the PFS master, which only applies
+// retention policies that the repos' owners have set.
+func (d *driver) checkIsAuthorizedToRewrite(pachClient *client.APIClient, stm col.STM, rewritten map[string]*pfs.CommitInfo, affectedRepos map[string]struct{}) error {
+	if pachClient == nil {
+		return nil
+	}
+	// A commit starts after its parent, so a branch's history can't contain
+	// a rewritten commit once it's older than the oldest one in the repo (a
+	// commit without a start time doesn't bound the walk)
+	started := func(commitInfo *pfs.CommitInfo) (time.Time, error) {
+		if commitInfo.Started == nil {
+			return time.Time{}, nil
+		}
+		return types.TimestampFromProto(commitInfo.Started)
+	}
+	oldest := make(map[string]time.Time)
+	for _, commitInfo := range rewritten {
+		t, err := started(commitInfo)
+		if err != nil {
+			return err
+		}
+		repo := commitInfo.Commit.Repo.Name
+		if o, ok := oldest[repo]; !ok || t.Before(o) {
+			oldest[repo] = t
+		}
+	}
+	for repo := range affectedRepos {
+		if _, ok := oldest[repo]; !ok {
+			continue
+		}
+		repoInfo := &pfs.RepoInfo{}
+		if err := d.repos.ReadWrite(stm).Get(repo, repoInfo); err != nil {
+			return err
+		}
+		for _, branch := range repoInfo.Branches {
+			branchInfo := &pfs.BranchInfo{}
+			if err := d.branches(repo).ReadWrite(stm).Get(branch.Name, branchInfo); err != nil {
+				if col.IsErrNotFound(err) {
+					continue
+				}
+				return err
+			}
+			if branchInfo.Protection == nil {
+				continue
+			}
+			// Walk the branch's history until it reaches a rewritten commit,
+			// or a commit older than all of them. Rewritten commits may
+			// already have been deleted, so check them before reading each
+			// commit.
+			for commit := branchInfo.Head; commit != nil; {
+				if _, ok := rewritten[commit.ID]; ok {
+					if err := d.checkIsAuthorizedForBranch(pachClient, branchInfo.Branch, auth.Scope_WRITER, branchOpRewrite); err != nil {
+						return err
+					}
+					break
+				}
+				commitInfo := &pfs.CommitInfo{}
+				if err := d.commits(repo).ReadWrite(stm).Get(commit.ID, commitInfo); err != nil {
+					return err
+				}
+				t, err := started(commitInfo)
+				if err != nil {
+					return err
+				}
+				if !t.IsZero() && t.Before(oldest[repo]) {
+					break
+				}
+				commit = commitInfo.ParentCommit
+			}
+		}
+	}
+	return nil
+}
+
 func now() *types.Timestamp {
 	t, err := types.TimestampProto(time.Now())
 	if err != nil {
@@ -189,7 +381,7 @@ func absent(key string) etcd.Cmp {
 	return etcd.Compare(etcd.CreateRevision(key), "=", 0)
 }
 
-func (d *driver) createRepo(pachClient *client.APIClient, repo *pfs.Repo, description string, update bool) error {
+func (d *driver) createRepo(pachClient *client.APIClient, repo *pfs.Repo, description string, quotaBytes uint64, setQuota bool, update bool) error {
 	ctx := pachClient.Ctx()
 	// Check that the user is logged in (user doesn't need any access level to
 	// create a repo, but they must be authenticated if auth is active)
@@ -203,7 +395,7 @@ func (d *driver) createRepo(pachClient *client.APIClient, repo *pfs.Repo, descri
 		return err
 	}
 	if update {
-		return d.updateRepo(pachClient, repo, description)
+		return d.updateRepo(pachClient, repo, description, quotaBytes, setQuota)
 	}
 
 	_, err = col.NewSTM(ctx, d.etcdClient, func(stm col.STM) error {
@@ -243,13 +435,14 @@ func (d *driver) createRepo(pachClient *client.APIClient, repo *pfs.Repo, descri
 			Repo:        repo,
 			Created:     now(),
 			Description: description,
+			QuotaBytes:  quotaBytes,
 		}
 		return repos.Create(repo.Name, repoInfo)
 	})
 	return err
 }
 
-func (d *driver) updateRepo(pachClient *client.APIClient, repo *pfs.Repo, description string) error {
+func (d *driver) updateRepo(pachClient *client.APIClient, repo *pfs.Repo, description string, quotaBytes uint64, setQuota bool) error {
 	ctx := pachClient.Ctx()
 	_, err := col.NewSTM(ctx, d.etcdClient, func(stm col.STM) error {
 		repos := d.repos.ReadWrite(stm)
@@ -262,6 +455,14 @@ func (d *driver) updateRepo(pachClient *client.APIClient, repo *pfs.Repo, descri
 		if err := d.checkIsAuthorized(pachClient, repo, auth.Scope_WRITER); err != nil {
 			return err
 		}
+		// The quota limits what the repo's writers can store, so only an owner
+		// may change it (and only if the caller asked to).
+		if setQuota && quotaBytes != repoInfo.QuotaBytes {
+			if err := d.checkIsAuthorized(pachClient, repo, auth.Scope_OWNER); err != nil {
+				return err
+			}
+			repoInfo.QuotaBytes = quotaBytes
+		}
 		repoInfo.Description = description
 		return repos.Put(repo.Name, repoInfo)
 	})
@@ -285,9 +486,128 @@ func (d *driver) inspectRepo(pachClient *client.APIClient, repo *pfs.Repo, inclu
 		}
 		result.AuthInfo = &pfs.RepoAuthInfo{AccessLevel: accessLevel}
 	}
+	result.QuotaWarning = quotaWarning(result)
 	return result, nil
 }
 
+// quotaWarning returns a warning if 'repoInfo' is over quotaWarningFraction
+// of its quota, or "" otherwise.
+func quotaWarning(repoInfo *pfs.RepoInfo) string {
+	if repoInfo.QuotaBytes == 0 {
+		return ""
+	}
+	used := float64(repoInfo.SizeBytes) / float64(repoInfo.QuotaBytes)
+	if used < quotaWarningFraction {
+		return ""
+	}
+	return fmt.Sprintf("repo %s is using %.0f%% of its quota (%d of %d bytes)", repoInfo.Repo.Name, used*100, repoInfo.SizeBytes, repoInfo.QuotaBytes)
+}
+
+// checkRepoQuota returns an ErrRepoQuotaExceeded if adding 'sizeChange' bytes
+// to the repo in 'repoInfo' would make it larger than its quota.
+func checkRepoQuota(repoInfo *pfs.RepoInfo, sizeChange uint64) error {
+	if repoInfo.QuotaBytes > 0 && repoInfo.SizeBytes+sizeChange > repoInfo.QuotaBytes {
+		return pfsserver.ErrRepoQuotaExceeded{
+			Repo:       repoInfo.Repo,
+			QuotaBytes: repoInfo.QuotaBytes,
+			SizeBytes:  repoInfo.SizeBytes + sizeChange,
+		}
+	}
+	return nil
+}
+
+// retainedUntil returns the time until which 'commitInfo' is protected by the
+// retention lock of its repo ('repoInfo'), which is the zero time if the repo
+// isn't retention-locked.
+func retainedUntil(repoInfo *pfs.RepoInfo, commitInfo *pfs.CommitInfo) (time.Time, error) {
+	if repoInfo.RetentionLock == nil {
+		return time.Time{}, nil
+	}
+	period, err := types.DurationFromProto(repoInfo.RetentionLock.Period)
+	if err != nil {
+		return time.Time{}, err
+	}
+	started, err := types.TimestampFromProto(commitInfo.Started)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return started.Add(period), nil
+}
+
+// checkCommitRetention returns an ErrRetentionLocked if 'commitInfo' is still
+// protected by the retention lock of its repo ('repoInfo').
+func checkCommitRetention(repoInfo *pfs.RepoInfo, commitInfo *pfs.CommitInfo) error {
+	until, err := retainedUntil(repoInfo, commitInfo)
+	if err != nil {
+		return err
+	}
+	if time.Now().Before(until) {
+		return pfsserver.ErrRetentionLocked{
+			Repo:          repoInfo.Repo,
+			Commit:        commitInfo.Commit,
+			RetainedUntil: until,
+		}
+	}
+	return nil
+}
+
+// checkRepoRetention returns an ErrRetentionLocked if any commit in the repo
+// in 'repoInfo' is still protected by its retention lock.
+func checkRepoRetention(repoInfo *pfs.RepoInfo) error {
+	if repoInfo.RetentionLock == nil || repoInfo.RetentionLock.RetainedUntil == nil {
+		return nil
+	}
+	until, err := types.TimestampFromProto(repoInfo.RetentionLock.RetainedUntil)
+	if err != nil {
+		return err
+	}
+	if time.Now().Before(until) {
+		return pfsserver.ErrRetentionLocked{
+			Repo:          repoInfo.Repo,
+			RetainedUntil: until,
+		}
+	}
+	return nil
+}
+
+// extendRetention updates the retention lock of 'repoInfo' (if it has one) so
+// that the repo is retained at least as long as 'commitInfo'. It must be
+// called whenever a commit is created.
+func extendRetention(repoInfo *pfs.RepoInfo, commitInfo *pfs.CommitInfo) error {
+	until, err := retainedUntil(repoInfo, commitInfo)
+	if err != nil || until.IsZero() {
+		return err
+	}
+	if repoInfo.RetentionLock.RetainedUntil != nil {
+		current, err := types.TimestampFromProto(repoInfo.RetentionLock.RetainedUntil)
+		if err != nil {
+			return err
+		}
+		if !until.After(current) {
+			return nil
+		}
+	}
+	repoInfo.RetentionLock.RetainedUntil, err = types.TimestampProto(until)
+	return err
+}
+
+// isBranchRetentionLocked returns true if 'branch' is one of the branches
+// locked by 'lock'
+func isBranchRetentionLocked(lock *pfs.RetentionLock, branch string) bool {
+	if lock == nil {
+		return false
+	}
+	if len(lock.Branches) == 0 {
+		return true
+	}
+	for _, lockedBranch := range lock.Branches {
+		if lockedBranch == branch {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *driver) getAccessLevel(pachClient *client.APIClient, repo *pfs.Repo) (auth.Scope, error) {
 	ctx := pachClient.Ctx()
 	who, err := pachClient.AuthAPIClient.WhoAmI(ctx, &auth.WhoAmIRequest{})
@@ -364,6 +684,22 @@ func (d *driver) deleteRepo(pachClient *client.APIClient, repo *pfs.Repo, force
 		if err := d.checkIsAuthorized(pachClient, repo, auth.Scope_OWNER); err != nil {
 			return err
 		}
+		// Retention locks apply to everyone, including cluster admins
+		if err := checkRepoRetention(&existingRepoInfo); err != nil {
+			return err
+		}
+		// As do protected branches that can't be deleted
+		for _, branch := range existingRepoInfo.Branches {
+			if err := d.checkIsAuthorizedForBranch(pachClient, client.NewBranch(repo.Name, branch.Name), auth.Scope_OWNER, branchOpDelete); err != nil {
+				return err
+			}
+		}
+		// Tagged commits can't be deleted, even if 'force' is set. This reads
+		// the tags outside of the STM, but createCommitTag rewrites the repo, so
+		// a tag created concurrently makes this STM retry (and fail).
+		if err := d.checkNoCommitTags(ctx, repo); err != nil {
+			return err
+		}
 
 		repoInfo := new(pfs.RepoInfo)
 		if err := repos.Get(repo.Name, repoInfo); err != nil {
@@ -397,6 +733,10 @@ func (d *driver) deleteRepo(pachClient *client.APIClient, repo *pfs.Repo, force
 		// exist in etcd but branches do.
 		branches := d.branches(repo.Name).ReadWrite(stm)
 		branches.DeleteAll()
+		// The repo has no commit tags, but as with branches, this insulates us
+		// against tags that were left behind by an earlier failure
+		d.commitTags(repo.Name).ReadWrite(stm).DeleteAll()
+		d.prunedCommits(repo.Name).ReadWrite(stm).DeleteAll()
 		if err := repos.Delete(repo.Name); err != nil && !col.IsErrNotFound(err) {
 			return fmt.Errorf("repos.Delete: %v", err)
 		}
@@ -414,12 +754,25 @@ func (d *driver) deleteRepo(pachClient *client.APIClient, repo *pfs.Repo, force
 	return nil
 }
 
-func (d *driver) startCommit(pachClient *client.APIClient, parent *pfs.Commit, branch string, provenance []*pfs.Commit, description string) (*pfs.Commit, error) {
-	return d.makeCommit(pachClient, "", parent, branch, provenance, nil, nil, nil, description)
+func (d *driver) startCommit(pachClient *client.APIClient, parent *pfs.Commit, branch string, provenance []*pfs.Commit, description string, labels map[string]string) (*pfs.Commit, error) {
+	if err := validateLabels(labels); err != nil {
+		return nil, err
+	}
+	if parent != nil && branch != "" {
+		if err := d.checkIsAuthorizedForBranch(pachClient, client.NewBranch(parent.Repo.Name, branch), auth.Scope_WRITER, branchOpCommit); err != nil {
+			return nil, err
+		}
+	}
+	return d.makeCommit(pachClient, "", parent, branch, provenance, nil, nil, nil, description, labels, nil)
 }
 
 func (d *driver) buildCommit(pachClient *client.APIClient, ID string, parent *pfs.Commit, branch string, provenance []*pfs.Commit, tree *pfs.Object) (*pfs.Commit, error) {
-	return d.makeCommit(pachClient, ID, parent, branch, provenance, tree, nil, nil, "")
+	if parent != nil && branch != "" {
+		if err := d.checkIsAuthorizedForBranch(pachClient, client.NewBranch(parent.Repo.Name, branch), auth.Scope_WRITER, branchOpCommit); err != nil {
+			return nil, err
+		}
+	}
+	return d.makeCommit(pachClient, ID, parent, branch, provenance, tree, nil, nil, "", nil, nil)
 }
 
 // make commit makes a new commit in 'branch', with the parent 'parent' and the
@@ -432,7 +785,10 @@ func (d *driver) buildCommit(pachClient *client.APIClient, ID string, parent *pf
 //   to the new commit
 // - If neither 'parent.ID' nor 'branch' are set, the new commit will have no
 //   parent
-func (d *driver) makeCommit(pachClient *client.APIClient, ID string, parent *pfs.Commit, branch string, provenance []*pfs.Commit, treeRef *pfs.Object, recordFiles []string, records []*pfs.PutFileRecords, description string) (*pfs.Commit, error) {
+// - If 'inTxn' is set, it's called at the start of the transaction that
+//   creates the new commit (e.g. to check that 'branch' hasn't moved). It may
+//   set fields of the new commit's CommitInfo.
+func (d *driver) makeCommit(pachClient *client.APIClient, ID string, parent *pfs.Commit, branch string, provenance []*pfs.Commit, treeRef *pfs.Object, recordFiles []string, records []*pfs.PutFileRecords, description string, labels map[string]string, inTxn func(stm col.STM, newCommitInfo *pfs.CommitInfo) error) (*pfs.Commit, error) {
 	// Validate arguments:
 	if parent == nil {
 		return nil, fmt.Errorf("parent cannot be nil")
@@ -455,6 +811,7 @@ func (d *driver) makeCommit(pachClient *client.APIClient, ID string, parent *pfs
 		Commit:      newCommit,
 		Started:     now(),
 		Description: description,
+		Labels:      labels,
 	}
 
 	//  BuildCommit case: if the caller passed a tree reference with the commit
@@ -476,6 +833,11 @@ func (d *driver) makeCommit(pachClient *client.APIClient, ID string, parent *pfs
 		repos := d.repos.ReadWrite(stm)
 		commits := d.commits(parent.Repo.Name).ReadWrite(stm)
 		branches := d.branches(parent.Repo.Name).ReadWrite(stm)
+		if inTxn != nil {
+			if err := inTxn(stm, newCommitInfo); err != nil {
+				return err
+			}
+		}
 
 		// Check if repo exists
 		repoInfo := new(pfs.RepoInfo)
@@ -486,6 +848,9 @@ func (d *driver) makeCommit(pachClient *client.APIClient, ID string, parent *pfs
 		// create/update 'branch' (if it was set) and set parent.ID (if, in addition,
 		// 'parent.ID' was not set)
 		if branch != "" {
+			if err := d.checkNotCommitTag(stm, newCommit.Repo, branch); err != nil {
+				return err
+			}
 			branchInfo := &pfs.BranchInfo{}
 			if err := branches.Upsert(branch, branchInfo, func() error {
 				if parent.ID == "" && branchInfo.Head != nil {
@@ -556,7 +921,11 @@ func (d *driver) makeCommit(pachClient *client.APIClient, ID string, parent *pfs
 					return err
 				}
 			}
-			repoInfo.SizeBytes += sizeChange(tree, parentTree)
+			sizeChange := sizeChange(tree, parentTree)
+			if err := checkRepoQuota(repoInfo, sizeChange); err != nil {
+				return err
+			}
+			repoInfo.SizeBytes += sizeChange
 		} else {
 			if err := d.openCommits.ReadWrite(stm).Put(newCommit.ID, newCommit); err != nil {
 				return err
@@ -567,6 +936,9 @@ func (d *driver) makeCommit(pachClient *client.APIClient, ID string, parent *pfs
 			newCommitInfo.SizeBytes = uint64(tree.FSSize())
 			newCommitInfo.Finished = now()
 		}
+		if err := extendRetention(repoInfo, newCommitInfo); err != nil {
+			return err
+		}
 
 		if err := repos.Put(parent.Repo.Name, repoInfo); err != nil {
 			return err
@@ -617,11 +989,14 @@ func (d *driver) makeCommit(pachClient *client.APIClient, ID string, parent *pfs
 	return newCommit, nil
 }
 
-func (d *driver) finishCommit(pachClient *client.APIClient, commit *pfs.Commit, tree *pfs.Object, empty bool, description string) (retErr error) {
+func (d *driver) finishCommit(pachClient *client.APIClient, commit *pfs.Commit, tree *pfs.Object, empty bool, description string, labels map[string]string) (retErr error) {
 	ctx := pachClient.Ctx()
 	if err := d.checkIsAuthorized(pachClient, commit.Repo, auth.Scope_WRITER); err != nil {
 		return err
 	}
+	if err := validateLabels(labels); err != nil {
+		return err
+	}
 	commitInfo, err := d.inspectCommit(pachClient, commit, pfs.CommitState_STARTED)
 	if err != nil {
 		return err
@@ -629,9 +1004,6 @@ func (d *driver) finishCommit(pachClient *client.APIClient, commit *pfs.Commit,
 	if commitInfo.Finished != nil {
 		return pfsserver.ErrCommitFinished{commit}
 	}
-	if description != "" {
-		commitInfo.Description = description
-	}
 
 	scratchPrefix := d.scratchCommitPrefix(commit)
 	defer func() {
@@ -643,6 +1015,7 @@ func (d *driver) finishCommit(pachClient *client.APIClient, commit *pfs.Commit,
 		_, retErr = d.etcdClient.Delete(ctx, scratchPrefix, etcd.WithPrefix())
 	}()
 
+	finished := now()
 	var parentTree, finishedTree hashtree.HashTree
 	if !empty {
 		// Retrieve the parent commit's tree (to apply writes from etcd or just
@@ -667,7 +1040,7 @@ func (d *driver) finishCommit(pachClient *client.APIClient, commit *pfs.Commit,
 
 		if tree == nil {
 			var err error
-			finishedTree, err = d.getTreeForOpenCommit(pachClient, &pfs.File{Commit: commit}, parentTree)
+			finishedTree, err = d.getTreeForOpenCommit(pachClient, &pfs.File{Commit: commit}, parentTree, finished)
 			if err != nil {
 				return err
 			}
@@ -689,12 +1062,32 @@ func (d *driver) finishCommit(pachClient *client.APIClient, commit *pfs.Commit,
 		commitInfo.SizeBytes = uint64(finishedTree.FSSize())
 	}
 
-	commitInfo.Finished = now()
 	sizeChange := sizeChange(finishedTree, parentTree)
 	_, err = col.NewSTM(ctx, d.etcdClient, func(stm col.STM) error {
 		commits := d.commits(commit.Repo.Name).ReadWrite(stm)
 		repos := d.repos.ReadWrite(stm)
-		if err := commits.Put(commit.ID, commitInfo); err != nil {
+		// Re-read the commit, so that labels set while it was being finished
+		// aren't overwritten
+		latestInfo := new(pfs.CommitInfo)
+		if err := commits.Get(commit.ID, latestInfo); err != nil {
+			return err
+		}
+		if latestInfo.Finished != nil {
+			return pfsserver.ErrCommitFinished{commit}
+		}
+		if description != "" {
+			latestInfo.Description = description
+		}
+		for key, value := range labels {
+			if latestInfo.Labels == nil {
+				latestInfo.Labels = make(map[string]string)
+			}
+			latestInfo.Labels[key] = value
+		}
+		latestInfo.Tree = commitInfo.Tree
+		latestInfo.SizeBytes = commitInfo.SizeBytes
+		latestInfo.Finished = finished
+		if err := commits.Put(commit.ID, latestInfo); err != nil {
 			return err
 		}
 		if err := d.openCommits.ReadWrite(stm).Delete(commit.ID); err != nil {
@@ -707,6 +1100,9 @@ func (d *driver) finishCommit(pachClient *client.APIClient, commit *pfs.Commit,
 				return err
 			}
 
+			if err := checkRepoQuota(repoInfo, sizeChange); err != nil {
+				return err
+			}
 			// Increment the repo sizes by the sizes of the files that have
 			// been added in this commit.
 			repoInfo.SizeBytes += sizeChange
@@ -731,18 +1127,57 @@ func (d *driver) finishOutputCommit(pachClient *client.APIClient, commit *pfs.Co
 	if commitInfo.Finished != nil {
 		return fmt.Errorf("commit %s has already been finished", commit.FullID())
 	}
-	commitInfo.Trees = trees
-	commitInfo.Datums = datums
-	commitInfo.SizeBytes = size
-	commitInfo.Finished = now()
+	// Output commits contain the whole output of their job, so the repo grows
+	// by however much larger this commit is than its parent. This is recorded
+	// even if the repo has no quota, so that a quota added later is accurate.
+	var sizeChange uint64
+	if commitInfo.ParentCommit != nil {
+		parentCommitInfo, err := d.inspectCommit(pachClient, commitInfo.ParentCommit, pfs.CommitState_STARTED)
+		if err != nil {
+			return err
+		}
+		if size > parentCommitInfo.SizeBytes {
+			sizeChange = size - parentCommitInfo.SizeBytes
+		}
+	} else {
+		sizeChange = size
+	}
+	finished := now()
 	_, err = col.NewSTM(ctx, d.etcdClient, func(stm col.STM) error {
 		commits := d.commits(commit.Repo.Name).ReadWrite(stm)
-		if err := commits.Put(commit.ID, commitInfo); err != nil {
+		// Re-read the commit, so that labels set while it was being finished
+		// aren't overwritten
+		latestInfo := new(pfs.CommitInfo)
+		if err := commits.Get(commit.ID, latestInfo); err != nil {
+			return err
+		}
+		if latestInfo.Finished != nil {
+			return fmt.Errorf("commit %s has already been finished", commit.FullID())
+		}
+		latestInfo.Trees = trees
+		latestInfo.Datums = datums
+		latestInfo.SizeBytes = size
+		latestInfo.Finished = finished
+		if err := commits.Put(commit.ID, latestInfo); err != nil {
 			return err
 		}
 		if err := d.openCommits.ReadWrite(stm).Delete(commit.ID); err != nil {
 			return fmt.Errorf("could not confirm that commit %s is open; this is likely a bug. err: %v", commit.ID, err)
 		}
+		if sizeChange > 0 {
+			repos := d.repos.ReadWrite(stm)
+			repoInfo := new(pfs.RepoInfo)
+			if err := repos.Get(commit.Repo.Name, repoInfo); err != nil {
+				return err
+			}
+			if err := checkRepoQuota(repoInfo, sizeChange); err != nil {
+				return err
+			}
+			repoInfo.SizeBytes += sizeChange
+			if err := repos.Put(commit.Repo.Name, repoInfo); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 	return err
@@ -908,6 +1343,20 @@ nextSubvBranch:
 			}
 		}
 
+		// Extend the retention lock of 'repo', if it has one, to cover 'newCommit'
+		repoInfo := &pfs.RepoInfo{}
+		if err := d.repos.ReadWrite(stm).Get(repo.Name, repoInfo); err != nil {
+			return err
+		}
+		if repoInfo.RetentionLock != nil {
+			if err := extendRetention(repoInfo, newCommitInfo); err != nil {
+				return err
+			}
+			if err := d.repos.ReadWrite(stm).Put(repo.Name, repoInfo); err != nil {
+				return err
+			}
+		}
+
 		// finally create open 'commit'
 		if err := commits.Create(newCommit.ID, newCommitInfo); err != nil {
 			return err
@@ -1002,7 +1451,7 @@ func (d *driver) inspectCommit(pachClient *client.APIClient, commit *pfs.Commit,
 }
 
 // resolveCommit contains the essential implementation of inspectCommit: it converts 'commit' (which may
-// be a commit ID or branch reference, plus '~' and/or '^') to a repo + commit
+// be a commit ID or branch reference, plus '@{<time>}', '~' and/or '^') to a repo + commit
 // ID. It accepts an STM so that it can be used in a transaction and avoids an
 // inconsistent call to d.inspectCommit()
 func (d *driver) resolveCommit(stm col.STM, userCommit *pfs.Commit) (*pfs.CommitInfo, error) {
@@ -1016,6 +1465,14 @@ func (d *driver) resolveCommit(stm col.STM, userCommit *pfs.Commit) (*pfs.Commit
 	// Extract any ancestor tokens from 'commit.ID' (i.e. ~ and ^)
 	var ancestryLength int
 	commit.ID, ancestryLength = ancestry.Parse(commit.ID)
+	// Extract any time reference from 'commit.ID' (i.e. @{<time>})
+	var before time.Time
+	var hasTime bool
+	var err error
+	commit.ID, before, hasTime, err = ancestry.ParseTime(commit.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
 
 	// Check if commit.ID is already a commit ID (i.e. a UUID).
 	if !uuid.IsUUIDWithoutDashes(commit.ID) {
@@ -1023,17 +1480,56 @@ func (d *driver) resolveCommit(stm col.STM, userCommit *pfs.Commit) (*pfs.Commit
 		branchInfo := &pfs.BranchInfo{}
 		// See if we are given a branch
 		if err := branches.Get(commit.ID, branchInfo); err != nil {
-			return nil, err
-		}
-		if branchInfo.Head == nil {
-			return nil, pfsserver.ErrNoHead{branchInfo.Branch}
+			if !col.IsErrNotFound(err) {
+				return nil, err
+			}
+			// See if we are given a commit tag
+			commitTagInfo := &pfs.CommitTagInfo{}
+			if tagErr := d.commitTags(commit.Repo.Name).ReadWrite(stm).Get(commit.ID, commitTagInfo); tagErr != nil {
+				if col.IsErrNotFound(tagErr) {
+					return nil, err
+				}
+				return nil, tagErr
+			}
+			commit.ID = commitTagInfo.Commit.ID
+		} else {
+			if branchInfo.Head == nil {
+				return nil, pfsserver.ErrNoHead{branchInfo.Branch}
+			}
+			commit.ID = branchInfo.Head.ID
 		}
-		commit.ID = branchInfo.Head.ID
 	}
 
-	// Traverse commits' parents until you've reached the right ancestor
 	commits := d.commits(commit.Repo.Name).ReadWrite(stm)
 	commitInfo := &pfs.CommitInfo{}
+	if hasTime {
+		// Traverse commits' parents until you've reached the latest commit
+		// finished at or before 'before'. A commit's parent is always finished
+		// before it is, so this is the first such commit.
+		for {
+			if commit == nil {
+				return nil, pfsserver.ErrCommitNotFound{Commit: userCommit}
+			}
+			if err := commits.Get(commit.ID, commitInfo); err != nil {
+				if col.IsErrNotFound(err) {
+					return nil, pfsserver.ErrCommitNotFound{Commit: commit}
+				}
+				return nil, err
+			}
+			if commitInfo.Finished != nil {
+				finished, err := types.TimestampFromProto(commitInfo.Finished)
+				if err != nil {
+					return nil, err
+				}
+				if !finished.After(before) {
+					break
+				}
+			}
+			commit = commitInfo.ParentCommit
+		}
+	}
+
+	// Traverse commits' parents until you've reached the right ancestor
 	for i := 0; i <= ancestryLength; i++ {
 		if commit == nil {
 			return nil, pfsserver.ErrCommitNotFound{userCommit}
@@ -1054,9 +1550,9 @@ func (d *driver) resolveCommit(stm col.STM, userCommit *pfs.Commit) (*pfs.Commit
 	return commitInfo, nil
 }
 
-func (d *driver) listCommit(pachClient *client.APIClient, repo *pfs.Repo, to *pfs.Commit, from *pfs.Commit, number uint64) ([]*pfs.CommitInfo, error) {
+func (d *driver) listCommit(pachClient *client.APIClient, repo *pfs.Repo, to *pfs.Commit, from *pfs.Commit, number uint64, selector string) ([]*pfs.CommitInfo, error) {
 	var result []*pfs.CommitInfo
-	if err := d.listCommitF(pachClient, repo, to, from, number, func(ci *pfs.CommitInfo) error {
+	if err := d.listCommitF(pachClient, repo, to, from, number, selector, func(ci *pfs.CommitInfo) error {
 		result = append(result, ci)
 		return nil
 	}); err != nil {
@@ -1065,7 +1561,7 @@ func (d *driver) listCommit(pachClient *client.APIClient, repo *pfs.Repo, to *pf
 	return result, nil
 }
 
-func (d *driver) listCommitF(pachClient *client.APIClient, repo *pfs.Repo, to *pfs.Commit, from *pfs.Commit, number uint64, f func(*pfs.CommitInfo) error) error {
+func (d *driver) listCommitF(pachClient *client.APIClient, repo *pfs.Repo, to *pfs.Commit, from *pfs.Commit, number uint64, selector string, f func(*pfs.CommitInfo) error) error {
 	ctx := pachClient.Ctx()
 	if err := d.checkIsAuthorized(pachClient, repo, auth.Scope_READER); err != nil {
 		return err
@@ -1073,9 +1569,13 @@ func (d *driver) listCommitF(pachClient *client.APIClient, repo *pfs.Repo, to *p
 	if from != nil && from.Repo.Name != repo.Name || to != nil && to.Repo.Name != repo.Name {
 		return fmt.Errorf("`from` and `to` commits need to be from repo %s", repo.Name)
 	}
+	labelSelector, err := parseSelector(selector)
+	if err != nil {
+		return err
+	}
 
 	// Make sure that the repo exists
-	_, err := d.inspectRepo(pachClient, repo, !includeAuth)
+	_, err = d.inspectRepo(pachClient, repo, !includeAuth)
 	if err != nil {
 		return err
 	}
@@ -1113,6 +1613,9 @@ func (d *driver) listCommitF(pachClient *client.APIClient, repo *pfs.Repo, to *p
 			if number <= 0 {
 				return errutil.ErrBreak
 			}
+			if !labelSelector.Matches(labels.Set(ci.Labels)) {
+				return nil
+			}
 			number--
 			return f(proto.Clone(ci).(*pfs.CommitInfo))
 		}); err != nil {
@@ -1125,23 +1628,30 @@ func (d *driver) listCommitF(pachClient *client.APIClient, repo *pfs.Repo, to *p
 			if err := commits.Get(cursor.ID, &commitInfo); err != nil {
 				return err
 			}
+			cursor = commitInfo.ParentCommit
+			if !labelSelector.Matches(labels.Set(commitInfo.Labels)) {
+				continue
+			}
 			if err := f(&commitInfo); err != nil {
 				if err == errutil.ErrBreak {
 					return nil
 				}
 				return err
 			}
-			cursor = commitInfo.ParentCommit
 			number--
 		}
 	}
 	return nil
 }
 
-func (d *driver) subscribeCommit(pachClient *client.APIClient, repo *pfs.Repo, branch string, from *pfs.Commit, state pfs.CommitState, f func(*pfs.CommitInfo) error) error {
+func (d *driver) subscribeCommit(pachClient *client.APIClient, repo *pfs.Repo, branch string, from *pfs.Commit, state pfs.CommitState, selector string, f func(*pfs.CommitInfo) error) error {
 	if from != nil && from.Repo.Name != repo.Name {
 		return fmt.Errorf("the `from` commit needs to be from repo %s", repo.Name)
 	}
+	labelSelector, err := parseSelector(selector)
+	if err != nil {
+		return err
+	}
 
 	branches := d.branches(repo.Name).ReadOnly(pachClient.Ctx())
 	newCommitWatcher, err := branches.WatchOne(branch)
@@ -1152,7 +1662,7 @@ func (d *driver) subscribeCommit(pachClient *client.APIClient, repo *pfs.Repo, b
 	// keep track of the commits that have been sent
 	seen := make(map[string]bool)
 	// include all commits that are currently on the given branch,
-	commitInfos, err := d.listCommit(pachClient, repo, client.NewCommit(repo.Name, branch), from, 0)
+	commitInfos, err := d.listCommit(pachClient, repo, client.NewCommit(repo.Name, branch), from, 0, "")
 	if err != nil {
 		// We skip NotFound error because it's ok if the branch
 		// doesn't exist yet, in which case ListCommit returns
@@ -1170,10 +1680,13 @@ func (d *driver) subscribeCommit(pachClient *client.APIClient, repo *pfs.Repo, b
 		if err != nil {
 			return err
 		}
+		seen[commitInfo.Commit.ID] = true
+		if !labelSelector.Matches(labels.Set(commitInfo.Labels)) {
+			continue
+		}
 		if err := f(commitInfo); err != nil {
 			return err
 		}
-		seen[commitInfo.Commit.ID] = true
 	}
 	for {
 		var branchName string
@@ -1207,10 +1720,13 @@ func (d *driver) subscribeCommit(pachClient *client.APIClient, repo *pfs.Repo, b
 				if err != nil {
 					return err
 				}
+				seen[commitInfo.Commit.ID] = true
+				if !labelSelector.Matches(labels.Set(commitInfo.Labels)) {
+					continue
+				}
 				if err := f(commitInfo); err != nil {
 					return err
 				}
-				seen[commitInfo.Commit.ID] = true
 			}
 		case watch.EventDelete:
 			continue
@@ -1218,13 +1734,68 @@ func (d *driver) subscribeCommit(pachClient *client.APIClient, repo *pfs.Repo, b
 	}
 }
 
-func (d *driver) flushCommit(pachClient *client.APIClient, fromCommits []*pfs.Commit, toRepos []*pfs.Repo, f func(*pfs.CommitInfo) error) error {
-	if len(fromCommits) == 0 {
-		return fmt.Errorf("fromCommits cannot be empty")
+// setCommitLabels adds 'set' to the labels of 'commit', and removes the labels
+// whose keys are in 'remove'.
+func (d *driver) setCommitLabels(pachClient *client.APIClient, commit *pfs.Commit, set map[string]string, remove []string) error {
+	if commit == nil {
+		return fmt.Errorf("commit cannot be nil")
 	}
-
-	// First compute intersection of the fromCommits subvenant commits, those
-	// are the commits we're interested in. Iterate over all commits and keep a
+	if err := d.checkIsAuthorized(pachClient, commit.Repo, auth.Scope_WRITER); err != nil {
+		return err
+	}
+	if err := validateLabels(set); err != nil {
+		return err
+	}
+	_, err := col.NewSTM(pachClient.Ctx(), d.etcdClient, func(stm col.STM) error {
+		commitInfo, err := d.resolveCommit(stm, proto.Clone(commit).(*pfs.Commit))
+		if err != nil {
+			return err
+		}
+		for _, key := range remove {
+			delete(commitInfo.Labels, key)
+		}
+		for key, value := range set {
+			if commitInfo.Labels == nil {
+				commitInfo.Labels = make(map[string]string)
+			}
+			commitInfo.Labels[key] = value
+		}
+		return d.commits(commit.Repo.Name).ReadWrite(stm).Put(commitInfo.Commit.ID, commitInfo)
+	})
+	return err
+}
+
+// validateLabels returns an error if any of 'commitLabels' aren't valid
+// Kubernetes labels, as they couldn't be selected with a label selector.
+func validateLabels(commitLabels map[string]string) error {
+	for key, value := range commitLabels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid label key \"%s\": %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid value for label \"%s\": %s", key, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// parseSelector parses a Kubernetes-style label selector. The empty selector
+// matches every commit.
+func parseSelector(selector string) (labels.Selector, error) {
+	labelSelector, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector \"%s\": %v", selector, err)
+	}
+	return labelSelector, nil
+}
+
+func (d *driver) flushCommit(pachClient *client.APIClient, fromCommits []*pfs.Commit, toRepos []*pfs.Repo, f func(*pfs.CommitInfo) error) error {
+	if len(fromCommits) == 0 {
+		return fmt.Errorf("fromCommits cannot be empty")
+	}
+
+	// First compute intersection of the fromCommits subvenant commits, those
+	// are the commits we're interested in. Iterate over all commits and keep a
 	// running intersection (in commitsToWatch) of the subvenance of all commits
 	// processed so far
 	commitsToWatch := make(map[string]*pfs.Commit)
@@ -1309,28 +1880,43 @@ func (d *driver) deleteCommit(pachClient *client.APIClient, userCommit *pfs.Comm
 				if commit == nil {
 					return fmt.Errorf("encountered nil parent commit in %s/%s...%s", lower.Repo.Name, lower.ID, upper.ID)
 				}
-				// Store commitInfo in 'deleted' and remove commit from etcd
-				commitInfo := &pfs.CommitInfo{}
-				if err := commits.Get(commit.ID, commitInfo); err != nil {
-					return err
-				}
-				deleted[commit.ID] = commitInfo
-				if err := commits.Delete(commit.ID); err != nil {
-					return err
-				}
+				// Downstream commit ranges may overlap, in which case 'commit' may
+				// have been deleted already
+				commitInfo, ok := deleted[commit.ID]
+				if !ok {
+					// Store commitInfo in 'deleted' and remove commit from etcd
+					commitInfo = &pfs.CommitInfo{}
+					if err := commits.Get(commit.ID, commitInfo); err != nil {
+						return err
+					}
+					if len(commitInfo.Tags) > 0 {
+						return pfsserver.ErrCommitTagged{Commit: commitInfo.Commit, Tag: commitInfo.Tags[0]}
+					}
+					deleted[commit.ID] = commitInfo
+					if err := commits.Delete(commit.ID); err != nil {
+						return err
+					}
 
-				// Update repo size
-				// TODO this is basically wrong. Other commits could share data with the
-				// commit being removed, in which case we're subtracting too much.
-				// We could also modify makeCommit and FinishCommit so that
-				// commitInfo.SizeBytes stores incremental size (which could cause
-				// commits to have negative sizes)
-				repoInfo := &pfs.RepoInfo{}
-				if err := d.repos.ReadWrite(stm).Update(commit.Repo.Name, repoInfo, func() error {
-					repoInfo.SizeBytes -= commitInfo.SizeBytes
-					return nil
-				}); err != nil {
-					return err
+					// Update repo size
+					// TODO this is basically wrong. Other commits could share data with the
+					// commit being removed, in which case we're subtracting too much.
+					// We could also modify makeCommit and FinishCommit so that
+					// commitInfo.SizeBytes stores incremental size (which could cause
+					// commits to have negative sizes)
+					repoInfo := &pfs.RepoInfo{}
+					if err := d.repos.ReadWrite(stm).Update(commit.Repo.Name, repoInfo, func() error {
+						if err := checkCommitRetention(repoInfo, commitInfo); err != nil {
+							return err
+						}
+						if commitInfo.SizeBytes > repoInfo.SizeBytes {
+							repoInfo.SizeBytes = 0
+						} else {
+							repoInfo.SizeBytes -= commitInfo.SizeBytes
+						}
+						return nil
+					}); err != nil {
+						return err
+					}
 				}
 				if commit.ID == lower.ID {
 					break // check after deletion so we delete 'lower' (inclusive range)
@@ -1344,216 +1930,423 @@ func (d *driver) deleteCommit(pachClient *client.APIClient, userCommit *pfs.Comm
 		if len(userCommitInfo.Provenance) > 0 {
 			return fmt.Errorf("cannot delete the commit \"%s/%s\" because it has non-empty provenance", userCommit.Repo.Name, userCommit.ID)
 		}
-		deleteCommit(userCommitInfo.Commit, userCommitInfo.Commit)
+		if err := deleteCommit(userCommitInfo.Commit, userCommitInfo.Commit); err != nil {
+			return err
+		}
 
 		// 4) Delete all of the downstream commits of 'commit'
 		for _, subv := range userCommitInfo.Subvenance {
-			deleteCommit(subv.Lower, subv.Upper)
-		}
-
-		// 5) Remove the commits in 'deleted' from all remaining upstream commits'
-		// subvenance.
-		// While 'commit' is required to be an input commit (no provenance),
-		// downstream commits from 'commit' may have multiple inputs, and those
-		// other inputs must have their subvenance updated
-		visited := make(map[string]bool) // visitied upstream (provenant) commits
-		for _, deletedInfo := range deleted {
-			for _, provCommit := range deletedInfo.Provenance {
-				// Check if we've fixed provCommit already (or if it's deleted and
-				// doesn't need to be fixed
-				if _, isDeleted := deleted[provCommit.ID]; isDeleted || visited[provCommit.ID] {
-					continue
-				}
-				visited[provCommit.ID] = true
-
-				// fix provCommit's subvenance
-				provCI := &pfs.CommitInfo{}
-				if err := d.commits(provCommit.Repo.Name).ReadWrite(stm).Update(provCommit.ID, provCI, func() error {
-					subvTo := 0 // copy subvFrom to subvTo, excepting subv ranges to delete (so that they're overwritten)
-				nextSubvRange:
-					for subvFrom, subv := range provCI.Subvenance {
-						// Compute path (of commit IDs) connecting subv.Upper to subv.Lower
-						cur := subv.Upper.ID
-						path := []string{cur}
-						for cur != subv.Lower.ID {
-							// Get CommitInfo for 'cur' (either in 'deleted' or from etcd)
-							// and traverse parent
-							curInfo, ok := deleted[cur]
-							if !ok {
-								curInfo = &pfs.CommitInfo{}
-								if err := d.commits(subv.Lower.Repo.Name).ReadWrite(stm).Get(cur, curInfo); err != nil {
-									return fmt.Errorf("error reading commitInfo for subvenant \"%s/%s\": %v", subv.Lower.Repo.Name, cur, err)
-								}
-							}
-							if curInfo.ParentCommit == nil {
-								break
-							}
-							cur = curInfo.ParentCommit.ID
-							path = append(path, cur)
-						}
+			if err := deleteCommit(subv.Lower, subv.Upper); err != nil {
+				return err
+			}
+		}
 
-						// move 'subv.Upper' through parents until it points to a non-deleted commit
-						for j := range path {
-							if _, ok := deleted[subv.Upper.ID]; !ok {
-								break
-							}
-							if j+1 >= len(path) {
-								// All commits in subvRange are deleted. Remove entire Range
-								// from provCI.Subvenance
-								continue nextSubvRange
-							}
-							subv.Upper.ID = path[j+1]
-						}
+		// 5) Check that no protected branch loses commits that the caller
+		// may not remove from it
+		if err := d.checkIsAuthorizedToRewrite(pachClient, stm, deleted, affectedRepos); err != nil {
+			return err
+		}
 
-						// move 'subv.Lower' through children until it points to a non-deleted commit
-						for j := len(path) - 1; j >= 0; j-- {
-							if _, ok := deleted[subv.Lower.ID]; !ok {
-								break
-							}
-							// We'll eventually get to a non-deleted commit because the
-							// 'upper' block didn't exit
-							subv.Lower.ID = path[j-1]
-						}
-						provCI.Subvenance[subvTo] = provCI.Subvenance[subvFrom]
-						subvTo++
-					}
-					provCI.Subvenance = provCI.Subvenance[:subvTo]
-					return nil
-				}); err != nil {
-					return fmt.Errorf("err fixing subvenance of upstream commit %s/%s: %v", provCommit.Repo.Name, provCommit.ID, err)
-				}
-			}
+		// 6) Remove the deleted commits from the commit graph
+		return d.repairCommitGraph(stm, deleted, affectedRepos)
+	}); err != nil {
+		return fmt.Errorf("error rewriting commit graph: %v", err)
+	}
+
+	// Delete the scratch space for this commit
+	// TODO put scratch spaces in a collection and do this in the txn above
+	if deleteScratch {
+		if _, err := d.etcdClient.Delete(ctx, d.scratchCommitPrefix(userCommit), etcd.WithPrefix()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// squashCommit squashes the commits in 'commitRange' into a single commit:
+// 'commitRange.Upper', whose tree already reflects every commit in the range,
+// takes the place of 'commitRange.Lower' in the commit graph and the
+// commits from 'commitRange.Lower' up to (but not including)
+// 'commitRange.Upper' are deleted.
+//
+// If any downstream commits are provenant on a squashed commit, squashCommit
+// fails unless 'force' is set, in which case those downstream commits are
+// deleted (and re-derived from 'commitRange.Upper' where needed).
+func (d *driver) squashCommit(pachClient *client.APIClient, commitRange *pfs.CommitRange, force bool) error {
+	ctx := pachClient.Ctx()
+	if commitRange == nil || commitRange.Lower == nil || commitRange.Upper == nil {
+		return fmt.Errorf("both ends of the commit range to squash must be set")
+	}
+	if commitRange.Lower.Repo.Name != commitRange.Upper.Repo.Name {
+		return fmt.Errorf("cannot squash commit range with mismatched repos \"%s\" and \"%s\"", commitRange.Lower.Repo.Name, commitRange.Upper.Repo.Name)
+	}
+	if err := d.checkIsAuthorized(pachClient, commitRange.Lower.Repo, auth.Scope_WRITER); err != nil {
+		return err
+	}
+	_, err := col.NewSTM(ctx, d.etcdClient, func(stm col.STM) error {
+		return d.squashCommitSTM(pachClient, stm, commitRange, force)
+	})
+	return err
+}
+
+// squashCommitSTM is the body of squashCommit, which doesn't check the
+// caller's access to the repo. The caller's branch protections are checked
+// unless 'pachClient' is nil (see checkIsAuthorizedToRewrite).
+func (d *driver) squashCommitSTM(pachClient *client.APIClient, stm col.STM, commitRange *pfs.CommitRange, force bool) error {
+	repo := commitRange.Lower.Repo
+	// 1) re-read CommitInfos inside txn
+	lowerInfo, err := d.resolveCommit(stm, commitRange.Lower)
+	if err != nil {
+		return fmt.Errorf("resolveCommit: %v", err)
+	}
+	upperInfo, err := d.resolveCommit(stm, commitRange.Upper)
+	if err != nil {
+		return fmt.Errorf("resolveCommit: %v", err)
+	}
+	if lowerInfo.Commit.ID == upperInfo.Commit.ID {
+		return nil // nothing to squash
+	}
+	if upperInfo.Finished == nil {
+		return fmt.Errorf("cannot squash into open commit %s/%s", repo.Name, upperInfo.Commit.ID)
+	}
+
+	repoInfo := &pfs.RepoInfo{}
+	if err := d.repos.ReadWrite(stm).Get(repo.Name, repoInfo); err != nil {
+		return err
+	}
+
+	// 2) Collect the commits to squash away (lower...upper's parent),
+	// checking that they form a linear chain ending in 'upper'
+	commits := d.commits(repo.Name).ReadWrite(stm)
+	squashed := make(map[string]*pfs.CommitInfo)
+	child := upperInfo
+	for {
+		if child.ParentCommit == nil {
+			return fmt.Errorf("commit %s/%s is not an ancestor of %s/%s", repo.Name, lowerInfo.Commit.ID, repo.Name, upperInfo.Commit.ID)
+		}
+		commitInfo := &pfs.CommitInfo{}
+		if err := commits.Get(child.ParentCommit.ID, commitInfo); err != nil {
+			return err
+		}
+		if commitInfo.Finished == nil {
+			return fmt.Errorf("cannot squash open commit %s/%s", repo.Name, commitInfo.Commit.ID)
+		}
+		if len(commitInfo.ChildCommits) > 1 {
+			return fmt.Errorf("cannot squash commit %s/%s because it has children outside of the range being squashed", repo.Name, commitInfo.Commit.ID)
+		}
+		if len(commitInfo.Tags) > 0 {
+			return pfsserver.ErrCommitTagged{Commit: commitInfo.Commit, Tag: commitInfo.Tags[0]}
+		}
+		if err := checkCommitRetention(repoInfo, commitInfo); err != nil {
+			return err
+		}
+		squashed[commitInfo.Commit.ID] = commitInfo
+		if commitInfo.Commit.ID == lowerInfo.Commit.ID {
+			break
 		}
+		child = commitInfo
+	}
+
+	// Squashing rewrites the history of every branch containing the squashed
+	// commits, which their protections may not allow
+	if err := d.checkIsAuthorizedToRewrite(pachClient, stm, squashed, map[string]struct{}{repo.Name: {}}); err != nil {
+		return err
+	}
 
-		// 6) Rewrite ParentCommit of deleted commits' children, and
-		// ChildCommits of deleted commits' parents
-		visited = make(map[string]bool) // visited child/parent commits
-		for deletedID, deletedInfo := range deleted {
-			if visited[deletedID] {
+	// 3) Squashed commits must not be the head of any branch--only 'upper'
+	// survives, so those branches would lose their head
+	for _, branch := range repoInfo.Branches {
+		branchInfo := &pfs.BranchInfo{}
+		if err := d.branches(repo.Name).ReadWrite(stm).Get(branch.Name, branchInfo); err != nil {
+			if col.IsErrNotFound(err) {
 				continue
 			}
+			return err
+		}
+		if branchInfo.Head != nil && squashed[branchInfo.Head.ID] != nil {
+			return fmt.Errorf("cannot squash commit %s/%s because it is the head of branch \"%s\"", repo.Name, branchInfo.Head.ID, branch.Name)
+		}
+	}
 
-			// Traverse downwards until we find the lowest (most ancestral)
-			// non-nil, deleted commit
-			lowestCommitInfo := deletedInfo
-			for {
-				if lowestCommitInfo.ParentCommit == nil {
-					break // parent is nil
-				}
-				parentInfo, ok := deleted[lowestCommitInfo.ParentCommit.ID]
-				if !ok {
-					break // parent is not deleted
-				}
-				lowestCommitInfo = parentInfo // parent exists and is deleted--go down
-			}
+	// 4) Check for downstream commits that were derived from squashed commits,
+	// and delete them if 'force' is set
+	deleted := make(map[string]*pfs.CommitInfo)
+	affectedRepos := map[string]struct{}{repo.Name: {}}
+	for id, commitInfo := range squashed {
+		if len(commitInfo.Subvenance) == 0 {
+			continue
+		}
+		if !force {
+			return fmt.Errorf("cannot squash commit %s/%s because downstream commits were derived from it (use --force to delete them)", repo.Name, id)
+		}
+		if err := d.deleteSubvenance(stm, commitInfo, deleted, affectedRepos); err != nil {
+			return err
+		}
+	}
 
-			// BFS upwards through graph for all non-deleted children
-			var next *pfs.Commit                            // next vertex to search
-			queue := []*pfs.Commit{lowestCommitInfo.Commit} // queue of vertices to explore
-			liveChildren := make(map[string]struct{})       // live children discovered so far
-			for len(queue) > 0 {
-				next, queue = queue[0], queue[1:]
-				if visited[next.ID] {
-					continue
+	// 5) Delete the squashed commits. Unlike the downstream commits above,
+	// their data lives on in 'upper', so the repo's size is unchanged.
+	for id, commitInfo := range squashed {
+		if err := commits.Delete(id); err != nil {
+			return err
+		}
+		deleted[id] = commitInfo
+	}
+
+	// 6) Remove the deleted commits from the commit graph. This connects
+	// 'upper' to 'lower's parent, and fixes the subvenance ranges of any
+	// commits upstream of the squashed commits.
+	return d.repairCommitGraph(stm, deleted, affectedRepos)
+}
+
+// deleteSubvenance deletes the downstream commits that were derived from
+// 'commitInfo', adding them to 'deleted' and their repos to 'affectedRepos'.
+// The caller must call repairCommitGraph once all commits have been deleted.
+func (d *driver) deleteSubvenance(stm col.STM, commitInfo *pfs.CommitInfo, deleted map[string]*pfs.CommitInfo, affectedRepos map[string]struct{}) error {
+	for _, subv := range commitInfo.Subvenance {
+		affectedRepos[subv.Lower.Repo.Name] = struct{}{}
+		subvCommits := d.commits(subv.Lower.Repo.Name).ReadWrite(stm)
+		subvCommit := subv.Upper
+		for {
+			if subvCommit == nil {
+				return fmt.Errorf("encountered nil parent commit in %s/%s...%s", subv.Lower.Repo.Name, subv.Lower.ID, subv.Upper.ID)
+			}
+			if _, ok := deleted[subvCommit.ID]; !ok {
+				subvCommitInfo := &pfs.CommitInfo{}
+				if err := subvCommits.Get(subvCommit.ID, subvCommitInfo); err != nil {
+					return err
 				}
-				visited[next.ID] = true
-				nextInfo, ok := deleted[next.ID]
-				if !ok {
-					liveChildren[next.ID] = struct{}{}
-					continue
+				if len(subvCommitInfo.Tags) > 0 {
+					return pfsserver.ErrCommitTagged{Commit: subvCommitInfo.Commit, Tag: subvCommitInfo.Tags[0]}
 				}
-				queue = append(queue, nextInfo.ChildCommits...)
-			}
-
-			// Point all non-deleted children at the first valid parent (or nil),
-			// and point first non-deleted parent at all non-deleted children
-			commits := d.commits(deletedInfo.Commit.Repo.Name).ReadWrite(stm)
-			parent := lowestCommitInfo.ParentCommit
-			for child := range liveChildren {
-				commitInfo := &pfs.CommitInfo{}
-				if err := commits.Update(child, commitInfo, func() error {
-					commitInfo.ParentCommit = parent
-					return nil
-				}); err != nil {
-					return fmt.Errorf("err updating child commit %v: %v", lowestCommitInfo.Commit, err)
+				deleted[subvCommit.ID] = subvCommitInfo
+				if err := subvCommits.Delete(subvCommit.ID); err != nil {
+					return err
 				}
-			}
-			if parent != nil {
-				commitInfo := &pfs.CommitInfo{}
-				if err := commits.Update(parent.ID, commitInfo, func() error {
-					// Add existing live commits in commitInfo.ChildCommits to the
-					// live children above lowestCommitInfo, then put them all in
-					// 'parent'
-					for _, child := range commitInfo.ChildCommits {
-						if _, ok := deleted[child.ID]; ok {
-							continue
-						}
-						liveChildren[child.ID] = struct{}{}
+				// See the TODO in deleteCommit about repo sizes
+				subvRepoInfo := &pfs.RepoInfo{}
+				if err := d.repos.ReadWrite(stm).Update(subvCommit.Repo.Name, subvRepoInfo, func() error {
+					if err := checkCommitRetention(subvRepoInfo, subvCommitInfo); err != nil {
+						return err
 					}
-					commitInfo.ChildCommits = make([]*pfs.Commit, 0, len(liveChildren))
-					for child := range liveChildren {
-						commitInfo.ChildCommits = append(commitInfo.ChildCommits, client.NewCommit(parent.Repo.Name, child))
+					if subvCommitInfo.SizeBytes > subvRepoInfo.SizeBytes {
+						subvRepoInfo.SizeBytes = 0
+					} else {
+						subvRepoInfo.SizeBytes -= subvCommitInfo.SizeBytes
 					}
 					return nil
 				}); err != nil {
-					return fmt.Errorf("err rewriting children of ancestor commit %v: %v", lowestCommitInfo.Commit, err)
+					return err
 				}
 			}
+			if subvCommit.ID == subv.Lower.ID {
+				break
+			}
+			subvCommit = deleted[subvCommit.ID].ParentCommit
 		}
+	}
+	return nil
+}
 
-		// 7) Traverse affected repos and rewrite all branches so that no branch
-		// points to a deleted commit
-		var shortestBranch *pfs.Branch
-		var shortestBranchLen = maxInt
-		for repo := range affectedRepos {
-			repoInfo := &pfs.RepoInfo{}
-			if err := d.repos.ReadWrite(stm).Get(repo, repoInfo); err != nil {
-				return err
+// repairCommitGraph restores the invariants of the commit graph after the
+// commits in 'deleted' (which span the repos in 'affectedRepos') have been
+// removed from etcd. It removes them from upstream commits' subvenance,
+// reconnects their parents and children, moves branch heads off of them, and
+// finally propagates the changes downstream.
+func (d *driver) repairCommitGraph(stm col.STM, deleted map[string]*pfs.CommitInfo, affectedRepos map[string]struct{}) error {
+	// 1) Remove the commits in 'deleted' from all remaining upstream commits'
+	// subvenance.
+	// Deleted commits may have multiple inputs, and those other inputs must
+	// have their subvenance updated
+	visited := make(map[string]bool) // visitied upstream (provenant) commits
+	for _, deletedInfo := range deleted {
+		for _, provCommit := range deletedInfo.Provenance {
+			// Check if we've fixed provCommit already (or if it's deleted and
+			// doesn't need to be fixed
+			if _, isDeleted := deleted[provCommit.ID]; isDeleted || visited[provCommit.ID] {
+				continue
 			}
-			for _, brokenBranch := range repoInfo.Branches {
-				// Traverse HEAD commit until we find a non-deleted parent or nil;
-				// rewrite branch
-				var branchInfo pfs.BranchInfo
-				if err := d.branches(brokenBranch.Repo.Name).ReadWrite(stm).Update(brokenBranch.Name, &branchInfo, func() error {
-					if len(branchInfo.Provenance) < shortestBranchLen {
-						shortestBranchLen = len(branchInfo.Provenance)
-						shortestBranch = branchInfo.Branch
+			visited[provCommit.ID] = true
+
+			// fix provCommit's subvenance
+			provCI := &pfs.CommitInfo{}
+			if err := d.commits(provCommit.Repo.Name).ReadWrite(stm).Update(provCommit.ID, provCI, func() error {
+				subvTo := 0 // copy subvFrom to subvTo, excepting subv ranges to delete (so that they're overwritten)
+			nextSubvRange:
+				for subvFrom, subv := range provCI.Subvenance {
+					// Compute path (of commit IDs) connecting subv.Upper to subv.Lower
+					cur := subv.Upper.ID
+					path := []string{cur}
+					for cur != subv.Lower.ID {
+						// Get CommitInfo for 'cur' (either in 'deleted' or from etcd)
+						// and traverse parent
+						curInfo, ok := deleted[cur]
+						if !ok {
+							curInfo = &pfs.CommitInfo{}
+							if err := d.commits(subv.Lower.Repo.Name).ReadWrite(stm).Get(cur, curInfo); err != nil {
+								return fmt.Errorf("error reading commitInfo for subvenant \"%s/%s\": %v", subv.Lower.Repo.Name, cur, err)
+							}
+						}
+						if curInfo.ParentCommit == nil {
+							break
+						}
+						cur = curInfo.ParentCommit.ID
+						path = append(path, cur)
 					}
-					for {
-						if branchInfo.Head == nil {
-							return nil // no commits left in branch
+
+					// move 'subv.Upper' through parents until it points to a non-deleted commit
+					for j := range path {
+						if _, ok := deleted[subv.Upper.ID]; !ok {
+							break
+						}
+						if j+1 >= len(path) {
+							// All commits in subvRange are deleted. Remove entire Range
+							// from provCI.Subvenance
+							continue nextSubvRange
 						}
-						headCommitInfo, headIsDeleted := deleted[branchInfo.Head.ID]
-						if !headIsDeleted {
+						subv.Upper.ID = path[j+1]
+					}
+
+					// move 'subv.Lower' through children until it points to a non-deleted commit
+					for j := len(path) - 1; j >= 0; j-- {
+						if _, ok := deleted[subv.Lower.ID]; !ok {
 							break
 						}
-						branchInfo.Head = headCommitInfo.ParentCommit
+						// We'll eventually get to a non-deleted commit because the
+						// 'upper' block didn't exit
+						subv.Lower.ID = path[j-1]
 					}
-					return err
-				}); err != nil && !col.IsErrNotFound(err) {
-					// If err is NotFound, branch is in downstream provenance but
-					// doesn't exist yet--nothing to update
-					return fmt.Errorf("error updating branch %v/%v: %v", brokenBranch.Repo.Name, brokenBranch.Name, err)
+					provCI.Subvenance[subvTo] = provCI.Subvenance[subvFrom]
+					subvTo++
 				}
+				provCI.Subvenance = provCI.Subvenance[:subvTo]
+				return nil
+			}); err != nil {
+				return fmt.Errorf("err fixing subvenance of upstream commit %s/%s: %v", provCommit.Repo.Name, provCommit.ID, err)
 			}
 		}
+	}
 
-		// 8) propagate the changes to 'branch' and its subvenance. This may start
-		// new HEAD commits downstream, if the new branch heads haven't been
-		// processed yet
-		return d.propagateCommit(stm, shortestBranch)
-	}); err != nil {
-		return fmt.Errorf("error rewriting commit graph: %v", err)
+	// 2) Rewrite ParentCommit of deleted commits' children, and
+	// ChildCommits of deleted commits' parents
+	visited = make(map[string]bool) // visited child/parent commits
+	for deletedID, deletedInfo := range deleted {
+		if visited[deletedID] {
+			continue
+		}
+
+		// Traverse downwards until we find the lowest (most ancestral)
+		// non-nil, deleted commit
+		lowestCommitInfo := deletedInfo
+		for {
+			if lowestCommitInfo.ParentCommit == nil {
+				break // parent is nil
+			}
+			parentInfo, ok := deleted[lowestCommitInfo.ParentCommit.ID]
+			if !ok {
+				break // parent is not deleted
+			}
+			lowestCommitInfo = parentInfo // parent exists and is deleted--go down
+		}
+
+		// BFS upwards through graph for all non-deleted children
+		var next *pfs.Commit                            // next vertex to search
+		queue := []*pfs.Commit{lowestCommitInfo.Commit} // queue of vertices to explore
+		liveChildren := make(map[string]struct{})       // live children discovered so far
+		for len(queue) > 0 {
+			next, queue = queue[0], queue[1:]
+			if visited[next.ID] {
+				continue
+			}
+			visited[next.ID] = true
+			nextInfo, ok := deleted[next.ID]
+			if !ok {
+				liveChildren[next.ID] = struct{}{}
+				continue
+			}
+			queue = append(queue, nextInfo.ChildCommits...)
+		}
+
+		// Point all non-deleted children at the first valid parent (or nil),
+		// and point first non-deleted parent at all non-deleted children
+		commits := d.commits(deletedInfo.Commit.Repo.Name).ReadWrite(stm)
+		parent := lowestCommitInfo.ParentCommit
+		for child := range liveChildren {
+			commitInfo := &pfs.CommitInfo{}
+			if err := commits.Update(child, commitInfo, func() error {
+				commitInfo.ParentCommit = parent
+				return nil
+			}); err != nil {
+				return fmt.Errorf("err updating child commit %v: %v", lowestCommitInfo.Commit, err)
+			}
+		}
+		if parent != nil {
+			commitInfo := &pfs.CommitInfo{}
+			if err := commits.Update(parent.ID, commitInfo, func() error {
+				// Add existing live commits in commitInfo.ChildCommits to the
+				// live children above lowestCommitInfo, then put them all in
+				// 'parent'
+				for _, child := range commitInfo.ChildCommits {
+					if _, ok := deleted[child.ID]; ok {
+						continue
+					}
+					liveChildren[child.ID] = struct{}{}
+				}
+				commitInfo.ChildCommits = make([]*pfs.Commit, 0, len(liveChildren))
+				for child := range liveChildren {
+					commitInfo.ChildCommits = append(commitInfo.ChildCommits, client.NewCommit(parent.Repo.Name, child))
+				}
+				return nil
+			}); err != nil {
+				return fmt.Errorf("err rewriting children of ancestor commit %v: %v", lowestCommitInfo.Commit, err)
+			}
+		}
 	}
 
-	// Delete the scratch space for this commit
-	// TODO put scratch spaces in a collection and do this in the txn above
-	if deleteScratch {
-		if _, err := d.etcdClient.Delete(ctx, d.scratchCommitPrefix(userCommit), etcd.WithPrefix()); err != nil {
+	// 3) Traverse affected repos and rewrite all branches so that no branch
+	// points to a deleted commit
+	var shortestBranch *pfs.Branch
+	var shortestBranchLen = maxInt
+	for repo := range affectedRepos {
+		repoInfo := &pfs.RepoInfo{}
+		if err := d.repos.ReadWrite(stm).Get(repo, repoInfo); err != nil {
 			return err
 		}
+		for _, brokenBranch := range repoInfo.Branches {
+			// Traverse HEAD commit until we find a non-deleted parent or nil;
+			// rewrite branch
+			var branchInfo pfs.BranchInfo
+			if err := d.branches(brokenBranch.Repo.Name).ReadWrite(stm).Update(brokenBranch.Name, &branchInfo, func() error {
+				if len(branchInfo.Provenance) < shortestBranchLen {
+					shortestBranchLen = len(branchInfo.Provenance)
+					shortestBranch = branchInfo.Branch
+				}
+				for {
+					if branchInfo.Head == nil {
+						return nil // no commits left in branch
+					}
+					headCommitInfo, headIsDeleted := deleted[branchInfo.Head.ID]
+					if !headIsDeleted {
+						break
+					}
+					branchInfo.Head = headCommitInfo.ParentCommit
+				}
+				return nil
+			}); err != nil && !col.IsErrNotFound(err) {
+				// If err is NotFound, branch is in downstream provenance but
+				// doesn't exist yet--nothing to update
+				return fmt.Errorf("error updating branch %v/%v: %v", brokenBranch.Repo.Name, brokenBranch.Name, err)
+			}
+		}
 	}
-	return nil
+
+	// 4) propagate the changes to 'branch' and its subvenance. This may start
+	// new HEAD commits downstream, if the new branch heads haven't been
+	// processed yet
+	if shortestBranch == nil {
+		return nil // no branches in the affected repos; nothing to propagate
+	}
+	return d.propagateCommit(stm, shortestBranch)
 }
 
 // createBranch creates a new branch or updates an existing branch (must be one
@@ -1565,7 +2358,7 @@ func (d *driver) deleteCommit(pachClient *client.APIClient, userCommit *pfs.Comm
 // for 'branch' itself once 'b.Provenance' has been set.
 func (d *driver) createBranch(pachClient *client.APIClient, branch *pfs.Branch, commit *pfs.Commit, provenance []*pfs.Branch) error {
 	ctx := pachClient.Ctx()
-	if err := d.checkIsAuthorized(pachClient, branch.Repo, auth.Scope_WRITER); err != nil {
+	if err := d.checkIsAuthorizedForBranch(pachClient, branch, auth.Scope_WRITER, branchOpMove); err != nil {
 		return err
 	}
 	// Validate request. The request must do exactly one of:
@@ -1595,6 +2388,10 @@ func (d *driver) createBranch(pachClient *client.APIClient, branch *pfs.Branch,
 			}
 		}
 
+		if err := d.checkNotCommitTag(stm, branch.Repo, branch.Name); err != nil {
+			return err
+		}
+
 		// Retrieve (and create, if necessary) the current version of this branch
 		branches := d.branches(branch.Repo.Name).ReadWrite(stm)
 		branchInfo := &pfs.BranchInfo{}
@@ -1683,6 +2480,9 @@ func (d *driver) createBranch(pachClient *client.APIClient, branch *pfs.Branch,
 func (d *driver) inspectBranch(pachClient *client.APIClient, branch *pfs.Branch) (*pfs.BranchInfo, error) {
 	result := &pfs.BranchInfo{}
 	if err := d.branches(branch.Repo.Name).ReadOnly(pachClient.Ctx()).Get(branch.Name, result); err != nil {
+		if col.IsErrNotFound(err) {
+			return nil, pfsserver.ErrBranchNotFound{Branch: branch}
+		}
 		return nil, err
 	}
 	return result, nil
@@ -1705,7 +2505,7 @@ func (d *driver) listBranch(pachClient *client.APIClient, repo *pfs.Repo) ([]*pf
 }
 
 func (d *driver) deleteBranch(pachClient *client.APIClient, branch *pfs.Branch, force bool) error {
-	if err := d.checkIsAuthorized(pachClient, branch.Repo, auth.Scope_WRITER); err != nil {
+	if err := d.checkIsAuthorizedForBranch(pachClient, branch, auth.Scope_WRITER, branchOpDelete); err != nil {
 		return err
 	}
 	_, err := col.NewSTM(pachClient.Ctx(), d.etcdClient, func(stm col.STM) error {
@@ -1723,6 +2523,9 @@ func (d *driver) deleteBranchSTM(stm col.STM, branch *pfs.Branch, force bool) er
 		}
 	}
 	if branchInfo.Branch != nil {
+		if err := d.checkBranchRetention(stm, branchInfo); err != nil {
+			return err
+		}
 		if !force {
 			if len(branchInfo.Subvenance) > 0 {
 				return fmt.Errorf("branch %s has %v as subvenance, deleting it would break those branches", branch.Name, branchInfo.Subvenance)
@@ -1753,776 +2556,2546 @@ func (d *driver) deleteBranchSTM(stm col.STM, branch *pfs.Branch, force bool) er
 	return nil
 }
 
-func (d *driver) scratchPrefix() string {
-	return path.Join(d.prefix, "scratch")
+// checkBranchRetention returns an ErrRetentionLocked if the branch in
+// 'branchInfo' is retention-locked and its head is still retained.
+func (d *driver) checkBranchRetention(stm col.STM, branchInfo *pfs.BranchInfo) error {
+	if branchInfo.Head == nil {
+		return nil
+	}
+	repoInfo := &pfs.RepoInfo{}
+	if err := d.repos.ReadWrite(stm).Get(branchInfo.Branch.Repo.Name, repoInfo); err != nil {
+		if col.IsErrNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !isBranchRetentionLocked(repoInfo.RetentionLock, branchInfo.Branch.Name) {
+		return nil
+	}
+	headInfo := &pfs.CommitInfo{}
+	if err := d.commits(branchInfo.Branch.Repo.Name).ReadWrite(stm).Get(branchInfo.Head.ID, headInfo); err != nil {
+		if col.IsErrNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return checkCommitRetention(repoInfo, headInfo)
 }
 
-// scratchCommitPrefix returns an etcd prefix that's used to temporarily
-// store the state of a file in an open commit.  Once the commit is finished,
-// the scratch space is removed.
-func (d *driver) scratchCommitPrefix(commit *pfs.Commit) string {
-	// TODO(msteffen) this doesn't currenty (2018-2-4) use d.scratchPrefix(),
-	// but probably should? If this is changed, filepathFromEtcdPath will also
-	// need to change.
-	return path.Join(commit.Repo.Name, commit.ID)
+func (d *driver) protectBranch(pachClient *client.APIClient, branch *pfs.Branch, protection *pfs.BranchProtection) error {
+	if err := d.checkIsAuthorized(pachClient, branch.Repo, auth.Scope_OWNER); err != nil {
+		return err
+	}
+	if protection != nil {
+		// As in ACLs, principals with no prefix are assumed to be GitHub users
+		for i, principal := range protection.AllowedPrincipals {
+			if !strings.Contains(principal, ":") {
+				protection.AllowedPrincipals[i] = auth.GitHubPrefix + principal
+			}
+		}
+	}
+	_, err := col.NewSTM(pachClient.Ctx(), d.etcdClient, func(stm col.STM) error {
+		branchInfo := &pfs.BranchInfo{}
+		return d.branches(branch.Repo.Name).ReadWrite(stm).Update(branch.Name, branchInfo, func() error {
+			branchInfo.Protection = protection
+			return nil
+		})
+	})
+	return err
 }
 
-// scratchFilePrefix returns an etcd prefix that's used to temporarily
-// store the state of a file in an open commit.  Once the commit is finished,
-// the scratch space is removed.
-func (d *driver) scratchFilePrefix(file *pfs.File) (string, error) {
-	return path.Join(d.scratchCommitPrefix(file.Commit), file.Path), nil
+func (d *driver) unprotectBranch(pachClient *client.APIClient, branch *pfs.Branch) error {
+	return d.protectBranch(pachClient, branch, nil)
 }
 
-func (d *driver) filePathFromEtcdPath(etcdPath string) string {
-	etcdPath = strings.TrimPrefix(etcdPath, d.prefix)
-	// etcdPath looks like /putFileRecords/repo/commit/path/to/file
-	split := strings.Split(etcdPath, "/")
-	// we only want /path/to/file so we use index 4 (note that there's an "" at
-	// the beginning of the slice because of the lead /)
-	return path.Join(split[4:]...)
+// checkNotCommitTag returns an error if 'name' is the name of a commit tag in
+// 'repo'. Branches and commit tags share a namespace, as both can be used in
+// place of a commit ID.
+func (d *driver) checkNotCommitTag(stm col.STM, repo *pfs.Repo, name string) error {
+	err := d.commitTags(repo.Name).ReadWrite(stm).Get(name, &pfs.CommitTagInfo{})
+	if err == nil {
+		return fmt.Errorf("\"%s\" is already the name of a commit tag in repo %s", name, repo.Name)
+	}
+	if !col.IsErrNotFound(err) {
+		return err
+	}
+	return nil
 }
 
-func (d *driver) putFiles(pachClient *client.APIClient, s *putFileServer) error {
-	req, err := s.Peek()
-	if err != nil {
+func (d *driver) createCommitTag(pachClient *client.APIClient, tag *pfs.CommitTag, commit *pfs.Commit) error {
+	if err := d.checkIsAuthorized(pachClient, tag.Repo, auth.Scope_WRITER); err != nil {
 		return err
 	}
-	commit := req.File.Commit
-	// oneOff is true if we're creating the commit as part of this put-file
-	oneOff := false
-	// inspectCommit will replace file.Commit.ID with an actual commit ID if
-	// it's a branch. So we want to save it first.
-	branch := ""
-	if !uuid.IsUUIDWithoutDashes(commit.ID) {
-		branch = commit.ID
+	if err := validateCommitTagName(tag.Name); err != nil {
+		return err
 	}
-	commitInfo, err := d.inspectCommit(pachClient, commit, pfs.CommitState_STARTED)
-	if err != nil {
-		if (!isNotFoundErr(err) && !isNoHeadErr(err)) || branch == "" {
+	if commit.Repo.Name != tag.Repo.Name {
+		return fmt.Errorf("cannot tag commit %s/%s with a tag in repo %s", commit.Repo.Name, commit.ID, tag.Repo.Name)
+	}
+	_, err := col.NewSTM(pachClient.Ctx(), d.etcdClient, func(stm col.STM) error {
+		// Rewrite the repo, so that a concurrent deleteRepo (which reads it in
+		// its STM) retries and sees the new tag
+		repos := d.repos.ReadWrite(stm)
+		repoInfo := &pfs.RepoInfo{}
+		if err := repos.Get(tag.Repo.Name, repoInfo); err != nil {
 			return err
 		}
-		oneOff = true
-	}
-	if commitInfo != nil && commitInfo.Finished != nil {
-		if branch == "" {
-			return pfsserver.ErrCommitFinished{commit}
+		if err := repos.Put(tag.Repo.Name, repoInfo); err != nil {
+			return err
 		}
-		oneOff = true
-	}
-
-	var files []*pfs.File
-	var putFilePaths []string
-	var putFileRecords []*pfs.PutFileRecords
-	var mu sync.Mutex
-	if err := forEachPutFile(s, func(req *pfs.PutFileRequest, r io.Reader) error {
-		records, err := d.putFile(pachClient, req.File, req.Delimiter, req.TargetFileDatums,
-			req.TargetFileBytes, req.HeaderRecords, req.OverwriteIndex, r)
+		branchInfo := &pfs.BranchInfo{}
+		if err := d.branches(tag.Repo.Name).ReadWrite(stm).Get(tag.Name, branchInfo); err == nil {
+			return fmt.Errorf("\"%s\" is already the name of a branch in repo %s", tag.Name, tag.Repo.Name)
+		} else if !col.IsErrNotFound(err) {
+			return err
+		}
+		commitInfo, err := d.resolveCommit(stm, commit)
 		if err != nil {
 			return err
 		}
-		mu.Lock()
-		defer mu.Unlock()
-		files = append(files, req.File)
-		putFilePaths = append(putFilePaths, req.File.Path)
-		putFileRecords = append(putFileRecords, records)
+		// Commit tags are immutable, so Create fails if 'tag' already exists
+		if err := d.commitTags(tag.Repo.Name).ReadWrite(stm).Create(tag.Name, &pfs.CommitTagInfo{
+			Tag:     tag,
+			Commit:  commitInfo.Commit,
+			Created: now(),
+		}); err != nil {
+			return err
+		}
+		commitInfo.Tags = append(commitInfo.Tags, tag)
+		return d.commits(tag.Repo.Name).ReadWrite(stm).Put(commitInfo.Commit.ID, commitInfo)
+	})
+	return err
+}
+
+func (d *driver) listCommitTag(pachClient *client.APIClient, repo *pfs.Repo) ([]*pfs.CommitTagInfo, error) {
+	if err := d.checkIsAuthorized(pachClient, repo, auth.Scope_READER); err != nil {
+		return nil, err
+	}
+	var result []*pfs.CommitTagInfo
+	commitTagInfo := &pfs.CommitTagInfo{}
+	commitTags := d.commitTags(repo.Name).ReadOnly(pachClient.Ctx())
+	if err := commitTags.List(commitTagInfo, col.DefaultOptions, func(string) error {
+		result = append(result, proto.Clone(commitTagInfo).(*pfs.CommitTagInfo))
 		return nil
 	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkNoCommitTags returns an ErrCommitTagged if any commit in 'repo' is
+// tagged
+func (d *driver) checkNoCommitTags(ctx context.Context, repo *pfs.Repo) error {
+	var tagged *pfs.CommitTagInfo
+	commitTagInfo := &pfs.CommitTagInfo{}
+	if err := d.commitTags(repo.Name).ReadOnly(ctx).List(commitTagInfo, col.DefaultOptions, func(string) error {
+		tagged = commitTagInfo
+		return errutil.ErrBreak
+	}); err != nil && err != errutil.ErrBreak {
 		return err
 	}
-	if oneOff {
-		// oneOff puts only work on branches, so we know branch != "". We pass
-		// a commit with no ID, that ID will be filled in with the head of
-		// branch (if it exists).
-		_, err := d.makeCommit(pachClient, "", client.NewCommit(commit.Repo.Name, ""), branch, nil, nil, putFilePaths, putFileRecords, "")
+	if tagged != nil {
+		return pfsserver.ErrCommitTagged{Commit: tagged.Commit, Tag: tagged.Tag}
+	}
+	return nil
+}
+
+func (d *driver) deleteCommitTag(pachClient *client.APIClient, tag *pfs.CommitTag) error {
+	if err := d.checkIsAuthorized(pachClient, tag.Repo, auth.Scope_WRITER); err != nil {
 		return err
 	}
-	for i, file := range files {
-		if err := d.upsertPutFileRecords(pachClient, file, putFileRecords[i]); err != nil {
+	_, err := col.NewSTM(pachClient.Ctx(), d.etcdClient, func(stm col.STM) error {
+		commitTags := d.commitTags(tag.Repo.Name).ReadWrite(stm)
+		commitTagInfo := &pfs.CommitTagInfo{}
+		if err := commitTags.Get(tag.Name, commitTagInfo); err != nil {
+			return err
+		}
+		if err := commitTags.Delete(tag.Name); err != nil {
 			return err
 		}
+		commitInfo := &pfs.CommitInfo{}
+		if err := d.commits(tag.Repo.Name).ReadWrite(stm).Update(commitTagInfo.Commit.ID, commitInfo, func() error {
+			for i, commitTag := range commitInfo.Tags {
+				if commitTag.Name == tag.Name {
+					commitInfo.Tags = append(commitInfo.Tags[:i], commitInfo.Tags[i+1:]...)
+					break
+				}
+			}
+			return nil
+		}); err != nil && !col.IsErrNotFound(err) {
+			return err
+		}
+		return nil
+	})
+	return err
+}
+
+// pruneBatchSize is the maximum number of commits that are squashed away in
+// a single etcd transaction while pruning
+const pruneBatchSize = 50
+
+func (d *driver) setRetentionPolicy(pachClient *client.APIClient, repo *pfs.Repo, branch string, policy *pfs.RetentionPolicy) error {
+	if err := d.checkIsAuthorized(pachClient, repo, auth.Scope_OWNER); err != nil {
+		return err
 	}
-	return nil
+	if policy != nil {
+		// A policy that keeps nothing would prune everything but the head,
+		// which is more likely a mistake than what was intended
+		if policy.KeepLast == 0 && policy.KeepWithin == nil {
+			return fmt.Errorf("retention policy must keep commits by count, by age, or both")
+		}
+		if policy.KeepWithin != nil {
+			keepWithin, err := types.DurationFromProto(policy.KeepWithin)
+			if err != nil {
+				return err
+			}
+			if keepWithin <= 0 {
+				return fmt.Errorf("retention policy's age limit must be positive")
+			}
+		}
+	}
+	_, err := col.NewSTM(pachClient.Ctx(), d.etcdClient, func(stm col.STM) error {
+		if branch == "" {
+			repoInfo := &pfs.RepoInfo{}
+			return d.repos.ReadWrite(stm).Update(repo.Name, repoInfo, func() error {
+				repoInfo.Retention = policy
+				return nil
+			})
+		}
+		branchInfo := &pfs.BranchInfo{}
+		return d.branches(repo.Name).ReadWrite(stm).Update(branch, branchInfo, func() error {
+			branchInfo.Retention = policy
+			return nil
+		})
+	})
+	return err
 }
 
-func (d *driver) putFile(pachClient *client.APIClient, file *pfs.File, delimiter pfs.Delimiter,
-	targetFileDatums, targetFileBytes, headerRecords int64, overwriteIndex *pfs.OverwriteIndex,
-	reader io.Reader) (*pfs.PutFileRecords, error) {
-	if err := d.checkIsAuthorized(pachClient, file.Commit.Repo, auth.Scope_WRITER); err != nil {
+// setRetentionLock sets the retention lock of 'repo', or extends its existing
+// lock. Locks can't be shortened or removed, so 'period' must be at least the
+// existing lock's period, and 'branches' must include every branch the
+// existing lock covers.
+func (d *driver) setRetentionLock(pachClient *client.APIClient, repo *pfs.Repo, period *types.Duration, branches []string) error {
+	ctx := pachClient.Ctx()
+	if err := d.checkIsAuthorized(pachClient, repo, auth.Scope_OWNER); err != nil {
+		return err
+	}
+	if period == nil {
+		return fmt.Errorf("a retention lock must have a period")
+	}
+	newPeriod, err := types.DurationFromProto(period)
+	if err != nil {
+		return err
+	}
+	if newPeriod <= 0 {
+		return fmt.Errorf("a retention lock's period must be positive")
+	}
+	for _, branch := range branches {
+		if branch == "" {
+			return fmt.Errorf("retention-locked branch names can't be empty")
+		}
+	}
+
+	// The lock covers commits that already exist, so find the newest of them
+	var commitInfos []*pfs.CommitInfo
+	commitInfo := &pfs.CommitInfo{}
+	if err := d.commits(repo.Name).ReadOnly(ctx).List(commitInfo, col.DefaultOptions, func(string) error {
+		commitInfos = append(commitInfos, proto.Clone(commitInfo).(*pfs.CommitInfo))
+		return nil
+	}); err != nil {
+		return err
+	}
+	_, err = col.NewSTM(ctx, d.etcdClient, func(stm col.STM) error {
+		repoInfo := &pfs.RepoInfo{}
+		return d.repos.ReadWrite(stm).Update(repo.Name, repoInfo, func() error {
+			lock := &pfs.RetentionLock{
+				Period:   period,
+				Branches: branches,
+				Locked:   now(),
+			}
+			if existing := repoInfo.RetentionLock; existing != nil {
+				existingPeriod, err := types.DurationFromProto(existing.Period)
+				if err != nil {
+					return err
+				}
+				if newPeriod < existingPeriod {
+					return fmt.Errorf("the retention lock of repo \"%s\" can't be shortened from %v", repo.Name, existingPeriod)
+				}
+				if len(branches) > 0 {
+					if len(existing.Branches) == 0 {
+						return fmt.Errorf("every branch in repo \"%s\" is retention-locked, and branches can't be unlocked", repo.Name)
+					}
+					for _, branch := range existing.Branches {
+						if !isBranchRetentionLocked(lock, branch) {
+							return fmt.Errorf("branch \"%s\" in repo \"%s\" is retention-locked, and branches can't be unlocked", branch, repo.Name)
+						}
+					}
+				}
+				lock.Locked = existing.Locked
+				lock.RetainedUntil = existing.RetainedUntil
+			}
+			repoInfo.RetentionLock = lock
+			// Branch heads are read inside the txn, so that commits created
+			// since the commits were listed are covered as well
+			for _, branch := range repoInfo.Branches {
+				branchInfo := &pfs.BranchInfo{}
+				if err := d.branches(repo.Name).ReadWrite(stm).Get(branch.Name, branchInfo); err != nil {
+					if col.IsErrNotFound(err) {
+						continue
+					}
+					return err
+				}
+				if branchInfo.Head == nil {
+					continue
+				}
+				headInfo := &pfs.CommitInfo{}
+				if err := d.commits(repo.Name).ReadWrite(stm).Get(branchInfo.Head.ID, headInfo); err != nil {
+					return err
+				}
+				if err := extendRetention(repoInfo, headInfo); err != nil {
+					return err
+				}
+			}
+			for _, commitInfo := range commitInfos {
+				if err := extendRetention(repoInfo, commitInfo); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	return err
+}
+
+func (d *driver) pruneCommits(pachClient *client.APIClient, repo *pfs.Repo) ([]*pfs.PrunedCommitInfo, error) {
+	if err := d.checkIsAuthorized(pachClient, repo, auth.Scope_WRITER); err != nil {
 		return nil, err
 	}
-	//  validation -- make sure the various putFileSplit options are coherent
-	hasPutFileOptions := targetFileBytes != 0 || targetFileDatums != 0 || headerRecords != 0
-	if hasPutFileOptions && delimiter == pfs.Delimiter_NONE {
-		return nil, fmt.Errorf("cannot set split options--targetFileBytes, targetFileDatums, or headerRecords--with delimiter == NONE, split disabled")
+	return d.pruneRepo(pachClient.Ctx(), pachClient, repo)
+}
+
+func (d *driver) listPrunedCommits(pachClient *client.APIClient, repo *pfs.Repo) ([]*pfs.PrunedCommitInfo, error) {
+	if err := d.checkIsAuthorized(pachClient, repo, auth.Scope_READER); err != nil {
+		return nil, err
 	}
-	records := &pfs.PutFileRecords{}
-	if overwriteIndex != nil && overwriteIndex.Index == 0 {
-		records.Tombstone = true
+	var result []*pfs.PrunedCommitInfo
+	prunedCommitInfo := &pfs.PrunedCommitInfo{}
+	if err := d.prunedCommits(repo.Name).ReadOnly(pachClient.Ctx()).List(prunedCommitInfo, col.DefaultOptions, func(string) error {
+		result = append(result, proto.Clone(prunedCommitInfo).(*pfs.PrunedCommitInfo))
+		return nil
+	}); err != nil {
+		return nil, err
 	}
-	if err := hashtree.ValidatePath(file.Path); err != nil {
+	return result, nil
+}
+
+// pruneRepo applies the retention policies of 'repo' and its branches,
+// squashing away the commits that they don't keep, and returns the commits
+// that were removed. It doesn't check the caller's access to the repo, as
+// it's also run by the PFS master (which passes a nil 'pachClient'), but
+// commits in the history of protected branches are only squashed if
+// 'pachClient' may rewrite them.
+func (d *driver) pruneRepo(ctx context.Context, pachClient *client.APIClient, repo *pfs.Repo) ([]*pfs.PrunedCommitInfo, error) {
+	repoInfo := &pfs.RepoInfo{}
+	if err := d.repos.ReadOnly(ctx).Get(repo.Name, repoInfo); err != nil {
 		return nil, err
 	}
+	var branchInfos []*pfs.BranchInfo
+	heads := make(map[string]bool)
+	for _, branch := range repoInfo.Branches {
+		branchInfo := &pfs.BranchInfo{}
+		if err := d.branches(repo.Name).ReadOnly(ctx).Get(branch.Name, branchInfo); err != nil {
+			if col.IsErrNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if branchInfo.Head != nil {
+			heads[branchInfo.Head.ID] = true
+		}
+		branchInfos = append(branchInfos, branchInfo)
+	}
+	var result []*pfs.PrunedCommitInfo
+	for _, branchInfo := range branchInfos {
+		policy := branchInfo.Retention
+		if policy == nil {
+			policy = repoInfo.Retention
+		}
+		if policy == nil || branchInfo.Head == nil {
+			continue
+		}
+		pruned, err := d.pruneBranch(ctx, pachClient, repoInfo, branchInfo, policy, heads)
+		result = append(result, pruned...)
+		if err != nil {
+			return result, fmt.Errorf("error pruning branch \"%s\": %v", branchInfo.Branch.Name, err)
+		}
+	}
+	return result, nil
+}
 
-	if delimiter == pfs.Delimiter_NONE {
-		objects, size, err := pachClient.PutObjectSplit(reader)
+// pruneBranch squashes away the commits in the history of 'branchInfo' that
+// aren't kept by 'policy'. Each run of consecutive prunable commits is
+// squashed into the commit above it, so no data visible in a surviving commit
+// is lost. Commits that can't be removed without breaking something else
+// (they have downstream commits, are tagged, are the head of a branch in
+// 'heads', have several children, or are protected by the retention lock of
+// 'repoInfo') are skipped.
+func (d *driver) pruneBranch(ctx context.Context, pachClient *client.APIClient, repoInfo *pfs.RepoInfo, branchInfo *pfs.BranchInfo, policy *pfs.RetentionPolicy, heads map[string]bool) ([]*pfs.PrunedCommitInfo, error) {
+	repo := branchInfo.Branch.Repo
+	var cutoff time.Time
+	if policy.KeepWithin != nil {
+		keepWithin, err := types.DurationFromProto(policy.KeepWithin)
 		if err != nil {
 			return nil, err
 		}
-
-		// Here we use the invariant that every one but the last object
-		// should have a size of ChunkSize.
-		for i, object := range objects {
-			record := &pfs.PutFileRecord{
-				ObjectHash: object.Hash,
-			}
-
-			if size > pfs.ChunkSize {
-				record.SizeBytes = pfs.ChunkSize
-			} else {
-				record.SizeBytes = size
+		cutoff = time.Now().Add(-keepWithin)
+	}
+	keep := func(i int, commitInfo *pfs.CommitInfo) bool {
+		if i == 0 || uint64(i) < policy.KeepLast || commitInfo.Finished == nil {
+			return true
+		}
+		if policy.KeepWithin != nil {
+			finished, err := types.TimestampFromProto(commitInfo.Finished)
+			if err != nil || finished.After(cutoff) {
+				return true
 			}
-			size -= pfs.ChunkSize
+		}
+		return false
+	}
 
-			// The first record takes care of the overwriting
-			if i == 0 && overwriteIndex != nil && overwriteIndex.Index != 0 {
-				record.OverwriteIndex = overwriteIndex
-			}
+	// Read the branch's history, from its head back to the first commit that
+	// the policy doesn't keep
+	commits := d.commits(repo.Name).ReadOnly(ctx)
+	var history []*pfs.CommitInfo
+	pruneFrom := -1
+	for commit := branchInfo.Head; commit != nil; {
+		commitInfo := &pfs.CommitInfo{}
+		if err := commits.Get(commit.ID, commitInfo); err != nil {
+			return nil, err
+		}
+		if pruneFrom < 0 && !keep(len(history), commitInfo) {
+			pruneFrom = len(history)
+		}
+		history = append(history, commitInfo)
+		commit = commitInfo.ParentCommit
+	}
+	if pruneFrom < 0 {
+		return nil, nil
+	}
 
-			records.Records = append(records.Records, record)
+	var result []*pfs.PrunedCommitInfo
+	upper := history[pruneFrom-1]
+	var run []*pfs.CommitInfo
+	squashRun := func() error {
+		if len(run) == 0 {
+			return nil
 		}
-	} else {
-		var (
-			buffer        = &bytes.Buffer{}
-			datumsWritten int64
-			bytesWritten  int64
-			filesPut      int
-			// Note: this code generally distinguishes between nil header/footer (no
-			// header) and empty header/footer. To create a header-enabled directory
-			// with an empty header, allocate an empty slice & store it here
-			header    []byte
-			footer    []byte
-			EOF       = false
-			eg        errgroup.Group
-			bufioR    = bufio.NewReader(reader)
-			decoder   = json.NewDecoder(bufioR)
-			sqlReader = sql.NewPGDumpReader(bufioR)
-			csvReader = csv.NewReader(bufioR)
-			csvBuffer bytes.Buffer
-			csvWriter = csv.NewWriter(&csvBuffer)
-			// indexToRecord serves as a de-facto slice of PutFileRecords. We can't
-			// use a real slice of PutFileRecords b/c indexToRecord has data appended
-			// to it by concurrent processes, and you can't append() to a slice
-			// concurrently (append() might allocate a new slice while a goro holds an
-			// stale pointer)
-			indexToRecord = make(map[int]*pfs.PutFileRecord)
-			mu            sync.Mutex
-		)
-		csvReader.FieldsPerRecord = -1 // ignore unexpected # of fields, for now
-		csvReader.ReuseRecord = true   // returned rows are written to buffer immediately
-		for !EOF {
-			var err error
-			var value []byte
-			var csvRow []string // only used if delimiter == CSV
-			switch delimiter {
-			case pfs.Delimiter_JSON:
-				var jsonValue json.RawMessage
-				err = decoder.Decode(&jsonValue)
-				value = jsonValue
-			case pfs.Delimiter_LINE:
-				value, err = bufioR.ReadBytes('\n')
-			case pfs.Delimiter_SQL:
-				value, err = sqlReader.ReadRow()
-				if err == io.EOF {
-					if header == nil {
-						header = sqlReader.Header
-					} else {
-						// header contains SQL records if anything, which should come after
-						// the sqlReader header, which creates tables & initializes the DB
-						header = append(sqlReader.Header, header...)
-					}
-					footer = sqlReader.Footer
-				}
-			case pfs.Delimiter_CSV:
-				csvBuffer.Reset()
-				if csvRow, err = csvReader.Read(); err == nil {
-					if err := csvWriter.Write(csvRow); err != nil {
-						return nil, fmt.Errorf("error parsing csv record: %v", err)
-					}
-					if csvWriter.Flush(); csvWriter.Error() != nil {
-						return nil, fmt.Errorf("error copying csv record: %v", csvWriter.Error())
-					}
-					value = csvBuffer.Bytes()
-				}
-			default:
-				return nil, fmt.Errorf("unrecognized delimiter %s", delimiter.String())
+		var pruned []*pfs.PrunedCommitInfo
+		if _, err := col.NewSTM(ctx, d.etcdClient, func(stm col.STM) error {
+			pruned = nil
+			commitRange := &pfs.CommitRange{
+				Lower: client.NewCommit(repo.Name, run[len(run)-1].Commit.ID),
+				Upper: client.NewCommit(repo.Name, upper.Commit.ID),
 			}
-			if err != nil {
-				if err == io.EOF {
-					EOF = true
-				} else {
-					return nil, err
-				}
+			if err := d.squashCommitSTM(pachClient, stm, commitRange, false); err != nil {
+				return err
 			}
-			buffer.Write(value)
-			bytesWritten += int64(len(value))
-			datumsWritten++
-			var (
-				headerDone         = headerRecords == 0 || header != nil
-				headerReady        = !headerDone && datumsWritten >= headerRecords
-				hitFileBytesLimit  = headerDone && targetFileBytes != 0 && bytesWritten >= targetFileBytes
-				hitFileDatumsLimit = headerDone && targetFileDatums != 0 && datumsWritten >= targetFileDatums
-				noLimitsSet        = headerDone && targetFileBytes == 0 && targetFileDatums == 0
-			)
-			if buffer.Len() != 0 &&
-				(headerReady || hitFileBytesLimit || hitFileDatumsLimit || noLimitsSet || EOF) {
-				_buffer := buffer
-				if !headerDone /* implies headerReady || EOF */ {
-					header = _buffer.Bytes() // record header
-				} else {
-					// put contents
-					_bufferLen := int64(_buffer.Len())
-					index := filesPut
-					filesPut++
-					d.memoryLimiter.Acquire(pachClient.Ctx(), _bufferLen)
-					putObjectLimiter.Acquire()
-					eg.Go(func() error {
-						defer putObjectLimiter.Release()
-						defer d.memoryLimiter.Release(_bufferLen)
-						object, size, err := pachClient.PutObject(_buffer)
-						if err != nil {
-							return err
-						}
-						mu.Lock()
-						defer mu.Unlock()
-						indexToRecord[index] = &pfs.PutFileRecord{
-							SizeBytes:  size,
-							ObjectHash: object.Hash,
-						}
-						return nil
-					})
+			prunedCommits := d.prunedCommits(repo.Name).ReadWrite(stm)
+			for _, commitInfo := range run {
+				prunedCommitInfo := &pfs.PrunedCommitInfo{
+					Commit:       commitInfo.Commit,
+					Branch:       branchInfo.Branch,
+					SquashedInto: upper.Commit,
+					Finished:     commitInfo.Finished,
+					Pruned:       now(),
 				}
-				buffer = &bytes.Buffer{} // can't reset buffer b/c _buffer still in use
-				datumsWritten = 0
-				bytesWritten = 0
+				if err := prunedCommits.PutTTL(commitInfo.Commit.ID, prunedCommitInfo, int64(prunedCommitTTL/time.Second)); err != nil {
+					return err
+				}
+				pruned = append(pruned, prunedCommitInfo)
 			}
+			return nil
+		}); err != nil {
+			return err
 		}
-		if err := eg.Wait(); err != nil {
-			return nil, err
-		}
-
-		records.Split = true
-		for i := 0; i < len(indexToRecord); i++ {
-			records.Records = append(records.Records, indexToRecord[i])
+		for _, prunedCommitInfo := range pruned {
+			logrus.Infof("pruned commit %s/%s (squashed into %s) under the retention policy of branch \"%s\"",
+				repo.Name, prunedCommitInfo.Commit.ID, prunedCommitInfo.SquashedInto.ID, branchInfo.Branch.Name)
 		}
-
-		// Put 'header' and 'footer' in PutFileRecords
-		setHeaderFooter := func(value []byte, hf **pfs.PutFileRecord) {
-			// always put empty header, even if 'value' is empty, so
-			// that the parent dir is a header/footer dir
-			*hf = &pfs.PutFileRecord{}
-			if len(value) > 0 {
-				putObjectLimiter.Acquire()
-				eg.Go(func() error {
-					defer putObjectLimiter.Release()
-					object, size, err := pachClient.PutObject(bytes.NewReader(value))
-					if err != nil {
-						return err
-					}
-					(*hf).SizeBytes = size
-					(*hf).ObjectHash = object.Hash
-					return nil
-				})
+		result = append(result, pruned...)
+		run = nil
+		return nil
+	}
+	for _, commitInfo := range history[pruneFrom:] {
+		until, err := retainedUntil(repoInfo, commitInfo)
+		if err != nil {
+			return result, err
+		}
+		if commitInfo.Finished == nil || heads[commitInfo.Commit.ID] || len(commitInfo.Subvenance) > 0 ||
+			len(commitInfo.Tags) > 0 || len(commitInfo.ChildCommits) > 1 || time.Now().Before(until) {
+			// 'commitInfo' must be kept, so older commits are squashed into it
+			// instead
+			if err := squashRun(); err != nil {
+				return result, err
 			}
+			upper = commitInfo
+			continue
 		}
-		if header != nil {
-			setHeaderFooter(header, &records.Header)
-		}
-		if footer != nil {
-			setHeaderFooter(footer, &records.Footer)
-		}
-		if err := eg.Wait(); err != nil {
-			return nil, err
+		run = append(run, commitInfo)
+		if len(run) == pruneBatchSize {
+			if err := squashRun(); err != nil {
+				return result, err
+			}
 		}
 	}
-	return records, nil
+	if err := squashRun(); err != nil {
+		return result, err
+	}
+	return result, nil
 }
 
-// headerDirToPutFileRecords is a helper for copyFile that handles copying
-// header/footer directories.
-//
-// Copy uses essentially the same codepath as putFile--it converts hashtree
-// node(s) to PutFileRecords and then uses applyWrite to put the records back
-// in the target hashtree. In putFile, the only way to create a headerDir is
-// with PutFileSplit (PutFileRecord with Split==true). Rather than split the
-// putFile codepath by adding a special case to applyWrite that was valid for
-// copyFile but invalid for putFile, we use heaaderDirToPutFileRecords to
-// convert a DirectoryNode to a PutFileRecord+Split==true, which applyWrite
-// will correctly convert back to a header dir in the target hashtree via the
-// regular putFile codepath.
-func headerDirToPutFileRecords(tree hashtree.HashTree, path string, node *hashtree.NodeProto) (*pfs.PutFileRecords, error) {
-	if node.DirNode == nil || node.DirNode.Shared == nil {
-		return nil, fmt.Errorf("headerDirToPutFileRecords only works on header/footer dirs")
-	}
-	s := node.DirNode.Shared
-	pfr := &pfs.PutFileRecords{
-		Split: true,
+// mergeBranch merges the changes made on 'from' since its common ancestor
+// with 'to' into 'to'. If 'to' has no changes of its own since then, 'to' is
+// fast-forwarded to the head of 'from'. Otherwise a new commit is created on
+// 'to' that contains the changes from both branches, with conflicting changes
+// resolved according to 'strategy'.
+func (d *driver) mergeBranch(pachClient *client.APIClient, from *pfs.Branch, to *pfs.Branch, strategy pfs.MergeStrategy, description string) (*pfs.MergeBranchResponse, error) {
+	if from.Repo.Name != to.Repo.Name {
+		return nil, fmt.Errorf("cannot merge branches in different repos \"%s\" and \"%s\"", from.Repo.Name, to.Repo.Name)
 	}
-	if s.Header != nil {
-		pfr.Header = &pfs.PutFileRecord{
-			SizeBytes:  s.HeaderSize,
-			ObjectHash: s.Header.Hash,
-		}
+	if err := d.checkIsAuthorizedForBranch(pachClient, to, auth.Scope_WRITER, branchOpMove); err != nil {
+		return nil, err
 	}
-	if s.Footer != nil {
-		pfr.Footer = &pfs.PutFileRecord{
-			SizeBytes:  s.FooterSize,
-			ObjectHash: s.Footer.Hash,
-		}
+	fromBranchInfo, err := d.inspectBranch(pachClient, from)
+	if err != nil {
+		return nil, err
 	}
-	if err := tree.List(path, func(child *hashtree.NodeProto) error {
-		if child.FileNode == nil {
-			return fmt.Errorf("header/footer dir contains child subdirectory, " +
-				"which is invalid--header/footer dirs must be created by PutFileSplit")
-		}
-		for i, o := range child.FileNode.Objects {
-			// same hack as copyFile--set size of first object to the size of the
-			// whole subtree (and size of other objects to 0). I don't think
-			// PutFileSplit files can have more than one object, but that invariant
-			// isn't necessary to this code's correctness, so don't verify it.
-			var size int64
-			if i == 0 {
-				size = child.SubtreeSize
-			}
-			pfr.Records = append(pfr.Records, &pfs.PutFileRecord{
-				SizeBytes:  size,
-				ObjectHash: o.Hash,
-			})
-		}
-		return nil
-	}); err != nil {
+	toBranchInfo, err := d.inspectBranch(pachClient, to)
+	if err != nil {
 		return nil, err
 	}
-	return pfr, nil // TODO(msteffen) put something real here
-}
-
-func (d *driver) copyFile(pachClient *client.APIClient, src *pfs.File, dst *pfs.File, overwrite bool) error {
-	if err := d.checkIsAuthorized(pachClient, src.Commit.Repo, auth.Scope_READER); err != nil {
-		return err
+	if len(fromBranchInfo.Provenance) > 0 || len(toBranchInfo.Provenance) > 0 {
+		return nil, fmt.Errorf("cannot merge output branches")
 	}
-	if err := d.checkIsAuthorized(pachClient, dst.Commit.Repo, auth.Scope_WRITER); err != nil {
-		return err
+	if fromBranchInfo.Head == nil {
+		// nothing to merge
+		return &pfs.MergeBranchResponse{Commit: toBranchInfo.Head}, nil
 	}
-	if err := hashtree.ValidatePath(dst.Path); err != nil {
-		return err
+	theirsInfo, err := d.inspectCommit(pachClient, fromBranchInfo.Head, pfs.CommitState_STARTED)
+	if err != nil {
+		return nil, err
 	}
-	branch := ""
-	if !uuid.IsUUIDWithoutDashes(dst.Commit.ID) {
-		branch = dst.Commit.ID
+	if theirsInfo.Finished == nil {
+		return nil, fmt.Errorf("cannot merge branch \"%s\" as its head commit %s is open", from.Name, theirsInfo.Commit.ID)
 	}
-	var dstIsOpenCommit bool
-	if ci, err := d.inspectCommit(pachClient, dst.Commit, pfs.CommitState_STARTED); err != nil {
-		if !isNoHeadErr(err) {
-			return err
+	if toBranchInfo.Head == nil {
+		if err := d.createBranch(pachClient, to, theirsInfo.Commit, nil); err != nil {
+			return nil, err
 		}
-	} else if ci.Finished == nil {
-		dstIsOpenCommit = true
+		return &pfs.MergeBranchResponse{Commit: theirsInfo.Commit}, nil
 	}
-	if !dstIsOpenCommit && branch == "" {
-		return pfsserver.ErrCommitFinished{dst.Commit}
+	oursInfo, err := d.inspectCommit(pachClient, toBranchInfo.Head, pfs.CommitState_STARTED)
+	if err != nil {
+		return nil, err
 	}
-	var paths []string
-	var records []*pfs.PutFileRecords // used if 'dst' is finished (atomic put-file)
-	if overwrite {
-		if dstIsOpenCommit {
-			if err := d.deleteFile(pachClient, dst); err != nil {
-				return err
-			}
-		} else {
-			paths = append(paths, dst.Path)
-			records = append(records, &pfs.PutFileRecords{Tombstone: true})
-		}
+	if oursInfo.Finished == nil {
+		return nil, fmt.Errorf("cannot merge into branch \"%s\" as its head commit %s is open", to.Name, oursInfo.Commit.ID)
 	}
-	srcTree, err := d.getTreeForFile(pachClient, src)
+
+	base, err := d.commonAncestor(pachClient, oursInfo, theirsInfo)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// This is necessary so we can call filepath.Rel below
-	if !strings.HasPrefix(src.Path, "/") {
-		src.Path = "/" + src.Path
+	if base != nil && base.ID == theirsInfo.Commit.ID {
+		// 'to' already contains every commit in 'from'
+		return &pfs.MergeBranchResponse{Commit: oursInfo.Commit}, nil
 	}
-	var eg errgroup.Group
-	if err := srcTree.Walk(src.Path, func(walkPath string, node *hashtree.NodeProto) error {
-		relPath, err := filepath.Rel(src.Path, walkPath)
-		if err != nil {
-			return fmt.Errorf("error from filepath.Rel (likely a bug): %v", err)
+	if base != nil && base.ID == oursInfo.Commit.ID {
+		// fast-forward 'to' to the head of 'from'
+		if err := d.createBranch(pachClient, to, theirsInfo.Commit, nil); err != nil {
+			return nil, err
 		}
-		target := client.NewFile(dst.Commit.Repo.Name, dst.Commit.ID, path.Clean(path.Join(dst.Path, relPath)))
-		// Populate 'record' appropriately for this node (or skip it)
-		record := &pfs.PutFileRecords{}
-		if node.DirNode != nil && node.DirNode.Shared != nil {
-			var err error
-			record, err = headerDirToPutFileRecords(srcTree, walkPath, node)
+		return &pfs.MergeBranchResponse{Commit: theirsInfo.Commit}, nil
+	}
+
+	// Compute the changes made on each side since 'base'
+	baseTree, err := d.getTreeForCommit(pachClient, base)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		// 'baseTree' is a new empty tree rather than a cached one
+		defer baseTree.Destroy()
+	}
+	oursTree, err := d.getTreeForCommit(pachClient, oursInfo.Commit)
+	if err != nil {
+		return nil, err
+	}
+	theirsTree, err := d.getTreeForCommit(pachClient, theirsInfo.Commit)
+	if err != nil {
+		return nil, err
+	}
+	oursChanges, err := changedFiles(oursTree, baseTree)
+	if err != nil {
+		return nil, err
+	}
+	theirsChanges, err := changedFiles(theirsTree, baseTree)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply the changes from 'from' to a copy of 'to's tree. Paths are
+	// processed in sorted order, so that deletions of (or conflicts at) a path
+	// are resolved before changes to that path's children.
+	tree, err := oursTree.Copy()
+	if err != nil {
+		return nil, err
+	}
+	defer tree.Destroy()
+	paths := make([]string, 0, len(theirsChanges))
+	for path := range theirsChanges {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var conflicts []*pfs.MergeConflict
+	for _, path := range paths {
+		theirs := theirsChanges[path]
+		if ours, ok := oursChanges[path]; ok {
+			if (ours == nil && theirs == nil) || (ours != nil && theirs != nil && bytes.Equal(ours.Hash, theirs.Hash)) {
+				continue // both sides made the same change
+			}
+			conflict := &pfs.MergeConflict{Path: path}
+			switch {
+			case ours != nil && theirs != nil:
+				conflict.Type = pfs.ConflictType_BOTH_MODIFIED
+			case ours != nil:
+				conflict.Type = pfs.ConflictType_MODIFIED_DELETED
+			default:
+				conflict.Type = pfs.ConflictType_DELETED_MODIFIED
+			}
+			if ours != nil {
+				conflict.Ours = nodeToFileInfo(oursInfo, path, ours, false)
+			}
+			if theirs != nil {
+				conflict.Theirs = nodeToFileInfo(theirsInfo, path, theirs, false)
+			}
+			conflicts = append(conflicts, conflict)
+			switch {
+			case strategy == pfs.MergeStrategy_THEIRS:
+				err = mergeNode(tree, path, theirs, false)
+			case strategy == pfs.MergeStrategy_CONCATENATE && theirs != nil:
+				err = mergeNode(tree, path, theirs, ours != nil)
+			}
 			if err != nil {
-				return err
+				return nil, err
 			}
-		} else if node.FileNode == nil {
-			return nil
-		} else if node.FileNode.HasHeaderFooter {
-			return nil // parent dir will be copied as a PutFileRecord w/ Split==true
-		} else {
-			for i, object := range node.FileNode.Objects {
-				// We only have the whole file size in src file, so mark the first object
-				// as the size of the whole file and all the rest as size 0; applyWrite
-				// will compute the right sum size for the target file
-				// TODO(msteffen): this is a bit of a hack--either PutFileRecords should
-				// only record the sum size of all PutFileRecord messages as well, or
-				// FileNodeProto should record the size of every object
-				var size int64
-				if i == 0 {
-					size = node.SubtreeSize
+			continue
+		}
+		// Only 'from' changed 'path', but it may still conflict with the
+		// directory structure of 'to'
+		if theirs != nil {
+			if conflictPath := fileDirectoryConflict(tree, path); conflictPath != "" {
+				conflict := &pfs.MergeConflict{
+					Path:   path,
+					Type:   pfs.ConflictType_FILE_DIRECTORY,
+					Theirs: nodeToFileInfo(theirsInfo, path, theirs, false),
+				}
+				if node, err := tree.Get(conflictPath); err == nil {
+					conflict.Ours = nodeToFileInfo(oursInfo, conflictPath, node, false)
+				}
+				conflicts = append(conflicts, conflict)
+				if strategy != pfs.MergeStrategy_THEIRS {
+					continue
+				}
+				if err := mergeNode(tree, conflictPath, nil, false); err != nil {
+					return nil, err
 				}
-				record.Records = append(record.Records, &pfs.PutFileRecord{
-					SizeBytes:  size,
-					ObjectHash: object.Hash,
-				})
 			}
 		}
+		if err := mergeNode(tree, path, theirs, false); err != nil {
+			return nil, err
+		}
+	}
+	if strategy == pfs.MergeStrategy_FAIL && len(conflicts) > 0 {
+		return &pfs.MergeBranchResponse{Conflicts: conflicts}, nil
+	}
 
-		// Either upsert 'record' to etcd (if 'dst' is in an open commit) or add it
-		// to 'records' to be put at the end
-		if dstIsOpenCommit {
-			eg.Go(func() error {
-				return d.upsertPutFileRecords(pachClient, target, record)
-			})
-		} else {
-			paths = append(paths, target.Path)
-			records = append(records, record)
+	// Create the merge commit on 'to'
+	if err := tree.Hash(); err != nil {
+		return nil, err
+	}
+	treeRef, err := hashtree.PutHashTree(pachClient, tree)
+	if err != nil {
+		return nil, err
+	}
+	if description == "" {
+		description = fmt.Sprintf("Merge branch '%s' into '%s'", from.Name, to.Name)
+	}
+	commit, err := d.makeCommit(pachClient, "", oursInfo.Commit, to.Name, nil, treeRef, nil, nil, description, nil, func(stm col.STM, newCommitInfo *pfs.CommitInfo) error {
+		// 'tree' was merged into 'to's head as of the start of the merge, so
+		// don't overwrite any commits made to 'to' since
+		branchInfo := &pfs.BranchInfo{}
+		if err := d.branches(to.Repo.Name).ReadWrite(stm).Get(to.Name, branchInfo); err != nil {
+			return err
 		}
+		if branchInfo.Head == nil || branchInfo.Head.ID != oursInfo.Commit.ID {
+			return fmt.Errorf("branch \"%s\" moved during the merge; retry it", to.Name)
+		}
+		// Record 'from's head as the merge commit's second parent, so that
+		// later merges start from it rather than from 'base'
+		newCommitInfo.MergeParent = theirsInfo.Commit
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pfs.MergeBranchResponse{Commit: commit, Conflicts: conflicts}, nil
+}
+
+// commonAncestor returns the most recent commit that is an ancestor of (or
+// equal to) both 'a' and 'b', or nil if they have no common ancestor.
+func (d *driver) commonAncestor(pachClient *client.APIClient, a *pfs.CommitInfo, b *pfs.CommitInfo) (*pfs.Commit, error) {
+	ancestors := make(map[string]bool)
+	if err := d.walkAncestors(pachClient, a, func(commitInfo *pfs.CommitInfo) error {
+		ancestors[commitInfo.Commit.ID] = true
 		return nil
 	}); err != nil {
-		return err
+		return nil, err
 	}
-	if err := eg.Wait(); err != nil {
-		return err
+	var base *pfs.Commit
+	if err := d.walkAncestors(pachClient, b, func(commitInfo *pfs.CommitInfo) error {
+		if ancestors[commitInfo.Commit.ID] {
+			base = commitInfo.Commit
+			return errutil.ErrBreak
+		}
+		return nil
+	}); err != nil && err != errutil.ErrBreak {
+		return nil, err
 	}
-	// dst is finished => all PutFileRecords are in 'records'--put in a new commit
-	if !dstIsOpenCommit {
-		_, err = d.makeCommit(pachClient, "", client.NewCommit(dst.Commit.Repo.Name, ""), branch, nil, nil, paths, records, "")
+	return base, nil
+}
+
+// walkAncestors calls 'f' on 'commitInfo' and each of its ancestors, nearest
+// first. A merge commit's ancestors include the head that was merged into it
+// (unless that commit has since been deleted).
+func (d *driver) walkAncestors(pachClient *client.APIClient, commitInfo *pfs.CommitInfo, f func(*pfs.CommitInfo) error) error {
+	commits := d.commits(commitInfo.Commit.Repo.Name).ReadOnly(pachClient.Ctx())
+	visited := map[string]bool{commitInfo.Commit.ID: true}
+	for queue := []*pfs.CommitInfo{commitInfo}; len(queue) > 0; queue = queue[1:] {
+		if err := f(queue[0]); err != nil {
+			return err
+		}
+		parents := []*pfs.Commit{queue[0].ParentCommit, queue[0].MergeParent}
+		for i, parent := range parents {
+			if parent == nil || visited[parent.ID] {
+				continue
+			}
+			visited[parent.ID] = true
+			parentInfo := &pfs.CommitInfo{}
+			if err := commits.Get(parent.ID, parentInfo); err != nil {
+				if i > 0 && col.IsErrNotFound(err) {
+					continue // the merged head was deleted
+				}
+				return err
+			}
+			queue = append(queue, parentInfo)
+		}
+	}
+	return nil
+}
+
+// changedFiles returns the files that differ between 'newTree' and
+// 'oldTree', mapped to their node in 'newTree' (or nil if they were deleted).
+func changedFiles(newTree hashtree.HashTree, oldTree hashtree.HashTree) (map[string]*hashtree.NodeProto, error) {
+	changes := make(map[string]*hashtree.NodeProto)
+	if err := newTree.Diff(oldTree, "/", "/", -1, func(path string, node *hashtree.NodeProto, isNewFile bool) error {
+		if isNewFile {
+			changes[path] = node
+		} else if _, ok := changes[path]; !ok {
+			changes[path] = nil // only in 'oldTree', so it was deleted
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// fileDirectoryConflict returns the path in 'tree' that prevents a file from
+// being written at 'filePath' (a directory at 'filePath' or a file at one of
+// its ancestors), or "" if there is no such path.
+func fileDirectoryConflict(tree hashtree.HashTree, filePath string) string {
+	if node, err := tree.Get(filePath); err == nil && node.DirNode != nil {
+		return filePath
+	}
+	for dir := path.Dir(filePath); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		if node, err := tree.Get(dir); err == nil && node.FileNode != nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// mergeNode sets the file at 'path' in 'tree' to 'node' (deleting it if
+// 'node' is nil). If 'concatenate' is set, node's contents are appended to
+// the existing file instead.
+func mergeNode(tree hashtree.HashTree, path string, node *hashtree.NodeProto, concatenate bool) error {
+	if !concatenate {
+		if err := tree.DeleteFile(path); err != nil && hashtree.Code(err) != hashtree.PathNotFound {
+			return err
+		}
+	}
+	if node == nil {
+		return nil
+	}
+	if node.FileNode == nil || len(node.FileNode.BlockRefs) > 0 || node.FileNode.HasHeaderFooter {
+		return fmt.Errorf("cannot merge \"%s\": only regular files without headers or footers can be merged", path)
+	}
+	if err := tree.PutFile(path, node.FileNode.Objects, node.SubtreeSize); err != nil {
 		return err
 	}
+	if len(node.FileNode.Sha256) > 0 && !concatenate {
+		if err := tree.PutFileChecksums(path, node.FileNode.Sha256, node.FileNode.Md5); err != nil {
+			return err
+		}
+	}
+	if len(node.FileNode.Metadata) > 0 {
+		return tree.PutFileMetadata(path, node.FileNode.Metadata)
+	}
 	return nil
 }
 
-func (d *driver) getTreeForCommit(pachClient *client.APIClient, commit *pfs.Commit) (hashtree.HashTree, error) {
-	if commit == nil || commit.ID == "" {
-		t, err := hashtree.NewDBHashTree(d.storageRoot)
-		if err != nil {
+// revertCommit creates a new commit on 'branch' that undoes the changes made
+// by 'commit' (relative to its parent). 'commit' must be an ancestor of the
+// branch's head, and none of the files it changed may have been changed again
+// since, as those changes would be lost.
+func (d *driver) revertCommit(pachClient *client.APIClient, commit *pfs.Commit, branch *pfs.Branch, description string) (*pfs.Commit, error) {
+	if commit == nil {
+		return nil, fmt.Errorf("commit cannot be nil")
+	}
+	if err := d.checkIsAuthorized(pachClient, commit.Repo, auth.Scope_WRITER); err != nil {
+		return nil, err
+	}
+	if branch == nil || branch.Name == "" {
+		// Revert on the branch that 'commit' is relative to, if any
+		name, _ := ancestry.Parse(commit.ID)
+		if ref, _, ok, _ := ancestry.ParseTime(name, time.Now()); ok {
+			name = ref
+		}
+		if uuid.IsUUIDWithoutDashes(name) {
+			return nil, fmt.Errorf("a branch must be specified to revert commit %s on", commit.ID)
+		}
+		branch = &pfs.Branch{Repo: commit.Repo, Name: name}
+	}
+	if branch.Repo.Name != commit.Repo.Name {
+		return nil, fmt.Errorf("cannot revert commit in repo \"%s\" on a branch in repo \"%s\"", commit.Repo.Name, branch.Repo.Name)
+	}
+	if err := d.checkIsAuthorizedForBranch(pachClient, branch, auth.Scope_WRITER, branchOpCommit); err != nil {
+		return nil, err
+	}
+	commitInfo, err := d.inspectCommit(pachClient, commit, pfs.CommitState_STARTED)
+	if err != nil {
+		return nil, err
+	}
+	if commitInfo.Finished == nil {
+		return nil, fmt.Errorf("cannot revert commit %s as it is still open", commitInfo.Commit.ID)
+	}
+	branchInfo, err := d.inspectBranch(pachClient, branch)
+	if err != nil {
+		return nil, err
+	}
+	if len(branchInfo.Provenance) > 0 {
+		return nil, fmt.Errorf("cannot revert commits on output branch \"%s\"", branch.Name)
+	}
+	if branchInfo.Head == nil {
+		return nil, pfsserver.ErrNoHead{Branch: branch}
+	}
+	headInfo, err := d.inspectCommit(pachClient, branchInfo.Head, pfs.CommitState_STARTED)
+	if err != nil {
+		return nil, err
+	}
+	if headInfo.Finished == nil {
+		return nil, fmt.Errorf("cannot revert onto branch \"%s\" as its head commit %s is open", branch.Name, headInfo.Commit.ID)
+	}
+	base, err := d.commonAncestor(pachClient, headInfo, commitInfo)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil || base.ID != commitInfo.Commit.ID {
+		return nil, fmt.Errorf("commit %s is not on branch \"%s\"", commitInfo.Commit.ID, branch.Name)
+	}
+
+	// The inverse of 'commit's changes are the changes from it to its parent
+	commitTree, err := d.getTreeForCommit(pachClient, commitInfo.Commit)
+	if err != nil {
+		return nil, err
+	}
+	parentTree, err := d.getTreeForCommit(pachClient, commitInfo.ParentCommit)
+	if err != nil {
+		return nil, err
+	}
+	headTree, err := d.getTreeForCommit(pachClient, headInfo.Commit)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := changedFiles(parentTree, commitTree)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(changes))
+	for path := range changes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var conflicts []string
+	for _, path := range paths {
+		if !sameNode(commitTree, headTree, path) {
+			conflicts = append(conflicts, path)
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("cannot revert commit %s, as these files have been changed since: %s", commitInfo.Commit.ID, strings.Join(conflicts, ", "))
+	}
+
+	tree, err := headTree.Copy()
+	if err != nil {
+		return nil, err
+	}
+	defer tree.Destroy()
+	for _, path := range paths {
+		if err := mergeNode(tree, path, changes[path], false); err != nil {
 			return nil, err
 		}
-		return t, nil
 	}
-
-	tree, ok := d.treeCache.Get(commit.ID)
-	if ok {
-		h, ok := tree.(hashtree.HashTree)
-		if ok {
-			return h, nil
+	if err := tree.Hash(); err != nil {
+		return nil, err
+	}
+	treeRef, err := hashtree.PutHashTree(pachClient, tree)
+	if err != nil {
+		return nil, err
+	}
+	if description == "" {
+		description = fmt.Sprintf("Revert commit %s", commitInfo.Commit.ID)
+	}
+	return d.makeCommit(pachClient, "", headInfo.Commit, branch.Name, nil, treeRef, nil, nil, description, nil, nil)
+}
+
+// sameNode returns true if 'path' has the same contents in trees 'a' and 'b'
+// (including if it's absent from both).
+func sameNode(a hashtree.HashTree, b hashtree.HashTree, path string) bool {
+	aNode, aErr := a.Get(path)
+	bNode, bErr := b.Get(path)
+	if aErr != nil || bErr != nil {
+		return aErr != nil && bErr != nil
+	}
+	return bytes.Equal(aNode.Hash, bNode.Hash)
+}
+
+// writeBranch returns the branch that 'commit' refers to, if writing to it
+// should create a new commit on that branch when its head is finished. It
+// returns "" if 'commit' is a commit ID, or a time reference (which refers to
+// a fixed point in a branch's history, and so can't be written to).
+func writeBranch(commit *pfs.Commit) string {
+	if uuid.IsUUIDWithoutDashes(commit.ID) {
+		return ""
+	}
+	if _, _, ok, _ := ancestry.ParseTime(commit.ID, time.Now()); ok {
+		return ""
+	}
+	return commit.ID
+}
+
+func (d *driver) scratchPrefix() string {
+	return path.Join(d.prefix, "scratch")
+}
+
+// scratchCommitPrefix returns an etcd prefix that's used to temporarily
+// store the state of a file in an open commit.  Once the commit is finished,
+// the scratch space is removed.
+func (d *driver) scratchCommitPrefix(commit *pfs.Commit) string {
+	// TODO(msteffen) this doesn't currenty (2018-2-4) use d.scratchPrefix(),
+	// but probably should? If this is changed, filepathFromEtcdPath will also
+	// need to change.
+	return path.Join(commit.Repo.Name, commit.ID)
+}
+
+// scratchFilePrefix returns an etcd prefix that's used to temporarily
+// store the state of a file in an open commit.  Once the commit is finished,
+// the scratch space is removed.
+func (d *driver) scratchFilePrefix(file *pfs.File) (string, error) {
+	return path.Join(d.scratchCommitPrefix(file.Commit), file.Path), nil
+}
+
+func (d *driver) filePathFromEtcdPath(etcdPath string) string {
+	etcdPath = strings.TrimPrefix(etcdPath, d.prefix)
+	// etcdPath looks like /putFileRecords/repo/commit/path/to/file
+	split := strings.Split(etcdPath, "/")
+	// we only want /path/to/file so we use index 4 (note that there's an "" at
+	// the beginning of the slice because of the lead /)
+	return path.Join(split[4:]...)
+}
+
+func (d *driver) putFiles(pachClient *client.APIClient, s *putFileServer) error {
+	req, err := s.Peek()
+	if err != nil {
+		return err
+	}
+	commit := req.File.Commit
+	// oneOff is true if we're creating the commit as part of this put-file
+	oneOff := false
+	// inspectCommit will replace file.Commit.ID with an actual commit ID if
+	// it's a branch. So we want to save it first.
+	branch := writeBranch(commit)
+	if branch != "" {
+		if err := d.checkIsAuthorizedForBranch(pachClient, client.NewBranch(commit.Repo.Name, branch), auth.Scope_WRITER, branchOpCommit); err != nil {
+			return err
+		}
+	}
+	commitInfo, err := d.inspectCommit(pachClient, commit, pfs.CommitState_STARTED)
+	if err != nil {
+		if (!isNotFoundErr(err) && !isNoHeadErr(err)) || branch == "" {
+			return err
+		}
+		oneOff = true
+	}
+	if commitInfo != nil && commitInfo.Finished != nil {
+		if branch == "" {
+			return pfsserver.ErrCommitFinished{commit}
+		}
+		oneOff = true
+	}
+
+	var files []*pfs.File
+	var putFilePaths []string
+	var putFileRecords []*pfs.PutFileRecords
+	var mu sync.Mutex
+	if err := forEachPutFile(s, func(req *pfs.PutFileRequest, r io.Reader) error {
+		var records *pfs.PutFileRecords
+		var err error
+		if req.SymlinkTarget != "" {
+			// Drain the reader, as the request stream blocks until it's read
+			var n int64
+			n, err = io.Copy(ioutil.Discard, r)
+			if err != nil {
+				return err
+			}
+			if n > 0 || req.Url != "" {
+				return fmt.Errorf("cannot put content into symlink %s", req.File.Path)
+			}
+			records, err = d.putSymlink(pachClient, req.File, req.SymlinkTarget)
+			if err != nil {
+				return err
+			}
+		} else {
+			if req.ExpectedSha256 != "" && (req.Delimiter != pfs.Delimiter_NONE || req.Recursive) {
+				return fmt.Errorf("cannot check the checksum of split or recursive put-files")
+			}
+			records, err = d.putFile(pachClient, req.File, req.Delimiter, req.TargetFileDatums,
+				req.TargetFileBytes, req.HeaderRecords, req.OverwriteIndex, r)
+			if err == nil && req.ExpectedSha256 != "" {
+				if actual := hex.EncodeToString(records.Sha256); actual != strings.ToLower(req.ExpectedSha256) {
+					return pfsserver.ErrChecksumMismatch{
+						File:     req.File,
+						Expected: req.ExpectedSha256,
+						Actual:   actual,
+					}
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		records.Metadata = req.Metadata
+		mu.Lock()
+		defer mu.Unlock()
+		files = append(files, req.File)
+		putFilePaths = append(putFilePaths, req.File.Path)
+		putFileRecords = append(putFileRecords, records)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if oneOff {
+		// oneOff puts only work on branches, so we know branch != "". We pass
+		// a commit with no ID, that ID will be filled in with the head of
+		// branch (if it exists).
+		_, err := d.makeCommit(pachClient, "", client.NewCommit(commit.Repo.Name, ""), branch, nil, nil, putFilePaths, putFileRecords, "", nil, nil)
+		return err
+	}
+	for i, file := range files {
+		if err := d.upsertPutFileRecords(pachClient, file, putFileRecords[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putSymlink returns the records that create a symlink at 'file' pointing to
+// 'target'. The target doesn't need to exist.
+func (d *driver) putSymlink(pachClient *client.APIClient, file *pfs.File, target string) (*pfs.PutFileRecords, error) {
+	if err := d.checkIsAuthorized(pachClient, file.Commit.Repo, auth.Scope_WRITER); err != nil {
+		return nil, err
+	}
+	if err := hashtree.ValidatePath(file.Path); err != nil {
+		return nil, err
+	}
+	return &pfs.PutFileRecords{
+		Tombstone:     true,
+		SymlinkTarget: target,
+	}, nil
+}
+
+func (d *driver) putFile(pachClient *client.APIClient, file *pfs.File, delimiter pfs.Delimiter,
+	targetFileDatums, targetFileBytes, headerRecords int64, overwriteIndex *pfs.OverwriteIndex,
+	reader io.Reader) (*pfs.PutFileRecords, error) {
+	if err := d.checkIsAuthorized(pachClient, file.Commit.Repo, auth.Scope_WRITER); err != nil {
+		return nil, err
+	}
+	//  validation -- make sure the various putFileSplit options are coherent
+	hasPutFileOptions := targetFileBytes != 0 || targetFileDatums != 0 || headerRecords != 0
+	if hasPutFileOptions && delimiter == pfs.Delimiter_NONE {
+		return nil, fmt.Errorf("cannot set split options--targetFileBytes, targetFileDatums, or headerRecords--with delimiter == NONE, split disabled")
+	}
+	records := &pfs.PutFileRecords{}
+	if overwriteIndex != nil && overwriteIndex.Index == 0 {
+		records.Tombstone = true
+	}
+	if err := hashtree.ValidatePath(file.Path); err != nil {
+		return nil, err
+	}
+	// Fail early if the repo is already over its quota, rather than
+	// uploading data that can't be committed
+	repoInfo := &pfs.RepoInfo{}
+	if err := d.repos.ReadOnly(pachClient.Ctx()).Get(file.Commit.Repo.Name, repoInfo); err != nil {
+		return nil, err
+	}
+	if err := checkRepoQuota(repoInfo, 0); err != nil {
+		return nil, err
+	}
+
+	if delimiter == pfs.Delimiter_NONE {
+		sha256Hash, md5Hash := sha256.New(), md5.New()
+		objects, size, err := pachClient.PutObjectSplit(io.TeeReader(reader, io.MultiWriter(sha256Hash, md5Hash)))
+		if err != nil {
+			return nil, err
+		}
+		records.Sha256 = sha256Hash.Sum(nil)
+		records.Md5 = md5Hash.Sum(nil)
+
+		// Here we use the invariant that every one but the last object
+		// should have a size of ChunkSize.
+		for i, object := range objects {
+			record := &pfs.PutFileRecord{
+				ObjectHash: object.Hash,
+			}
+
+			if size > pfs.ChunkSize {
+				record.SizeBytes = pfs.ChunkSize
+			} else {
+				record.SizeBytes = size
+			}
+			size -= pfs.ChunkSize
+
+			// The first record takes care of the overwriting
+			if i == 0 && overwriteIndex != nil && overwriteIndex.Index != 0 {
+				record.OverwriteIndex = overwriteIndex
+			}
+
+			records.Records = append(records.Records, record)
+		}
+	} else {
+		var (
+			buffer        = &bytes.Buffer{}
+			datumsWritten int64
+			bytesWritten  int64
+			filesPut      int
+			// Note: this code generally distinguishes between nil header/footer (no
+			// header) and empty header/footer. To create a header-enabled directory
+			// with an empty header, allocate an empty slice & store it here
+			header    []byte
+			footer    []byte
+			EOF       = false
+			eg        errgroup.Group
+			bufioR    = bufio.NewReader(reader)
+			decoder   = json.NewDecoder(bufioR)
+			sqlReader = sql.NewPGDumpReader(bufioR)
+			csvReader = csv.NewReader(bufioR)
+			csvBuffer bytes.Buffer
+			csvWriter = csv.NewWriter(&csvBuffer)
+			// indexToRecord serves as a de-facto slice of PutFileRecords. We can't
+			// use a real slice of PutFileRecords b/c indexToRecord has data appended
+			// to it by concurrent processes, and you can't append() to a slice
+			// concurrently (append() might allocate a new slice while a goro holds an
+			// stale pointer)
+			indexToRecord = make(map[int]*pfs.PutFileRecord)
+			mu            sync.Mutex
+		)
+		csvReader.FieldsPerRecord = -1 // ignore unexpected # of fields, for now
+		csvReader.ReuseRecord = true   // returned rows are written to buffer immediately
+		for !EOF {
+			var err error
+			var value []byte
+			var csvRow []string // only used if delimiter == CSV
+			switch delimiter {
+			case pfs.Delimiter_JSON:
+				var jsonValue json.RawMessage
+				err = decoder.Decode(&jsonValue)
+				value = jsonValue
+			case pfs.Delimiter_LINE:
+				value, err = bufioR.ReadBytes('\n')
+			case pfs.Delimiter_SQL:
+				value, err = sqlReader.ReadRow()
+				if err == io.EOF {
+					if header == nil {
+						header = sqlReader.Header
+					} else {
+						// header contains SQL records if anything, which should come after
+						// the sqlReader header, which creates tables & initializes the DB
+						header = append(sqlReader.Header, header...)
+					}
+					footer = sqlReader.Footer
+				}
+			case pfs.Delimiter_CSV:
+				csvBuffer.Reset()
+				if csvRow, err = csvReader.Read(); err == nil {
+					if err := csvWriter.Write(csvRow); err != nil {
+						return nil, fmt.Errorf("error parsing csv record: %v", err)
+					}
+					if csvWriter.Flush(); csvWriter.Error() != nil {
+						return nil, fmt.Errorf("error copying csv record: %v", csvWriter.Error())
+					}
+					value = csvBuffer.Bytes()
+				}
+			default:
+				return nil, fmt.Errorf("unrecognized delimiter %s", delimiter.String())
+			}
+			if err != nil {
+				if err == io.EOF {
+					EOF = true
+				} else {
+					return nil, err
+				}
+			}
+			buffer.Write(value)
+			bytesWritten += int64(len(value))
+			datumsWritten++
+			var (
+				headerDone         = headerRecords == 0 || header != nil
+				headerReady        = !headerDone && datumsWritten >= headerRecords
+				hitFileBytesLimit  = headerDone && targetFileBytes != 0 && bytesWritten >= targetFileBytes
+				hitFileDatumsLimit = headerDone && targetFileDatums != 0 && datumsWritten >= targetFileDatums
+				noLimitsSet        = headerDone && targetFileBytes == 0 && targetFileDatums == 0
+			)
+			if buffer.Len() != 0 &&
+				(headerReady || hitFileBytesLimit || hitFileDatumsLimit || noLimitsSet || EOF) {
+				_buffer := buffer
+				if !headerDone /* implies headerReady || EOF */ {
+					header = _buffer.Bytes() // record header
+				} else {
+					// put contents
+					_bufferLen := int64(_buffer.Len())
+					index := filesPut
+					filesPut++
+					d.memoryLimiter.Acquire(pachClient.Ctx(), _bufferLen)
+					putObjectLimiter.Acquire()
+					eg.Go(func() error {
+						defer putObjectLimiter.Release()
+						defer d.memoryLimiter.Release(_bufferLen)
+						sha256Sum, md5Sum := sha256.Sum256(_buffer.Bytes()), md5.Sum(_buffer.Bytes())
+						object, size, err := pachClient.PutObject(_buffer)
+						if err != nil {
+							return err
+						}
+						mu.Lock()
+						defer mu.Unlock()
+						indexToRecord[index] = &pfs.PutFileRecord{
+							SizeBytes:  size,
+							ObjectHash: object.Hash,
+							Sha256:     sha256Sum[:],
+							Md5:        md5Sum[:],
+						}
+						return nil
+					})
+				}
+				buffer = &bytes.Buffer{} // can't reset buffer b/c _buffer still in use
+				datumsWritten = 0
+				bytesWritten = 0
+			}
+		}
+		if err := eg.Wait(); err != nil {
+			return nil, err
+		}
+
+		records.Split = true
+		for i := 0; i < len(indexToRecord); i++ {
+			records.Records = append(records.Records, indexToRecord[i])
+		}
+
+		// Put 'header' and 'footer' in PutFileRecords
+		setHeaderFooter := func(value []byte, hf **pfs.PutFileRecord) {
+			// always put empty header, even if 'value' is empty, so
+			// that the parent dir is a header/footer dir
+			*hf = &pfs.PutFileRecord{}
+			if len(value) > 0 {
+				putObjectLimiter.Acquire()
+				eg.Go(func() error {
+					defer putObjectLimiter.Release()
+					object, size, err := pachClient.PutObject(bytes.NewReader(value))
+					if err != nil {
+						return err
+					}
+					(*hf).SizeBytes = size
+					(*hf).ObjectHash = object.Hash
+					return nil
+				})
+			}
+		}
+		if header != nil {
+			setHeaderFooter(header, &records.Header)
+		}
+		if footer != nil {
+			setHeaderFooter(footer, &records.Footer)
+		}
+		if err := eg.Wait(); err != nil {
+			return nil, err
+		}
+	}
+	// This doesn't account for data that's overwritten, so the repo's size is
+	// checked again when the write is committed
+	if err := checkRepoQuota(repoInfo, putFileRecordsSize(records)); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// putFileRecordsSize returns the total size of the data written by 'records'
+func putFileRecordsSize(records *pfs.PutFileRecords) uint64 {
+	var size uint64
+	for _, record := range records.Records {
+		size += uint64(record.SizeBytes)
+	}
+	if records.Header != nil {
+		size += uint64(records.Header.SizeBytes)
+	}
+	if records.Footer != nil {
+		size += uint64(records.Footer.SizeBytes)
+	}
+	return size
+}
+
+// headerDirToPutFileRecords is a helper for copyFile that handles copying
+// header/footer directories.
+//
+// Copy uses essentially the same codepath as putFile--it converts hashtree
+// node(s) to PutFileRecords and then uses applyWrite to put the records back
+// in the target hashtree. In putFile, the only way to create a headerDir is
+// with PutFileSplit (PutFileRecord with Split==true). Rather than split the
+// putFile codepath by adding a special case to applyWrite that was valid for
+// copyFile but invalid for putFile, we use heaaderDirToPutFileRecords to
+// convert a DirectoryNode to a PutFileRecord+Split==true, which applyWrite
+// will correctly convert back to a header dir in the target hashtree via the
+// regular putFile codepath.
+func headerDirToPutFileRecords(tree hashtree.HashTree, path string, node *hashtree.NodeProto) (*pfs.PutFileRecords, error) {
+	if node.DirNode == nil || node.DirNode.Shared == nil {
+		return nil, fmt.Errorf("headerDirToPutFileRecords only works on header/footer dirs")
+	}
+	s := node.DirNode.Shared
+	pfr := &pfs.PutFileRecords{
+		Split: true,
+	}
+	if s.Header != nil {
+		pfr.Header = &pfs.PutFileRecord{
+			SizeBytes:  s.HeaderSize,
+			ObjectHash: s.Header.Hash,
+		}
+	}
+	if s.Footer != nil {
+		pfr.Footer = &pfs.PutFileRecord{
+			SizeBytes:  s.FooterSize,
+			ObjectHash: s.Footer.Hash,
+		}
+	}
+	if err := tree.List(path, func(child *hashtree.NodeProto) error {
+		if child.FileNode == nil {
+			return fmt.Errorf("header/footer dir contains child subdirectory, " +
+				"which is invalid--header/footer dirs must be created by PutFileSplit")
+		}
+		for i, o := range child.FileNode.Objects {
+			// same hack as copyFile--set size of first object to the size of the
+			// whole subtree (and size of other objects to 0). I don't think
+			// PutFileSplit files can have more than one object, but that invariant
+			// isn't necessary to this code's correctness, so don't verify it.
+			var size int64
+			if i == 0 {
+				size = child.SubtreeSize
+			}
+			pfr.Records = append(pfr.Records, &pfs.PutFileRecord{
+				SizeBytes:  size,
+				ObjectHash: o.Hash,
+			})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return pfr, nil // TODO(msteffen) put something real here
+}
+
+func (d *driver) copyFile(pachClient *client.APIClient, src *pfs.File, dst *pfs.File, overwrite bool) error {
+	if err := d.checkIsAuthorized(pachClient, src.Commit.Repo, auth.Scope_READER); err != nil {
+		return err
+	}
+	if err := d.checkIsAuthorized(pachClient, dst.Commit.Repo, auth.Scope_WRITER); err != nil {
+		return err
+	}
+	if err := hashtree.ValidatePath(dst.Path); err != nil {
+		return err
+	}
+	branch := writeBranch(dst.Commit)
+	if branch != "" {
+		if err := d.checkIsAuthorizedForBranch(pachClient, client.NewBranch(dst.Commit.Repo.Name, branch), auth.Scope_WRITER, branchOpCommit); err != nil {
+			return err
+		}
+	}
+	var dstIsOpenCommit bool
+	if ci, err := d.inspectCommit(pachClient, dst.Commit, pfs.CommitState_STARTED); err != nil {
+		if !isNoHeadErr(err) {
+			return err
+		}
+	} else if ci.Finished == nil {
+		dstIsOpenCommit = true
+	}
+	if !dstIsOpenCommit && branch == "" {
+		return pfsserver.ErrCommitFinished{dst.Commit}
+	}
+	var paths []string
+	var records []*pfs.PutFileRecords // used if 'dst' is finished (atomic put-file)
+	if overwrite {
+		if dstIsOpenCommit {
+			if err := d.deleteFile(pachClient, dst); err != nil {
+				return err
+			}
+		} else {
+			paths = append(paths, dst.Path)
+			records = append(records, &pfs.PutFileRecords{Tombstone: true})
+		}
+	}
+	srcTree, err := d.getTreeForFile(pachClient, src)
+	if err != nil {
+		return err
+	}
+	// This is necessary so we can call filepath.Rel below
+	if !strings.HasPrefix(src.Path, "/") {
+		src.Path = "/" + src.Path
+	}
+	var eg errgroup.Group
+	if err := srcTree.Walk(src.Path, func(walkPath string, node *hashtree.NodeProto) error {
+		relPath, err := filepath.Rel(src.Path, walkPath)
+		if err != nil {
+			return fmt.Errorf("error from filepath.Rel (likely a bug): %v", err)
+		}
+		target := client.NewFile(dst.Commit.Repo.Name, dst.Commit.ID, path.Clean(path.Join(dst.Path, relPath)))
+		// Populate 'record' appropriately for this node (or skip it)
+		record := &pfs.PutFileRecords{}
+		if node.DirNode != nil && node.DirNode.Shared != nil {
+			var err error
+			record, err = headerDirToPutFileRecords(srcTree, walkPath, node)
+			if err != nil {
+				return err
+			}
+		} else if node.SymlinkNode != nil {
+			record.Tombstone = true
+			record.SymlinkTarget = node.SymlinkNode.Target
+		} else if node.FileNode == nil {
+			return nil
+		} else if node.FileNode.HasHeaderFooter {
+			return nil // parent dir will be copied as a PutFileRecord w/ Split==true
+		} else {
+			for i, object := range node.FileNode.Objects {
+				// We only have the whole file size in src file, so mark the first object
+				// as the size of the whole file and all the rest as size 0; applyWrite
+				// will compute the right sum size for the target file
+				// TODO(msteffen): this is a bit of a hack--either PutFileRecords should
+				// only record the sum size of all PutFileRecord messages as well, or
+				// FileNodeProto should record the size of every object
+				var size int64
+				if i == 0 {
+					size = node.SubtreeSize
+				}
+				record.Records = append(record.Records, &pfs.PutFileRecord{
+					SizeBytes:  size,
+					ObjectHash: object.Hash,
+				})
+			}
+			record.Metadata = node.FileNode.Metadata
+			record.Sha256 = node.FileNode.Sha256
+			record.Md5 = node.FileNode.Md5
+		}
+
+		// Either upsert 'record' to etcd (if 'dst' is in an open commit) or add it
+		// to 'records' to be put at the end
+		if dstIsOpenCommit {
+			eg.Go(func() error {
+				return d.upsertPutFileRecords(pachClient, target, record)
+			})
+		} else {
+			paths = append(paths, target.Path)
+			records = append(records, record)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	// dst is finished => all PutFileRecords are in 'records'--put in a new commit
+	if !dstIsOpenCommit {
+		_, err = d.makeCommit(pachClient, "", client.NewCommit(dst.Commit.Repo.Name, ""), branch, nil, nil, paths, records, "", nil, nil)
+		return err
+	}
+	return nil
+}
+
+func (d *driver) getTreeForCommit(pachClient *client.APIClient, commit *pfs.Commit) (hashtree.HashTree, error) {
+	if commit == nil || commit.ID == "" {
+		t, err := hashtree.NewDBHashTree(d.storageRoot)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+
+	// Trees are cached by hash rather than by commit, as PurgeFile may rewrite
+	// a commit's tree
+	commits := d.commits(commit.Repo.Name).ReadOnly(pachClient.Ctx())
+	commitInfo := &pfs.CommitInfo{}
+	if err := commits.Get(commit.ID, commitInfo); err == nil && commitInfo.Tree != nil {
+		tree, ok := d.treeCache.Get(commitInfo.Tree.Hash)
+		if ok {
+			h, ok := tree.(hashtree.HashTree)
+			if ok {
+				return h, nil
+			}
+			return nil, fmt.Errorf("corrupted cache: expected hashtree.Hashtree, found %v", tree)
+		}
+	}
+
+	if _, err := d.inspectCommit(pachClient, commit, pfs.CommitState_STARTED); err != nil {
+		return nil, err
+	}
+
+	if err := commits.Get(commit.ID, commitInfo); err != nil {
+		return nil, err
+	}
+	if commitInfo.Finished == nil {
+		return nil, fmt.Errorf("cannot read from an open commit")
+	}
+	treeRef := commitInfo.Tree
+
+	if treeRef == nil {
+		t, err := hashtree.NewDBHashTree(d.storageRoot)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+
+	// read the tree from the block store
+	h, err := hashtree.GetHashTreeObject(pachClient, d.storageRoot, treeRef)
+	if err != nil {
+		return nil, err
+	}
+
+	d.treeCache.Add(treeRef.Hash, h)
+
+	return h, nil
+}
+
+func (d *driver) getTree(pachClient *client.APIClient, commitInfo *pfs.CommitInfo, path string) (rs []io.ReadCloser, retErr error) {
+	// Determine the hashtree in which the path is located and download the chunk it is in
+	idx := hashtree.PathToTree(path, int64(len(commitInfo.Trees)))
+	r, err := d.downloadTree(pachClient, commitInfo.Trees[idx], path, "")
+	if err != nil {
+		return nil, err
+	}
+	return []io.ReadCloser{r}, nil
+}
+
+// outputTreeGet returns a function that looks up individual paths in the
+// (finished) output commit 'commitInfo', for use with hashtree.Resolve.
+func (d *driver) outputTreeGet(pachClient *client.APIClient, commitInfo *pfs.CommitInfo) func(string) (*hashtree.NodeProto, error) {
+	return func(p string) (_ *hashtree.NodeProto, retErr error) {
+		rs, err := d.getTree(pachClient, commitInfo, p)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			for _, r := range rs {
+				if err := r.Close(); err != nil && retErr == nil {
+					retErr = err
+				}
+			}
+		}()
+		return hashtree.Get(rs, p)
+	}
+}
+
+func (d *driver) getTrees(pachClient *client.APIClient, commitInfo *pfs.CommitInfo, pattern string) (rs []io.ReadCloser, retErr error) {
+	return d.getTreesAfter(pachClient, commitInfo, hashtree.GlobLiteralPrefix(pattern), "")
+}
+
+// getTreesAfter is like getTrees, but if 'after' is set, only the part of each
+// chunk's subtree at 'prefix' that comes after the path 'after' (give or take
+// one index entry) is downloaded.
+func (d *driver) getTreesAfter(pachClient *client.APIClient, commitInfo *pfs.CommitInfo, prefix string, after string) (rs []io.ReadCloser, retErr error) {
+	limiter := limit.New(hashtree.DefaultMergeConcurrency)
+	var eg errgroup.Group
+	var mu sync.Mutex
+	// Download each hashtree chunk based on the literal prefix of the pattern
+	for _, object := range commitInfo.Trees {
+		object := object
+		limiter.Acquire()
+		eg.Go(func() (retErr error) {
+			defer limiter.Release()
+			r, err := d.downloadTree(pachClient, object, prefix, after)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			rs = append(rs, r)
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+func (d *driver) downloadTree(pachClient *client.APIClient, object *pfs.Object, prefix string, after string) (r io.ReadCloser, retErr error) {
+	objClient, err := obj.NewClientFromEnv(pachClient.Ctx(), d.storageRoot)
+	if err != nil {
+		return nil, err
+	}
+	info, err := pachClient.InspectObject(object.Hash)
+	if err != nil {
+		return nil, err
+	}
+	path, err := obj.BlockPathFromEnv(info.BlockRef.Block)
+	if err != nil {
+		return nil, err
+	}
+	offset, size, err := getTreeRange(objClient, path, prefix, after)
+	if err != nil {
+		return nil, err
+	}
+	objR, err := objClient.Reader(path, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := objR.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	name := filepath.Join(d.storageRoot, uuid.NewWithoutDashes())
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	// Mark the file for removal (Linux won't remove it until we close the file)
+	if err := os.Remove(name); err != nil {
+		return nil, err
+	}
+	buf := grpcutil.GetBuffer()
+	defer grpcutil.PutBuffer(buf)
+	if _, err := io.CopyBuffer(f, objR, buf); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func getTreeRange(objClient obj.Client, path string, prefix string, after string) (uint64, uint64, error) {
+	p := path + hashtree.IndexPath
+	r, err := objClient.Reader(p, 0, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	idx, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if after != "" {
+		return hashtree.GetRangeFromIndexAfter(bytes.NewBuffer(idx), prefix, after)
+	}
+	return hashtree.GetRangeFromIndex(bytes.NewBuffer(idx), prefix)
+}
+
+// getTreeForFile is like getTreeForCommit except that it can handle open commits.
+// It takes a file instead of a commit so that it can apply the changes for
+// that path to the tree before it returns it.
+func (d *driver) getTreeForFile(pachClient *client.APIClient, file *pfs.File) (hashtree.HashTree, error) {
+	if file.Commit == nil {
+		t, err := hashtree.NewDBHashTree(d.storageRoot)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	commitInfo, err := d.inspectCommit(pachClient, file.Commit, pfs.CommitState_STARTED)
+	if err != nil {
+		return nil, err
+	}
+	if commitInfo.Finished != nil {
+		tree, err := d.getTreeForCommit(pachClient, file.Commit)
+		if err != nil {
+			return nil, err
+		}
+		return tree, nil
+	}
+	parentTree, err := d.getTreeForCommit(pachClient, commitInfo.ParentCommit)
+	if err != nil {
+		return nil, err
+	}
+	return d.getTreeForOpenCommit(pachClient, file, parentTree, nil)
+}
+
+// getTreeForOpenCommit applies the writes in the open commit of 'file' to a
+// copy of 'parentTree'. Nodes changed by those writes are stamped with
+// 'committed', if it's set (see hashtree.NodeProto.Committed).
+func (d *driver) getTreeForOpenCommit(pachClient *client.APIClient, file *pfs.File, parentTree hashtree.HashTree, committed *types.Timestamp) (hashtree.HashTree, error) {
+	ctx := pachClient.Ctx()
+	prefix, err := d.scratchFilePrefix(file)
+	if err != nil {
+		return nil, err
+	}
+	var tree hashtree.HashTree
+	if _, err := col.NewSTM(ctx, d.etcdClient, func(stm col.STM) error {
+		tree, err = parentTree.Copy()
+		if err != nil {
+			return err
+		}
+		recordsCol := d.putFileRecords.ReadOnly(ctx)
+		putFileRecords := &pfs.PutFileRecords{}
+		opts := &col.Options{etcd.SortByModRevision, etcd.SortAscend, true}
+		return recordsCol.ListPrefix(prefix, putFileRecords, opts, func(key string) error {
+			return d.applyWrite(path.Join(file.Path, key), putFileRecords, tree)
+		})
+	}); err != nil {
+		return nil, err
+	}
+	if committed != nil {
+		if err := tree.SetCommitted(committed); err != nil {
+			return nil, err
+		}
+	}
+	if err := tree.Hash(); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func (d *driver) getFile(pachClient *client.APIClient, file *pfs.File, offset int64, size int64) (r io.Reader, retErr error) {
+	ctx := pachClient.Ctx()
+	if err := d.checkIsAuthorized(pachClient, file.Commit.Repo, auth.Scope_READER); err != nil {
+		return nil, err
+	}
+	commitInfo, err := d.inspectCommit(pachClient, file.Commit, pfs.CommitState_STARTED)
+	if err != nil {
+		return nil, err
+	}
+	// Handle commits to input repos
+	if commitInfo.Provenance == nil {
+		tree, err := d.getTreeForFile(pachClient, client.NewFile(file.Commit.Repo.Name, file.Commit.ID, ""))
+		if err != nil {
+			return nil, err
+		}
+		var (
+			pathsFound int
+			objects    []*pfs.Object
+			totalSize  uint64
+			footer     *pfs.Object
+			prevDir    string
+		)
+		addNode := func(p string, node *hashtree.NodeProto) error {
+			pathsFound++
+			if node.SymlinkNode != nil {
+				var err error
+				if p, node, err = hashtree.Resolve(tree.Get, p); err != nil {
+					return err
+				}
+			}
+			if node.FileNode == nil {
+				return nil
+			}
+
+			// add footer + header for next dir. If a user calls e.g.
+			// 'GetFile("/*/*")', then the output looks like:
+			// [d1 header][d1/1]...[d1/n][d1 footer] [d2 header]...[d2 footer] ...
+			parentPath := path.Dir(p)
+			if parentPath != prevDir {
+				if footer != nil {
+					objects = append(objects, footer)
+				}
+				footer = nil // don't apply footer twice if next dir has no footer
+				prevDir = parentPath
+				if node.FileNode.HasHeaderFooter {
+					// if any child of 'node's parent directory has HasHeaderFooter set,
+					// then they all should
+					parentNode, err := tree.Get(parentPath)
+					if err != nil {
+						return fmt.Errorf("file %q has a header, but could not "+
+							"retrieve parent node at %q to get header content: %v", p,
+							parentPath, err)
+					}
+					if parentNode.DirNode == nil {
+						return fmt.Errorf("parent of %q is not a directory—this is "+
+							"likely an internal error", p)
+					}
+					if parentNode.DirNode.Shared == nil {
+						return fmt.Errorf("file %q has a shared header or footer, "+
+							"but parent directory does not permit shared data", p)
+					}
+					if parentNode.DirNode.Shared.Header != nil {
+						objects = append(objects, parentNode.DirNode.Shared.Header)
+					}
+					if parentNode.DirNode.Shared.Footer != nil {
+						footer = parentNode.DirNode.Shared.Footer
+					}
+				}
+			}
+			objects = append(objects, node.FileNode.Objects...)
+			totalSize += uint64(node.SubtreeSize)
+			return nil
+		}
+		if err := tree.Glob(file.Path, addNode); err != nil {
+			return nil, err
+		}
+		if pathsFound == 0 && !hashtree.IsGlob(file.Path) {
+			// 'file' may be under a symlinked directory
+			if p, node, err := hashtree.Resolve(tree.Get, file.Path); err == nil {
+				if err := addNode(p, node); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if footer != nil {
+			objects = append(objects, footer) // apply final footer
+		}
+		if pathsFound == 0 {
+			return nil, fmt.Errorf("no file(s) found that match %v", file.Path)
+		}
+
+		// retrieve the content of all objects in 'objects'
+		getObjectsClient, err := pachClient.ObjectAPIClient.GetObjects(
+			ctx,
+			&pfs.GetObjectsRequest{
+				Objects:     objects,
+				OffsetBytes: uint64(offset),
+				SizeBytes:   uint64(size),
+				TotalSize:   uint64(totalSize),
+			})
+		if err != nil {
+			return nil, err
+		}
+		return grpcutil.NewStreamingBytesReader(getObjectsClient, nil), nil
+	}
+	// Handle commits to output repos
+	if commitInfo.Finished == nil {
+		return nil, fmt.Errorf("output commit %v not finished", commitInfo.Commit.ID)
+	}
+	if commitInfo.Trees == nil {
+		return nil, fmt.Errorf("no file(s) found that match %v", file.Path)
+	}
+	var rs []io.ReadCloser
+	// Handles the case when looking for a specific file/directory
+	if !hashtree.IsGlob(file.Path) {
+		rs, err = d.getTree(pachClient, commitInfo, file.Path)
+	} else {
+		rs, err = d.getTrees(pachClient, commitInfo, file.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, r := range rs {
+			if err := r.Close(); err != nil && retErr != nil {
+				retErr = err
+			}
+		}
+	}()
+	blockRefs := []*pfs.BlockRef{}
+	var totalSize int64
+	var found bool
+	addNode := func(p string, node *hashtree.NodeProto) error {
+		if node.SymlinkNode != nil {
+			var err error
+			if _, node, err = hashtree.Resolve(d.outputTreeGet(pachClient, commitInfo), p); err != nil {
+				return err
+			}
+		}
+		if node.FileNode == nil {
+			return nil
+		}
+		blockRefs = append(blockRefs, node.FileNode.BlockRefs...)
+		totalSize += node.SubtreeSize
+		found = true
+		return nil
+	}
+	if err := hashtree.Glob(rs, file.Path, addNode); err != nil {
+		return nil, err
+	}
+	if !found && !hashtree.IsGlob(file.Path) {
+		// 'file' may be under a symlinked directory
+		if p, node, err := hashtree.Resolve(d.outputTreeGet(pachClient, commitInfo), file.Path); err == nil {
+			if err := addNode(p, node); err != nil {
+				return nil, err
+			}
 		}
-		return nil, fmt.Errorf("corrupted cache: expected hashtree.Hashtree, found %v", tree)
 	}
-
-	if _, err := d.inspectCommit(pachClient, commit, pfs.CommitState_STARTED); err != nil {
+	if !found {
+		return nil, fmt.Errorf("no file(s) found that match %v", file.Path)
+	}
+	getBlocksClient, err := pachClient.ObjectAPIClient.GetBlocks(
+		ctx,
+		&pfs.GetBlocksRequest{
+			BlockRefs:   blockRefs,
+			OffsetBytes: uint64(offset),
+			SizeBytes:   uint64(size),
+			TotalSize:   uint64(totalSize),
+		},
+	)
+	if err != nil {
 		return nil, err
 	}
+	return grpcutil.NewStreamingBytesReader(getBlocksClient, nil), nil
+}
 
-	commits := d.commits(commit.Repo.Name).ReadOnly(pachClient.Ctx())
-	commitInfo := &pfs.CommitInfo{}
-	if err := commits.Get(commit.ID, commitInfo); err != nil {
-		return nil, err
+// If full is false, exclude potentially large fields such as `Objects`
+// and `Children`
+func nodeToFileInfo(ci *pfs.CommitInfo, path string, node *hashtree.NodeProto, full bool) *pfs.FileInfo {
+	fileInfo := &pfs.FileInfo{
+		File: &pfs.File{
+			Commit: ci.Commit,
+			Path:   path,
+		},
+		SizeBytes: uint64(node.SubtreeSize),
+		Hash:      node.Hash,
+		Committed: node.Committed,
 	}
-	if commitInfo.Finished == nil {
-		return nil, fmt.Errorf("cannot read from an open commit")
+	if fileInfo.Committed == nil {
+		// The node predates per-node commit times
+		fileInfo.Committed = ci.Finished
 	}
-	treeRef := commitInfo.Tree
-
-	if treeRef == nil {
-		t, err := hashtree.NewDBHashTree(d.storageRoot)
-		if err != nil {
-			return nil, err
+	if node.FileNode != nil {
+		fileInfo.FileType = pfs.FileType_FILE
+		fileInfo.Metadata = node.FileNode.Metadata
+		fileInfo.Sha256 = node.FileNode.Sha256
+		fileInfo.Md5 = node.FileNode.Md5
+		if full {
+			fileInfo.Objects = node.FileNode.Objects
+			fileInfo.BlockRefs = node.FileNode.BlockRefs
 		}
-		return t, nil
+	} else if node.DirNode != nil {
+		fileInfo.FileType = pfs.FileType_DIR
+		if full {
+			fileInfo.Children = node.DirNode.Children
+		}
+	} else if node.SymlinkNode != nil {
+		fileInfo.FileType = pfs.FileType_SYMLINK
+		fileInfo.SymlinkTarget = node.SymlinkNode.Target
 	}
+	return fileInfo
+}
 
-	// read the tree from the block store
-	h, err := hashtree.GetHashTreeObject(pachClient, d.storageRoot, treeRef)
+// nodeToFileInfoHeaderFooter is like nodeToFileInfo, but handles the case (which
+// currently only occurs in input commits) where files have a header that is
+// stored in their parent directory
+func nodeToFileInfoHeaderFooter(ci *pfs.CommitInfo, filePath string,
+	node *hashtree.NodeProto, tree hashtree.HashTree, full bool) (*pfs.FileInfo, error) {
+	if node.FileNode == nil || !node.FileNode.HasHeaderFooter {
+		return nodeToFileInfo(ci, filePath, node, full), nil
+	}
+	node = proto.Clone(node).(*hashtree.NodeProto)
+	// validate baseFileInfo for logic below--if input hashtrees start using
+	// blockrefs instead of objects, this logic will need to be adjusted
+	if node.FileNode.Objects == nil {
+		return nil, fmt.Errorf("input commit node uses blockrefs; cannot apply header")
+	}
+
+	// 'file' includes header from parent—construct synthetic file info that
+	// includes header in list of objects & hash
+	parentPath := path.Dir(filePath)
+	parentNode, err := tree.Get(parentPath)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("file %q has a header, but could not "+
+			"retrieve parent node at %q to get header content: %v", filePath,
+			parentPath, err)
+	}
+	if parentNode.DirNode == nil {
+		return nil, fmt.Errorf("parent of %q is not a directory; this is "+
+			"likely an internal error", filePath)
+	}
+	if parentNode.DirNode.Shared == nil {
+		return nil, fmt.Errorf("file %q has a shared header or footer, "+
+			"but parent directory does not permit shared data", filePath)
 	}
 
-	d.treeCache.Add(commit.ID, h)
+	s := parentNode.DirNode.Shared
+	var newObjects []*pfs.Object
+	if s.Header != nil {
+		// cap := len+1 => newObjects is right whether or not we append() a footer
+		newL := len(node.FileNode.Objects) + 1
+		newObjects = make([]*pfs.Object, newL, newL+1)
 
-	return h, nil
+		newObjects[0] = s.Header
+		copy(newObjects[1:], node.FileNode.Objects)
+	} else {
+		newObjects = node.FileNode.Objects
+	}
+	if s.Footer != nil {
+		newObjects = append(newObjects, s.Footer)
+	}
+	node.FileNode.Objects = newObjects
+	node.SubtreeSize += s.HeaderSize + s.FooterSize
+	node.Hash = hashtree.HashFileNode(node.FileNode)
+	return nodeToFileInfo(ci, filePath, node, full), nil
 }
 
-func (d *driver) getTree(pachClient *client.APIClient, commitInfo *pfs.CommitInfo, path string) (rs []io.ReadCloser, retErr error) {
-	// Determine the hashtree in which the path is located and download the chunk it is in
-	idx := hashtree.PathToTree(path, int64(len(commitInfo.Trees)))
-	r, err := d.downloadTree(pachClient, commitInfo.Trees[idx], path)
+func (d *driver) inspectFile(pachClient *client.APIClient, file *pfs.File) (fi *pfs.FileInfo, retErr error) {
+	if err := d.checkIsAuthorized(pachClient, file.Commit.Repo, auth.Scope_READER); err != nil {
+		return nil, err
+	}
+	commitInfo, err := d.inspectCommit(pachClient, file.Commit, pfs.CommitState_STARTED)
 	if err != nil {
 		return nil, err
 	}
-	return []io.ReadCloser{r}, nil
-}
-
-func (d *driver) getTrees(pachClient *client.APIClient, commitInfo *pfs.CommitInfo, pattern string) (rs []io.ReadCloser, retErr error) {
-	prefix := hashtree.GlobLiteralPrefix(pattern)
-	limiter := limit.New(hashtree.DefaultMergeConcurrency)
-	var eg errgroup.Group
-	var mu sync.Mutex
-	// Download each hashtree chunk based on the literal prefix of the pattern
-	for _, object := range commitInfo.Trees {
-		object := object
-		limiter.Acquire()
-		eg.Go(func() (retErr error) {
-			defer limiter.Release()
-			r, err := d.downloadTree(pachClient, object, prefix)
+	// Handle commits to input repos
+	if commitInfo.Provenance == nil {
+		tree, err := d.getTreeForFile(pachClient, file)
+		if err != nil {
+			return nil, err
+		}
+		node, err := tree.Get(file.Path)
+		if err != nil {
+			// 'file' may be under a symlinked directory
+			node, err = getUnderSymlinks(tree.Get, file.Path)
 			if err != nil {
-				return err
+				return nil, pfsserver.ErrFileNotFound{file}
 			}
-			mu.Lock()
-			defer mu.Unlock()
-			rs = append(rs, r)
-			return nil
-		})
+		}
+		return nodeToFileInfoHeaderFooter(commitInfo, file.Path, node, tree, true)
 	}
-	if err := eg.Wait(); err != nil {
+	// Handle commits to output repos
+	if commitInfo.Finished == nil {
+		return nil, fmt.Errorf("output commit %v not finished", commitInfo.Commit.ID)
+	}
+	if commitInfo.Trees == nil {
+		return nil, fmt.Errorf("no file(s) found that match %v", file.Path)
+	}
+	rs, err := d.getTree(pachClient, commitInfo, file.Path)
+	if err != nil {
 		return nil, err
 	}
-	return rs, nil
+	defer func() {
+		for _, r := range rs {
+			if err := r.Close(); err != nil && retErr != nil {
+				retErr = err
+			}
+		}
+	}()
+	node, err := hashtree.Get(rs, file.Path)
+	if err != nil {
+		// 'file' may be under a symlinked directory
+		node, err = getUnderSymlinks(d.outputTreeGet(pachClient, commitInfo), file.Path)
+		if err != nil {
+			return nil, pfsserver.ErrFileNotFound{file}
+		}
+	}
+	return nodeToFileInfo(commitInfo, file.Path, node, true), nil
 }
 
-func (d *driver) downloadTree(pachClient *client.APIClient, object *pfs.Object, prefix string) (r io.ReadCloser, retErr error) {
-	objClient, err := obj.NewClientFromEnv(pachClient.Ctx(), d.storageRoot)
+// getUnderSymlinks looks up 'p' after following any symlinks among its parent
+// directories. Unlike hashtree.Resolve, if 'p' itself is a symlink, the link
+// is returned rather than its target.
+func getUnderSymlinks(get func(string) (*hashtree.NodeProto, error), p string) (*hashtree.NodeProto, error) {
+	dir, _, err := hashtree.Resolve(get, path.Dir(path.Join("/", p)))
 	if err != nil {
 		return nil, err
 	}
-	info, err := pachClient.InspectObject(object.Hash)
-	if err != nil {
-		return nil, err
+	return get(path.Join(dir, path.Base(p)))
+}
+
+func (d *driver) listFile(pachClient *client.APIClient, file *pfs.File, full bool, history int64, f func(*pfs.FileInfo) error) (retErr error) {
+	if err := d.checkIsAuthorized(pachClient, file.Commit.Repo, auth.Scope_READER); err != nil {
+		return err
 	}
-	path, err := obj.BlockPathFromEnv(info.BlockRef.Block)
+	commitInfo, err := d.inspectCommit(pachClient, file.Commit, pfs.CommitState_STARTED)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	offset, size, err := getTreeRange(objClient, path, prefix)
+	g, err := hashtree.CompileGlob(file.Path)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	objR, err := objClient.Reader(path, offset, size)
+	// Handle commits to input repos
+	if commitInfo.Provenance == nil {
+		tree, err := d.getTreeForFile(pachClient, client.NewFile(file.Commit.Repo.Name, file.Commit.ID, ""))
+		if err != nil {
+			return err
+		}
+		return tree.Glob(file.Path, func(rootPath string, rootNode *hashtree.NodeProto) error {
+			if rootNode.DirNode == nil {
+				if history != 0 {
+					return d.fileHistory(pachClient, client.NewFile(file.Commit.Repo.Name, file.Commit.ID, rootPath), history, f)
+				}
+				fi, err := nodeToFileInfoHeaderFooter(commitInfo, rootPath, rootNode, tree, full)
+				if err != nil {
+					return err
+				}
+				return f(fi)
+			}
+			return tree.List(rootPath, func(node *hashtree.NodeProto) error {
+				path := filepath.Join(rootPath, node.Name)
+				if g.Match(path) {
+					// Don't return the file now, it will be returned later by Glob
+					return nil
+				}
+				if g.Excludes(path) {
+					return nil
+				}
+				if history != 0 {
+					return d.fileHistory(pachClient, client.NewFile(file.Commit.Repo.Name, file.Commit.ID, path), history, f)
+				}
+				fi, err := nodeToFileInfoHeaderFooter(commitInfo, path, node, tree, full)
+				if err != nil {
+					return err
+				}
+				return f(fi)
+			})
+		})
+	}
+	// Handle commits to output repos
+	if commitInfo.Finished == nil {
+		return fmt.Errorf("output commit %v not finished", commitInfo.Commit.ID)
+	}
+	if commitInfo.Trees == nil {
+		return nil
+	}
+	rs, err := d.getTrees(pachClient, commitInfo, file.Path)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func() {
-		if err := objR.Close(); err != nil && retErr == nil {
-			retErr = err
+		for _, r := range rs {
+			if err := r.Close(); err != nil && retErr != nil {
+				retErr = err
+			}
 		}
 	}()
-	name := filepath.Join(d.storageRoot, uuid.NewWithoutDashes())
-	f, err := os.Create(name)
+	return hashtree.List(rs, file.Path, func(path string, node *hashtree.NodeProto) error {
+		if history != 0 {
+			return d.fileHistory(pachClient, client.NewFile(file.Commit.Repo.Name, file.Commit.ID, path), history, f)
+		}
+		return f(nodeToFileInfo(commitInfo, path, node, full))
+	})
+}
+
+// listFilePageToken is the (JSON-encoded) content of a ListFile page token. It
+// identifies the last file returned by the previous page.
+type listFilePageToken struct {
+	Sort       pfs.ListFileSort `json:"sort"`
+	Descending bool             `json:"descending,omitempty"`
+	Key        int64            `json:"key,omitempty"`
+	Path       string           `json:"path"`
+}
+
+func encodeListFilePageToken(request *pfs.ListFileRequest, fi *pfs.FileInfo) (string, error) {
+	data, err := json.Marshal(&listFilePageToken{
+		Sort:       request.Sort,
+		Descending: request.Descending,
+		Key:        listFileSortKey(request.Sort, fi),
+		Path:       fi.File.Path,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeListFilePageToken(request *pfs.ListFileRequest) (*listFilePageToken, error) {
+	token := &listFilePageToken{}
+	if request.PageToken == "" {
+		return token, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(request.PageToken)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("malformed page token: %v", err)
 	}
-	// Mark the file for removal (Linux won't remove it until we close the file)
-	if err := os.Remove(name); err != nil {
-		return nil, err
+	if err := json.Unmarshal(data, token); err != nil {
+		return nil, fmt.Errorf("malformed page token: %v", err)
 	}
-	buf := grpcutil.GetBuffer()
-	defer grpcutil.PutBuffer(buf)
-	if _, err := io.CopyBuffer(f, objR, buf); err != nil {
-		return nil, err
+	if token.Sort != request.Sort || token.Descending != request.Descending {
+		return nil, fmt.Errorf("page token was issued for a different sort order")
 	}
-	if _, err := f.Seek(0, 0); err != nil {
-		return nil, err
+	return token, nil
+}
+
+// listFileSortKey returns the key that 'fi' is sorted by (before its path).
+func listFileSortKey(sort pfs.ListFileSort, fi *pfs.FileInfo) int64 {
+	switch sort {
+	case pfs.ListFileSort_SIZE:
+		return int64(fi.SizeBytes)
+	case pfs.ListFileSort_COMMITTED:
+		if fi.Committed == nil {
+			return 0
+		}
+		return fi.Committed.Seconds*int64(time.Second) + int64(fi.Committed.Nanos)
 	}
-	return f, nil
+	return 0
 }
 
-func getTreeRange(objClient obj.Client, path string, prefix string) (uint64, uint64, error) {
-	p := path + hashtree.IndexPath
-	r, err := objClient.Reader(p, 0, 0)
-	if err != nil {
-		return 0, 0, err
+// listFileLess returns true if the file with sort key 'aKey' and path 'aPath'
+// comes before the one with 'bKey' and 'bPath' in the requested order.
+func listFileLess(request *pfs.ListFileRequest, aKey int64, aPath string, bKey int64, bPath string) bool {
+	if request.Descending {
+		aKey, aPath, bKey, bPath = bKey, bPath, aKey, aPath
 	}
-	idx, err := ioutil.ReadAll(r)
-	if err != nil {
-		return 0, 0, err
+	if aKey != bKey {
+		return aKey < bKey
 	}
-	return hashtree.GetRangeFromIndex(bytes.NewBuffer(idx), prefix)
+	return aPath < bPath
 }
 
-// getTreeForFile is like getTreeForCommit except that it can handle open commits.
-// It takes a file instead of a commit so that it can apply the changes for
-// that path to the tree before it returns it.
-func (d *driver) getTreeForFile(pachClient *client.APIClient, file *pfs.File) (hashtree.HashTree, error) {
-	if file.Commit == nil {
-		t, err := hashtree.NewDBHashTree(d.storageRoot)
-		if err != nil {
-			return nil, err
-		}
-		return t, nil
+// listFileMatches returns true if 'fi' passes the filters in 'request'.
+func listFileMatches(request *pfs.ListFileRequest, fi *pfs.FileInfo) (bool, error) {
+	if request.FileType != pfs.FileType_RESERVED && fi.FileType != request.FileType {
+		return false, nil
 	}
-	commitInfo, err := d.inspectCommit(pachClient, file.Commit, pfs.CommitState_STARTED)
-	if err != nil {
-		return nil, err
+	if fi.SizeBytes < request.MinSizeBytes {
+		return false, nil
 	}
-	if commitInfo.Finished != nil {
-		tree, err := d.getTreeForCommit(pachClient, file.Commit)
+	if request.MaxSizeBytes > 0 && fi.SizeBytes > request.MaxSizeBytes {
+		return false, nil
+	}
+	if request.CommittedAfter != nil {
+		if fi.Committed == nil {
+			return false, nil
+		}
+		after, err := types.TimestampFromProto(request.CommittedAfter)
 		if err != nil {
-			return nil, err
+			return false, err
+		}
+		committed, err := types.TimestampFromProto(fi.Committed)
+		if err != nil {
+			return false, err
+		}
+		if !committed.After(after) {
+			return false, nil
 		}
-		return tree, nil
-	}
-	parentTree, err := d.getTreeForCommit(pachClient, commitInfo.ParentCommit)
-	if err != nil {
-		return nil, err
 	}
-	return d.getTreeForOpenCommit(pachClient, file, parentTree)
+	return true, nil
 }
 
-func (d *driver) getTreeForOpenCommit(pachClient *client.APIClient, file *pfs.File, parentTree hashtree.HashTree) (hashtree.HashTree, error) {
-	ctx := pachClient.Ctx()
-	prefix, err := d.scratchFilePrefix(file)
+// listFilePage is like listFile, but also handles the pagination, sorting and
+// filtering options in 'request'. It returns the token for the next page, or
+// "" if this is the last one.
+//
+// Listing a directory by name in ascending order (the default) only reads the
+// children on the requested page, since that's the order in which hashtrees
+// store them. Any other order (or a glob) reads the metadata of every
+// matching file, but only keeps one page of them in memory.
+func (d *driver) listFilePage(pachClient *client.APIClient, request *pfs.ListFileRequest, f func(*pfs.FileInfo) error) (string, error) {
+	if request.PageSize < 0 {
+		return "", fmt.Errorf("page size must be non-negative")
+	}
+	if request.PageSize == 0 && request.PageToken == "" && request.Sort == pfs.ListFileSort_NAME && !request.Descending &&
+		request.MinSizeBytes == 0 && request.MaxSizeBytes == 0 && request.CommittedAfter == nil && request.FileType == pfs.FileType_RESERVED {
+		return "", d.listFile(pachClient, request.File, request.Full, request.History, f)
+	}
+	if request.History != 0 {
+		return "", fmt.Errorf("history can't be combined with pagination, sorting or filters")
+	}
+	token, err := decodeListFilePageToken(request)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	var tree hashtree.HashTree
-	if _, err := col.NewSTM(ctx, d.etcdClient, func(stm col.STM) error {
-		tree, err = parentTree.Copy()
-		if err != nil {
-			return err
+
+	if request.Sort == pfs.ListFileSort_NAME && !request.Descending && !hashtree.IsGlob(request.File.Path) {
+		// Files are listed in the right order, so they can be returned as
+		// they're read, until one more than a page has been seen
+		var last *pfs.FileInfo
+		var sent int64
+		var more bool
+		if err := d.listFileAfter(pachClient, request.File, request.Full, token.Path, func(fi *pfs.FileInfo) error {
+			if ok, err := listFileMatches(request, fi); err != nil || !ok {
+				return err
+			}
+			if request.PageSize > 0 && sent == request.PageSize {
+				more = true
+				return errutil.ErrBreak
+			}
+			sent++
+			last = fi
+			return f(fi)
+		}); err != nil {
+			return "", err
 		}
-		recordsCol := d.putFileRecords.ReadOnly(ctx)
-		putFileRecords := &pfs.PutFileRecords{}
-		opts := &col.Options{etcd.SortByModRevision, etcd.SortAscend, true}
-		return recordsCol.ListPrefix(prefix, putFileRecords, opts, func(key string) error {
-			return d.applyWrite(path.Join(file.Path, key), putFileRecords, tree)
+		if !more {
+			return "", nil
+		}
+		return encodeListFilePageToken(request, last)
+	}
+
+	// Otherwise keep the first page (plus one file, to tell if there's another
+	// page) of the files that come after the token
+	type item struct {
+		key int64
+		fi  *pfs.FileInfo
+	}
+	var items []item
+	trim := func() {
+		sort.Slice(items, func(i, j int) bool {
+			return listFileLess(request, items[i].key, items[i].fi.File.Path, items[j].key, items[j].fi.File.Path)
 		})
+		if request.PageSize > 0 && int64(len(items)) > request.PageSize+1 {
+			items = items[:request.PageSize+1]
+		}
+	}
+	if err := d.listFile(pachClient, request.File, request.Full, 0, func(fi *pfs.FileInfo) error {
+		if ok, err := listFileMatches(request, fi); err != nil || !ok {
+			return err
+		}
+		key := listFileSortKey(request.Sort, fi)
+		if request.PageToken != "" && !listFileLess(request, token.Key, token.Path, key, fi.File.Path) {
+			return nil
+		}
+		items = append(items, item{key, fi})
+		if request.PageSize > 0 && int64(len(items)) > 2*(request.PageSize+1) {
+			trim()
+		}
+		return nil
 	}); err != nil {
-		return nil, err
+		return "", err
 	}
-	if err := tree.Hash(); err != nil {
-		return nil, err
+	trim()
+	var nextToken string
+	if request.PageSize > 0 && int64(len(items)) > request.PageSize {
+		items = items[:request.PageSize]
+		if nextToken, err = encodeListFilePageToken(request, items[len(items)-1].fi); err != nil {
+			return "", err
+		}
 	}
-	return tree, nil
+	for _, item := range items {
+		if err := f(item.fi); err != nil {
+			if err == errutil.ErrBreak {
+				break
+			}
+			return "", err
+		}
+	}
+	return nextToken, nil
 }
 
-func (d *driver) getFile(pachClient *client.APIClient, file *pfs.File, offset int64, size int64) (r io.Reader, retErr error) {
-	ctx := pachClient.Ctx()
+// listFileAfter lists the children of the directory at file.Path (which
+// mustn't be a glob) whose paths come after 'after', in order. If file.Path is
+// a regular file, it's returned (if it comes after 'after').
+func (d *driver) listFileAfter(pachClient *client.APIClient, file *pfs.File, full bool, after string, f func(*pfs.FileInfo) error) (retErr error) {
 	if err := d.checkIsAuthorized(pachClient, file.Commit.Repo, auth.Scope_READER); err != nil {
-		return nil, err
+		return err
 	}
 	commitInfo, err := d.inspectCommit(pachClient, file.Commit, pfs.CommitState_STARTED)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	dir := path.Join("/", file.Path)
 	// Handle commits to input repos
 	if commitInfo.Provenance == nil {
 		tree, err := d.getTreeForFile(pachClient, client.NewFile(file.Commit.Repo.Name, file.Commit.ID, ""))
 		if err != nil {
-			return nil, err
+			return err
 		}
-		var (
-			pathsFound int
-			objects    []*pfs.Object
-			totalSize  uint64
-			footer     *pfs.Object
-			prevDir    string
-		)
-		if err := tree.Glob(file.Path, func(p string, node *hashtree.NodeProto) error {
-			pathsFound++
-			if node.FileNode == nil {
+		node, err := tree.Get(dir)
+		if err != nil {
+			return pfsserver.ErrFileNotFound{File: file}
+		}
+		if node.DirNode == nil {
+			if dir <= after {
 				return nil
 			}
+			fi, err := nodeToFileInfoHeaderFooter(commitInfo, dir, node, tree, full)
+			if err != nil {
+				return err
+			}
+			return f(fi)
+		}
+		var from string
+		if after != "" {
+			if path.Dir(after) != dir {
+				return fmt.Errorf("page token was issued for a different path")
+			}
+			from = path.Base(after)
+		}
+		return tree.ListFrom(dir, from, func(node *hashtree.NodeProto) error {
+			fi, err := nodeToFileInfoHeaderFooter(commitInfo, path.Join(dir, node.Name), node, tree, full)
+			if err != nil {
+				return err
+			}
+			return f(fi)
+		})
+	}
+	// Handle commits to output repos
+	if commitInfo.Finished == nil {
+		return fmt.Errorf("output commit %v not finished", commitInfo.Commit.ID)
+	}
+	if commitInfo.Trees == nil {
+		return nil
+	}
+	rs, err := d.getTreesAfter(pachClient, commitInfo, dir, after)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, r := range rs {
+			if err := r.Close(); err != nil && retErr == nil {
+				retErr = err
+			}
+		}
+	}()
+	if err := hashtree.List(rs, dir, func(p string, node *hashtree.NodeProto) error {
+		if p <= after {
+			return nil
+		}
+		return f(nodeToFileInfo(commitInfo, p, node, full))
+	}); err != nil && err != errutil.ErrBreak {
+		return err
+	}
+	return nil
+}
 
-			// add footer + header for next dir. If a user calls e.g.
-			// 'GetFile("/*/*")', then the output looks like:
-			// [d1 header][d1/1]...[d1/n][d1 footer] [d2 header]...[d2 footer] ...
-			parentPath := path.Dir(p)
-			if parentPath != prevDir {
-				if footer != nil {
-					objects = append(objects, footer)
-				}
-				footer = nil // don't apply footer twice if next dir has no footer
-				prevDir = parentPath
-				if node.FileNode.HasHeaderFooter {
-					// if any child of 'node's parent directory has HasHeaderFooter set,
-					// then they all should
-					parentNode, err := tree.Get(parentPath)
-					if err != nil {
-						return fmt.Errorf("file %q has a header, but could not "+
-							"retrieve parent node at %q to get header content: %v", p,
-							parentPath, err)
-					}
-					if parentNode.DirNode == nil {
-						return fmt.Errorf("parent of %q is not a directory—this is "+
-							"likely an internal error", p)
-					}
-					if parentNode.DirNode.Shared == nil {
-						return fmt.Errorf("file %q has a shared header or footer, "+
-							"but parent directory does not permit shared data", p)
-					}
-					if parentNode.DirNode.Shared.Header != nil {
-						objects = append(objects, parentNode.DirNode.Shared.Header)
-					}
-					if parentNode.DirNode.Shared.Footer != nil {
-						footer = parentNode.DirNode.Shared.Footer
-					}
+// fileHistory calls f with FileInfos for the file, starting with how it looked
+// at the referenced commit and then all past versions that are different.
+func (d *driver) fileHistory(pachClient *client.APIClient, file *pfs.File, history int64, f func(*pfs.FileInfo) error) error {
+	var fi *pfs.FileInfo
+	for {
+		_fi, err := d.inspectFile(pachClient, file)
+		if err != nil {
+			if _, ok := err.(pfsserver.ErrFileNotFound); ok {
+				return f(fi)
+			}
+			return err
+		}
+		if fi != nil && bytes.Compare(fi.Hash, _fi.Hash) != 0 {
+			if err := f(fi); err != nil {
+				return err
+			}
+			if history > 0 {
+				history--
+				if history == 0 {
+					return nil
 				}
 			}
-			objects = append(objects, node.FileNode.Objects...)
-			totalSize += uint64(node.SubtreeSize)
-			return nil
-		}); err != nil {
-			return nil, err
 		}
-		if footer != nil {
-			objects = append(objects, footer) // apply final footer
+		fi = _fi
+		ci, err := d.inspectCommit(pachClient, file.Commit, pfs.CommitState_STARTED)
+		if err != nil {
+			return err
 		}
-		if pathsFound == 0 {
-			return nil, fmt.Errorf("no file(s) found that match %v", file.Path)
+		if ci.ParentCommit == nil {
+			return f(fi)
+		}
+		file.Commit = ci.ParentCommit
+	}
+}
+
+func (d *driver) walkFile(pachClient *client.APIClient, file *pfs.File, f func(*pfs.FileInfo) error) (retErr error) {
+	if err := d.checkIsAuthorized(pachClient, file.Commit.Repo, auth.Scope_READER); err != nil {
+		return err
+	}
+	commitInfo, err := d.inspectCommit(pachClient, file.Commit, pfs.CommitState_STARTED)
+	if err != nil {
+		return err
+	}
+	// Handle commits to input repos
+	if commitInfo.Provenance == nil {
+		tree, err := d.getTreeForFile(pachClient, client.NewFile(file.Commit.Repo.Name, file.Commit.ID, file.Path))
+		if err != nil {
+			return err
+		}
+		return tree.Walk(file.Path, func(path string, node *hashtree.NodeProto) error {
+			fi, err := nodeToFileInfoHeaderFooter(commitInfo, path, node, tree, false)
+			if err != nil {
+				return err
+			}
+			return f(fi)
+		})
+	}
+	// Handle commits to output repos
+	if commitInfo.Finished == nil {
+		return fmt.Errorf("output commit %v not finished", commitInfo.Commit.ID)
+	}
+	if commitInfo.Trees == nil {
+		return nil
+	}
+	rs, err := d.getTrees(pachClient, commitInfo, file.Path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, r := range rs {
+			if err := r.Close(); err != nil && retErr != nil {
+				retErr = err
+			}
 		}
+	}()
+	return hashtree.Walk(rs, file.Path, func(path string, node *hashtree.NodeProto) error {
+		return f(nodeToFileInfo(commitInfo, path, node, false))
+	})
+}
 
-		// retrieve the content of all objects in 'objects'
-		getObjectsClient, err := pachClient.ObjectAPIClient.GetObjects(
-			ctx,
-			&pfs.GetObjectsRequest{
-				Objects:     objects,
-				OffsetBytes: uint64(offset),
-				SizeBytes:   uint64(size),
-				TotalSize:   uint64(totalSize),
-			})
+func (d *driver) globFile(pachClient *client.APIClient, commit *pfs.Commit, pattern string, full bool, f func(*pfs.FileInfo) error) (retErr error) {
+	if err := d.checkIsAuthorized(pachClient, commit.Repo, auth.Scope_READER); err != nil {
+		return err
+	}
+	commitInfo, err := d.inspectCommit(pachClient, commit, pfs.CommitState_STARTED)
+	if err != nil {
+		return err
+	}
+	// Handle commits to input repos
+	if commitInfo.Provenance == nil {
+		tree, err := d.getTreeForFile(pachClient, client.NewFile(commit.Repo.Name, commit.ID, ""))
 		if err != nil {
-			return nil, err
+			return err
 		}
-		return grpcutil.NewStreamingBytesReader(getObjectsClient, nil), nil
+		return tree.Glob(pattern, func(path string, node *hashtree.NodeProto) error {
+			fi, err := nodeToFileInfoHeaderFooter(commitInfo, path, node, tree, full)
+			if err != nil {
+				return err
+			}
+			return f(fi)
+		})
 	}
 	// Handle commits to output repos
 	if commitInfo.Finished == nil {
-		return nil, fmt.Errorf("output commit %v not finished", commitInfo.Commit.ID)
+		return fmt.Errorf("output commit %v not finished", commitInfo.Commit.ID)
 	}
 	if commitInfo.Trees == nil {
-		return nil, fmt.Errorf("no file(s) found that match %v", file.Path)
+		return nil
 	}
 	var rs []io.ReadCloser
 	// Handles the case when looking for a specific file/directory
-	if !hashtree.IsGlob(file.Path) {
-		rs, err = d.getTree(pachClient, commitInfo, file.Path)
+	if !hashtree.IsGlob(pattern) {
+		rs, err = d.getTree(pachClient, commitInfo, pattern)
 	} else {
-		rs, err = d.getTrees(pachClient, commitInfo, file.Path)
+		rs, err = d.getTrees(pachClient, commitInfo, pattern)
 	}
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func() {
 		for _, r := range rs {
@@ -2531,466 +5104,962 @@ func (d *driver) getFile(pachClient *client.APIClient, file *pfs.File, offset in
 			}
 		}
 	}()
-	blockRefs := []*pfs.BlockRef{}
-	var totalSize int64
-	var found bool
-	if err := hashtree.Glob(rs, file.Path, func(path string, node *hashtree.NodeProto) error {
-		if node.FileNode == nil {
+	return hashtree.Glob(rs, pattern, func(rootPath string, rootNode *hashtree.NodeProto) error {
+		return f(nodeToFileInfo(commitInfo, rootPath, rootNode, full))
+	})
+}
+
+// grepFile searches the files that match request.Pattern for lines that
+// match request.Regex, calling 'f' with each matching line. 'f' is never
+// called concurrently, and it's called with all of a file's matches at once.
+// If the search is stopped early by one of the request's limits, 'f' is
+// called one last time with a response that only sets Truncated.
+func (d *driver) grepFile(pachClient *client.APIClient, request *pfs.GrepFileRequest, f func(*pfs.GrepFileResponse) error) error {
+	re, err := regexp.Compile(request.Regex)
+	if err != nil {
+		return fmt.Errorf("invalid regex %q: %v", request.Regex, err)
+	}
+	parallelism := int(request.Parallelism)
+	if parallelism <= 0 {
+		parallelism = defaultGrepParallelism
+	}
+	var (
+		mu        sync.Mutex
+		scanned   int64
+		results   int64
+		truncated bool
+	)
+	isTruncated := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return truncated
+	}
+	fileInfos := make(chan *pfs.FileInfo)
+	eg, ctx := errgroup.WithContext(pachClient.Ctx())
+	pachClient = pachClient.WithCtx(ctx)
+	eg.Go(func() error {
+		defer close(fileInfos)
+		err := d.globFile(pachClient, request.Commit, request.Pattern, true, func(fi *pfs.FileInfo) error {
+			if fi.FileType != pfs.FileType_FILE {
+				return nil
+			}
+			mu.Lock()
+			if request.MaxBytesScanned > 0 && scanned+int64(fi.SizeBytes) > request.MaxBytesScanned {
+				truncated = true
+			}
+			scanned += int64(fi.SizeBytes)
+			stop := truncated
+			mu.Unlock()
+			if stop {
+				return errutil.ErrBreak
+			}
+			select {
+			case fileInfos <- fi:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if err == errutil.ErrBreak {
 			return nil
 		}
-		blockRefs = append(blockRefs, node.FileNode.BlockRefs...)
-		totalSize += node.SubtreeSize
-		found = true
-		return nil
-	}); err != nil {
-		return nil, err
+		return err
+	})
+	for i := 0; i < parallelism; i++ {
+		eg.Go(func() error {
+			for fi := range fileInfos {
+				if isTruncated() {
+					continue // drain 'fileInfos' so that globbing can finish
+				}
+				// Each match takes one of the request's results as it's found,
+				// so only the matches that will be returned are kept, and the
+				// search stops once max_results is reached
+				var matches []*pfs.GrepFileResponse
+				if err := d.grepOneFile(pachClient, fi, re, func(match *pfs.GrepFileResponse) error {
+					mu.Lock()
+					defer mu.Unlock()
+					if request.MaxResults > 0 && results >= request.MaxResults {
+						truncated = true
+						return errutil.ErrBreak
+					}
+					results++
+					matches = append(matches, match)
+					return nil
+				}); err != nil && err != errutil.ErrBreak {
+					return err
+				}
+				if err := func() error {
+					mu.Lock()
+					defer mu.Unlock()
+					for _, match := range matches {
+						if err := f(match); err != nil {
+							return err
+						}
+					}
+					return nil
+				}(); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
 	}
-	if !found {
-		return nil, fmt.Errorf("no file(s) found that match %v", file.Path)
+	if err := eg.Wait(); err != nil {
+		return err
 	}
-	getBlocksClient, err := pachClient.ObjectAPIClient.GetBlocks(
-		ctx,
-		&pfs.GetBlocksRequest{
-			BlockRefs:   blockRefs,
-			OffsetBytes: uint64(offset),
-			SizeBytes:   uint64(size),
-			TotalSize:   uint64(totalSize),
-		},
-	)
+	if truncated {
+		return f(&pfs.GrepFileResponse{Truncated: true})
+	}
+	return nil
+}
+
+// fileInfoReader returns the contents of the file described by 'fileInfo',
+// which must include its objects or block refs (i.e. it must be "full").
+func fileInfoReader(pachClient *client.APIClient, fileInfo *pfs.FileInfo) (io.Reader, error) {
+	switch {
+	case len(fileInfo.Objects) > 0:
+		getObjectsClient, err := pachClient.ObjectAPIClient.GetObjects(
+			pachClient.Ctx(),
+			&pfs.GetObjectsRequest{
+				Objects:   fileInfo.Objects,
+				TotalSize: fileInfo.SizeBytes,
+			})
+		if err != nil {
+			return nil, err
+		}
+		return grpcutil.NewStreamingBytesReader(getObjectsClient, nil), nil
+	case len(fileInfo.BlockRefs) > 0:
+		getBlocksClient, err := pachClient.ObjectAPIClient.GetBlocks(
+			pachClient.Ctx(),
+			&pfs.GetBlocksRequest{
+				BlockRefs: fileInfo.BlockRefs,
+				TotalSize: fileInfo.SizeBytes,
+			})
+		if err != nil {
+			return nil, err
+		}
+		return grpcutil.NewStreamingBytesReader(getBlocksClient, nil), nil
+	default:
+		return &bytes.Buffer{}, nil // the file is empty
+	}
+}
+
+// grepOneFile calls 'f' with each line of the file described by 'fileInfo'
+// (which must include its objects or block refs) that matches 're', in order.
+// If 'f' returns an error, the search stops and that error is returned.
+func (d *driver) grepOneFile(pachClient *client.APIClient, fileInfo *pfs.FileInfo, re *regexp.Regexp, f func(*pfs.GrepFileResponse) error) error {
+	r, err := fileInfoReader(pachClient, fileInfo)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return grpcutil.NewStreamingBytesReader(getBlocksClient, nil), nil
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxGrepLineBytes)
+	var lineNumber int64
+	for scanner.Scan() {
+		lineNumber++
+		if re.Match(scanner.Bytes()) {
+			if err := f(&pfs.GrepFileResponse{
+				File:       fileInfo.File,
+				LineNumber: lineNumber,
+				Line:       scanner.Text(),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error searching %s after line %d: %v", fileInfo.File.Path, lineNumber, err)
+	}
+	return nil
 }
 
-// If full is false, exclude potentially large fields such as `Objects`
-// and `Children`
-func nodeToFileInfo(ci *pfs.CommitInfo, path string, node *hashtree.NodeProto, full bool) *pfs.FileInfo {
-	fileInfo := &pfs.FileInfo{
-		File: &pfs.File{
-			Commit: ci.Commit,
-			Path:   path,
-		},
-		SizeBytes: uint64(node.SubtreeSize),
-		Hash:      node.Hash,
-		Committed: ci.Finished,
+// If full is false, the returned FileInfos exclude potentially large fields
+// (see nodeToFileInfo)
+func (d *driver) diffFile(pachClient *client.APIClient, newFile *pfs.File, oldFile *pfs.File, shallow bool, full bool) ([]*pfs.FileInfo, []*pfs.FileInfo, error) {
+	// Do READER authorization check for both newFile and oldFile
+	if oldFile != nil && oldFile.Commit != nil {
+		if err := d.checkIsAuthorized(pachClient, oldFile.Commit.Repo, auth.Scope_READER); err != nil {
+			return nil, nil, err
+		}
 	}
-	if node.FileNode != nil {
-		fileInfo.FileType = pfs.FileType_FILE
-		if full {
-			fileInfo.Objects = node.FileNode.Objects
-			fileInfo.BlockRefs = node.FileNode.BlockRefs
+	if newFile != nil && newFile.Commit != nil {
+		if err := d.checkIsAuthorized(pachClient, newFile.Commit.Repo, auth.Scope_READER); err != nil {
+			return nil, nil, err
 		}
-	} else if node.DirNode != nil {
-		fileInfo.FileType = pfs.FileType_DIR
-		if full {
-			fileInfo.Children = node.DirNode.Children
+	}
+	newTree, err := d.getTreeForFile(pachClient, newFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	newCommitInfo, err := d.inspectCommit(pachClient, newFile.Commit, pfs.CommitState_STARTED)
+	if err != nil {
+		return nil, nil, err
+	}
+	// if oldFile is new we use the parent of newFile
+	if oldFile == nil {
+		oldFile = &pfs.File{}
+		// ParentCommit may be nil, that's fine because getTreeForCommit
+		// handles nil
+		oldFile.Commit = newCommitInfo.ParentCommit
+		oldFile.Path = newFile.Path
+	}
+	oldCommitInfo, err := d.inspectCommit(pachClient, oldFile.Commit, pfs.CommitState_STARTED)
+	if err != nil {
+		return nil, nil, err
+	}
+	oldTree, err := d.getTreeForFile(pachClient, oldFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	var newFileInfos []*pfs.FileInfo
+	var oldFileInfos []*pfs.FileInfo
+	recursiveDepth := -1
+	if shallow {
+		recursiveDepth = 1
+	}
+	if err := newTree.Diff(oldTree, newFile.Path, oldFile.Path, int64(recursiveDepth), func(path string, node *hashtree.NodeProto, isNewFile bool) error {
+		if isNewFile {
+			fi, err := nodeToFileInfoHeaderFooter(newCommitInfo, path, node, newTree, full)
+			if err != nil {
+				return err
+			}
+			newFileInfos = append(newFileInfos, fi)
+		} else {
+			fi, err := nodeToFileInfoHeaderFooter(oldCommitInfo, path, node, oldTree, full)
+			if err != nil {
+				return err
+			}
+			oldFileInfos = append(oldFileInfos, fi)
 		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
 	}
-	return fileInfo
+	return newFileInfos, oldFileInfos, nil
 }
 
-// nodeToFileInfoHeaderFooter is like nodeToFileInfo, but handles the case (which
-// currently only occurs in input commits) where files have a header that is
-// stored in their parent directory
-func nodeToFileInfoHeaderFooter(ci *pfs.CommitInfo, filePath string,
-	node *hashtree.NodeProto, tree hashtree.HashTree, full bool) (*pfs.FileInfo, error) {
-	if node.FileNode == nil || !node.FileNode.HasHeaderFooter {
-		return nodeToFileInfo(ci, filePath, node, full), nil
+// diffFileStream is like diffFile, but pairs up the new and old versions of
+// each path that differs, and (if request.Content is set) diffs the
+// contents of the files.
+func (d *driver) diffFileStream(pachClient *client.APIClient, request *pfs.DiffFileRequest, f func(*pfs.FileDiff) error) error {
+	newFileInfos, oldFileInfos, err := d.diffFile(pachClient, request.NewFile, request.OldFile, request.Shallow, request.Content)
+	if err != nil {
+		return err
 	}
-	node = proto.Clone(node).(*hashtree.NodeProto)
-	// validate baseFileInfo for logic below--if input hashtrees start using
-	// blockrefs instead of objects, this logic will need to be adjusted
-	if node.FileNode.Objects == nil {
-		return nil, fmt.Errorf("input commit node uses blockrefs; cannot apply header")
+	// Paths are paired up by their position under the paths being diffed
+	newRoot := path.Join("/", request.NewFile.Path)
+	oldRoot := newRoot
+	if request.OldFile != nil {
+		oldRoot = path.Join("/", request.OldFile.Path)
+	}
+	diffs := make(map[string]*pfs.FileDiff)
+	var keys []string
+	getDiff := func(key string) *pfs.FileDiff {
+		if _, ok := diffs[key]; !ok {
+			diffs[key] = &pfs.FileDiff{}
+			keys = append(keys, key)
+		}
+		return diffs[key]
+	}
+	for _, fi := range newFileInfos {
+		if !request.Content || fi.FileType == pfs.FileType_FILE {
+			getDiff(strings.TrimPrefix(fi.File.Path, newRoot)).NewFile = fi
+		}
+	}
+	for _, fi := range oldFileInfos {
+		if !request.Content || fi.FileType == pfs.FileType_FILE {
+			getDiff(strings.TrimPrefix(fi.File.Path, oldRoot)).OldFile = fi
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		diff := diffs[key]
+		if request.Content {
+			if err := diffContent(pachClient, diff, int(request.ContextLines), request.MaxContentBytes); err != nil {
+				return err
+			}
+		}
+		if err := f(diff); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// 'file' includes header from parent—construct synthetic file info that
-	// includes header in list of objects & hash
-	parentPath := path.Dir(filePath)
-	parentNode, err := tree.Get(parentPath)
-	if err != nil {
-		return nil, fmt.Errorf("file %q has a header, but could not "+
-			"retrieve parent node at %q to get header content: %v", filePath,
-			parentPath, err)
+// diffContent sets diff.Diff (or diff.Binary or diff.TooLarge) by comparing
+// the contents of diff.OldFile and diff.NewFile, which must be full
+// FileInfos. Their objects and block refs are cleared afterwards, as they
+// aren't returned by DiffFileStream.
+func diffContent(pachClient *client.APIClient, diff *pfs.FileDiff, contextLines int, maxBytes int64) error {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxDiffBytes
 	}
-	if parentNode.DirNode == nil {
-		return nil, fmt.Errorf("parent of %q is not a directory; this is "+
-			"likely an internal error", filePath)
+	fileInfos := []*pfs.FileInfo{diff.OldFile, diff.NewFile}
+	defer func() {
+		for _, fi := range fileInfos {
+			if fi != nil {
+				fi.Objects, fi.BlockRefs = nil, nil
+			}
+		}
+	}()
+	var contents [2][]byte
+	names := [2]string{"/dev/null", "/dev/null"}
+	prefixes := [2]string{"a", "b"}
+	for i, fi := range fileInfos {
+		if fi == nil {
+			continue
+		}
+		names[i] = prefixes[i] + fi.File.Path
+		if int64(fi.SizeBytes) > maxBytes {
+			diff.TooLarge = true
+			continue
+		}
+		r, err := fileInfoReader(pachClient, fi)
+		if err != nil {
+			return err
+		}
+		if contents[i], err = ioutil.ReadAll(r); err != nil {
+			return err
+		}
+		diff.Binary = diff.Binary || pfsserver.IsBinary(contents[i])
 	}
-	if parentNode.DirNode.Shared == nil {
-		return nil, fmt.Errorf("file %q has a shared header or footer, "+
-			"but parent directory does not permit shared data", filePath)
+	if diff.TooLarge || diff.Binary {
+		return nil
 	}
+	diff.Diff = pfsserver.UnifiedDiff(names[0], names[1], string(contents[0]), string(contents[1]), contextLines)
+	return nil
+}
 
-	s := parentNode.DirNode.Shared
-	var newObjects []*pfs.Object
-	if s.Header != nil {
-		// cap := len+1 => newObjects is right whether or not we append() a footer
-		newL := len(node.FileNode.Objects) + 1
-		newObjects = make([]*pfs.Object, newL, newL+1)
-
-		newObjects[0] = s.Header
-		copy(newObjects[1:], node.FileNode.Objects)
-	} else {
-		newObjects = node.FileNode.Objects
+// subscribeFile calls 'f' with an event for each file that matches
+// request.Pattern (or that's under a directory that does) and that's added,
+// modified or deleted by each finished commit on request.Branch, starting
+// after request.From. A commit's events are sorted by path.
+func (d *driver) subscribeFile(pachClient *client.APIClient, request *pfs.SubscribeFileRequest, f func(*pfs.FileEvent) error) error {
+	if err := d.checkIsAuthorized(pachClient, request.Repo, auth.Scope_READER); err != nil {
+		return err
 	}
-	if s.Footer != nil {
-		newObjects = append(newObjects, s.Footer)
+	g, err := hashtree.CompileGlob(request.Pattern)
+	if err != nil {
+		return err
 	}
-	node.FileNode.Objects = newObjects
-	node.SubtreeSize += s.HeaderSize + s.FooterSize
-	node.Hash = hashtree.HashFileNode(node.FileNode)
-	return nodeToFileInfo(ci, filePath, node, full), nil
+	// Only files under the directory containing the pattern's literal prefix
+	// can match it, so commits are only diffed below there
+	root := hashtree.GlobLiteralPrefix(request.Pattern)
+	if hashtree.IsGlob(request.Pattern) {
+		root = root[:strings.LastIndex(root, "/")+1]
+	}
+	if root == "" {
+		root = "/"
+	}
+	match := func(p string) bool {
+		if g.Excludes(p) {
+			return false
+		}
+		for ; p != "/" && p != "."; p = path.Dir(p) {
+			if g.Match(p) {
+				return true
+			}
+		}
+		return false
+	}
+	return d.subscribeCommit(pachClient, request.Repo, request.Branch, request.From, pfs.CommitState_FINISHED, "", func(commitInfo *pfs.CommitInfo) error {
+		newFileInfos, oldFileInfos, err := d.diffCommit(pachClient, commitInfo, root)
+		if err != nil {
+			return err
+		}
+		events := make(map[string]*pfs.FileEvent)
+		var paths []string
+		for _, fi := range newFileInfos {
+			if match(fi.File.Path) {
+				events[fi.File.Path] = &pfs.FileEvent{Type: pfs.FileEventType_ADDED, Commit: commitInfo.Commit, File: fi}
+				paths = append(paths, fi.File.Path)
+			}
+		}
+		for _, fi := range oldFileInfos {
+			if !match(fi.File.Path) {
+				continue
+			}
+			if event, ok := events[fi.File.Path]; ok {
+				event.Type = pfs.FileEventType_MODIFIED
+				event.OldFile = fi
+				continue
+			}
+			events[fi.File.Path] = &pfs.FileEvent{Type: pfs.FileEventType_DELETED, Commit: commitInfo.Commit, File: fi}
+			paths = append(paths, fi.File.Path)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			if err := f(events[p]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func (d *driver) inspectFile(pachClient *client.APIClient, file *pfs.File) (fi *pfs.FileInfo, retErr error) {
-	if err := d.checkIsAuthorized(pachClient, file.Commit.Repo, auth.Scope_READER); err != nil {
-		return nil, err
+// diffCommit returns the files under 'root' that differ between the finished
+// commit 'commitInfo' and its parent: their new versions (from 'commitInfo')
+// and their old versions (from its parent). Directories aren't returned.
+func (d *driver) diffCommit(pachClient *client.APIClient, commitInfo *pfs.CommitInfo, root string) (_ []*pfs.FileInfo, _ []*pfs.FileInfo, retErr error) {
+	repo := commitInfo.Commit.Repo.Name
+	if commitInfo.Trees == nil {
+		return d.diffFile(pachClient, client.NewFile(repo, commitInfo.Commit.ID, root), nil, false, false)
 	}
-	commitInfo, err := d.inspectCommit(pachClient, file.Commit, pfs.CommitState_STARTED)
+	// Output commits' trees are split into chunks, which are merged (under
+	// 'root' only) so that unchanged subtrees are skipped by hashtree.Diff
+	newTree, err := d.getMergedTree(pachClient, commitInfo, root)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	// Handle commits to input repos
-	if commitInfo.Provenance == nil {
-		tree, err := d.getTreeForFile(pachClient, file)
-		if err != nil {
-			return nil, err
+	defer func() {
+		if err := newTree.Destroy(); err != nil && retErr == nil {
+			retErr = err
 		}
-		node, err := tree.Get(file.Path)
+	}()
+	oldCommitInfo := &pfs.CommitInfo{}
+	var oldTree hashtree.HashTree
+	if commitInfo.ParentCommit != nil {
+		oldCommitInfo, err = d.inspectCommit(pachClient, commitInfo.ParentCommit, pfs.CommitState_STARTED)
 		if err != nil {
-			return nil, pfsserver.ErrFileNotFound{file}
+			return nil, nil, err
 		}
-		return nodeToFileInfoHeaderFooter(commitInfo, file.Path, node, tree, true)
 	}
-	// Handle commits to output repos
-	if commitInfo.Finished == nil {
-		return nil, fmt.Errorf("output commit %v not finished", commitInfo.Commit.ID)
+	if oldCommitInfo.Trees != nil {
+		oldTree, err = d.getMergedTree(pachClient, oldCommitInfo, root)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer func() {
+			if err := oldTree.Destroy(); err != nil && retErr == nil {
+				retErr = err
+			}
+		}()
+	} else {
+		// Trees returned by getTreeForCommit may be cached, so they aren't
+		// destroyed here
+		oldTree, err = d.getTreeForCommit(pachClient, commitInfo.ParentCommit)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	if commitInfo.Trees == nil {
-		return nil, fmt.Errorf("no file(s) found that match %v", file.Path)
+	var newFileInfos, oldFileInfos []*pfs.FileInfo
+	if err := newTree.Diff(oldTree, root, root, -1, func(path string, node *hashtree.NodeProto, isNewFile bool) error {
+		if isNewFile {
+			fi, err := nodeToFileInfoHeaderFooter(commitInfo, path, node, newTree, false)
+			if err != nil {
+				return err
+			}
+			newFileInfos = append(newFileInfos, fi)
+		} else {
+			fi, err := nodeToFileInfoHeaderFooter(oldCommitInfo, path, node, oldTree, false)
+			if err != nil {
+				return err
+			}
+			oldFileInfos = append(oldFileInfos, fi)
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, err
 	}
-	rs, err := d.getTree(pachClient, commitInfo, file.Path)
+	return newFileInfos, oldFileInfos, nil
+}
+
+// getMergedTree downloads the part of each of the output commit
+// 'commitInfo's tree chunks under 'prefix' and merges them into one tree,
+// which the caller must destroy.
+func (d *driver) getMergedTree(pachClient *client.APIClient, commitInfo *pfs.CommitInfo, prefix string) (_ hashtree.HashTree, retErr error) {
+	rs, err := d.getTrees(pachClient, commitInfo, prefix)
 	if err != nil {
 		return nil, err
 	}
 	defer func() {
 		for _, r := range rs {
-			if err := r.Close(); err != nil && retErr != nil {
+			if err := r.Close(); err != nil && retErr == nil {
 				retErr = err
 			}
 		}
 	}()
-	node, err := hashtree.Get(rs, file.Path)
-	if err != nil {
-		return nil, pfsserver.ErrFileNotFound{file}
-	}
-	return nodeToFileInfo(commitInfo, file.Path, node, true), nil
+	return hashtree.MergeDBHashTree(d.storageRoot, rs)
 }
 
-func (d *driver) listFile(pachClient *client.APIClient, file *pfs.File, full bool, history int64, f func(*pfs.FileInfo) error) (retErr error) {
-	if err := d.checkIsAuthorized(pachClient, file.Commit.Repo, auth.Scope_READER); err != nil {
+func (d *driver) deleteFile(pachClient *client.APIClient, file *pfs.File) error {
+	if err := d.checkIsAuthorized(pachClient, file.Commit.Repo, auth.Scope_WRITER); err != nil {
 		return err
 	}
+	branch := writeBranch(file.Commit)
+	if branch != "" {
+		if err := d.checkIsAuthorizedForBranch(pachClient, client.NewBranch(file.Commit.Repo.Name, branch), auth.Scope_WRITER, branchOpCommit); err != nil {
+			return err
+		}
+	}
 	commitInfo, err := d.inspectCommit(pachClient, file.Commit, pfs.CommitState_STARTED)
 	if err != nil {
 		return err
 	}
-	g, err := globlib.Compile(file.Path, '/')
+	if commitInfo.Finished != nil {
+		if branch == "" {
+			return pfsserver.ErrCommitFinished{file.Commit}
+		}
+		_, err := d.makeCommit(pachClient, "", client.NewCommit(file.Commit.Repo.Name, ""), branch, nil, nil, []string{file.Path}, []*pfs.PutFileRecords{&pfs.PutFileRecords{Tombstone: true}}, "", nil, nil)
+		return err
+	}
+	return d.upsertPutFileRecords(pachClient, file, &pfs.PutFileRecords{Tombstone: true})
+}
+
+func (d *driver) deleteAll(pachClient *client.APIClient) error {
+	// Note: d.listRepo() doesn't return the 'spec' repo, so it doesn't get
+	// deleted here. Instead, PPS is responsible for deleting and re-creating it
+	repoInfos, err := d.listRepo(pachClient, !includeAuth)
 	if err != nil {
-		// TODO this should be a MalformedGlob error like the hashtree returns
 		return err
 	}
-	// Handle commits to input repos
-	if commitInfo.Provenance == nil {
-		tree, err := d.getTreeForFile(pachClient, client.NewFile(file.Commit.Repo.Name, file.Commit.ID, ""))
-		if err != nil {
+	// Check every repo's retention lock up front, so that nothing is deleted
+	// if any repo is locked
+	for _, repoInfo := range repoInfos.RepoInfo {
+		if err := checkRepoRetention(repoInfo); err != nil {
 			return err
 		}
-		return tree.Glob(file.Path, func(rootPath string, rootNode *hashtree.NodeProto) error {
-			if rootNode.DirNode == nil {
-				if history != 0 {
-					return d.fileHistory(pachClient, client.NewFile(file.Commit.Repo.Name, file.Commit.ID, rootPath), history, f)
-				}
-				fi, err := nodeToFileInfoHeaderFooter(commitInfo, rootPath, rootNode, tree, full)
-				if err != nil {
-					return err
-				}
-				return f(fi)
+		for _, branch := range repoInfo.Branches {
+			if err := d.checkIsAuthorizedForBranch(pachClient, client.NewBranch(repoInfo.Repo.Name, branch.Name), auth.Scope_OWNER, branchOpDelete); err != nil && !auth.IsErrNotAuthorized(err) {
+				return err
 			}
-			return tree.List(rootPath, func(node *hashtree.NodeProto) error {
-				path := filepath.Join(rootPath, node.Name)
-				if g.Match(path) {
-					// Don't return the file now, it will be returned later by Glob
-					return nil
-				}
-				if history != 0 {
-					return d.fileHistory(pachClient, client.NewFile(file.Commit.Repo.Name, file.Commit.ID, path), history, f)
-				}
-				fi, err := nodeToFileInfoHeaderFooter(commitInfo, path, node, tree, full)
-				if err != nil {
-					return err
-				}
-				return f(fi)
-			})
-		})
+		}
+		// Repos are deleted with 'force' below (so that their provenance
+		// doesn't matter), but tagged commits are still kept
+		if err := d.checkNoCommitTags(pachClient.Ctx(), repoInfo.Repo); err != nil {
+			return err
+		}
 	}
-	// Handle commits to output repos
-	if commitInfo.Finished == nil {
-		return fmt.Errorf("output commit %v not finished", commitInfo.Commit.ID)
+	for _, repoInfo := range repoInfos.RepoInfo {
+		if err := d.deleteRepo(pachClient, repoInfo.Repo, true); err != nil && !auth.IsErrNotAuthorized(err) {
+			return err
+		}
 	}
-	if commitInfo.Trees == nil {
-		return nil
+	return nil
+}
+
+// checkIsAdmin returns an error if auth is active and the current user (in
+// 'pachClient') isn't a cluster admin. Otherwise, it returns the current
+// user's name ("" if auth isn't active).
+func (d *driver) checkIsAdmin(pachClient *client.APIClient, op string) (string, error) {
+	me, err := pachClient.WhoAmI(pachClient.Ctx(), &auth.WhoAmIRequest{})
+	if auth.IsErrNotActivated(err) {
+		return "", nil
 	}
-	rs, err := d.getTrees(pachClient, commitInfo, file.Path)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("error during authorization check: %v", grpcutil.ScrubGRPC(err))
 	}
-	defer func() {
-		for _, r := range rs {
-			if err := r.Close(); err != nil && retErr != nil {
-				retErr = err
-			}
-		}
-	}()
-	return hashtree.List(rs, file.Path, func(path string, node *hashtree.NodeProto) error {
-		if history != 0 {
-			return d.fileHistory(pachClient, client.NewFile(file.Commit.Repo.Name, file.Commit.ID, path), history, f)
-		}
-		return f(nodeToFileInfo(commitInfo, path, node, full))
-	})
+	if !me.IsAdmin {
+		return "", &auth.ErrNotAuthorized{Subject: me.Username, AdminOp: op}
+	}
+	return me.Username, nil
 }
 
-// fileHistory calls f with FileInfos for the file, starting with how it looked
-// at the referenced commit and then all past versions that are different.
-func (d *driver) fileHistory(pachClient *client.APIClient, file *pfs.File, history int64, f func(*pfs.FileInfo) error) error {
-	var fi *pfs.FileInfo
-	for {
-		_fi, err := d.inspectFile(pachClient, file)
+// purgedCommit is a commit whose tree is rewritten by purgeFile
+type purgedCommit struct {
+	tree *pfs.Object
+	// trees and datums replace the tree chunks and datums of an output commit
+	trees     []*pfs.Object
+	datums    *pfs.Object
+	sizeBytes uint64
+	// numChildren is the number of children the commit had when its tree was
+	// rewritten, to detect commits made on top of it while purging
+	numChildren int
+}
+
+// purgeFile removes 'filePath' (and everything under it, if it's a directory)
+// from every commit in 'repo' by rewriting the trees of the commits that
+// contain it. Downstream commits that were derived from those commits are
+// deleted, so that their pipelines reprocess the (purged) heads of their
+// inputs. If 'repo' is a pipeline's output repo, the datums whose output
+// contained 'filePath' are reprocessed by the pipeline's next job. The purged
+// data remains in object storage until GarbageCollect removes the objects
+// that no tree or datum refers to anymore.
+func (d *driver) purgeFile(pachClient *client.APIClient, repo *pfs.Repo, filePath string) (*pfs.PurgeRecord, error) {
+	ctx := pachClient.Ctx()
+	principal, err := d.checkIsAdmin(pachClient, "PurgeFile")
+	if err != nil {
+		return nil, err
+	}
+	filePath = path.Join("/", filePath)
+	if filePath == "/" {
+		return nil, fmt.Errorf("cannot purge the root directory")
+	}
+	repoInfo := &pfs.RepoInfo{}
+	if err := d.repos.ReadOnly(ctx).Get(repo.Name, repoInfo); err != nil {
+		return nil, err
+	}
+
+	// Rewrite the trees of the commits that contain 'filePath'. Commits can't
+	// be finished while this is in progress, or they could inherit the path
+	// from a commit that has already been read.
+	var commitInfos []*pfs.CommitInfo
+	commitInfo := &pfs.CommitInfo{}
+	if err := d.commits(repo.Name).ReadOnly(ctx).List(commitInfo, col.DefaultOptions, func(string) error {
+		commitInfos = append(commitInfos, proto.Clone(commitInfo).(*pfs.CommitInfo))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	purged := make(map[string]*purgedCommit)
+	purgedVersions := make(map[string]bool)
+	// droppedDatums records, for each datum of an output commit that has been
+	// checked, whether its output contains 'filePath'
+	droppedDatums := make(map[string]bool)
+	var purgedBytes uint64
+	for _, commitInfo := range commitInfos {
+		if commitInfo.Finished == nil {
+			return nil, fmt.Errorf("cannot purge files from repo \"%s\" while commit %s is open", repo.Name, commitInfo.Commit.ID)
+		}
+		purgedCommit, node, err := d.purgeCommitTree(pachClient, repoInfo, commitInfo, filePath, droppedDatums)
 		if err != nil {
-			if _, ok := err.(pfsserver.ErrFileNotFound); ok {
-				return f(fi)
+			return nil, err
+		}
+		if purgedCommit == nil {
+			continue
+		}
+		// Most versions of the path are inherited from a parent commit, so
+		// only count each one once
+		if !purgedVersions[string(node.Hash)] {
+			purgedVersions[string(node.Hash)] = true
+			purgedBytes += uint64(node.SubtreeSize)
+		}
+		purged[commitInfo.Commit.ID] = purgedCommit
+	}
+	if len(purged) == 0 {
+		return nil, fmt.Errorf("\"%s\" isn't in any commit in repo \"%s\"", filePath, repo.Name)
+	}
+	purgedIDs := make([]string, 0, len(purged))
+	for id := range purged {
+		purgedIDs = append(purgedIDs, id)
+	}
+	sort.Strings(purgedIDs)
+
+	// The new purge record follows the latest existing one. If another purge
+	// races with this one, creating the record below fails.
+	var previous *pfs.PurgeRecord
+	purgeRecord := &pfs.PurgeRecord{}
+	if err := d.purgeRecords.ReadOnly(ctx).List(purgeRecord, col.DefaultOptions, func(string) error {
+		if previous == nil || purgeRecord.Index > previous.Index {
+			previous = proto.Clone(purgeRecord).(*pfs.PurgeRecord)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	pathSum := sha256.Sum256([]byte(filePath))
+	record := &pfs.PurgeRecord{
+		Repo:        repo,
+		PathSha256:  hex.EncodeToString(pathSum[:]),
+		PurgedBytes: purgedBytes,
+		Principal:   principal,
+		Purged:      now(),
+	}
+	if previous != nil {
+		record.Index = previous.Index + 1
+		record.PreviousHash = previous.Hash
+	}
+
+	if _, err := col.NewSTM(ctx, d.etcdClient, func(stm col.STM) error {
+		record.RewrittenCommits = nil
+		record.DeletedCommits = nil
+		commits := d.commits(repo.Name).ReadWrite(stm)
+		rewritten := make(map[string]*pfs.CommitInfo)
+		deleted := make(map[string]*pfs.CommitInfo)
+		affectedRepos := make(map[string]struct{})
+		for _, id := range purgedIDs {
+			commitInfo := &pfs.CommitInfo{}
+			if err := commits.Get(id, commitInfo); err != nil {
+				return err
 			}
+			rewritten[id] = commitInfo
+		}
+		if err := d.checkIsAuthorizedToRewrite(pachClient, stm, rewritten, map[string]struct{}{repo.Name: {}}); err != nil {
 			return err
 		}
-		if fi != nil && bytes.Compare(fi.Hash, _fi.Hash) != 0 {
-			if err := f(fi); err != nil {
+		for _, id := range purgedIDs {
+			commitInfo := rewritten[id]
+			if len(commitInfo.ChildCommits) != purged[id].numChildren {
+				return fmt.Errorf("commit %s/%s was extended while purging; retry the purge", repo.Name, id)
+			}
+			if commitInfo.Trees != nil {
+				commitInfo.Trees = purged[id].trees
+				commitInfo.Datums = purged[id].datums
+			} else {
+				commitInfo.Tree = purged[id].tree
+			}
+			commitInfo.SizeBytes = purged[id].sizeBytes
+			if err := commits.Put(id, commitInfo); err != nil {
 				return err
 			}
-			if history > 0 {
-				history--
-				if history == 0 {
-					return nil
-				}
+			record.RewrittenCommits = append(record.RewrittenCommits, commitInfo.Commit)
+			if err := d.deleteSubvenance(stm, commitInfo, deleted, affectedRepos); err != nil {
+				return err
+			}
+		}
+		if err := d.checkIsAuthorizedToRewrite(pachClient, stm, deleted, affectedRepos); err != nil {
+			return err
+		}
+		repoInfo := &pfs.RepoInfo{}
+		if err := d.repos.ReadWrite(stm).Update(repo.Name, repoInfo, func() error {
+			if purgedBytes > repoInfo.SizeBytes {
+				repoInfo.SizeBytes = 0
+			} else {
+				repoInfo.SizeBytes -= purgedBytes
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if len(deleted) > 0 {
+			if err := d.repairCommitGraph(stm, deleted, affectedRepos); err != nil {
+				return err
 			}
+			for _, commitInfo := range deleted {
+				record.DeletedCommits = append(record.DeletedCommits, commitInfo.Commit)
+			}
+			sort.Slice(record.DeletedCommits, func(i, j int) bool {
+				return record.DeletedCommits[i].ID < record.DeletedCommits[j].ID
+			})
 		}
-		fi = _fi
-		ci, err := d.inspectCommit(pachClient, file.Commit, pfs.CommitState_STARTED)
+		var err error
+		record.Hash, err = pfsserver.HashPurgeRecord(record)
 		if err != nil {
 			return err
 		}
-		if ci.ParentCommit == nil {
-			return f(fi)
-		}
-		file.Commit = ci.ParentCommit
+		return d.purgeRecords.ReadWrite(stm).Create(pfsdb.PurgeRecordKey(record.Index), record)
+	}); err != nil {
+		return nil, err
 	}
-}
 
-func (d *driver) walkFile(pachClient *client.APIClient, file *pfs.File, f func(*pfs.FileInfo) error) (retErr error) {
-	if err := d.checkIsAuthorized(pachClient, file.Commit.Repo, auth.Scope_READER); err != nil {
-		return err
+	// Delete the tags of the datums whose output contained 'filePath', so that
+	// the pipeline's next job reprocesses them instead of reusing their output
+	var tags []*pfs.Tag
+	for datum, dropped := range droppedDatums {
+		if dropped {
+			tags = append(tags, client.NewTag(datum))
+		}
 	}
-	commitInfo, err := d.inspectCommit(pachClient, file.Commit, pfs.CommitState_STARTED)
-	if err != nil {
-		return err
+	if len(tags) > 0 {
+		if _, err := pachClient.ObjectAPIClient.DeleteTags(ctx, &pfs.DeleteTagsRequest{Tags: tags}); err != nil {
+			return nil, err
+		}
 	}
-	// Handle commits to input repos
-	if commitInfo.Provenance == nil {
-		tree, err := d.getTreeForFile(pachClient, client.NewFile(file.Commit.Repo.Name, file.Commit.ID, file.Path))
+	return record, nil
+}
+
+// purgeCommitTree rewrites the tree of 'commitInfo' without 'filePath', and
+// returns the rewritten commit along with the purged node. If the commit
+// doesn't contain 'filePath', it returns nil. 'droppedDatums' is updated with
+// the datums of output commits whose output contains 'filePath'.
+func (d *driver) purgeCommitTree(pachClient *client.APIClient, repoInfo *pfs.RepoInfo, commitInfo *pfs.CommitInfo, filePath string, droppedDatums map[string]bool) (_ *purgedCommit, _ *hashtree.NodeProto, retErr error) {
+	var tree hashtree.HashTree
+	if commitInfo.Trees != nil {
+		// Output commits' trees are sharded by path, so they're merged into one
+		// tree to be rewritten and then split up the same way
+		mergedTree, err := d.getMergedTree(pachClient, commitInfo, "")
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		return tree.Walk(file.Path, func(path string, node *hashtree.NodeProto) error {
-			fi, err := nodeToFileInfoHeaderFooter(commitInfo, path, node, tree, false)
-			if err != nil {
-				return err
-			}
-			return f(fi)
-		})
-	}
-	// Handle commits to output repos
-	if commitInfo.Finished == nil {
-		return fmt.Errorf("output commit %v not finished", commitInfo.Commit.ID)
-	}
-	if commitInfo.Trees == nil {
-		return nil
-	}
-	rs, err := d.getTrees(pachClient, commitInfo, file.Path)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		for _, r := range rs {
-			if err := r.Close(); err != nil && retErr != nil {
+		defer func() {
+			if err := mergedTree.Destroy(); err != nil && retErr == nil {
 				retErr = err
 			}
+		}()
+		tree = mergedTree
+	} else if commitInfo.Tree != nil {
+		var err error
+		tree, err = d.getTreeForCommit(pachClient, commitInfo.Commit)
+		if err != nil {
+			return nil, nil, err
 		}
-	}()
-	return hashtree.Walk(rs, file.Path, func(path string, node *hashtree.NodeProto) error {
-		return f(nodeToFileInfo(commitInfo, path, node, false))
-	})
-}
-
-func (d *driver) globFile(pachClient *client.APIClient, commit *pfs.Commit, pattern string, f func(*pfs.FileInfo) error) (retErr error) {
-	if err := d.checkIsAuthorized(pachClient, commit.Repo, auth.Scope_READER); err != nil {
-		return err
+	} else {
+		return nil, nil, nil
 	}
-	commitInfo, err := d.inspectCommit(pachClient, commit, pfs.CommitState_STARTED)
+	node, err := tree.Get(filePath)
 	if err != nil {
-		return err
-	}
-	// Handle commits to input repos
-	if commitInfo.Provenance == nil {
-		tree, err := d.getTreeForFile(pachClient, client.NewFile(commit.Repo.Name, commit.ID, ""))
-		if err != nil {
-			return err
+		if hashtree.Code(err) == hashtree.PathNotFound {
+			return nil, nil, nil
 		}
-		return tree.Glob(pattern, func(path string, node *hashtree.NodeProto) error {
-			fi, err := nodeToFileInfoHeaderFooter(commitInfo, path, node, tree, false)
-			if err != nil {
-				return err
-			}
-			return f(fi)
-		})
+		return nil, nil, err
 	}
-	// Handle commits to output repos
-	if commitInfo.Finished == nil {
-		return fmt.Errorf("output commit %v not finished", commitInfo.Commit.ID)
+	if err := checkCommitRetention(repoInfo, commitInfo); err != nil {
+		return nil, nil, err
 	}
-	if commitInfo.Trees == nil {
-		return nil
+	purgedTree, err := tree.Copy()
+	if err != nil {
+		return nil, nil, err
 	}
-	var rs []io.ReadCloser
-	// Handles the case when looking for a specific file/directory
-	if !hashtree.IsGlob(pattern) {
-		rs, err = d.getTree(pachClient, commitInfo, pattern)
-	} else {
-		rs, err = d.getTrees(pachClient, commitInfo, pattern)
+	defer purgedTree.Destroy()
+	if err := purgedTree.DeleteFile(filePath); err != nil {
+		return nil, nil, err
 	}
-	if err != nil {
-		return err
+	if err := purgedTree.Hash(); err != nil {
+		return nil, nil, err
 	}
-	defer func() {
-		for _, r := range rs {
-			if err := r.Close(); err != nil && retErr != nil {
-				retErr = err
-			}
-		}
-	}()
-	return hashtree.Glob(rs, pattern, func(rootPath string, rootNode *hashtree.NodeProto) error {
-		return f(nodeToFileInfo(commitInfo, rootPath, rootNode, false))
-	})
-}
-
-func (d *driver) diffFile(pachClient *client.APIClient, newFile *pfs.File, oldFile *pfs.File, shallow bool) ([]*pfs.FileInfo, []*pfs.FileInfo, error) {
-	// Do READER authorization check for both newFile and oldFile
-	if oldFile != nil && oldFile.Commit != nil {
-		if err := d.checkIsAuthorized(pachClient, oldFile.Commit.Repo, auth.Scope_READER); err != nil {
+	result := &purgedCommit{
+		sizeBytes:   commitInfo.SizeBytes - uint64(node.SubtreeSize),
+		numChildren: len(commitInfo.ChildCommits),
+	}
+	if commitInfo.Trees == nil {
+		result.tree, err = hashtree.PutHashTree(pachClient, purgedTree)
+		if err != nil {
 			return nil, nil, err
 		}
+		return result, node, nil
 	}
-	if newFile != nil && newFile.Commit != nil {
-		if err := d.checkIsAuthorized(pachClient, newFile.Commit.Repo, auth.Scope_READER); err != nil {
+	numTrees := int64(len(commitInfo.Trees))
+	for i := int64(0); i < numTrees; i++ {
+		chunk, err := d.putTreeChunk(pachClient, purgedTree, hashtree.NewFilter(numTrees, i))
+		if err != nil {
 			return nil, nil, err
 		}
+		result.trees = append(result.trees, chunk)
 	}
-	newTree, err := d.getTreeForFile(pachClient, newFile)
+	result.datums, err = d.purgeDatums(pachClient, commitInfo.Datums, filePath, droppedDatums)
 	if err != nil {
 		return nil, nil, err
 	}
-	newCommitInfo, err := d.inspectCommit(pachClient, newFile.Commit, pfs.CommitState_STARTED)
+	return result, node, nil
+}
+
+// putTreeChunk uploads the nodes of 'tree' that pass 'filter' as one chunk of
+// an output commit's tree, along with the chunk's index
+func (d *driver) putTreeChunk(pachClient *client.APIClient, tree hashtree.HashTree, filter func(k []byte) (bool, error)) (_ *pfs.Object, retErr error) {
+	objW, err := pachClient.PutObjectAsync(nil)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	// if oldFile is new we use the parent of newFile
-	if oldFile == nil {
-		oldFile = &pfs.File{}
-		// ParentCommit may be nil, that's fine because getTreeForCommit
-		// handles nil
-		oldFile.Commit = newCommitInfo.ParentCommit
-		oldFile.Path = newFile.Path
+	w := hashtree.NewWriter(objW)
+	if err := hashtree.WriteDBHashTree(tree, w, filter); err != nil {
+		objW.Close()
+		return nil, err
 	}
-	oldCommitInfo, err := d.inspectCommit(pachClient, oldFile.Commit, pfs.CommitState_STARTED)
+	if err := objW.Close(); err != nil {
+		return nil, err
+	}
+	chunk, err := objW.Object()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	oldTree, err := d.getTreeForFile(pachClient, oldFile)
+	idx, err := w.Index()
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	var newFileInfos []*pfs.FileInfo
-	var oldFileInfos []*pfs.FileInfo
-	recursiveDepth := -1
-	if shallow {
-		recursiveDepth = 1
+	info, err := pachClient.InspectObject(chunk.Hash)
+	if err != nil {
+		return nil, err
 	}
-	if err := newTree.Diff(oldTree, newFile.Path, oldFile.Path, int64(recursiveDepth), func(path string, node *hashtree.NodeProto, isNewFile bool) error {
-		if isNewFile {
-			fi, err := nodeToFileInfoHeaderFooter(newCommitInfo, path, node, newTree, false)
-			if err != nil {
-				return err
-			}
-			newFileInfos = append(newFileInfos, fi)
-		} else {
-			fi, err := nodeToFileInfoHeaderFooter(oldCommitInfo, path, node, oldTree, false)
-			if err != nil {
-				return err
-			}
-			oldFileInfos = append(oldFileInfos, fi)
+	path, err := obj.BlockPathFromEnv(info.BlockRef.Block)
+	if err != nil {
+		return nil, err
+	}
+	objClient, err := obj.NewClientFromEnv(pachClient.Ctx(), d.storageRoot)
+	if err != nil {
+		return nil, err
+	}
+	idxW, err := objClient.Writer(path + hashtree.IndexPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := idxW.Close(); err != nil && retErr == nil {
+			retErr = err
 		}
-		return nil
-	}); err != nil {
-		return nil, nil, err
+	}()
+	if _, err := idxW.Write(idx); err != nil {
+		return nil, err
 	}
-	return newFileInfos, oldFileInfos, nil
+	return chunk, nil
 }
 
-func (d *driver) deleteFile(pachClient *client.APIClient, file *pfs.File) error {
-	if err := d.checkIsAuthorized(pachClient, file.Commit.Repo, auth.Scope_WRITER); err != nil {
-		return err
+// purgeDatums returns the datums object 'datums' without the datums whose
+// output contains 'filePath', so that the pipeline's next job reprocesses them
+// rather than skipping them. The datums' tags are looked up in
+// 'droppedDatums' before they're read, and the result is recorded there.
+func (d *driver) purgeDatums(pachClient *client.APIClient, datums *pfs.Object, filePath string, droppedDatums map[string]bool) (_ *pfs.Object, retErr error) {
+	if datums == nil {
+		return nil, nil
 	}
-	branch := ""
-	if !uuid.IsUUIDWithoutDashes(file.Commit.ID) {
-		branch = file.Commit.ID
-	}
-	commitInfo, err := d.inspectCommit(pachClient, file.Commit, pfs.CommitState_STARTED)
+	r, err := pachClient.GetObjectReader(datums.Hash)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if commitInfo.Finished != nil {
-		if branch == "" {
-			return pfsserver.ErrCommitFinished{file.Commit}
+	defer func() {
+		if err := r.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	pbr := pbutil.NewReader(r)
+	buf := &bytes.Buffer{}
+	pbw := pbutil.NewWriter(buf)
+	var changed bool
+	for {
+		datumBytes, err := pbr.ReadBytes()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		datum := string(datumBytes)
+		dropped, ok := droppedDatums[datum]
+		if !ok {
+			if dropped, err = d.datumContains(pachClient, datum, filePath); err != nil {
+				return nil, err
+			}
+			droppedDatums[datum] = dropped
+		}
+		if dropped {
+			changed = true
+			continue
+		}
+		if _, err := pbw.WriteBytes(datumBytes); err != nil {
+			return nil, err
 		}
-		_, err := d.makeCommit(pachClient, "", client.NewCommit(file.Commit.Repo.Name, ""), branch, nil, nil, []string{file.Path}, []*pfs.PutFileRecords{&pfs.PutFileRecords{Tombstone: true}}, "")
-		return err
 	}
-	return d.upsertPutFileRecords(pachClient, file, &pfs.PutFileRecords{Tombstone: true})
+	if !changed {
+		return datums, nil
+	}
+	result, _, err := pachClient.PutObject(buf)
+	return result, err
 }
 
-func (d *driver) deleteAll(pachClient *client.APIClient) error {
-	// Note: d.listRepo() doesn't return the 'spec' repo, so it doesn't get
-	// deleted here. Instead, PPS is responsible for deleting and re-creating it
-	repoInfos, err := d.listRepo(pachClient, !includeAuth)
+// datumContains returns true if the output of the datum with the tag 'datum'
+// contains 'filePath'
+func (d *driver) datumContains(pachClient *client.APIClient, datum string, filePath string) (_ bool, retErr error) {
+	r, err := pachClient.GetTagReader(datum)
 	if err != nil {
-		return err
+		return false, err
 	}
-	for _, repoInfo := range repoInfos.RepoInfo {
-		if err := d.deleteRepo(pachClient, repoInfo.Repo, true); err != nil && !auth.IsErrNotAuthorized(err) {
-			return err
+	defer func() {
+		if err := r.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	if _, err := hashtree.Get([]io.ReadCloser{r}, filePath); err != nil {
+		if hashtree.Code(err) == hashtree.PathNotFound {
+			return false, nil
 		}
+		return false, err
 	}
-	return nil
+	return true, nil
+}
+
+func (d *driver) listPurgeRecords(pachClient *client.APIClient) ([]*pfs.PurgeRecord, error) {
+	if _, err := d.checkIsAdmin(pachClient, "ListPurgeRecords"); err != nil {
+		return nil, err
+	}
+	var result []*pfs.PurgeRecord
+	purgeRecord := &pfs.PurgeRecord{}
+	if err := d.purgeRecords.ReadOnly(pachClient.Ctx()).List(purgeRecord, col.DefaultOptions, func(string) error {
+		result = append(result, proto.Clone(purgeRecord).(*pfs.PurgeRecord))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Index < result[j].Index })
+	return result, nil
 }
 
 // Put the tree into the blob store
@@ -3023,7 +6092,26 @@ func (d *driver) upsertPutFileRecords(pachClient *client.APIClient, file *pfs.Fi
 				existingRecords.Tombstone = true
 				existingRecords.Records = nil
 			}
+			existingRecords.SymlinkTarget = newRecords.SymlinkTarget
+			if newRecords.Tombstone {
+				existingRecords.Metadata = nil
+			}
+			for k, v := range newRecords.Metadata {
+				if existingRecords.Metadata == nil {
+					existingRecords.Metadata = make(map[string]string)
+				}
+				existingRecords.Metadata[k] = v
+			}
 			existingRecords.Split = newRecords.Split
+			// The checksums of several writes appended to each other aren't
+			// known
+			if len(existingRecords.Records) == 0 {
+				existingRecords.Sha256 = newRecords.Sha256
+				existingRecords.Md5 = newRecords.Md5
+			} else {
+				existingRecords.Sha256 = nil
+				existingRecords.Md5 = nil
+			}
 			existingRecords.Records = append(existingRecords.Records, newRecords.Records...)
 			existingRecords.Header = newRecords.Header
 			existingRecords.Footer = newRecords.Footer
@@ -3045,11 +6133,30 @@ func (d *driver) applyWrite(key string, records *pfs.PutFileRecords, tree hashtr
 		if err := tree.DeleteFile(key); err != nil {
 			return err
 		}
+	} else if node, err := tree.Get(key); err == nil && node.SymlinkNode != nil {
+		// writing to a symlink replaces it
+		if err := tree.DeleteFile(key); err != nil {
+			return err
+		}
+	}
+	if records.SymlinkTarget != "" {
+		return tree.PutSymlink(key, records.SymlinkTarget)
 	}
 	if !records.Split {
 		if len(records.Records) == 0 {
+			// A put-file with no data can still set the metadata of an
+			// existing file
+			if len(records.Metadata) > 0 {
+				if err := tree.PutFileMetadata(key, records.Metadata); err != nil && hashtree.Code(err) != hashtree.PathNotFound {
+					return err
+				}
+			}
 			return nil
 		}
+		// The records' checksums are only the file's checksums if the records
+		// are all of its data
+		_, err := tree.Get(key)
+		isNewFile := records.Tombstone || hashtree.Code(err) == hashtree.PathNotFound
 		for _, record := range records.Records {
 			sizeMap[record.ObjectHash] = record.SizeBytes
 			if record.OverwriteIndex != nil {
@@ -3072,6 +6179,16 @@ func (d *driver) applyWrite(key string, records *pfs.PutFileRecords, tree hashtr
 				}
 			}
 		}
+		if isNewFile && len(records.Sha256) > 0 {
+			if err := tree.PutFileChecksums(key, records.Sha256, records.Md5); err != nil {
+				return err
+			}
+		}
+		if len(records.Metadata) > 0 {
+			if err := tree.PutFileMetadata(key, records.Metadata); err != nil {
+				return err
+			}
+		}
 	} else {
 		nodes, err := tree.ListAll(key)
 		if err != nil && hashtree.Code(err) != hashtree.PathNotFound {
@@ -3109,14 +6226,25 @@ func (d *driver) applyWrite(key string, records *pfs.PutFileRecords, tree hashtr
 
 		// Put individual objects into hashtree
 		for i, record := range records.Records {
+			filePath := path.Join(key, fmt.Sprintf(splitSuffixFmt, i+int(indexOffset)))
 			if records.Header != nil || records.Footer != nil {
 				if err := tree.PutFileHeaderFooter(
-					path.Join(key, fmt.Sprintf(splitSuffixFmt, i+int(indexOffset))),
-					[]*pfs.Object{{Hash: record.ObjectHash}}, record.SizeBytes); err != nil {
+					filePath, []*pfs.Object{{Hash: record.ObjectHash}}, record.SizeBytes); err != nil {
 					return err
 				}
 			} else {
-				if err := tree.PutFile(path.Join(key, fmt.Sprintf(splitSuffixFmt, i+int(indexOffset))), []*pfs.Object{{Hash: record.ObjectHash}}, record.SizeBytes); err != nil {
+				if err := tree.PutFile(filePath, []*pfs.Object{{Hash: record.ObjectHash}}, record.SizeBytes); err != nil {
+					return err
+				}
+			}
+			if len(record.Sha256) > 0 {
+				if err := tree.PutFileChecksums(filePath, record.Sha256, record.Md5); err != nil {
+					return err
+				}
+			}
+			// Metadata applies to each of the files that the data is split into
+			if len(records.Metadata) > 0 {
+				if err := tree.PutFileMetadata(filePath, records.Metadata); err != nil {
 					return err
 				}
 			}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"path"
+	"time"
+
+	"github.com/pachyderm/pachyderm/src/client/pfs"
+	"github.com/pachyderm/pachyderm/src/server/pkg/backoff"
+	col "github.com/pachyderm/pachyderm/src/server/pkg/collection"
+	"github.com/pachyderm/pachyderm/src/server/pkg/dlock"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	masterLockPath = "_master_lock"
+	// pruneInterval is how often the PFS master applies retention policies
+	pruneInterval = 10 * time.Minute
+	// prunedCommitTTL is how long the records of pruned commits are kept
+	prunedCommitTTL = 30 * 24 * time.Hour
+)
+
+// The master process runs the background tasks that only one pachd should run
+// at a time. Currently, that's pruning commits according to repos' and
+// branches' retention policies.
+func (d *driver) master() {
+	masterLock := dlock.NewDLock(d.etcdClient, path.Join(d.prefix, masterLockPath))
+	backoff.RetryNotify(func() error {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ctx, err := masterLock.Lock(ctx)
+		if err != nil {
+			return err
+		}
+		defer masterLock.Unlock(ctx)
+
+		log.Infof("Launching PFS master process")
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		for {
+			if err := d.pruneAll(ctx); err != nil {
+				return err
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}, backoff.NewInfiniteBackOff(), func(err error, d time.Duration) error {
+		log.Errorf("master: error running the PFS master process: %v; retrying in %v", err, d)
+		return nil
+	})
+}
+
+// pruneAll applies the retention policies of every repo. Failing to prune
+// one repo (e.g. because it's being written to concurrently) doesn't prevent
+// the others from being pruned; it will be retried in the next round.
+func (d *driver) pruneAll(ctx context.Context) error {
+	var repos []*pfs.Repo
+	repoInfo := &pfs.RepoInfo{}
+	if err := d.repos.ReadOnly(ctx).List(repoInfo, col.DefaultOptions, func(string) error {
+		repos = append(repos, repoInfo.Repo)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, repo := range repos {
+		if _, err := d.pruneRepo(ctx, nil, repo); err != nil {
+			log.Errorf("master: error pruning repo \"%s\": %v", repo.Name, err)
+		}
+	}
+	return nil
+}
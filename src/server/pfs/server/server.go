@@ -24,8 +24,21 @@ type BlockAPIServer interface {
 	pfsclient.ObjectAPIServer
 }
 
-// NewAPIServer creates an APIServer.
+// NewAPIServer creates an APIServer, and starts the PFS master process (which
+// only runs in one pachd at a time).
 func NewAPIServer(address string, etcdAddresses []string, etcdPrefix string, treeCache *hashtree.Cache, storageRoot string, memoryRequest int64) (APIServer, error) {
+	apiServer, err := newAPIServer(address, etcdAddresses, etcdPrefix, treeCache, storageRoot, memoryRequest)
+	if err != nil {
+		return nil, err
+	}
+	go apiServer.driver.master()
+	return apiServer, nil
+}
+
+// NewSidecarAPIServer creates an APIServer that is meant to be run as a
+// worker sidecar. Unlike NewAPIServer, it doesn't start the PFS master
+// process.
+func NewSidecarAPIServer(address string, etcdAddresses []string, etcdPrefix string, treeCache *hashtree.Cache, storageRoot string, memoryRequest int64) (APIServer, error) {
 	return newAPIServer(address, etcdAddresses, etcdPrefix, treeCache, storageRoot, memoryRequest)
 }
 
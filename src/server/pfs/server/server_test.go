@@ -3,6 +3,9 @@ package server
 import (
 	"bufio"
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -22,7 +25,9 @@ import (
 	pclient "github.com/pachyderm/pachyderm/src/client"
 	"github.com/pachyderm/pachyderm/src/client/pfs"
 	"github.com/pachyderm/pachyderm/src/client/pkg/require"
+	pfsserver "github.com/pachyderm/pachyderm/src/server/pfs"
 	"github.com/pachyderm/pachyderm/src/server/pkg/ancestry"
+	"github.com/pachyderm/pachyderm/src/server/pkg/errutil"
 	"github.com/pachyderm/pachyderm/src/server/pkg/hashtree"
 	"github.com/pachyderm/pachyderm/src/server/pkg/obj"
 	"github.com/pachyderm/pachyderm/src/server/pkg/sql"
@@ -588,6 +593,410 @@ func TestDeleteCommitFinished(t *testing.T) {
 	require.Equal(t, 0, int(repoInfo.SizeBytes))
 }
 
+func TestSquashCommit(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+
+	var commits []*pfs.Commit
+	for i := 0; i < 4; i++ {
+		commit, err := client.StartCommit(repo, "master")
+		require.NoError(t, err)
+		_, err = client.PutFile(repo, commit.ID, fmt.Sprintf("file%d", i), strings.NewReader("foo\n"))
+		require.NoError(t, err)
+		require.NoError(t, client.FinishCommit(repo, commit.ID))
+		commits = append(commits, commit)
+	}
+
+	// Squash commits 1-3 into commit 3
+	require.NoError(t, client.SquashCommit(repo, commits[1].ID, "master", false))
+	_, err := client.InspectCommit(repo, commits[1].ID)
+	require.YesError(t, err)
+	_, err = client.InspectCommit(repo, commits[2].ID)
+	require.YesError(t, err)
+
+	// commit 3 is now the child of commit 0, and its contents are unchanged
+	commitInfo, err := client.InspectCommit(repo, "master")
+	require.NoError(t, err)
+	require.Equal(t, commits[3].ID, commitInfo.Commit.ID)
+	require.Equal(t, commits[0].ID, commitInfo.ParentCommit.ID)
+	commitInfo, err = client.InspectCommit(repo, commits[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(commitInfo.ChildCommits))
+	require.Equal(t, commits[3].ID, commitInfo.ChildCommits[0].ID)
+	fileInfos, err := client.ListFile(repo, "master", "")
+	require.NoError(t, err)
+	require.Equal(t, 4, len(fileInfos))
+	commitInfos, err := client.ListCommit(repo, "master", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(commitInfos))
+
+	// commit 3 isn't an ancestor of commit 0
+	require.YesError(t, client.SquashCommit(repo, commits[3].ID, commits[0].ID, false))
+}
+
+func TestSquashCommitDownstream(t *testing.T) {
+	client := GetPachClient(t)
+
+	require.NoError(t, client.CreateRepo("in"))
+	require.NoError(t, client.CreateRepo("out"))
+	require.NoError(t, client.CreateBranch("out", "master", "", []*pfs.Branch{pclient.NewBranch("in", "master")}))
+
+	var commits []*pfs.Commit
+	for i := 0; i < 3; i++ {
+		commit, err := client.StartCommit("in", "master")
+		require.NoError(t, err)
+		_, err = client.PutFile("in", commit.ID, fmt.Sprintf("file%d", i), strings.NewReader("foo\n"))
+		require.NoError(t, err)
+		require.NoError(t, client.FinishCommit("in", commit.ID))
+		commits = append(commits, commit)
+		require.NoError(t, client.FinishCommit("out", "master"))
+	}
+
+	// Output commits were derived from commits 0 and 1, so squashing them
+	// requires --force
+	require.YesError(t, client.SquashCommit("in", commits[0].ID, commits[2].ID, false))
+	commitInfos, err := client.ListCommit("out", "master", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(commitInfos))
+
+	require.NoError(t, client.SquashCommit("in", commits[0].ID, commits[2].ID, true))
+	commitInfos, err = client.ListCommit("in", "master", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(commitInfos))
+	require.Nil(t, commitInfos[0].ParentCommit)
+
+	// Only the output commit derived from commit 2 remains
+	commitInfos, err = client.ListCommit("out", "master", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(commitInfos))
+	require.Equal(t, commits[2].ID, commitInfos[0].Provenance[0].ID)
+	commitInfo, err := client.InspectCommit("in", commits[2].ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(commitInfo.Subvenance))
+	require.Equal(t, commitInfos[0].Commit.ID, commitInfo.Subvenance[0].Lower.ID)
+	require.Equal(t, commitInfos[0].Commit.ID, commitInfo.Subvenance[0].Upper.ID)
+}
+
+func TestMergeBranch(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+	_, err := client.PutFile(repo, "master", "base", strings.NewReader("base\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.CreateBranch(repo, "feature", "master", nil))
+
+	// "master" hasn't changed, so merging fast-forwards it
+	_, err = client.PutFile(repo, "feature", "feature1", strings.NewReader("feature1\n"))
+	require.NoError(t, err)
+	response, err := client.MergeBranch(repo, "feature", "master", pfs.MergeStrategy_FAIL, "")
+	require.NoError(t, err)
+	featureInfo, err := client.InspectCommit(repo, "feature")
+	require.NoError(t, err)
+	require.Equal(t, featureInfo.Commit.ID, response.Commit.ID)
+	masterInfo, err := client.InspectCommit(repo, "master")
+	require.NoError(t, err)
+	require.Equal(t, featureInfo.Commit.ID, masterInfo.Commit.ID)
+
+	// Merging again is a no-op
+	response, err = client.MergeBranch(repo, "feature", "master", pfs.MergeStrategy_FAIL, "")
+	require.NoError(t, err)
+	require.Equal(t, masterInfo.Commit.ID, response.Commit.ID)
+
+	// Non-conflicting changes on both branches produce a merge commit
+	_, err = client.PutFile(repo, "feature", "feature2", strings.NewReader("feature2\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.DeleteFile(repo, "feature", "base"))
+	_, err = client.PutFile(repo, "master", "master1", strings.NewReader("master1\n"))
+	require.NoError(t, err)
+	oursInfo, err := client.InspectCommit(repo, "master")
+	require.NoError(t, err)
+	response, err = client.MergeBranch(repo, "feature", "master", pfs.MergeStrategy_FAIL, "")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(response.Conflicts))
+	masterInfo, err = client.InspectCommit(repo, "master")
+	require.NoError(t, err)
+	require.Equal(t, response.Commit.ID, masterInfo.Commit.ID)
+	require.Equal(t, oursInfo.Commit.ID, masterInfo.ParentCommit.ID)
+	featureInfo, err = client.InspectCommit(repo, "feature")
+	require.NoError(t, err)
+	require.Equal(t, featureInfo.Commit.ID, masterInfo.MergeParent.ID)
+	require.Equal(t, "Merge branch 'feature' into 'master'", masterInfo.Description)
+	fileInfos, err := client.ListFile(repo, "master", "")
+	require.NoError(t, err)
+	var paths []string
+	for _, fileInfo := range fileInfos {
+		paths = append(paths, fileInfo.File.Path)
+	}
+	require.ElementsEqual(t, []string{"/feature1", "/feature2", "/master1"}, paths)
+
+	// Branches in different repos can't be merged
+	require.NoError(t, client.CreateRepo("other"))
+	_, err = client.PfsAPIClient.MergeBranch(client.Ctx(), &pfs.MergeBranchRequest{
+		From: pclient.NewBranch(repo, "feature"),
+		To:   pclient.NewBranch("other", "master"),
+	})
+	require.YesError(t, err)
+}
+
+func TestMergeBranchConflicts(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+	_, err := client.PutFile(repo, "master", "file", strings.NewReader("base\n"))
+	require.NoError(t, err)
+	_, err = client.PutFile(repo, "master", "deleted", strings.NewReader("base\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.CreateBranch(repo, "feature", "master", nil))
+	_, err = client.PutFileOverwrite(repo, "feature", "file", strings.NewReader("theirs\n"), 0)
+	require.NoError(t, err)
+	require.NoError(t, client.DeleteFile(repo, "feature", "deleted"))
+	_, err = client.PutFileOverwrite(repo, "master", "file", strings.NewReader("ours\n"), 0)
+	require.NoError(t, err)
+	_, err = client.PutFileOverwrite(repo, "master", "deleted", strings.NewReader("ours\n"), 0)
+	require.NoError(t, err)
+	oursInfo, err := client.InspectCommit(repo, "master")
+	require.NoError(t, err)
+
+	// The default strategy reports conflicts without merging
+	response, err := client.MergeBranch(repo, "feature", "master", pfs.MergeStrategy_FAIL, "")
+	require.NoError(t, err)
+	require.Nil(t, response.Commit)
+	require.Equal(t, 2, len(response.Conflicts))
+	require.Equal(t, "/deleted", response.Conflicts[0].Path)
+	require.Equal(t, pfs.ConflictType_MODIFIED_DELETED, response.Conflicts[0].Type)
+	require.Nil(t, response.Conflicts[0].Theirs)
+	require.Equal(t, "/file", response.Conflicts[1].Path)
+	require.Equal(t, pfs.ConflictType_BOTH_MODIFIED, response.Conflicts[1].Type)
+	masterInfo, err := client.InspectCommit(repo, "master")
+	require.NoError(t, err)
+	require.Equal(t, oursInfo.Commit.ID, masterInfo.Commit.ID)
+
+	checkFile := func(commitID string, path string, expected string) {
+		var buffer bytes.Buffer
+		require.NoError(t, client.GetFile(repo, commitID, path, 0, 0, &buffer))
+		require.Equal(t, expected, buffer.String())
+	}
+	checkDeleted := func(commitID string, path string) {
+		_, err := client.InspectFile(repo, commitID, path)
+		require.YesError(t, err)
+	}
+
+	response, err = client.MergeBranch(repo, "feature", "master", pfs.MergeStrategy_OURS, "")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(response.Conflicts))
+	checkFile(response.Commit.ID, "file", "ours\n")
+	checkFile(response.Commit.ID, "deleted", "ours\n")
+
+	// Each of the other strategies is tried from the same starting point
+	require.NoError(t, client.CreateBranch(repo, "master", oursInfo.Commit.ID, nil))
+	response, err = client.MergeBranch(repo, "feature", "master", pfs.MergeStrategy_CONCATENATE, "")
+	require.NoError(t, err)
+	checkFile(response.Commit.ID, "file", "ours\ntheirs\n")
+	checkFile(response.Commit.ID, "deleted", "ours\n")
+
+	require.NoError(t, client.CreateBranch(repo, "master", oursInfo.Commit.ID, nil))
+	response, err = client.MergeBranch(repo, "feature", "master", pfs.MergeStrategy_THEIRS, "")
+	require.NoError(t, err)
+	checkFile(response.Commit.ID, "file", "theirs\n")
+	checkDeleted(response.Commit.ID, "deleted")
+	mergeInfo, err := client.InspectCommit(repo, "master")
+	require.NoError(t, err)
+	require.Equal(t, oursInfo.Commit.ID, mergeInfo.ParentCommit.ID)
+
+	// Once merged, "feature"'s head is an ancestor of "master", so merging
+	// again is a no-op, and later merges don't report the same conflicts
+	response, err = client.MergeBranch(repo, "feature", "master", pfs.MergeStrategy_FAIL, "")
+	require.NoError(t, err)
+	require.Equal(t, mergeInfo.Commit.ID, response.Commit.ID)
+	_, err = client.PutFile(repo, "feature", "file2", strings.NewReader("theirs\n"))
+	require.NoError(t, err)
+	response, err = client.MergeBranch(repo, "feature", "master", pfs.MergeStrategy_FAIL, "")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(response.Conflicts))
+	checkFile(response.Commit.ID, "file", "theirs\n")
+	checkFile(response.Commit.ID, "file2", "theirs\n")
+}
+
+func TestRevertCommit(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+	_, err := client.PutFile(repo, "master", "modified", strings.NewReader("foo\n"))
+	require.NoError(t, err)
+	_, err = client.PutFile(repo, "master", "deleted", strings.NewReader("foo\n"))
+	require.NoError(t, err)
+
+	commit, err := client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = client.PutFile(repo, commit.ID, "modified", strings.NewReader("bar\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.DeleteFile(repo, commit.ID, "deleted"))
+	_, err = client.PutFile(repo, commit.ID, "dir/added", strings.NewReader("bar\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit.ID))
+	_, err = client.PutFile(repo, "master", "unrelated", strings.NewReader("baz\n"))
+	require.NoError(t, err)
+
+	revert, err := client.RevertCommit(repo, "master^", "")
+	require.NoError(t, err)
+	commitInfo, err := client.InspectCommit(repo, "master")
+	require.NoError(t, err)
+	require.Equal(t, revert.ID, commitInfo.Commit.ID)
+	require.Equal(t, fmt.Sprintf("Revert commit %s", commit.ID), commitInfo.Description)
+
+	var buffer bytes.Buffer
+	require.NoError(t, client.GetFile(repo, "master", "modified", 0, 0, &buffer))
+	require.Equal(t, "foo\n", buffer.String())
+	buffer.Reset()
+	require.NoError(t, client.GetFile(repo, "master", "deleted", 0, 0, &buffer))
+	require.Equal(t, "foo\n", buffer.String())
+	_, err = client.InspectFile(repo, "master", "dir/added")
+	require.YesError(t, err)
+	_, err = client.InspectFile(repo, "master", "unrelated")
+	require.NoError(t, err)
+
+	// The reverted commit remains in the branch's history
+	_, err = client.InspectCommit(repo, commit.ID)
+	require.NoError(t, err)
+
+	// A commit ID isn't relative to a branch, so the branch is required
+	_, err = client.RevertCommit(repo, commit.ID, "")
+	require.YesError(t, err)
+
+	// Files changed again since the reverted commit can't be reverted
+	_, err = client.PutFileOverwrite(repo, "master", "modified", strings.NewReader("qux\n"), 0)
+	require.NoError(t, err)
+	_, err = client.RevertCommit(repo, commit.ID, "master")
+	require.YesError(t, err)
+
+	// Commits can only be reverted on branches they're part of
+	require.NoError(t, client.CreateBranch(repo, "other", "", nil))
+	_, err = client.PutFile(repo, "other", "file", strings.NewReader("foo\n"))
+	require.NoError(t, err)
+	_, err = client.RevertCommit(repo, commit.ID, "other")
+	require.YesError(t, err)
+}
+
+func TestProtectedBranch(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+	_, err := client.PutFile(repo, "master", "file", strings.NewReader("foo\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.ProtectBranch(repo, "master", nil, true, true))
+	branchInfo, err := client.InspectBranch(repo, "master")
+	require.NoError(t, err)
+	require.True(t, branchInfo.Protection.NoDelete)
+	require.True(t, branchInfo.Protection.MergeOnly)
+
+	// Commits can't be made on master directly
+	_, err = client.StartCommit(repo, "master")
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsBranchProtectedErr(err))
+	_, err = client.PutFile(repo, "master", "file", strings.NewReader("bar\n"))
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsBranchProtectedErr(err))
+	require.YesError(t, client.DeleteFile(repo, "master", "file"))
+
+	// but can be merged in from other branches
+	require.NoError(t, client.CreateBranch(repo, "feature", "master", nil))
+	_, err = client.PutFile(repo, "feature", "file", strings.NewReader("bar\n"))
+	require.NoError(t, err)
+	response, err := client.MergeBranch(repo, "feature", "master", pfs.MergeStrategy_FAIL, "")
+	require.NoError(t, err)
+	require.NotNil(t, response.Commit)
+	var buffer bytes.Buffer
+	require.NoError(t, client.GetFile(repo, "master", "file", 0, 0, &buffer))
+	require.Equal(t, "foo\nbar\n", buffer.String())
+	// or promoted by pointing master at an existing commit
+	require.NoError(t, client.CreateBranch(repo, "master", "master^", nil))
+
+	// master can't be deleted until it's unprotected
+	err = client.DeleteBranch(repo, "master", false)
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsBranchProtectedErr(err))
+	require.NoError(t, client.UnprotectBranch(repo, "master"))
+	_, err = client.PutFile(repo, "master", "file", strings.NewReader("buzz\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.DeleteBranch(repo, "master", false))
+}
+
+func TestProtectedBranchHistory(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+	var commits []*pfs.Commit
+	for i := 0; i < 4; i++ {
+		commit, err := client.StartCommit(repo, "master")
+		require.NoError(t, err)
+		_, err = client.PutFile(repo, commit.ID, fmt.Sprintf("file%d", i), strings.NewReader("foo\n"))
+		require.NoError(t, err)
+		require.NoError(t, client.FinishCommit(repo, commit.ID))
+		commits = append(commits, commit)
+	}
+	// 'feature' shares master's history, so rewriting it rewrites master's
+	require.NoError(t, client.CreateBranch(repo, "feature", "master", nil))
+	require.NoError(t, client.ProtectBranch(repo, "master", nil, true, true))
+
+	// Commits can't be built on master
+	tree, err := hashtree.NewDBHashTree("")
+	require.NoError(t, err)
+	require.NoError(t, tree.Hash())
+	treeObj, err := hashtree.PutHashTree(client, tree)
+	require.NoError(t, err)
+	_, err = client.BuildCommit(repo, "master", "", treeObj.Hash)
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsBranchProtectedErr(err))
+
+	// Master's history can't be rewritten, even through another branch
+	err = client.DeleteCommit(repo, commits[3].ID)
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsBranchProtectedErr(err))
+	err = client.SquashCommit(repo, commits[1].ID, "feature", false)
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsBranchProtectedErr(err))
+	require.NoError(t, client.SetRetentionPolicy(repo, "feature", 1, 0))
+	_, err = client.PruneCommits(repo)
+	require.YesError(t, err)
+	_, err = client.PurgeFile(repo, "file0")
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsBranchProtectedErr(err))
+	commitInfos, err := client.ListCommit(repo, "master", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, 4, len(commitInfos))
+	_, err = client.InspectFile(repo, commits[0].ID, "file0")
+	require.NoError(t, err)
+
+	// but commits that are only on other branches can be
+	_, err = client.PutFile(repo, "feature", "file4", strings.NewReader("foo\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.DeleteCommit(repo, "feature"))
+
+	// The repo can't be deleted while master can't be
+	err = client.DeleteRepo(repo, false)
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsBranchProtectedErr(err))
+	_, err = client.PfsAPIClient.DeleteAll(client.Ctx(), &types.Empty{})
+	require.YesError(t, err)
+	_, err = client.InspectRepo(repo)
+	require.NoError(t, err)
+
+	require.NoError(t, client.UnprotectBranch(repo, "master"))
+	_, err = client.BuildCommit(repo, "master", "", treeObj.Hash)
+	require.NoError(t, err)
+	require.NoError(t, client.SquashCommit(repo, commits[1].ID, commits[3].ID, false))
+	_, err = client.PurgeFile(repo, "file0")
+	require.NoError(t, err)
+	require.NoError(t, client.DeleteRepo(repo, false))
+}
+
 func TestCleanPath(t *testing.T) {
 	c := GetPachClient(t)
 	repo := "TestCleanPath"
@@ -766,6 +1175,59 @@ func TestAncestrySyntax(t *testing.T) {
 	require.Equal(t, "1", buffer.String())
 }
 
+func TestTimeReference(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+
+	// Record the time after each commit is finished
+	var commits []*pfs.Commit
+	var times []time.Time
+	for i := 0; i < 3; i++ {
+		commit, err := client.StartCommit(repo, "master")
+		require.NoError(t, err)
+		_, err = client.PutFileOverwrite(repo, commit.ID, "file", strings.NewReader(fmt.Sprintf("%d", i)), 0)
+		require.NoError(t, err)
+		require.NoError(t, client.FinishCommit(repo, commit.ID))
+		commits = append(commits, commit)
+		time.Sleep(10 * time.Millisecond)
+		times = append(times, time.Now())
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i, ts := range times {
+		commitInfo, err := client.InspectCommit(repo, ancestry.AddTime("master", ts))
+		require.NoError(t, err)
+		require.Equal(t, commits[i].ID, commitInfo.Commit.ID)
+
+		var buffer bytes.Buffer
+		require.NoError(t, client.GetFile(repo, ancestry.AddTime("master", ts), "file", 0, 0, &buffer))
+		require.Equal(t, fmt.Sprintf("%d", i), buffer.String())
+	}
+	// Time references can be combined with ancestry references, and used with
+	// commit IDs
+	commitInfo, err := client.InspectCommit(repo, ancestry.AddTime("master", times[2])+"^")
+	require.NoError(t, err)
+	require.Equal(t, commits[1].ID, commitInfo.Commit.ID)
+	commitInfo, err = client.InspectCommit(repo, ancestry.AddTime(commits[2].ID, times[0]))
+	require.NoError(t, err)
+	require.Equal(t, commits[0].ID, commitInfo.Commit.ID)
+	commitInfo, err = client.InspectCommit(repo, "master@{0.seconds.ago}")
+	require.NoError(t, err)
+	require.Equal(t, commits[2].ID, commitInfo.Commit.ID)
+
+	// There are no commits before the first one
+	_, err = client.InspectCommit(repo, "master@{1.year.ago}")
+	require.YesError(t, err)
+	_, err = client.InspectCommit(repo, "master@{not a time}")
+	require.YesError(t, err)
+
+	// Time references refer to the past, so they can't be written to
+	_, err = client.PutFile(repo, ancestry.AddTime("master", times[0]), "file2", strings.NewReader("foo"))
+	require.YesError(t, err)
+}
+
 // TestProvenance implements the following DAG
 //  A ─▶ B ─▶ C ─▶ D
 //            ▲
@@ -1433,28 +1895,151 @@ func TestListFile3(t *testing.T) {
 	require.Equal(t, 2, len(fileInfos))
 }
 
-func TestPutFileTypeConflict(t *testing.T) {
-	client := GetPachClient(t)
+func TestListFilePagination(t *testing.T) {
+	c := GetPachClient(t)
 
 	repo := "test"
-	require.NoError(t, client.CreateRepo(repo))
+	require.NoError(t, c.CreateRepo(repo))
+	commit, err := c.StartCommit(repo, "master")
+	require.NoError(t, err)
+	// dir/00 is 0 bytes, dir/01 is 1 byte, etc.
+	for i := 0; i < 10; i++ {
+		_, err = c.PutFile(repo, commit.ID, fmt.Sprintf("dir/%02d", i), strings.NewReader(strings.Repeat("a", i)))
+		require.NoError(t, err)
+	}
+	_, err = c.PutFile(repo, commit.ID, "dir/sub/file", strings.NewReader("abc"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit(repo, commit.ID))
 
-	fileContent := "foo\n"
+	listAll := func(request *pfs.ListFileRequest) []string {
+		var paths []string
+		for {
+			fileInfos, nextPageToken, err := c.ListFilePage(request)
+			require.NoError(t, err)
+			require.True(t, int64(len(fileInfos)) <= request.PageSize)
+			for _, fi := range fileInfos {
+				paths = append(paths, fi.File.Path)
+			}
+			if nextPageToken == "" {
+				return paths
+			}
+			request.PageToken = nextPageToken
+		}
+	}
 
-	commit1, err := client.StartCommit(repo, "master")
+	// Pages by name
+	paths := listAll(&pfs.ListFileRequest{File: pclient.NewFile(repo, commit.ID, "dir"), PageSize: 3})
+	require.Equal(t, []string{"/dir/00", "/dir/01", "/dir/02", "/dir/03", "/dir/04", "/dir/05",
+		"/dir/06", "/dir/07", "/dir/08", "/dir/09", "/dir/sub"}, paths)
+	fileInfos, nextPageToken, err := c.ListFilePage(&pfs.ListFileRequest{File: pclient.NewFile(repo, commit.ID, "dir"), PageSize: 11})
+	require.NoError(t, err)
+	require.Equal(t, 11, len(fileInfos))
+	require.Equal(t, "", nextPageToken)
+
+	// Pages by size, largest first (ties are broken by path, in reverse)
+	paths = listAll(&pfs.ListFileRequest{
+		File:       pclient.NewFile(repo, commit.ID, "dir"),
+		PageSize:   4,
+		Sort:       pfs.ListFileSort_SIZE,
+		Descending: true,
+	})
+	require.Equal(t, []string{"/dir/09", "/dir/08", "/dir/07", "/dir/06", "/dir/05", "/dir/04",
+		"/dir/sub", "/dir/03", "/dir/02", "/dir/01", "/dir/00"}, paths)
+
+	// Filters
+	paths = listAll(&pfs.ListFileRequest{
+		File:         pclient.NewFile(repo, commit.ID, "dir"),
+		PageSize:     2,
+		MinSizeBytes: 3,
+		MaxSizeBytes: 5,
+		FileType:     pfs.FileType_FILE,
+	})
+	require.Equal(t, []string{"/dir/03", "/dir/04", "/dir/05"}, paths)
+	fileInfos, _, err = c.ListFilePage(&pfs.ListFileRequest{
+		File:     pclient.NewFile(repo, commit.ID, "dir"),
+		FileType: pfs.FileType_DIR,
+	})
 	require.NoError(t, err)
-	_, err = client.PutFile(repo, commit1.ID, "dir/1", strings.NewReader(fileContent))
+	require.Equal(t, 1, len(fileInfos))
+	require.Equal(t, "/dir/sub", fileInfos[0].File.Path)
+	committedAfter, err := types.TimestampProto(time.Now().Add(time.Hour))
 	require.NoError(t, err)
-	require.NoError(t, client.FinishCommit(repo, commit1.ID))
+	fileInfos, _, err = c.ListFilePage(&pfs.ListFileRequest{
+		File:           pclient.NewFile(repo, commit.ID, "dir"),
+		CommittedAfter: committedAfter,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 0, len(fileInfos))
 
-	commit2, err := client.StartCommit(repo, "master")
+	// Files are sorted and filtered by the commit that last changed them
+	commitInfo, err := c.InspectCommit(repo, commit.ID)
 	require.NoError(t, err)
-	_, err = client.PutFile(repo, commit2.ID, "dir", strings.NewReader(fileContent))
+	commit2, err := c.StartCommit(repo, "master")
 	require.NoError(t, err)
-	require.YesError(t, client.FinishCommit(repo, commit2.ID))
-}
-
-func TestRootDirectory(t *testing.T) {
+	_, err = c.PutFile(repo, commit2.ID, "dir/03", strings.NewReader("b"))
+	require.NoError(t, err)
+	_, err = c.PutFile(repo, commit2.ID, "dir/sub/file", strings.NewReader("b"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit(repo, commit2.ID))
+	paths = listAll(&pfs.ListFileRequest{
+		File:       pclient.NewFile(repo, commit2.ID, "dir"),
+		PageSize:   5,
+		Sort:       pfs.ListFileSort_COMMITTED,
+		Descending: true,
+	})
+	require.Equal(t, []string{"/dir/sub", "/dir/03", "/dir/09", "/dir/08", "/dir/07", "/dir/06",
+		"/dir/05", "/dir/04", "/dir/02", "/dir/01", "/dir/00"}, paths)
+	paths = listAll(&pfs.ListFileRequest{
+		File:           pclient.NewFile(repo, commit2.ID, "dir"),
+		PageSize:       5,
+		CommittedAfter: commitInfo.Finished,
+	})
+	require.Equal(t, []string{"/dir/03", "/dir/sub"}, paths)
+
+	// Globs are paged too
+	paths = listAll(&pfs.ListFileRequest{File: pclient.NewFile(repo, commit.ID, "dir/0*"), PageSize: 4})
+	require.Equal(t, 10, len(paths))
+
+	// Tokens only work with the sort order that they were issued for
+	_, nextPageToken, err = c.ListFilePage(&pfs.ListFileRequest{File: pclient.NewFile(repo, commit.ID, "dir"), PageSize: 1})
+	require.NoError(t, err)
+	_, _, err = c.ListFilePage(&pfs.ListFileRequest{
+		File:      pclient.NewFile(repo, commit.ID, "dir"),
+		PageSize:  1,
+		PageToken: nextPageToken,
+		Sort:      pfs.ListFileSort_SIZE,
+	})
+	require.YesError(t, err)
+	_, _, err = c.ListFilePage(&pfs.ListFileRequest{
+		File:     pclient.NewFile(repo, commit.ID, "dir"),
+		PageSize: 1,
+		History:  -1,
+	})
+	require.YesError(t, err)
+}
+
+func TestPutFileTypeConflict(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+
+	fileContent := "foo\n"
+
+	commit1, err := client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = client.PutFile(repo, commit1.ID, "dir/1", strings.NewReader(fileContent))
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit1.ID))
+
+	commit2, err := client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = client.PutFile(repo, commit2.ID, "dir", strings.NewReader(fileContent))
+	require.NoError(t, err)
+	require.YesError(t, client.FinishCommit(repo, commit2.ID))
+}
+
+func TestRootDirectory(t *testing.T) {
 	client := GetPachClient(t)
 
 	repo := "test"
@@ -1721,151 +2306,789 @@ func TestListCommit(t *testing.T) {
 		require.Equal(t, commitInfos[i].ParentCommit, commitInfos[i+1].Commit)
 	}
 
-	// Now list all commits up to the mid commit, excluding the mid commit
-	// itself
-	commitInfos, err = client.ListCommit(repo, "master", midCommitID, 0)
+	// Now list all commits up to the mid commit, excluding the mid commit
+	// itself
+	commitInfos, err = client.ListCommit(repo, "master", midCommitID, 0)
+	require.NoError(t, err)
+	require.Equal(t, numCommits-numCommits/2-1, len(commitInfos))
+
+	// Test that commits are sorted in newest-first order
+	for i := 0; i < len(commitInfos)-1; i++ {
+		require.Equal(t, commitInfos[i].ParentCommit, commitInfos[i+1].Commit)
+	}
+
+	// list commits by branch
+	commitInfos, err = client.ListCommit(repo, "master", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, numCommits, len(commitInfos))
+
+	// Test that commits are sorted in newest-first order
+	for i := 0; i < len(commitInfos)-1; i++ {
+		require.Equal(t, commitInfos[i].ParentCommit, commitInfos[i+1].Commit)
+	}
+}
+
+func TestOffsetRead(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "TestOffsetRead"
+	require.NoError(t, client.CreateRepo(repo))
+	commit, err := client.StartCommit(repo, "")
+	require.NoError(t, err)
+	fileData := "foo\n"
+	_, err = client.PutFile(repo, commit.ID, "foo", strings.NewReader(fileData))
+	require.NoError(t, err)
+	_, err = client.PutFile(repo, commit.ID, "foo", strings.NewReader(fileData))
+	require.NoError(t, err)
+
+	var buffer bytes.Buffer
+	require.NoError(t, client.GetFile(repo, commit.ID, "foo", int64(len(fileData)*2)+1, 0, &buffer))
+	require.Equal(t, "", buffer.String())
+
+	require.NoError(t, client.FinishCommit(repo, commit.ID))
+
+	buffer.Reset()
+	require.NoError(t, client.GetFile(repo, commit.ID, "foo", int64(len(fileData)*2)+1, 0, &buffer))
+	require.Equal(t, "", buffer.String())
+}
+
+func TestBranch2(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+
+	commit, err := client.StartCommit(repo, "")
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit.ID))
+
+	expectedBranches := []string{"branch1", "branch2", "branch3"}
+	for _, branch := range expectedBranches {
+		require.NoError(t, client.SetBranch(repo, commit.ID, branch))
+	}
+
+	branches, err := client.ListBranch(repo)
+	require.Equal(t, len(expectedBranches), len(branches))
+	for i, branch := range branches {
+		// branches should return in newest-first order
+		require.Equal(t, expectedBranches[len(branches)-i-1], branch.Name)
+		require.Equal(t, commit, branch.Head)
+	}
+
+	commit2, err := client.StartCommit(repo, "branch1")
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, "branch1"))
+
+	commit2Info, err := client.InspectCommit(repo, "branch1")
+	require.NoError(t, err)
+	require.Equal(t, commit, commit2Info.ParentCommit)
+
+	// delete the last branch
+	var lastBranch string
+	lastBranch = expectedBranches[len(expectedBranches)-1]
+	require.NoError(t, client.DeleteBranch(repo, lastBranch, false))
+	branches, err = client.ListBranch(repo)
+	require.Equal(t, 2, len(branches))
+	require.Equal(t, "branch2", branches[0].Name)
+	require.Equal(t, commit, branches[0].Head)
+	require.Equal(t, "branch1", branches[1].Name)
+	require.Equal(t, commit2, branches[1].Head)
+}
+
+func TestDeleteNonexistantBranch(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "TestDeleteNonexistantBranch"
+	require.NoError(t, client.CreateRepo(repo))
+	require.NoError(t, client.DeleteBranch(repo, "doesnt_exist", false))
+}
+
+func TestSubscribeCommit(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+
+	numCommits := 10
+
+	// create some commits that shouldn't affect the below SubscribeCommit call
+	// reproduces #2469
+	for i := 0; i < numCommits; i++ {
+		commit, err := client.StartCommit(repo, "master-v1")
+		require.NoError(t, err)
+		require.NoError(t, client.FinishCommit(repo, commit.ID))
+	}
+
+	var commits []*pfs.Commit
+	for i := 0; i < numCommits; i++ {
+		commit, err := client.StartCommit(repo, "master")
+		require.NoError(t, err)
+		require.NoError(t, client.FinishCommit(repo, commit.ID))
+		commits = append(commits, commit)
+	}
+
+	commitIter, err := client.SubscribeCommit(repo, "master", "", pfs.CommitState_STARTED)
+	require.NoError(t, err)
+	for i := 0; i < numCommits; i++ {
+		commitInfo, err := commitIter.Next()
+		require.NoError(t, err)
+		require.Equal(t, commits[i], commitInfo.Commit)
+	}
+
+	// Create another batch of commits
+	commits = nil
+	for i := 0; i < numCommits; i++ {
+		commit, err := client.StartCommit(repo, "master")
+		require.NoError(t, err)
+		require.NoError(t, client.FinishCommit(repo, "master"))
+		commits = append(commits, commit)
+	}
+
+	for i := 0; i < numCommits; i++ {
+		commitInfo, err := commitIter.Next()
+		require.NoError(t, err)
+		require.Equal(t, commits[i], commitInfo.Commit)
+	}
+
+	commitIter.Close()
+}
+
+func TestSubscribeFile(t *testing.T) {
+	c := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, c.CreateRepo(repo))
+	commit1, err := c.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = c.PutFile(repo, commit1.ID, "models/prod/a", strings.NewReader("a"))
+	require.NoError(t, err)
+	_, err = c.PutFile(repo, commit1.ID, "models/prod/b", strings.NewReader("b"))
+	require.NoError(t, err)
+	_, err = c.PutFile(repo, commit1.ID, "models/dev/c", strings.NewReader("c"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit(repo, commit1.ID))
+
+	commit2, err := c.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = c.PutFile(repo, commit2.ID, "models/prod/a", strings.NewReader("a2"))
+	require.NoError(t, err)
+	require.NoError(t, c.DeleteFile(repo, commit2.ID, "models/prod/b"))
+	_, err = c.PutFile(repo, commit2.ID, "models/prod/sub/d", strings.NewReader("d"))
+	require.NoError(t, err)
+	_, err = c.PutFile(repo, commit2.ID, "models/dev/c", strings.NewReader("c2"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit(repo, commit2.ID))
+
+	type event struct {
+		commit, path string
+		eventType    pfs.FileEventType
+	}
+	// subscribe returns the first n events under 'pattern'
+	subscribe := func(pattern, from string, n int) []event {
+		var events []event
+		require.NoError(t, c.SubscribeFile(repo, "master", pattern, from, func(e *pfs.FileEvent) error {
+			events = append(events, event{e.Commit.ID, e.File.File.Path, e.Type})
+			if e.Type == pfs.FileEventType_MODIFIED {
+				require.Equal(t, "/models/prod/a", e.OldFile.File.Path)
+				require.Equal(t, uint64(1), e.OldFile.SizeBytes)
+				require.Equal(t, uint64(2), e.File.SizeBytes)
+			}
+			if len(events) == n {
+				return errutil.ErrBreak
+			}
+			return nil
+		}))
+		return events
+	}
+	require.Equal(t, []event{
+		{commit1.ID, "/models/prod/a", pfs.FileEventType_ADDED},
+		{commit1.ID, "/models/prod/b", pfs.FileEventType_ADDED},
+		{commit2.ID, "/models/prod/a", pfs.FileEventType_MODIFIED},
+		{commit2.ID, "/models/prod/b", pfs.FileEventType_DELETED},
+		{commit2.ID, "/models/prod/sub/d", pfs.FileEventType_ADDED},
+	}, subscribe("models/prod", "", 5))
+
+	// Subscriptions can be resumed after a commit, and take globs
+	require.Equal(t, []event{
+		{commit2.ID, "/models/dev/c", pfs.FileEventType_MODIFIED},
+		{commit2.ID, "/models/prod/a", pfs.FileEventType_MODIFIED},
+	}, subscribe("/models/*/{a,c}", commit1.ID, 2))
+
+	// New commits are returned once they're finished
+	done := make(chan []event)
+	go func() {
+		done <- subscribe("/**/e", commit2.ID, 1)
+	}()
+	commit3, err := c.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = c.PutFile(repo, commit3.ID, "models/prod/e", strings.NewReader("e"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit(repo, commit3.ID))
+	select {
+	case events := <-done:
+		require.Equal(t, []event{{commit3.ID, "/models/prod/e", pfs.FileEventType_ADDED}}, events)
+	case <-time.After(time.Minute):
+		t.Fatal("timed out waiting for SubscribeFile")
+	}
+}
+
+func TestCommitLabels(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+
+	// Labels can be set when a commit is started and when it's finished
+	commit1, err := client.PfsAPIClient.StartCommit(client.Ctx(), &pfs.StartCommitRequest{
+		Parent: pclient.NewCommit(repo, ""),
+		Branch: "master",
+		Labels: map[string]string{"source": "crm", "env": "prod"},
+	})
+	require.NoError(t, err)
+	// Labels set on the open commit are kept when it's finished
+	require.NoError(t, client.SetCommitLabels(repo, commit1.ID, map[string]string{"owner": "ops"}, nil))
+	_, err = client.PfsAPIClient.FinishCommit(client.Ctx(), &pfs.FinishCommitRequest{
+		Commit: commit1,
+		Labels: map[string]string{"env": "staging", "quality": "validated"},
+	})
+	require.NoError(t, err)
+	commitInfo, err := client.InspectCommit(repo, commit1.ID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"source": "crm", "env": "staging", "quality": "validated", "owner": "ops"}, commitInfo.Labels)
+
+	commit2, err := client.PfsAPIClient.StartCommit(client.Ctx(), &pfs.StartCommitRequest{
+		Parent: pclient.NewCommit(repo, ""),
+		Branch: "master",
+		Labels: map[string]string{"source": "erp", "env": "prod"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit2.ID))
+	commit3, err := client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit3.ID))
+
+	// Labels can be changed after a commit is finished
+	require.NoError(t, client.SetCommitLabels(repo, commit2.ID, map[string]string{"quality": "failed"}, []string{"env"}))
+	commitInfo, err = client.InspectCommit(repo, commit2.ID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"source": "erp", "quality": "failed"}, commitInfo.Labels)
+
+	listCommitIDs := func(to string, number uint64, selector string) []string {
+		var ids []string
+		require.NoError(t, client.ListCommitSelectorF(repo, to, "", number, selector, func(ci *pfs.CommitInfo) error {
+			ids = append(ids, ci.Commit.ID)
+			return nil
+		}))
+		return ids
+	}
+	require.ElementsEqual(t, []string{commit1.ID, commit2.ID, commit3.ID}, listCommitIDs("", 0, ""))
+	require.ElementsEqual(t, []string{commit1.ID}, listCommitIDs("", 0, "quality=validated"))
+	require.ElementsEqual(t, []string{commit1.ID, commit2.ID}, listCommitIDs("master", 0, "source in (crm,erp)"))
+	require.ElementsEqual(t, []string{commit2.ID, commit3.ID}, listCommitIDs("master", 0, "quality!=validated"))
+	require.ElementsEqual(t, []string{commit3.ID}, listCommitIDs("master", 0, "!source"))
+	require.ElementsEqual(t, []string{commit2.ID}, listCommitIDs("master", 0, "source,!env"))
+	// 'number' only counts matching commits
+	require.ElementsEqual(t, []string{commit2.ID}, listCommitIDs("master", 1, "source"))
+
+	commitIter, err := client.SubscribeCommitSelector(repo, "master", "", pfs.CommitState_STARTED, "source=erp")
+	require.NoError(t, err)
+	defer commitIter.Close()
+	commitInfo, err = commitIter.Next()
+	require.NoError(t, err)
+	require.Equal(t, commit2.ID, commitInfo.Commit.ID)
+	_, err = client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, "master"))
+	commit5, err := client.PfsAPIClient.StartCommit(client.Ctx(), &pfs.StartCommitRequest{
+		Parent: pclient.NewCommit(repo, ""),
+		Branch: "master",
+		Labels: map[string]string{"source": "erp"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit5.ID))
+	commitInfo, err = commitIter.Next()
+	require.NoError(t, err)
+	require.Equal(t, commit5.ID, commitInfo.Commit.ID)
+
+	// Invalid labels and selectors are rejected
+	require.YesError(t, client.SetCommitLabels(repo, commit1.ID, map[string]string{"bad key": "value"}, nil))
+	require.YesError(t, client.SetCommitLabels(repo, commit1.ID, map[string]string{"key": "bad value"}, nil))
+	require.YesError(t, client.ListCommitSelectorF(repo, "", "", 0, "key in (", func(*pfs.CommitInfo) error { return nil }))
+}
+
+func TestCommitTags(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+
+	commit1, err := client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = client.PutFile(repo, commit1.ID, "file", strings.NewReader("foo\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit1.ID))
+	require.NoError(t, client.CreateCommitTag(repo, "master", "v1"))
+
+	commit2, err := client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = client.PutFile(repo, commit2.ID, "file", strings.NewReader("bar\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit2.ID))
+
+	// The tag resolves to the commit it was created on, not the branch head
+	commitInfo, err := client.InspectCommit(repo, "v1")
+	require.NoError(t, err)
+	require.Equal(t, commit1.ID, commitInfo.Commit.ID)
+	require.Equal(t, 1, len(commitInfo.Tags))
+	require.Equal(t, "v1", commitInfo.Tags[0].Name)
+	var buffer bytes.Buffer
+	require.NoError(t, client.GetFile(repo, "v1", "file", 0, 0, &buffer))
+	require.Equal(t, "foo\n", buffer.String())
+	// Ancestry references work relative to tags
+	require.NoError(t, client.CreateCommitTag(repo, commit2.ID, "v2"))
+	commitInfo, err = client.InspectCommit(repo, "v2^")
+	require.NoError(t, err)
+	require.Equal(t, commit1.ID, commitInfo.Commit.ID)
+
+	commitTagInfos, err := client.ListCommitTag(repo)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(commitTagInfos))
+
+	// Tags are immutable, and share a namespace with branches
+	require.YesError(t, client.CreateCommitTag(repo, commit2.ID, "v1"))
+	require.YesError(t, client.CreateCommitTag(repo, commit2.ID, "master"))
+	require.YesError(t, client.CreateBranch(repo, "v1", "master", nil))
+	_, err = client.StartCommit(repo, "v1")
+	require.YesError(t, err)
+	require.YesError(t, client.CreateCommitTag(repo, commit2.ID, "bad^name"))
+
+	// Tagged commits can't be deleted until the tag is
+	err = client.DeleteCommit(repo, commit1.ID)
+	require.YesError(t, err)
+	require.Matches(t, "is tagged", err.Error())
+	require.NoError(t, client.DeleteCommitTag(repo, "v1"))
+	require.NoError(t, client.DeleteCommit(repo, commit1.ID))
+	_, err = client.InspectCommit(repo, "v1")
+	require.YesError(t, err)
+
+	commitTagInfos, err = client.ListCommitTag(repo)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(commitTagInfos))
+	require.Equal(t, "v2", commitTagInfos[0].Tag.Name)
+	require.Equal(t, commit2.ID, commitTagInfos[0].Commit.ID)
+
+	// Nor can their repo, even with 'force'
+	err = client.DeleteRepo(repo, false)
+	require.YesError(t, err)
+	require.Matches(t, "is tagged", err.Error())
+	err = client.DeleteRepo(repo, true)
+	require.YesError(t, err)
+	require.Matches(t, "is tagged", err.Error())
+	_, err = client.PfsAPIClient.DeleteAll(client.Ctx(), &types.Empty{})
+	require.YesError(t, err)
+	require.Matches(t, "is tagged", err.Error())
+	_, err = client.InspectCommit(repo, "v2")
+	require.NoError(t, err)
+	require.NoError(t, client.DeleteCommitTag(repo, "v2"))
+	require.NoError(t, client.DeleteRepo(repo, false))
+	_, err = client.InspectRepo(repo)
+	require.YesError(t, err)
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+	var commits []*pfs.Commit
+	for i := 0; i < 5; i++ {
+		commit, err := client.StartCommit(repo, "master")
+		require.NoError(t, err)
+		_, err = client.PutFile(repo, commit.ID, fmt.Sprintf("file%d", i), strings.NewReader("foo\n"))
+		require.NoError(t, err)
+		require.NoError(t, client.FinishCommit(repo, commit.ID))
+		commits = append(commits, commit)
+	}
+
+	// Policies must keep something
+	require.YesError(t, client.SetRetentionPolicy(repo, "", 0, 0))
+
+	// A branch's policy takes precedence over its repo's
+	require.NoError(t, client.SetRetentionPolicy(repo, "", 2, 0))
+	require.NoError(t, client.SetRetentionPolicy(repo, "master", 0, time.Hour))
+	prunedCommitInfos, err := client.PruneCommits(repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(prunedCommitInfos))
+
+	// Tagged commits are kept, and the commits below them are squashed into
+	// them instead
+	require.NoError(t, client.CreateCommitTag(repo, commits[1].ID, "v1"))
+	require.NoError(t, client.DeleteRetentionPolicy(repo, "master"))
+	prunedCommitInfos, err = client.PruneCommits(repo)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(prunedCommitInfos))
+	require.Equal(t, commits[2].ID, prunedCommitInfos[0].Commit.ID)
+	require.Equal(t, commits[3].ID, prunedCommitInfos[0].SquashedInto.ID)
+	require.Equal(t, commits[0].ID, prunedCommitInfos[1].Commit.ID)
+	require.Equal(t, commits[1].ID, prunedCommitInfos[1].SquashedInto.ID)
+
+	commitInfos, err := client.ListCommit(repo, "master", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(commitInfos))
+	require.Equal(t, commits[1].ID, commitInfos[1].ParentCommit.ID)
+	require.Nil(t, commitInfos[2].ParentCommit)
+	_, err = client.InspectCommit(repo, commits[2].ID)
+	require.YesError(t, err)
+	// No data is lost from the surviving commits
+	fileInfos, err := client.ListFile(repo, "master", "")
+	require.NoError(t, err)
+	require.Equal(t, 5, len(fileInfos))
+
+	prunedCommitInfos, err = client.ListPrunedCommits(repo)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(prunedCommitInfos))
+	prunedCommitInfos, err = client.PruneCommits(repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(prunedCommitInfos))
+
+	// Commits with downstream commits are never pruned
+	require.NoError(t, client.CreateRepo("in"))
+	require.NoError(t, client.CreateRepo("out"))
+	require.NoError(t, client.CreateBranch("out", "master", "", []*pfs.Branch{pclient.NewBranch("in", "master")}))
+	for i := 0; i < 3; i++ {
+		_, err := client.PutFile("in", "master", fmt.Sprintf("file%d", i), strings.NewReader("foo\n"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, client.SetRetentionPolicy("in", "master", 1, 0))
+	prunedCommitInfos, err = client.PruneCommits("in")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(prunedCommitInfos))
+}
+
+func TestPurgeFile(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, client.CreateRepo(repo))
+	var commits []*pfs.Commit
+	for i := 0; i < 3; i++ {
+		commit, err := client.StartCommit(repo, "master")
+		require.NoError(t, err)
+		_, err = client.PutFile(repo, commit.ID, "secret", strings.NewReader(fmt.Sprintf("secret%d\n", i)))
+		require.NoError(t, err)
+		_, err = client.PutFile(repo, commit.ID, fmt.Sprintf("file%d", i), strings.NewReader("foo\n"))
+		require.NoError(t, err)
+		require.NoError(t, client.FinishCommit(repo, commit.ID))
+		commits = append(commits, commit)
+	}
+
+	_, err := client.PurgeFile(repo, "/")
+	require.YesError(t, err)
+	_, err = client.PurgeFile(repo, "nonexistent")
+	require.YesError(t, err)
+
+	purgeRecord, err := client.PurgeFile(repo, "secret")
 	require.NoError(t, err)
-	require.Equal(t, numCommits-numCommits/2-1, len(commitInfos))
-
-	// Test that commits are sorted in newest-first order
-	for i := 0; i < len(commitInfos)-1; i++ {
-		require.Equal(t, commitInfos[i].ParentCommit, commitInfos[i+1].Commit)
+	require.Equal(t, 3, len(purgeRecord.RewrittenCommits))
+	require.Equal(t, 0, len(purgeRecord.DeletedCommits))
+	require.Equal(t, uint64(len("secret0\n")*3), purgeRecord.PurgedBytes)
+	// The file is gone from every commit, but nothing else is
+	for i, commit := range commits {
+		_, err := client.InspectFile(repo, commit.ID, "secret")
+		require.YesError(t, err)
+		fileInfos, err := client.ListFile(repo, commit.ID, "")
+		require.NoError(t, err)
+		require.Equal(t, i+1, len(fileInfos))
 	}
+	// Purging the same path again finds nothing
+	_, err = client.PurgeFile(repo, "secret")
+	require.YesError(t, err)
 
-	// list commits by branch
-	commitInfos, err = client.ListCommit(repo, "master", "", 0)
+	_, err = client.PurgeFile(repo, "file0")
 	require.NoError(t, err)
-	require.Equal(t, numCommits, len(commitInfos))
-
-	// Test that commits are sorted in newest-first order
-	for i := 0; i < len(commitInfos)-1; i++ {
-		require.Equal(t, commitInfos[i].ParentCommit, commitInfos[i+1].Commit)
-	}
+	purgeRecords, err := client.ListPurgeRecords()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(purgeRecords))
+	require.Equal(t, purgeRecords[0].Hash, purgeRecords[1].PreviousHash)
+	require.NoError(t, pfsserver.VerifyPurgeRecords(purgeRecords))
 }
 
-func TestOffsetRead(t *testing.T) {
+func TestRetentionLock(t *testing.T) {
 	client := GetPachClient(t)
 
-	repo := "TestOffsetRead"
+	repo := "test"
 	require.NoError(t, client.CreateRepo(repo))
-	commit, err := client.StartCommit(repo, "")
+	commit1, err := client.StartCommit(repo, "master")
 	require.NoError(t, err)
-	fileData := "foo\n"
-	_, err = client.PutFile(repo, commit.ID, "foo", strings.NewReader(fileData))
+	_, err = client.PutFile(repo, commit1.ID, "file", strings.NewReader("foo\n"))
 	require.NoError(t, err)
-	_, err = client.PutFile(repo, commit.ID, "foo", strings.NewReader(fileData))
+	require.NoError(t, client.FinishCommit(repo, commit1.ID))
+	require.NoError(t, client.CreateBranch(repo, "dev", commit1.ID, nil))
+
+	require.YesError(t, client.SetRetentionLock(repo, 0))
+	require.NoError(t, client.SetRetentionLock(repo, 5*time.Second, "master"))
+	// Locks can be extended, but not shortened or removed
+	require.YesError(t, client.SetRetentionLock(repo, time.Second, "master"))
+	require.YesError(t, client.SetRetentionLock(repo, 5*time.Second, "dev"))
+	require.NoError(t, client.SetRetentionLock(repo, 6*time.Second, "master", "dev"))
+	repoInfo, err := client.InspectRepo(repo)
 	require.NoError(t, err)
+	require.NotNil(t, repoInfo.RetentionLock.RetainedUntil)
 
-	var buffer bytes.Buffer
-	require.NoError(t, client.GetFile(repo, commit.ID, "foo", int64(len(fileData)*2)+1, 0, &buffer))
-	require.Equal(t, "", buffer.String())
-
-	require.NoError(t, client.FinishCommit(repo, commit.ID))
-
-	buffer.Reset()
-	require.NoError(t, client.GetFile(repo, commit.ID, "foo", int64(len(fileData)*2)+1, 0, &buffer))
-	require.Equal(t, "", buffer.String())
+	// Commits made after the repo is locked are retained too
+	commit2, err := client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit2.ID))
+	repoInfo2, err := client.InspectRepo(repo)
+	require.NoError(t, err)
+	require.True(t, repoInfo2.RetentionLock.RetainedUntil.Compare(repoInfo.RetentionLock.RetainedUntil) > 0)
+
+	err = client.DeleteCommit(repo, commit2.ID)
+	require.True(t, pfsserver.IsRetentionLockedErr(err))
+	err = client.SquashCommit(repo, commit1.ID, commit2.ID, false)
+	require.True(t, pfsserver.IsRetentionLockedErr(err))
+	err = client.DeleteBranch(repo, "dev", true)
+	require.True(t, pfsserver.IsRetentionLockedErr(err))
+	_, err = client.PurgeFile(repo, "file")
+	require.True(t, pfsserver.IsRetentionLockedErr(err))
+	err = client.DeleteRepo(repo, true)
+	require.True(t, pfsserver.IsRetentionLockedErr(err))
+	_, err = client.PfsAPIClient.DeleteAll(client.Ctx(), &types.Empty{})
+	require.True(t, pfsserver.IsRetentionLockedErr(err))
+	_, err = client.InspectCommit(repo, commit2.ID)
+	require.NoError(t, err)
+
+	// Once every commit's retention has passed, the repo can be deleted
+	until, err := types.TimestampFromProto(repoInfo2.RetentionLock.RetainedUntil)
+	require.NoError(t, err)
+	time.Sleep(time.Until(until))
+	require.NoError(t, client.DeleteRepo(repo, true))
 }
 
-func TestBranch2(t *testing.T) {
+func TestSymlink(t *testing.T) {
 	client := GetPachClient(t)
 
 	repo := "test"
 	require.NoError(t, client.CreateRepo(repo))
-
-	commit, err := client.StartCommit(repo, "")
+	commit, err := client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = client.PutFile(repo, commit.ID, "dir/file", strings.NewReader("foo\n"))
 	require.NoError(t, err)
+	require.NoError(t, client.PutSymlink(repo, commit.ID, "abs", "/dir/file"))
+	require.NoError(t, client.PutSymlink(repo, commit.ID, "dir/rel", "file"))
+	require.NoError(t, client.PutSymlink(repo, commit.ID, "link", "dir"))
 	require.NoError(t, client.FinishCommit(repo, commit.ID))
 
-	expectedBranches := []string{"branch1", "branch2", "branch3"}
-	for _, branch := range expectedBranches {
-		require.NoError(t, client.SetBranch(repo, commit.ID, branch))
-	}
-
-	branches, err := client.ListBranch(repo)
-	require.Equal(t, len(expectedBranches), len(branches))
-	for i, branch := range branches {
-		// branches should return in newest-first order
-		require.Equal(t, expectedBranches[len(branches)-i-1], branch.Name)
-		require.Equal(t, commit, branch.Head)
+	// Links are read through to their targets
+	for _, p := range []string{"abs", "dir/rel", "link/file", "link/rel"} {
+		var buffer bytes.Buffer
+		require.NoError(t, client.GetFile(repo, commit.ID, p, 0, 0, &buffer))
+		require.Equal(t, "foo\n", buffer.String(), p)
 	}
 
-	commit2, err := client.StartCommit(repo, "branch1")
+	// But inspected as links
+	fileInfo, err := client.InspectFile(repo, commit.ID, "abs")
 	require.NoError(t, err)
-	require.NoError(t, client.FinishCommit(repo, "branch1"))
+	require.Equal(t, pfs.FileType_SYMLINK, fileInfo.FileType)
+	require.Equal(t, "/dir/file", fileInfo.SymlinkTarget)
+	fileInfo, err = client.InspectFile(repo, commit.ID, "link/rel")
+	require.NoError(t, err)
+	require.Equal(t, pfs.FileType_SYMLINK, fileInfo.FileType)
+	fileInfo, err = client.InspectFile(repo, commit.ID, "link/file")
+	require.NoError(t, err)
+	require.Equal(t, pfs.FileType_FILE, fileInfo.FileType)
+	fileInfos, err := client.ListFile(repo, commit.ID, "dir")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(fileInfos))
 
-	commit2Info, err := client.InspectCommit(repo, "branch1")
+	// Links are kept as links when copied, and replaced when written to
+	commit, err = client.StartCommit(repo, "master")
 	require.NoError(t, err)
-	require.Equal(t, commit, commit2Info.ParentCommit)
+	require.NoError(t, client.CopyFile(repo, commit.ID, "abs", repo, commit.ID, "copy", false))
+	require.NoError(t, client.PutSymlink(repo, commit.ID, "dangling", "nothing"))
+	_, err = client.PutFile(repo, commit.ID, "abs", strings.NewReader("bar\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit.ID))
+	fileInfo, err = client.InspectFile(repo, commit.ID, "copy")
+	require.NoError(t, err)
+	require.Equal(t, "/dir/file", fileInfo.SymlinkTarget)
+	var buffer bytes.Buffer
+	require.NoError(t, client.GetFile(repo, commit.ID, "abs", 0, 0, &buffer))
+	require.Equal(t, "bar\n", buffer.String())
+	require.YesError(t, client.GetFile(repo, commit.ID, "dangling", 0, 0, &bytes.Buffer{}))
 
-	// delete the last branch
-	var lastBranch string
-	lastBranch = expectedBranches[len(expectedBranches)-1]
-	require.NoError(t, client.DeleteBranch(repo, lastBranch, false))
-	branches, err = client.ListBranch(repo)
-	require.Equal(t, 2, len(branches))
-	require.Equal(t, "branch2", branches[0].Name)
-	require.Equal(t, commit, branches[0].Head)
-	require.Equal(t, "branch1", branches[1].Name)
-	require.Equal(t, commit2, branches[1].Head)
+	// Symlinks at invalid paths are rejected, both in open commits and when
+	// the put creates its own commit
+	commit, err = client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	require.YesError(t, client.PutSymlink(repo, commit.ID, "glob*", "dir/file"))
+	require.NoError(t, client.FinishCommit(repo, commit.ID))
+	require.YesError(t, client.PutSymlink(repo, "master", "glob*", "dir/file"))
 }
 
-func TestDeleteNonexistantBranch(t *testing.T) {
+func TestFileMetadata(t *testing.T) {
 	client := GetPachClient(t)
 
-	repo := "TestDeleteNonexistantBranch"
+	repo := "test"
 	require.NoError(t, client.CreateRepo(repo))
-	require.NoError(t, client.DeleteBranch(repo, "doesnt_exist", false))
+	commit, err := client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	metadata := map[string]string{pfs.ContentTypeKey: "text/plain", "source": "http://example.com/foo"}
+	_, err = client.PutFileWithMetadata(repo, commit.ID, "foo", strings.NewReader("foo\n"), false, metadata)
+	require.NoError(t, err)
+	_, err = client.PutFile(repo, commit.ID, "bar", strings.NewReader("foo\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit.ID))
+
+	fooInfo, err := client.InspectFile(repo, commit.ID, "foo")
+	require.NoError(t, err)
+	require.Equal(t, metadata, fooInfo.Metadata)
+	// Metadata doesn't affect the file's hash
+	barInfo, err := client.InspectFile(repo, commit.ID, "bar")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(barInfo.Metadata))
+	require.Equal(t, barInfo.Hash, fooInfo.Hash)
+
+	// Metadata is carried by CopyFile, merged when appending, and reset when
+	// overwriting
+	commit, err = client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	require.NoError(t, client.CopyFile(repo, commit.ID, "foo", repo, commit.ID, "copy", false))
+	_, err = client.PutFileWithMetadata(repo, commit.ID, "foo", strings.NewReader("foo\n"), false, map[string]string{"source": "elsewhere"})
+	require.NoError(t, err)
+	_, err = client.PutFileWithMetadata(repo, commit.ID, "bar", strings.NewReader("bar\n"), true, map[string]string{"a": "b"})
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit.ID))
+	fileInfo, err := client.InspectFile(repo, commit.ID, "copy")
+	require.NoError(t, err)
+	require.Equal(t, metadata, fileInfo.Metadata)
+	fileInfo, err = client.InspectFile(repo, commit.ID, "foo")
+	require.NoError(t, err)
+	require.Equal(t, "text/plain", fileInfo.Metadata[pfs.ContentTypeKey])
+	require.Equal(t, "elsewhere", fileInfo.Metadata["source"])
+	fileInfo, err = client.InspectFile(repo, commit.ID, "bar")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "b"}, fileInfo.Metadata)
 }
 
-func TestSubscribeCommit(t *testing.T) {
+func TestFileChecksums(t *testing.T) {
 	client := GetPachClient(t)
 
 	repo := "test"
 	require.NoError(t, client.CreateRepo(repo))
+	sha256Sum := sha256.Sum256([]byte("foo\n"))
+	md5Sum := md5.Sum([]byte("foo\n"))
+	commit, err := client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = client.PutFile(repo, commit.ID, "foo", strings.NewReader("foo\n"))
+	require.NoError(t, err)
+	_, err = client.PutFileSplit(repo, commit.ID, "split", pfs.Delimiter_LINE, 0, 0, 0, false, strings.NewReader("foo\nbar\n"))
+	require.NoError(t, err)
+	// A mismatched checksum fails the put, and nothing is written
+	_, err = client.PutFileWithChecksum(repo, commit.ID, "bad", strings.NewReader("foo\n"), false, nil, strings.Repeat("0", 64))
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsChecksumMismatchErr(err))
+	_, err = client.PutFileWithChecksum(repo, commit.ID, "good", strings.NewReader("foo\n"), false, nil, hex.EncodeToString(sha256Sum[:]))
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit.ID))
 
-	numCommits := 10
+	fileInfo, err := client.InspectFile(repo, commit.ID, "foo")
+	require.NoError(t, err)
+	require.Equal(t, sha256Sum[:], fileInfo.Sha256)
+	require.Equal(t, md5Sum[:], fileInfo.Md5)
+	_, err = client.InspectFile(repo, commit.ID, "bad")
+	require.YesError(t, err)
+	fileInfos, err := client.ListFile(repo, commit.ID, "split")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(fileInfos))
+	sha256Sum = sha256.Sum256([]byte("foo\n"))
+	require.Equal(t, sha256Sum[:], fileInfos[0].Sha256)
+	sha256Sum = sha256.Sum256([]byte("bar\n"))
+	require.Equal(t, sha256Sum[:], fileInfos[1].Sha256)
 
-	// create some commits that shouldn't affect the below SubscribeCommit call
-	// reproduces #2469
-	for i := 0; i < numCommits; i++ {
-		commit, err := client.StartCommit(repo, "master-v1")
-		require.NoError(t, err)
-		require.NoError(t, client.FinishCommit(repo, commit.ID))
-	}
+	// Checksums are carried by CopyFile and invalidated by appending
+	commit, err = client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	require.NoError(t, client.CopyFile(repo, commit.ID, "foo", repo, commit.ID, "copy", false))
+	_, err = client.PutFile(repo, commit.ID, "good", strings.NewReader("foo\n"))
+	require.NoError(t, err)
+	require.NoError(t, client.FinishCommit(repo, commit.ID))
+	fileInfo, err = client.InspectFile(repo, commit.ID, "copy")
+	require.NoError(t, err)
+	require.Equal(t, md5Sum[:], fileInfo.Md5)
+	fileInfo, err = client.InspectFile(repo, commit.ID, "good")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(fileInfo.Sha256))
+}
 
-	var commits []*pfs.Commit
-	for i := 0; i < numCommits; i++ {
-		commit, err := client.StartCommit(repo, "master")
-		require.NoError(t, err)
-		require.NoError(t, client.FinishCommit(repo, commit.ID))
-		commits = append(commits, commit)
+func TestRepoQuota(t *testing.T) {
+	client := GetPachClient(t)
+
+	repo := "test"
+	setQuota := func(quotaBytes uint64, update bool) error {
+		_, err := client.PfsAPIClient.CreateRepo(client.Ctx(), &pfs.CreateRepoRequest{
+			Repo:       pclient.NewRepo(repo),
+			QuotaBytes: quotaBytes,
+			SetQuota:   true,
+			Update:     update,
+		})
+		return err
 	}
+	require.NoError(t, setQuota(10, false))
+	repoInfo, err := client.InspectRepo(repo)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), repoInfo.QuotaBytes)
 
-	commitIter, err := client.SubscribeCommit(repo, "master", "", pfs.CommitState_STARTED)
+	// Updating the repo without set_quota leaves the quota alone
+	_, err = client.PfsAPIClient.CreateRepo(client.Ctx(), &pfs.CreateRepoRequest{
+		Repo:        pclient.NewRepo(repo),
+		Description: "quota test",
+		Update:      true,
+	})
 	require.NoError(t, err)
-	for i := 0; i < numCommits; i++ {
-		commitInfo, err := commitIter.Next()
-		require.NoError(t, err)
-		require.Equal(t, commits[i], commitInfo.Commit)
-	}
+	repoInfo, err = client.InspectRepo(repo)
+	require.NoError(t, err)
+	require.Equal(t, "quota test", repoInfo.Description)
+	require.Equal(t, uint64(10), repoInfo.QuotaBytes)
 
-	// Create another batch of commits
-	commits = nil
-	for i := 0; i < numCommits; i++ {
-		commit, err := client.StartCommit(repo, "master")
-		require.NoError(t, err)
-		require.NoError(t, client.FinishCommit(repo, "master"))
-		commits = append(commits, commit)
-	}
+	_, err = client.PutFile(repo, "master", "file1", strings.NewReader("123456"))
+	require.NoError(t, err)
+	repoInfo, err = client.InspectRepo(repo)
+	require.NoError(t, err)
+	require.Equal(t, "", repoInfo.QuotaWarning)
+	_, err = client.PutFile(repo, "master", "file2", strings.NewReader("789"))
+	require.NoError(t, err)
+	// The repo is now over 90% full
+	repoInfo, err = client.InspectRepo(repo)
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), repoInfo.SizeBytes)
+	require.True(t, repoInfo.QuotaWarning != "")
 
-	for i := 0; i < numCommits; i++ {
-		commitInfo, err := commitIter.Next()
-		require.NoError(t, err)
-		require.Equal(t, commits[i], commitInfo.Commit)
-	}
+	// Writes that would exceed the quota are rejected
+	_, err = client.PutFile(repo, "master", "file3", strings.NewReader("abcde"))
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsRepoQuotaExceededErr(err))
+	commit, err := client.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = client.PutFile(repo, commit.ID, "file3", strings.NewReader("abcde"))
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsRepoQuotaExceededErr(err))
 
-	commitIter.Close()
+	// Raising the quota allows the write
+	require.NoError(t, setQuota(100, true))
+	_, err = client.PutFile(repo, commit.ID, "file3", strings.NewReader("abcde"))
+	require.NoError(t, err)
+
+	// The quota is also checked when the commit is finished
+	require.NoError(t, setQuota(10, true))
+	err = client.FinishCommit(repo, commit.ID)
+	require.YesError(t, err)
+	require.True(t, pfsserver.IsRepoQuotaExceededErr(err))
+	require.NoError(t, setQuota(0, true))
+	require.NoError(t, client.FinishCommit(repo, commit.ID))
+	repoInfo, err = client.InspectRepo(repo)
+	require.NoError(t, err)
+	require.Equal(t, uint64(14), repoInfo.SizeBytes)
 }
 
 func TestInspectRepoSimple(t *testing.T) {
@@ -2923,6 +4146,65 @@ func TestDiff(t *testing.T) {
 	require.Equal(t, "dir/fizz", oldFiles[0].File.Path)
 }
 
+func TestDiffContent(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+
+	c := GetPachClient(t)
+	repo := tu.UniqueString("TestDiffContent")
+	require.NoError(t, c.CreateRepo(repo))
+
+	_, err := c.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = c.PutFile(repo, "master", "dir/changed", strings.NewReader("1\n2\n3\n"))
+	require.NoError(t, err)
+	_, err = c.PutFile(repo, "master", "dir/deleted", strings.NewReader("gone\n"))
+	require.NoError(t, err)
+	_, err = c.PutFile(repo, "master", "dir/binary", strings.NewReader("a\x00b"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit(repo, "master"))
+
+	_, err = c.StartCommit(repo, "master")
+	require.NoError(t, err)
+	_, err = c.PutFileOverwrite(repo, "master", "dir/changed", strings.NewReader("1\ntwo\n3\n"), 0)
+	require.NoError(t, err)
+	require.NoError(t, c.DeleteFile(repo, "master", "dir/deleted"))
+	_, err = c.PutFile(repo, "master", "dir/added", strings.NewReader("new\n"))
+	require.NoError(t, err)
+	_, err = c.PutFile(repo, "master", "dir/binary", strings.NewReader("c"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit(repo, "master"))
+
+	var diffs []*pfs.FileDiff
+	require.NoError(t, c.DiffFileContent(repo, "master", "dir", "", "", "", 1, 0, func(diff *pfs.FileDiff) error {
+		diffs = append(diffs, diff)
+		return nil
+	}))
+	// Only files are returned, in order
+	require.Equal(t, 4, len(diffs))
+	require.Equal(t, "/dir/added", diffs[0].NewFile.File.Path)
+	require.Nil(t, diffs[0].OldFile)
+	require.Equal(t, "--- /dev/null\n+++ b/dir/added\n@@ -0,0 +1 @@\n+new\n", diffs[0].Diff)
+	require.True(t, diffs[1].Binary)
+	require.Equal(t, "", diffs[1].Diff)
+	require.Equal(t, "--- a/dir/changed\n+++ b/dir/changed\n@@ -1,3 +1,3 @@\n 1\n-2\n+two\n 3\n", diffs[2].Diff)
+	require.Nil(t, diffs[3].NewFile)
+	require.Equal(t, "--- a/dir/deleted\n+++ /dev/null\n@@ -1 +0,0 @@\n-gone\n", diffs[3].Diff)
+	// The files' objects aren't returned
+	require.Equal(t, 0, len(diffs[2].NewFile.Objects))
+
+	// Files above the size limit aren't diffed
+	diffs = nil
+	require.NoError(t, c.DiffFileContent(repo, "master", "dir/changed", "", "", "", 3, 4, func(diff *pfs.FileDiff) error {
+		diffs = append(diffs, diff)
+		return nil
+	}))
+	require.Equal(t, 1, len(diffs))
+	require.True(t, diffs[0].TooLarge)
+	require.Equal(t, "", diffs[0].Diff)
+}
+
 func TestGlob(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping integration tests in short mode")
@@ -3054,6 +4336,91 @@ func TestGlob(t *testing.T) {
 	require.Equal(t, 0, len(fileInfos))
 }
 
+func TestGlobRecursive(t *testing.T) {
+	c := GetPachClient(t)
+	repo := "test"
+	require.NoError(t, c.CreateRepo(repo))
+	commit, err := c.StartCommit(repo, "master")
+	require.NoError(t, err)
+	for _, p := range []string{"a.parquet", "2019/01/01/b.parquet", "2019/01/01/c.csv", "2019/_tmp/d.parquet", "2020/e.parquet"} {
+		_, err = c.PutFile(repo, commit.ID, p, strings.NewReader("1"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, c.FinishCommit(repo, commit.ID))
+
+	paths := func(fileInfos []*pfs.FileInfo) []string {
+		var result []string
+		for _, fi := range fileInfos {
+			result = append(result, fi.File.Path)
+		}
+		return result
+	}
+	fileInfos, err := c.GlobFile(repo, commit.ID, "**/*.parquet")
+	require.NoError(t, err)
+	require.ElementsEqual(t, []string{"/a.parquet", "/2019/01/01/b.parquet", "/2019/_tmp/d.parquet", "/2020/e.parquet"}, paths(fileInfos))
+	fileInfos, err = c.GlobFile(repo, commit.ID, "/**/*.parquet!/**/_tmp")
+	require.NoError(t, err)
+	require.ElementsEqual(t, []string{"/a.parquet", "/2019/01/01/b.parquet", "/2020/e.parquet"}, paths(fileInfos))
+	fileInfos, err = c.GlobFile(repo, commit.ID, "/{2019/**/*.csv,2020/*}")
+	require.NoError(t, err)
+	require.ElementsEqual(t, []string{"/2019/01/01/c.csv", "/2020/e.parquet"}, paths(fileInfos))
+
+	// Exclusions apply to the children of directories listed by ListFile
+	fileInfos, err = c.ListFile(repo, commit.ID, "/2019!/2019/_tmp")
+	require.NoError(t, err)
+	require.ElementsEqual(t, []string{"/2019/01"}, paths(fileInfos))
+
+	_, err = c.GlobFile(repo, commit.ID, "/{2019,2020")
+	require.YesError(t, err)
+}
+
+func TestGrepFile(t *testing.T) {
+	c := GetPachClient(t)
+
+	repo := "test"
+	require.NoError(t, c.CreateRepo(repo))
+	commit, err := c.StartCommit(repo, "master")
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = c.PutFile(repo, commit.ID, fmt.Sprintf("logs/%d.log", i), strings.NewReader(fmt.Sprintf("start\nrequest req-%d\nend\n", i)))
+		require.NoError(t, err)
+	}
+	_, err = c.PutFile(repo, commit.ID, "other.txt", strings.NewReader("request req-3\n"))
+	require.NoError(t, err)
+	require.NoError(t, c.FinishCommit(repo, commit.ID))
+
+	matches, truncated, err := c.GrepFile(repo, commit.ID, "/logs/*", "req-3$", 0, 0)
+	require.NoError(t, err)
+	require.False(t, truncated)
+	require.Equal(t, 1, len(matches))
+	require.Equal(t, "/logs/3.log", matches[0].File.Path)
+	require.Equal(t, int64(2), matches[0].LineNumber)
+	require.Equal(t, "request req-3", matches[0].Line)
+
+	// Lines from the same file are returned in order
+	matches, _, err = c.GrepFile(repo, commit.ID, "/logs/5.log", ".", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(matches))
+	for i, match := range matches {
+		require.Equal(t, int64(i+1), match.LineNumber)
+	}
+
+	// Limits stop the search early
+	matches, truncated, err = c.GrepFile(repo, commit.ID, "/logs/*", "req", 0, 4)
+	require.NoError(t, err)
+	require.True(t, truncated)
+	require.Equal(t, 4, len(matches))
+	fileInfo, err := c.InspectFile(repo, commit.ID, "/logs/0.log")
+	require.NoError(t, err)
+	matches, truncated, err = c.GrepFile(repo, commit.ID, "/logs/*", "req", int64(3*fileInfo.SizeBytes), 0)
+	require.NoError(t, err)
+	require.True(t, truncated)
+	require.Equal(t, 3, len(matches))
+
+	_, _, err = c.GrepFile(repo, commit.ID, "/logs/*", "(", 0, 0)
+	require.YesError(t, err)
+}
+
 // TestGetFileGlobOrder checks that GetFile(glob) streams data back in the
 // right order. GetFile(glob) is supposed to return a stream of data of the
 // form file1 + file2 + .. + fileN, where file1 is the lexicographically lowest
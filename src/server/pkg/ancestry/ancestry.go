@@ -2,8 +2,10 @@ package ancestry
 
 import (
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Parse parses s for git ancestry references.
@@ -17,11 +19,21 @@ import (
 // foo^^ -> foo, 2
 // foo^3 -> foo 3
 // (all examples apply with ~ in place of ^ as well
+// Time references (see ParseTime) are left in the base reference, e.g.
+// foo@{2.days.ago}^ -> foo@{2.days.ago}, 1
 func Parse(s string) (string, int) {
-	sepIndex := strings.IndexAny(s, "^~")
+	// Separators inside a time reference aren't ancestry references
+	var timeEnd int
+	if i := strings.Index(s, "@{"); i != -1 {
+		if j := strings.Index(s[i:], "}"); j != -1 {
+			timeEnd = i + j + 1
+		}
+	}
+	sepIndex := strings.IndexAny(s[timeEnd:], "^~")
 	if sepIndex == -1 {
 		return s, 0
 	}
+	sepIndex += timeEnd
 
 	// Find the separator, which is either "^" or "~"
 	sep := s[sepIndex]
@@ -54,3 +66,79 @@ func Parse(s string) (string, int) {
 func Add(s string, ancestors int) string {
 	return fmt.Sprintf("%s~%d", s, ancestors)
 }
+
+// relativeTimeRe matches git-style relative times, e.g. "2.days.ago" or
+// "1 hour ago"
+var relativeTimeRe = regexp.MustCompile(`^(\d+)[. ](second|minute|hour|day|week|month|year)s?[. ]ago$`)
+
+// timeFormats are the absolute time formats accepted by ParseTime. Times
+// without a zone are in UTC.
+var timeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// ParseTime parses s for a git-style time reference of the form
+// "foo@{<time>}", where <time> is either an absolute time (e.g.
+// "2006-01-02T15:04:05Z" or "2006-01-02") or a time relative to 'now' (e.g.
+// "2.days.ago"). It returns the base reference, the time referred to, and
+// whether s contained a time reference at all. For example:
+// foo@{2006-01-02} -> foo, 2006-01-02 00:00:00 UTC, true
+// foo@{1.hour.ago} -> foo, now - 1h, true
+// foo -> foo, zero time, false
+func ParseTime(s string, now time.Time) (string, time.Time, bool, error) {
+	i := strings.Index(s, "@{")
+	if i == -1 || !strings.HasSuffix(s, "}") {
+		return s, time.Time{}, false, nil
+	}
+	ref, spec := s[:i], s[i+2:len(s)-1]
+	if ref == "" {
+		return "", time.Time{}, false, fmt.Errorf("invalid time reference \"%s\": no branch or commit before \"@\"", s)
+	}
+	t, err := ParseTimeSpec(spec, now)
+	if err != nil {
+		return "", time.Time{}, false, fmt.Errorf("invalid time reference \"%s\": %v", s, err)
+	}
+	return ref, t, true, nil
+}
+
+// ParseTimeSpec parses the time inside a time reference (i.e. the part
+// between "@{" and "}"), such as "2006-01-02" or "2.days.ago".
+func ParseTimeSpec(spec string, now time.Time) (time.Time, error) {
+	spec = strings.TrimSpace(spec)
+	if m := relativeTimeRe.FindStringSubmatch(spec); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch m[2] {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -n), nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), nil
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		default: // year
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+	for _, format := range timeFormats {
+		if t, err := time.ParseInLocation(format, spec, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time \"%s\", must be of the form \"2006-01-02T15:04:05Z\" or \"<n>.<unit>.ago\"", spec)
+}
+
+// AddTime adds a time reference to the given string.
+func AddTime(s string, t time.Time) string {
+	return fmt.Sprintf("%s@{%s}", s, t.UTC().Format(time.RFC3339Nano))
+}
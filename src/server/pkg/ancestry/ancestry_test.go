@@ -0,0 +1,68 @@
+package ancestry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pachyderm/pachyderm/src/client/pkg/require"
+)
+
+func TestParse(t *testing.T) {
+	for s, expected := range map[string]struct {
+		ref       string
+		ancestors int
+	}{
+		"master":               {"master", 0},
+		"master^":              {"master", 1},
+		"master^^^":            {"master", 3},
+		"master~2":             {"master", 2},
+		"master~whatever":      {"master~whatever", 0},
+		"master@{1.day.ago}":   {"master@{1.day.ago}", 0},
+		"master@{1.day.ago}^":  {"master@{1.day.ago}", 1},
+		"master@{1.day.ago}~3": {"master@{1.day.ago}", 3},
+	} {
+		ref, ancestors := Parse(s)
+		require.Equal(t, expected.ref, ref, s)
+		require.Equal(t, expected.ancestors, ancestors, s)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 30, 0, 0, time.UTC)
+	for s, expected := range map[string]time.Time{
+		"master@{2026-03-03T00:00:00Z}":      time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC),
+		"master@{2026-03-03T01:00:00+01:00}": time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC),
+		"master@{2026-03-03T00:00:00}":       time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC),
+		"master@{2026-03-03}":                time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC),
+		"master@{30.seconds.ago}":            now.Add(-30 * time.Second),
+		"master@{1.minute.ago}":              now.Add(-time.Minute),
+		"master@{5 hours ago}":               now.Add(-5 * time.Hour),
+		"master@{2.days.ago}":                time.Date(2026, 3, 8, 12, 30, 0, 0, time.UTC),
+		"master@{1.week.ago}":                time.Date(2026, 3, 3, 12, 30, 0, 0, time.UTC),
+		"master@{1.month.ago}":               time.Date(2026, 2, 10, 12, 30, 0, 0, time.UTC),
+		"master@{1.year.ago}":                time.Date(2025, 3, 10, 12, 30, 0, 0, time.UTC),
+	} {
+		ref, before, ok, err := ParseTime(s, now)
+		require.NoError(t, err, s)
+		require.True(t, ok, s)
+		require.Equal(t, "master", ref, s)
+		require.True(t, expected.Equal(before), s)
+	}
+
+	ref, _, ok, err := ParseTime("master", now)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Equal(t, "master", ref)
+
+	_, _, _, err = ParseTime("master@{yesterday-ish}", now)
+	require.YesError(t, err)
+	_, _, _, err = ParseTime("@{2.days.ago}", now)
+	require.YesError(t, err)
+
+	// AddTime produces references that ParseTime understands
+	ref, before, ok, err := ParseTime(AddTime("master", now), time.Time{})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "master", ref)
+	require.True(t, now.Equal(before))
+}
@@ -98,6 +98,20 @@ func ParseBranches(args []string) ([]*pfs.Branch, error) {
 	return result, nil
 }
 
+// ParseLabels takes a slice of arguments of the form "key=value", and returns
+// them as a map of labels
+func ParseLabels(args []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid label \"%s\": labels must be of the form \"key=value\"", arg)
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
+
 // RepeatedStringArg is an alias for []string
 type RepeatedStringArg []string
 
@@ -551,6 +551,10 @@ func (c *readonlyCollection) WatchWithPrev() (watch.Watcher, error) {
 	return watch.NewWatcherWithPrev(c.ctx, c.etcdClient, c.prefix, c.prefix, c.template)
 }
 
+func (c *readonlyCollection) WatchWithPrevFrom(rev int64) (watch.Watcher, error) {
+	return watch.NewWatcherWithPrevFrom(c.ctx, c.etcdClient, c.prefix, c.prefix, rev, c.template)
+}
+
 // WatchByIndex watches items in a collection that match a particular index
 func (c *readonlyCollection) WatchByIndex(index *Index, val interface{}) (watch.Watcher, error) {
 	eventCh := make(chan *watch.Event)
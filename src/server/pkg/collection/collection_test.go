@@ -187,6 +187,53 @@ func TestIndexWatch(t *testing.T) {
 	require.Equal(t, j2.Job.ID, ID)
 }
 
+func TestWatchWithPrevFrom(t *testing.T) {
+	etcdClient := getEtcdClient()
+	uuidPrefix := uuid.NewWithoutDashes()
+
+	jobInfos := NewCollection(etcdClient, uuidPrefix, nil, &pps.JobInfo{}, nil, nil)
+	put := func(pipeline string) {
+		_, err := NewSTM(context.Background(), etcdClient, func(stm STM) error {
+			return jobInfos.ReadWrite(stm).Put("j1", &pps.JobInfo{
+				Job:      client.NewJob("j1"),
+				Pipeline: client.NewPipeline(pipeline),
+			})
+		})
+		require.NoError(t, err)
+	}
+	checkEvent := func(event *watch.Event, pipeline string, prevPipeline string) {
+		var ID string
+		job := new(pps.JobInfo)
+		require.NoError(t, event.Err)
+		require.Equal(t, watch.EventPut, event.Type)
+		require.NoError(t, event.Unmarshal(&ID, job))
+		require.Equal(t, pipeline, job.Pipeline.Name)
+		require.NoError(t, event.UnmarshalPrev(&ID, job))
+		require.Equal(t, prevPipeline, job.Pipeline.Name)
+	}
+
+	put("p1")
+	watcher, err := jobInfos.ReadOnly(context.Background()).WatchWithPrev()
+	require.NoError(t, err)
+	event := <-watcher.Watch()
+	require.NoError(t, event.Err)
+	require.Nil(t, event.PrevValue)
+	put("p2")
+	event = <-watcher.Watch()
+	checkEvent(event, "p2", "p1")
+	rev := event.Rev
+	watcher.Close()
+
+	// Resuming at 'rev' replays the update made at 'rev' and every update
+	// made since
+	put("p3")
+	watcher, err = jobInfos.ReadOnly(context.Background()).WatchWithPrevFrom(rev)
+	require.NoError(t, err)
+	defer watcher.Close()
+	checkEvent(<-watcher.Watch(), "p2", "p1")
+	checkEvent(<-watcher.Watch(), "p3", "p2")
+}
+
 func TestMultiIndex(t *testing.T) {
 	etcdClient := getEtcdClient()
 	uuidPrefix := uuid.NewWithoutDashes()
@@ -109,6 +109,10 @@ type ReadonlyCollection interface {
 	// WatchWithPrev is like Watch, but the events will include the previous
 	// versions of the key/value.
 	WatchWithPrev() (watch.Watcher, error)
+	// WatchWithPrevFrom is like WatchWithPrev, but instead of the current
+	// contents of the collection, the events start with the changes made at
+	// revision 'rev'.
+	WatchWithPrevFrom(rev int64) (watch.Watcher, error)
 	WatchOne(key string) (watch.Watcher, error)
 	WatchByIndex(index *Index, val interface{}) (watch.Watcher, error)
 }
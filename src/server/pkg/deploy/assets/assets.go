@@ -553,6 +553,11 @@ func PachdDeployment(opts *AssetOpts, objectStoreBackend backend, hostPath strin
 									Protocol:      "TCP",
 									Name:          "peer-port",
 								},
+								{
+									ContainerPort: 600, // also set in cmd/pachd/main.go
+									Protocol:      "TCP",
+									Name:          "s3gateway-port",
+								},
 								{
 									ContainerPort: githook.GitHookPort,
 									Protocol:      "TCP",
@@ -618,6 +623,11 @@ func PachdService(opts *AssetOpts) *v1.Service {
 					Name:     "api-http-port",
 					NodePort: 30652,
 				},
+				{
+					Port:     600, // also set in cmd/pachd/main.go
+					Name:     "s3gateway-port",
+					NodePort: 30600,
+				},
 				{
 					Port:     auth.SamlPort,
 					Name:     "saml-port",
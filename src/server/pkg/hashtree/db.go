@@ -16,7 +16,7 @@ import (
 
 	"github.com/OneOfOne/xxhash"
 	bolt "github.com/coreos/bbolt"
-	globlib "github.com/gobwas/glob"
+	"github.com/gogo/protobuf/types"
 	"github.com/pachyderm/pachyderm/src/client"
 	"github.com/pachyderm/pachyderm/src/client/pfs"
 	"github.com/pachyderm/pachyderm/src/client/pkg/pbutil"
@@ -193,6 +193,12 @@ func dirPrefix(path string) string {
 
 // iterDir iterates through the nodes under path, it errors with PathNotFound if path doesn't exist, it errors with PathConflict if path exists but isn't a directory.
 func iterDir(tx *bolt.Tx, path string, f func(k, v []byte, c *bolt.Cursor) error) error {
+	return iterDirFrom(tx, path, "", f)
+}
+
+// iterDirFrom is like iterDir, but skips the children of path whose names
+// don't sort after 'from' (if it's set).
+func iterDirFrom(tx *bolt.Tx, path string, from string, f func(k, v []byte, c *bolt.Cursor) error) error {
 	node, err := get(tx, path)
 	if err != nil {
 		return err
@@ -202,7 +208,11 @@ func iterDir(tx *bolt.Tx, path string, f func(k, v []byte, c *bolt.Cursor) error
 			path)
 	}
 	c := NewChildCursor(tx, path)
-	for k, v := c.K(), c.V(); k != nil; k, v = c.Next() {
+	k, v := c.K(), c.V()
+	if from != "" {
+		k, v = c.SeekAfter(from)
+	}
+	for ; k != nil; k, v = c.Next() {
 		if err := f(k, v, c.c); err != nil {
 			if err == errutil.ErrBreak {
 				return nil
@@ -231,6 +241,22 @@ func (h *dbHashTree) List(path string, f func(*NodeProto) error) error {
 	})
 }
 
+// ListFrom is like List, but only visits the children of path whose names
+// sort after 'from'. Children are visited in (byte) order of their names, so
+// this can be used to resume a List.
+func (h *dbHashTree) ListFrom(path string, from string, f func(*NodeProto) error) error {
+	path = clean(path)
+	return h.View(func(tx *bolt.Tx) error {
+		return iterDirFrom(tx, path, from, func(_, v []byte, _ *bolt.Cursor) error {
+			node := &NodeProto{}
+			if err := node.Unmarshal(v); err != nil {
+				return err
+			}
+			return f(node)
+		})
+	})
+}
+
 // ListAll retrieves all the files under a directory (or a file if the path is a file).
 func (h *dbHashTree) ListAll(path string) ([]*NodeProto, error) {
 	var result []*NodeProto
@@ -249,12 +275,12 @@ func List(rs []io.ReadCloser, pattern string, f func(string, *NodeProto) error)
 	if pattern == "" {
 		pattern = "/"
 	}
-	g, err := globlib.Compile(pattern, '/')
+	g, err := CompileGlob(pattern)
 	if err != nil {
-		return errorf(MalformedGlob, err.Error())
+		return err
 	}
 	return nodes(rs, func(path string, node *NodeProto) error {
-		if (g.Match(path) && node.DirNode == nil) || (g.Match(pathlib.Dir(path))) {
+		if (g.Match(path) && node.DirNode == nil) || (g.Match(pathlib.Dir(path)) && !g.Excludes(path)) {
 			return f(path, node)
 		}
 		return nil
@@ -270,23 +296,40 @@ func glob(tx *bolt.Tx, pattern string, f func(string, *NodeProto) error) error {
 		return f(externalDefault(pattern), node)
 	}
 
-	g, err := globlib.Compile(pattern, '/')
+	g, err := CompileGlob(pattern)
 	if err != nil {
-		return errorf(MalformedGlob, err.Error())
+		return err
 	}
-	c := fs(tx).Cursor()
-	for k, v := c.First(); k != nil; k, v = c.Next() {
-		if g.Match(s(k)) {
-			node := &NodeProto{}
-			if node.Unmarshal(v); err != nil {
-				return err
+	match := func(k, v []byte) error {
+		if !g.Match(s(k)) {
+			return nil
+		}
+		node := &NodeProto{}
+		if err := node.Unmarshal(v); err != nil {
+			return err
+		}
+		return f(externalDefault(s(k)), node)
+	}
+	// Only the subtree under the pattern's literal prefix can match it, plus
+	// the directory containing that prefix (e.g. "/data/**" matches "/data",
+	// which doesn't have the prefix "/data/")
+	prefix := GlobLiteralPrefix(pattern)
+	dir := prefix[:strings.LastIndex(prefix, "/")]
+	if v := fs(tx).Get(b(dir)); v != nil {
+		if err := match(b(dir), v); err != nil {
+			if err == errutil.ErrBreak {
+				return nil
 			}
-			if err := f(externalDefault(s(k)), node); err != nil {
-				if err == errutil.ErrBreak {
-					return nil
-				}
-				return err
+			return err
+		}
+	}
+	c := fs(tx).Cursor()
+	for k, v := c.Seek(b(prefix)); k != nil && bytes.HasPrefix(k, b(prefix)); k, v = c.Next() {
+		if err := match(k, v); err != nil {
+			if err == errutil.ErrBreak {
+				return nil
 			}
+			return err
 		}
 	}
 	return nil
@@ -303,9 +346,9 @@ func (h *dbHashTree) Glob(pattern string, f func(string, *NodeProto) error) erro
 // Glob executes a callback for each path that matches the glob pattern.
 func Glob(rs []io.ReadCloser, pattern string, f func(string, *NodeProto) error) (retErr error) {
 	pattern = clean(pattern)
-	g, err := globlib.Compile(pattern, '/')
+	g, err := CompileGlob(pattern)
 	if err != nil {
-		return errorf(MalformedGlob, err.Error())
+		return err
 	}
 	return nodes(rs, func(path string, node *NodeProto) error {
 		if g.Match(path) {
@@ -388,7 +431,7 @@ func diff(newTx, oldTx *bolt.Tx, newPath string, oldPath string, recursiveDepth
 	}
 	var newC *ChildCursor
 	if newNode != nil {
-		if newNode.FileNode != nil || recursiveDepth == 0 {
+		if newNode.DirNode == nil || recursiveDepth == 0 {
 			if err := f(newPath, newNode, true); err != nil {
 				return err
 			}
@@ -398,7 +441,7 @@ func diff(newTx, oldTx *bolt.Tx, newPath string, oldPath string, recursiveDepth
 	}
 	var oldC *ChildCursor
 	if oldNode != nil {
-		if oldNode.FileNode != nil || recursiveDepth == 0 {
+		if oldNode.DirNode == nil || recursiveDepth == 0 {
 			if err := f(oldPath, oldNode, false); err != nil {
 				return err
 			}
@@ -802,6 +845,10 @@ func (h *dbHashTree) putFile(path string, objects []*pfs.Object,
 		}
 		node.SubtreeSize += sizeDelta
 		node.FileNode.Objects = append(node.FileNode.Objects, objects...)
+		// The file's contents changed, so its checksums (if any) are stale.
+		// Callers that know the new checksums set them with PutFileChecksums.
+		node.FileNode.Sha256 = nil
+		node.FileNode.Md5 = nil
 		// Put the node
 		if err := put(tx, path, node); err != nil {
 			return err
@@ -818,6 +865,61 @@ func (h *dbHashTree) putFile(path string, objects []*pfs.Object,
 	})
 }
 
+// PutFileMetadata sets metadata on the file at 'path'.
+func (h *dbHashTree) PutFileMetadata(path string, metadata map[string]string) error {
+	return h.updateFileNode(path, "metadata", func(n *FileNodeProto) {
+		n.Metadata = mergeMetadata(n.Metadata, metadata)
+	})
+}
+
+// PutFileChecksums sets the checksums of the file at 'path'.
+func (h *dbHashTree) PutFileChecksums(path string, sha256 []byte, md5 []byte) error {
+	return h.updateFileNode(path, "checksums", func(n *FileNodeProto) {
+		n.Sha256 = sha256
+		n.Md5 = md5
+	})
+}
+
+// updateFileNode calls 'f' on the FileNodeProto of the file at 'path', which
+// must not change anything that's part of the file's hash. 'what' describes
+// the change, for errors.
+func (h *dbHashTree) updateFileNode(path string, what string, f func(n *FileNodeProto)) error {
+	path = clean(path)
+	return h.Batch(func(tx *bolt.Tx) error {
+		node, err := get(tx, path)
+		if err != nil {
+			return err
+		}
+		if node.nodetype() != file {
+			return errorf(PathConflict, "could not set %s on %q; a file of "+
+				"type %s is there", what, path, node.nodetype())
+		}
+		f(node.FileNode)
+		// The node's hash is unchanged, so unlike put() this doesn't mark it
+		// (or its parents) as needing to be rehashed
+		data, err := node.Marshal()
+		if err != nil {
+			return err
+		}
+		return fs(tx).Put(b(path), data)
+	})
+}
+
+// mergeMetadata returns the union of 'base' and 'metadata', preferring the
+// values in 'metadata'. 'base' may be modified.
+func mergeMetadata(base map[string]string, metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return base
+	}
+	if base == nil {
+		base = make(map[string]string)
+	}
+	for k, v := range metadata {
+		base[k] = v
+	}
+	return base
+}
+
 // PutDir creates a directory (or does nothing if one exists).
 func (h *dbHashTree) PutDir(path string) error {
 	path = clean(path)
@@ -852,6 +954,37 @@ func (h *dbHashTree) PutDir(path string) error {
 	})
 }
 
+// PutSymlink creates a symlink at 'path' that points to 'target' (or updates
+// the target of the symlink already there).
+func (h *dbHashTree) PutSymlink(path string, target string) error {
+	path = clean(path)
+	return h.Batch(func(tx *bolt.Tx) error {
+		node, err := get(tx, path)
+		if err != nil && Code(err) != PathNotFound {
+			return err
+		}
+		if node != nil && node.nodetype() != symlink {
+			return errorf(PathConflict, "could not create symlink at \"%s\"; a "+
+				"file of type %s is already there", path, node.nodetype())
+		}
+		node = &NodeProto{
+			Name:        base(path),
+			SymlinkNode: &SymlinkNodeProto{Target: target},
+		}
+		if err := put(tx, path, node); err != nil {
+			return err
+		}
+		return visit(tx, path, func(node *NodeProto, parent, child string) error {
+			if node.DirNode == nil {
+				// node created as part of this visit call, fill in the basics
+				node.Name = base(parent)
+				node.DirNode = &DirectoryNodeProto{}
+			}
+			return nil
+		})
+	})
+}
+
 // deleteDir deletes a directory and all the children under it
 func deleteDir(tx *bolt.Tx, path string) error {
 	c := fs(tx).Cursor()
@@ -1123,6 +1256,40 @@ func GetRangeFromIndex(r io.Reader, prefix string) (uint64, uint64, error) {
 	return lower, upper - lower, nil
 }
 
+// GetRangeFromIndexAfter is like GetRangeFromIndex, but the range begins at
+// (or shortly before) the node at 'after', which should be under 'prefix',
+// rather than at the start of the subtree. It's used to resume reading a
+// large directory.
+func GetRangeFromIndexAfter(r io.Reader, prefix string, after string) (uint64, uint64, error) {
+	prefixKey, afterKey := b(clean(prefix)), b(clean(after))
+	pbr := pbutil.NewReader(r)
+	var lower, upper uint64
+	for {
+		idx := &Index{}
+		if err := pbr.Read(idx); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, 0, err
+		}
+		if bytes.Compare(idx.K, afterKey) <= 0 {
+			// The node at 'after' is at or past this index entry
+			lower = idx.Offset
+			continue
+		}
+		if !bytes.HasPrefix(idx.K, prefixKey) {
+			// This index entry is past the end of the subtree
+			upper = idx.Offset
+			break
+		}
+	}
+	// Handles the case when at the end of the indexes
+	if upper <= 0 {
+		return lower, 0, nil
+	}
+	return lower, upper - lower, nil
+}
+
 // NewFilter creates a filter for a hashtree shard.
 func NewFilter(numTrees int64, tree int64) func(k []byte) (bool, error) {
 	return func(k []byte) (bool, error) {
@@ -1217,10 +1384,23 @@ func merge(ns []*MergeNode) (*MergeNode, error) {
 			return nil, errorf(PathConflict, "could not merge path \"%s\" "+
 				"which is a different type in different hashtrees", s(base.k))
 		}
+		// Symlinks can't be merged, but may appear in several trees if they
+		// agree on the target
+		if base.nodeProto.nodetype() == symlink &&
+			base.nodeProto.SymlinkNode.Target != n.nodeProto.SymlinkNode.Target {
+			return nil, errorf(PathConflict, "could not merge symlink \"%s\" "+
+				"which has different targets in different hashtrees", s(base.k))
+		}
 		// Merge file content
 		if base.nodeProto.nodetype() == file {
 			base.nodeProto.FileNode.BlockRefs = append(base.nodeProto.FileNode.BlockRefs, n.nodeProto.FileNode.BlockRefs...)
-
+			base.nodeProto.FileNode.Metadata = mergeMetadata(base.nodeProto.FileNode.Metadata, n.nodeProto.FileNode.Metadata)
+			// The checksums of the concatenated file aren't known
+			base.nodeProto.FileNode.Sha256 = nil
+			base.nodeProto.FileNode.Md5 = nil
+		}
+		if laterThan(n.nodeProto.Committed, base.nodeProto.Committed) {
+			base.nodeProto.Committed = n.nodeProto.Committed
 		}
 		hasher := pfs.NewHash()
 		hasher.Write(append(base.nodeProto.Hash, n.nodeProto.Hash...))
@@ -1230,6 +1410,15 @@ func merge(ns []*MergeNode) (*MergeNode, error) {
 	return base, nil
 }
 
+// laterThan returns true if 'a' is set and is after 'b' (or 'b' is unset).
+func laterThan(a, b *types.Timestamp) bool {
+	if a == nil {
+		return false
+	}
+	return b == nil || a.Seconds > b.Seconds ||
+		(a.Seconds == b.Seconds && a.Nanos > b.Nanos)
+}
+
 func (mq *mergePQ) fill() error {
 	// Save stream for re-insert
 	ns := mq.q[1]
@@ -1291,6 +1480,71 @@ func Merge(w *Writer, rs []*Reader) (uint64, error) {
 	return w.size, nil
 }
 
+// MergeDBHashTree merges serialized hashtrees (such as the chunks of an output
+// commit's tree) into a new database (bolt) backed hashtree, so that they can
+// be diffed against another tree.
+func MergeDBHashTree(storageRoot string, rs []io.ReadCloser) (_ HashTree, retErr error) {
+	result, err := NewDBHashTree(storageRoot)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			result.Destroy()
+		}
+	}()
+	mq := &mergePQ{q: make([]*nodeStream, len(rs)+1)}
+	for _, r := range rs {
+		if err := mq.insert(&nodeStream{r: NewReader(r, nil)}); err != nil {
+			return nil, err
+		}
+	}
+	batchSize := 10000
+	for mq.q[1] != nil {
+		if err := result.(*dbHashTree).Update(func(tx *bolt.Tx) error {
+			for count := 0; count < batchSize && mq.q[1] != nil; count++ {
+				ns, err := mq.next()
+				if err != nil {
+					return err
+				}
+				n, err := merge(ns)
+				if err != nil {
+					return err
+				}
+				if n.nodeProto != nil {
+					if n.v, err = n.nodeProto.Marshal(); err != nil {
+						return err
+					}
+				}
+				if err := fs(tx).Put(n.k, n.v); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// WriteDBHashTree writes the nodes of a database backed hashtree that pass
+// 'filter' (or all of them, if 'filter' is nil) to 'w', so that it can be
+// split into chunks like an output commit's tree.
+func WriteDBHashTree(h HashTree, w *Writer, filter func(k []byte) (bool, error)) error {
+	return h.(*dbHashTree).View(func(tx *bolt.Tx) error {
+		return fs(tx).ForEach(func(k, v []byte) error {
+			if filter != nil {
+				ok, err := filter(k)
+				if err != nil || !ok {
+					return err
+				}
+			}
+			return w.Write(&MergeNode{k: k, v: v})
+		})
+	})
+}
+
 func nodes(rs []io.ReadCloser, f func(path string, nodeProto *NodeProto) error) error {
 	mq := &mergePQ{q: make([]*nodeStream, len(rs)+1)}
 	// Setup first set of nodes
@@ -1336,6 +1590,14 @@ func HashFileNode(n *FileNodeProto) []byte {
 	return hash.Sum(nil)
 }
 
+// hashSymlinkNode computes the hash of a symlink node, which depends only on
+// its target
+func hashSymlinkNode(n *SymlinkNodeProto) []byte {
+	hash := sha256.New()
+	hash.Write([]byte("symlink:" + n.Target))
+	return hash.Sum(nil)
+}
+
 func canonicalize(tx *bolt.Tx, path string) error {
 	path = clean(path)
 	if !hasChanged(tx, path) {
@@ -1378,9 +1640,11 @@ func canonicalize(tx *bolt.Tx, path string) error {
 		n.Hash = hash.Sum(nil)
 	case file:
 		n.Hash = HashFileNode(n.FileNode)
+	case symlink:
+		n.Hash = hashSymlinkNode(n.SymlinkNode)
 	default:
 		return errorf(Internal,
-			"malformed file at \"%s\" is neither a file nor a directory", path)
+			"malformed file at \"%s\" is not a file, directory or symlink", path)
 	}
 
 	if err := put(tx, path, n); err != nil {
@@ -1389,6 +1653,28 @@ func canonicalize(tx *bolt.Tx, path string) error {
 	return changed(tx).Delete(b(path))
 }
 
+// SetCommitted sets the committed time of every node that has changed since
+// the last call to Hash(), including the ancestors of changed nodes.
+func (h *dbHashTree) SetCommitted(committed *types.Timestamp) error {
+	return h.Batch(func(tx *bolt.Tx) error {
+		return changed(tx).ForEach(func(k, _ []byte) error {
+			n, err := get(tx, s(k))
+			if err != nil {
+				if Code(err) == PathNotFound {
+					return nil // 'k' was deleted
+				}
+				return err
+			}
+			n.Committed = committed
+			data, err := n.Marshal()
+			if err != nil {
+				return err
+			}
+			return fs(tx).Put(k, data)
+		})
+	})
+}
+
 // Hash updates all of the hashes and node size metadata, it also checks
 // for conflicts.
 func (h *dbHashTree) Hash() error {
@@ -1403,17 +1689,20 @@ const (
 	none         nodetype = iota // No file is present at this point in the tree
 	directory                    // The file at this point in the tree is a directory
 	file                         // ... is a regular file
+	symlink                      // ... is a symlink
 	unrecognized                 // ... is an an unknown type
 )
 
 func (n *NodeProto) nodetype() nodetype {
 	switch {
-	case n == nil || (n.DirNode == nil && n.FileNode == nil):
+	case n == nil || (n.DirNode == nil && n.FileNode == nil && n.SymlinkNode == nil):
 		return none
 	case n.DirNode != nil:
 		return directory
 	case n.FileNode != nil:
 		return file
+	case n.SymlinkNode != nil:
+		return symlink
 	default:
 		return unrecognized
 	}
@@ -1427,6 +1716,8 @@ func (n nodetype) String() string {
 		return "directory"
 	case file:
 		return "file"
+	case symlink:
+		return "symlink"
 	default:
 		return "unknown"
 	}
@@ -1579,6 +1870,19 @@ func (d *ChildCursor) Next() ([]byte, []byte) {
 	return k, v
 }
 
+// SeekAfter moves the cursor to the first child whose name sorts after
+// 'name', and returns its key and value.
+func (d *ChildCursor) SeekAfter(name string) ([]byte, []byte) {
+	// Like in Next, appending 1 skips 'name' and all of its descendants
+	key := append(append(append([]byte{}, d.dir...), []byte(name)...), 1)
+	k, v := d.c.Seek(key)
+	if !bytes.HasPrefix(k, d.dir) {
+		k, v = nil, nil
+	}
+	d.k, d.v = k, v
+	return k, v
+}
+
 func compare(a, b *ChildCursor) int {
 	switch {
 	case a.k == nil && b.k == nil:
@@ -1672,6 +1976,18 @@ func (o *Ordered) PutFile(path string, hash []byte, size int64, fileNodeProto *F
 	o.putFile(path, nodeProto)
 }
 
+// PutSymlink puts a symlink in the hashtree.
+func (o *Ordered) PutSymlink(path string, target string) {
+	path = clean(path)
+	symlinkNodeProto := &SymlinkNodeProto{Target: target}
+	nodeProto := &NodeProto{
+		Name:        base(path),
+		Hash:        hashSymlinkNode(symlinkNodeProto),
+		SymlinkNode: symlinkNodeProto,
+	}
+	o.putFile(path, nodeProto)
+}
+
 func (o *Ordered) putFile(path string, nodeProto *NodeProto) {
 	path = join(o.root, path)
 	o.handleEndOfDirectory(path)
@@ -1696,6 +2012,13 @@ func (o *Ordered) handleEndOfDirectory(path string) {
 	}
 }
 
+// SetCommitted sets the committed time of every node in the hashtree.
+func (o *Ordered) SetCommitted(committed *types.Timestamp) {
+	for _, n := range o.fs {
+		n.nodeProto.Committed = committed
+	}
+}
+
 // Serialize serializes an ordered hashtree.
 func (o *Ordered) Serialize(_w io.Writer) error {
 	w := NewWriter(_w)
@@ -0,0 +1,277 @@
+package hashtree
+
+import (
+	"strings"
+
+	globlib "github.com/gobwas/glob"
+)
+
+// maxGlobExpansions is the maximum number of patterns that brace alternation
+// in a single glob pattern may expand to
+const maxGlobExpansions = 1024
+
+// GlobPattern is a compiled glob pattern. Patterns are matched against whole
+// paths, one path segment at a time, and support:
+//   - '*', '?', '[...]' and '[!...]' within a segment (see
+//     github.com/gobwas/glob), none of which match '/'
+//   - '**' as a whole segment, which matches any number (including zero) of
+//     directories, e.g. "/data/**/*.parquet" matches "/data/a.parquet" and
+//     "/data/2019/01/a.parquet". Elsewhere, '**' matches any string,
+//     including '/' (so "/**.parquet" also matches "/data/a.parquet")
+//   - brace alternation, e.g. "/{logs,metrics/**}/*.json", which may nest
+//   - exclusions, each introduced by a '!' outside of brackets and braces,
+//     e.g. "/**/*.parquet!/**/_tmp". A path that matches an exclusion, or that
+//     is under a directory that does, doesn't match the pattern. A pattern
+//     that starts with '!' matches every path that isn't excluded.
+//
+// '!' isn't allowed in paths (see ValidatePath), so it's never ambiguous.
+type GlobPattern struct {
+	include [][]globSegment
+	exclude [][]globSegment
+}
+
+// globSegment matches one segment of a path. If 'super' is set (i.e. the
+// segment is '**'), it matches any number of segments instead, and if 'spans'
+// is set (i.e. the segment contains '**'), it matches one or more segments
+// that 'g' matches when joined with '/'.
+type globSegment struct {
+	super bool
+	spans bool
+	g     globlib.Glob
+}
+
+// CompileGlob compiles 'pattern' (see GlobPattern). It returns a
+// MalformedGlob error if the pattern is invalid.
+func CompileGlob(pattern string) (*GlobPattern, error) {
+	parts, err := splitTopLevel(pattern, '!')
+	if err != nil {
+		return nil, err
+	}
+	result := &GlobPattern{}
+	include := parts[0]
+	if strings.Trim(include, "/") == "" && len(parts) > 1 {
+		// The pattern starts with an exclusion (possibly after a leading
+		// slash added by clean()), so include everything
+		include = "/**"
+	}
+	if result.include, err = compileAlternatives(include); err != nil {
+		return nil, err
+	}
+	for _, part := range parts[1:] {
+		exclude, err := compileAlternatives(part)
+		if err != nil {
+			return nil, err
+		}
+		result.exclude = append(result.exclude, exclude...)
+	}
+	return result, nil
+}
+
+// Match returns true if 'path' matches the pattern and isn't excluded.
+func (g *GlobPattern) Match(path string) bool {
+	segments := splitPath(path)
+	return matchAny(g.include, segments) && !g.excludes(segments)
+}
+
+// Excludes returns true if 'path', or one of the directories that it's under,
+// matches one of the pattern's exclusions.
+func (g *GlobPattern) Excludes(path string) bool {
+	return g.excludes(splitPath(path))
+}
+
+func (g *GlobPattern) excludes(segments []string) bool {
+	if len(g.exclude) == 0 {
+		return false
+	}
+	for i := 1; i <= len(segments); i++ {
+		if matchAny(g.exclude, segments[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitPath splits a path into its segments. The root has no segments.
+func splitPath(path string) []string {
+	path = clean(path)
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path[1:], "/")
+}
+
+func matchAny(patterns [][]globSegment, segments []string) bool {
+	for _, pattern := range patterns {
+		if matchSegments(pattern, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments returns true if 'pattern' matches all of 'segments'. The root
+// (no segments) is only matched by the empty pattern (i.e. "/").
+func matchSegments(pattern []globSegment, segments []string) bool {
+	if len(segments) == 0 {
+		return len(pattern) == 0
+	}
+	// m[j] is true if the segments of the pattern seen so far match
+	// segments[:j]
+	m := make([]bool, len(segments)+1)
+	m[0] = true
+	for _, seg := range pattern {
+		next := make([]bool, len(segments)+1)
+		for j := 0; j <= len(segments); j++ {
+			switch {
+			case seg.super:
+				next[j] = m[j] || (j > 0 && next[j-1])
+			case seg.spans:
+				for k := j - 1; k >= 0 && !next[j]; k-- {
+					next[j] = m[k] && seg.g.Match(strings.Join(segments[k:j], "/"))
+				}
+			default:
+				next[j] = j > 0 && m[j-1] && seg.g.Match(segments[j-1])
+			}
+		}
+		m = next
+	}
+	return m[len(segments)]
+}
+
+// compileAlternatives expands the brace alternation in 'pattern' and compiles
+// each of the resulting patterns.
+func compileAlternatives(pattern string) ([][]globSegment, error) {
+	expanded, err := expandBraces(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var result [][]globSegment
+	for _, p := range expanded {
+		var segments []globSegment
+		for _, s := range splitPath(p) {
+			if s == "**" {
+				if len(segments) > 0 && segments[len(segments)-1].super {
+					continue // "**/**" is the same as "**"
+				}
+				segments = append(segments, globSegment{super: true})
+				continue
+			}
+			g, err := globlib.Compile(s, '/')
+			if err != nil {
+				return nil, errorf(MalformedGlob, "invalid glob %q: %v", pattern, err)
+			}
+			segments = append(segments, globSegment{spans: strings.Contains(s, "**"), g: g})
+		}
+		result = append(result, segments)
+	}
+	return result, nil
+}
+
+// expandBraces returns the patterns that the brace alternation in 'pattern'
+// expands to, e.g. "/{a,b}/{c,d}" expands to "/a/c", "/a/d", "/b/c" and
+// "/b/d".
+func expandBraces(pattern string) ([]string, error) {
+	start, end, err := findBraces(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+	alternatives, err := splitTopLevel(pattern[start+1:end], ',')
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, alternative := range alternatives {
+		expanded, err := expandBraces(pattern[:start] + alternative + pattern[end+1:])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, expanded...)
+		if len(result) > maxGlobExpansions {
+			return nil, errorf(MalformedGlob, "invalid glob %q: braces expand to more than %d patterns", pattern, maxGlobExpansions)
+		}
+	}
+	return result, nil
+}
+
+// findBraces returns the positions of the first '{' in 'pattern' that isn't
+// escaped or in brackets, and of its matching '}'. It returns -1, -1 if there
+// is no such '{'.
+func findBraces(pattern string) (int, int, error) {
+	start, end, depth := -1, -1, 0
+	if err := scanGlob(pattern, func(i int, c byte, inBrackets bool) bool {
+		switch {
+		case inBrackets:
+		case c == '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+			if depth == 0 {
+				end = i
+				return true
+			}
+		}
+		return false
+	}); err != nil {
+		return 0, 0, err
+	}
+	if start >= 0 && end < 0 {
+		return 0, 0, errorf(MalformedGlob, "invalid glob %q: unmatched '{'", pattern)
+	}
+	return start, end, nil
+}
+
+// splitTopLevel splits 'pattern' at each 'sep' that isn't escaped or in
+// brackets or braces.
+func splitTopLevel(pattern string, sep byte) ([]string, error) {
+	var result []string
+	last, depth := 0, 0
+	if err := scanGlob(pattern, func(i int, c byte, inBrackets bool) bool {
+		switch {
+		case inBrackets:
+		case c == '{':
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+		case c == sep && depth == 0:
+			result = append(result, pattern[last:i])
+			last = i + 1
+		}
+		return false
+	}); err != nil {
+		return nil, err
+	}
+	return append(result, pattern[last:]), nil
+}
+
+// scanGlob calls 'f' with each unescaped character in 'pattern', along with
+// whether it's inside brackets (a character class), until 'f' returns true.
+// It returns a MalformedGlob error if a '[' is never closed.
+func scanGlob(pattern string, f func(i int, c byte, inBrackets bool) bool) error {
+	inBrackets := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '\\':
+			i++
+			continue
+		case inBrackets && c == ']':
+			inBrackets = false
+			continue
+		case !inBrackets && c == '[':
+			inBrackets = true
+		}
+		if f(i, c, inBrackets) {
+			return nil
+		}
+	}
+	if inBrackets {
+		return errorf(MalformedGlob, "invalid glob %q: unmatched '['", pattern)
+	}
+	return nil
+}
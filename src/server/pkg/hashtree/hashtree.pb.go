@@ -6,6 +6,7 @@ package hashtree // import "github.com/pachyderm/pachyderm/src/server/pkg/hashtr
 import proto "github.com/golang/protobuf/proto"
 import fmt "fmt"
 import math "math"
+import types "github.com/gogo/protobuf/types"
 import pfs "github.com/pachyderm/pachyderm/src/client/pfs"
 
 import io "io"
@@ -38,7 +39,16 @@ type FileNodeProto struct {
 	// block_refs/objects. Without this signal, all calls to pfs.GetFile() would
 	// need to check the parent directory's metadata before beginning to return
 	// the file's contents, which would be slow.)
-	HasHeaderFooter      bool     `protobuf:"varint,6,opt,name=has_header_footer,json=hasHeaderFooter,proto3" json:"has_header_footer,omitempty"`
+	HasHeaderFooter bool `protobuf:"varint,6,opt,name=has_header_footer,json=hasHeaderFooter,proto3" json:"has_header_footer,omitempty"`
+	// metadata is user-defined metadata about this file (e.g. its MIME type).
+	// Unlike the fields above, it isn't part of the file's hash.
+	Metadata map[string]string `protobuf:"bytes,7,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// sha256 and md5 are standard checksums of this file's contents (excluding
+	// any shared header or footer), or empty if they aren't known. Like
+	// metadata, they aren't part of the file's hash, and they're cleared
+	// whenever the file's contents change.
+	Sha256               []byte   `protobuf:"bytes,8,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	Md5                  []byte   `protobuf:"bytes,9,opt,name=md5,proto3" json:"md5,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -48,7 +58,7 @@ func (m *FileNodeProto) Reset()         { *m = FileNodeProto{} }
 func (m *FileNodeProto) String() string { return proto.CompactTextString(m) }
 func (*FileNodeProto) ProtoMessage()    {}
 func (*FileNodeProto) Descriptor() ([]byte, []int) {
-	return fileDescriptor_hashtree_7c4d1e2389fbd556, []int{0}
+	return fileDescriptor_hashtree_2a259a2d52a3fd09, []int{0}
 }
 func (m *FileNodeProto) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -98,6 +108,27 @@ func (m *FileNodeProto) GetHasHeaderFooter() bool {
 	return false
 }
 
+func (m *FileNodeProto) GetMetadata() map[string]string {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+func (m *FileNodeProto) GetSha256() []byte {
+	if m != nil {
+		return m.Sha256
+	}
+	return nil
+}
+
+func (m *FileNodeProto) GetMd5() []byte {
+	if m != nil {
+		return m.Md5
+	}
+	return nil
+}
+
 // Shared refers to data common to all direct children of a directory (i.e.
 // headers and footers)
 type Shared struct {
@@ -116,7 +147,7 @@ func (m *Shared) Reset()         { *m = Shared{} }
 func (m *Shared) String() string { return proto.CompactTextString(m) }
 func (*Shared) ProtoMessage()    {}
 func (*Shared) Descriptor() ([]byte, []int) {
-	return fileDescriptor_hashtree_7c4d1e2389fbd556, []int{1}
+	return fileDescriptor_hashtree_2a259a2d52a3fd09, []int{1}
 }
 func (m *Shared) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -194,7 +225,7 @@ func (m *DirectoryNodeProto) Reset()         { *m = DirectoryNodeProto{} }
 func (m *DirectoryNodeProto) String() string { return proto.CompactTextString(m) }
 func (*DirectoryNodeProto) ProtoMessage()    {}
 func (*DirectoryNodeProto) Descriptor() ([]byte, []int) {
-	return fileDescriptor_hashtree_7c4d1e2389fbd556, []int{2}
+	return fileDescriptor_hashtree_2a259a2d52a3fd09, []int{2}
 }
 func (m *DirectoryNodeProto) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -237,7 +268,60 @@ func (m *DirectoryNodeProto) GetShared() *Shared {
 	return nil
 }
 
-// NodeProto is a node in the file tree (either a file or a directory)
+// SymlinkNodeProto is a node corresponding to a symbolic link (which is also a
+// leaf node).
+type SymlinkNodeProto struct {
+	// target is the path that the link points to. Absolute targets are relative
+	// to the root of the tree that contains the link, and relative targets are
+	// relative to the directory that contains it. Targets are resolved lazily,
+	// so they don't need to exist.
+	Target               string   `protobuf:"bytes,1,opt,name=target,proto3" json:"target,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SymlinkNodeProto) Reset()         { *m = SymlinkNodeProto{} }
+func (m *SymlinkNodeProto) String() string { return proto.CompactTextString(m) }
+func (*SymlinkNodeProto) ProtoMessage()    {}
+func (*SymlinkNodeProto) Descriptor() ([]byte, []int) {
+	return fileDescriptor_hashtree_2a259a2d52a3fd09, []int{3}
+}
+func (m *SymlinkNodeProto) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *SymlinkNodeProto) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_SymlinkNodeProto.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (dst *SymlinkNodeProto) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SymlinkNodeProto.Merge(dst, src)
+}
+func (m *SymlinkNodeProto) XXX_Size() int {
+	return m.Size()
+}
+func (m *SymlinkNodeProto) XXX_DiscardUnknown() {
+	xxx_messageInfo_SymlinkNodeProto.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SymlinkNodeProto proto.InternalMessageInfo
+
+func (m *SymlinkNodeProto) GetTarget() string {
+	if m != nil {
+		return m.Target
+	}
+	return ""
+}
+
+// NodeProto is a node in the file tree (a file, a directory or a symlink)
 type NodeProto struct {
 	// Name is the name (not path) of the file/directory (e.g. /lib).
 	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
@@ -250,18 +334,23 @@ type NodeProto struct {
 	SubtreeSize int64 `protobuf:"varint,3,opt,name=subtree_size,json=subtreeSize,proto3" json:"subtree_size,omitempty"`
 	// Exactly one of the following fields must be set. The type of this node will
 	// be determined by which field is set.
-	FileNode             *FileNodeProto      `protobuf:"bytes,4,opt,name=file_node,json=fileNode,proto3" json:"file_node,omitempty"`
-	DirNode              *DirectoryNodeProto `protobuf:"bytes,5,opt,name=dir_node,json=dirNode,proto3" json:"dir_node,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
-	XXX_unrecognized     []byte              `json:"-"`
-	XXX_sizecache        int32               `json:"-"`
+	FileNode    *FileNodeProto      `protobuf:"bytes,4,opt,name=file_node,json=fileNode,proto3" json:"file_node,omitempty"`
+	DirNode     *DirectoryNodeProto `protobuf:"bytes,5,opt,name=dir_node,json=dirNode,proto3" json:"dir_node,omitempty"`
+	SymlinkNode *SymlinkNodeProto   `protobuf:"bytes,6,opt,name=symlink_node,json=symlinkNode,proto3" json:"symlink_node,omitempty"`
+	// committed is when this node (or, for a directory, anything under it) was
+	// last changed, or unset if that isn't known. Like metadata, it isn't part
+	// of the node's hash.
+	Committed            *types.Timestamp `protobuf:"bytes,7,opt,name=committed,proto3" json:"committed,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
+	XXX_unrecognized     []byte           `json:"-"`
+	XXX_sizecache        int32            `json:"-"`
 }
 
 func (m *NodeProto) Reset()         { *m = NodeProto{} }
 func (m *NodeProto) String() string { return proto.CompactTextString(m) }
 func (*NodeProto) ProtoMessage()    {}
 func (*NodeProto) Descriptor() ([]byte, []int) {
-	return fileDescriptor_hashtree_7c4d1e2389fbd556, []int{3}
+	return fileDescriptor_hashtree_2a259a2d52a3fd09, []int{4}
 }
 func (m *NodeProto) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -325,6 +414,20 @@ func (m *NodeProto) GetDirNode() *DirectoryNodeProto {
 	return nil
 }
 
+func (m *NodeProto) GetSymlinkNode() *SymlinkNodeProto {
+	if m != nil {
+		return m.SymlinkNode
+	}
+	return nil
+}
+
+func (m *NodeProto) GetCommitted() *types.Timestamp {
+	if m != nil {
+		return m.Committed
+	}
+	return nil
+}
+
 // HashTreeProto is a tree corresponding to the complete file contents of a
 // pachyderm repo at a given commit (based on a Merkle Tree). We store one
 // HashTree for every PFS commit.
@@ -350,7 +453,7 @@ func (m *HashTreeProto) Reset()         { *m = HashTreeProto{} }
 func (m *HashTreeProto) String() string { return proto.CompactTextString(m) }
 func (*HashTreeProto) ProtoMessage()    {}
 func (*HashTreeProto) Descriptor() ([]byte, []int) {
-	return fileDescriptor_hashtree_7c4d1e2389fbd556, []int{4}
+	return fileDescriptor_hashtree_2a259a2d52a3fd09, []int{5}
 }
 func (m *HashTreeProto) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -404,7 +507,7 @@ func (m *BucketHeader) Reset()         { *m = BucketHeader{} }
 func (m *BucketHeader) String() string { return proto.CompactTextString(m) }
 func (*BucketHeader) ProtoMessage()    {}
 func (*BucketHeader) Descriptor() ([]byte, []int) {
-	return fileDescriptor_hashtree_7c4d1e2389fbd556, []int{5}
+	return fileDescriptor_hashtree_2a259a2d52a3fd09, []int{6}
 }
 func (m *BucketHeader) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -452,7 +555,7 @@ func (m *Index) Reset()         { *m = Index{} }
 func (m *Index) String() string { return proto.CompactTextString(m) }
 func (*Index) ProtoMessage()    {}
 func (*Index) Descriptor() ([]byte, []int) {
-	return fileDescriptor_hashtree_7c4d1e2389fbd556, []int{6}
+	return fileDescriptor_hashtree_2a259a2d52a3fd09, []int{7}
 }
 func (m *Index) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -497,8 +600,10 @@ func (m *Index) GetOffset() uint64 {
 
 func init() {
 	proto.RegisterType((*FileNodeProto)(nil), "hashtree.FileNodeProto")
+	proto.RegisterMapType((map[string]string)(nil), "hashtree.FileNodeProto.MetadataEntry")
 	proto.RegisterType((*Shared)(nil), "hashtree.Shared")
 	proto.RegisterType((*DirectoryNodeProto)(nil), "hashtree.DirectoryNodeProto")
+	proto.RegisterType((*SymlinkNodeProto)(nil), "hashtree.SymlinkNodeProto")
 	proto.RegisterType((*NodeProto)(nil), "hashtree.NodeProto")
 	proto.RegisterType((*HashTreeProto)(nil), "hashtree.HashTreeProto")
 	proto.RegisterMapType((map[string]*NodeProto)(nil), "hashtree.HashTreeProto.FsEntry")
@@ -554,6 +659,35 @@ func (m *FileNodeProto) MarshalTo(dAtA []byte) (int, error) {
 		}
 		i++
 	}
+	if len(m.Metadata) > 0 {
+		for k, _ := range m.Metadata {
+			dAtA[i] = 0x3a
+			i++
+			v := m.Metadata[k]
+			mapSize := 1 + len(k) + sovHashtree(uint64(len(k))) + 1 + len(v) + sovHashtree(uint64(len(v)))
+			i = encodeVarintHashtree(dAtA, i, uint64(mapSize))
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintHashtree(dAtA, i, uint64(len(k)))
+			i += copy(dAtA[i:], k)
+			dAtA[i] = 0x12
+			i++
+			i = encodeVarintHashtree(dAtA, i, uint64(len(v)))
+			i += copy(dAtA[i:], v)
+		}
+	}
+	if len(m.Sha256) > 0 {
+		dAtA[i] = 0x42
+		i++
+		i = encodeVarintHashtree(dAtA, i, uint64(len(m.Sha256)))
+		i += copy(dAtA[i:], m.Sha256)
+	}
+	if len(m.Md5) > 0 {
+		dAtA[i] = 0x4a
+		i++
+		i = encodeVarintHashtree(dAtA, i, uint64(len(m.Md5)))
+		i += copy(dAtA[i:], m.Md5)
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
@@ -657,6 +791,33 @@ func (m *DirectoryNodeProto) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
+func (m *SymlinkNodeProto) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SymlinkNodeProto) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Target) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintHashtree(dAtA, i, uint64(len(m.Target)))
+		i += copy(dAtA[i:], m.Target)
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
 func (m *NodeProto) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -709,6 +870,26 @@ func (m *NodeProto) MarshalTo(dAtA []byte) (int, error) {
 		}
 		i += n5
 	}
+	if m.SymlinkNode != nil {
+		dAtA[i] = 0x32
+		i++
+		i = encodeVarintHashtree(dAtA, i, uint64(m.SymlinkNode.Size()))
+		n6, err := m.SymlinkNode.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n6
+	}
+	if m.Committed != nil {
+		dAtA[i] = 0x3a
+		i++
+		i = encodeVarintHashtree(dAtA, i, uint64(m.Committed.Size()))
+		n8, err := m.Committed.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n8
+	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
 	}
@@ -755,11 +936,11 @@ func (m *HashTreeProto) MarshalTo(dAtA []byte) (int, error) {
 				dAtA[i] = 0x12
 				i++
 				i = encodeVarintHashtree(dAtA, i, uint64(v.Size()))
-				n6, err := v.MarshalTo(dAtA[i:])
+				n7, err := v.MarshalTo(dAtA[i:])
 				if err != nil {
 					return 0, err
 				}
-				i += n6
+				i += n7
 			}
 		}
 	}
@@ -858,6 +1039,22 @@ func (m *FileNodeProto) Size() (n int) {
 	if m.HasHeaderFooter {
 		n += 2
 	}
+	if len(m.Metadata) > 0 {
+		for k, v := range m.Metadata {
+			_ = k
+			_ = v
+			mapEntrySize := 1 + len(k) + sovHashtree(uint64(len(k))) + 1 + len(v) + sovHashtree(uint64(len(v)))
+			n += mapEntrySize + 1 + sovHashtree(uint64(mapEntrySize))
+		}
+	}
+	l = len(m.Sha256)
+	if l > 0 {
+		n += 1 + l + sovHashtree(uint64(l))
+	}
+	l = len(m.Md5)
+	if l > 0 {
+		n += 1 + l + sovHashtree(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -912,6 +1109,22 @@ func (m *DirectoryNodeProto) Size() (n int) {
 	return n
 }
 
+func (m *SymlinkNodeProto) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Target)
+	if l > 0 {
+		n += 1 + l + sovHashtree(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
 func (m *NodeProto) Size() (n int) {
 	if m == nil {
 		return 0
@@ -937,6 +1150,14 @@ func (m *NodeProto) Size() (n int) {
 		l = m.DirNode.Size()
 		n += 1 + l + sovHashtree(uint64(l))
 	}
+	if m.SymlinkNode != nil {
+		l = m.SymlinkNode.Size()
+		n += 1 + l + sovHashtree(uint64(l))
+	}
+	if m.Committed != nil {
+		l = m.Committed.Size()
+		n += 1 + l + sovHashtree(uint64(l))
+	}
 	if m.XXX_unrecognized != nil {
 		n += len(m.XXX_unrecognized)
 	}
@@ -1130,6 +1351,186 @@ func (m *FileNodeProto) Unmarshal(dAtA []byte) error {
 				}
 			}
 			m.HasHeaderFooter = bool(v != 0)
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Metadata", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHashtree
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthHashtree
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Metadata == nil {
+				m.Metadata = make(map[string]string)
+			}
+			var mapkey string
+			var mapvalue string
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowHashtree
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= (uint64(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowHashtree
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= (uint64(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthHashtree
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					var stringLenmapvalue uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowHashtree
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapvalue |= (uint64(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapvalue := int(stringLenmapvalue)
+					if intStringLenmapvalue < 0 {
+						return ErrInvalidLengthHashtree
+					}
+					postStringIndexmapvalue := iNdEx + intStringLenmapvalue
+					if postStringIndexmapvalue > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapvalue = string(dAtA[iNdEx:postStringIndexmapvalue])
+					iNdEx = postStringIndexmapvalue
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipHashtree(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if skippy < 0 {
+						return ErrInvalidLengthHashtree
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.Metadata[mapkey] = mapvalue
+			iNdEx = postIndex
+		case 8:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Sha256", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHashtree
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthHashtree
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Sha256 = append(m.Sha256[:0], dAtA[iNdEx:postIndex]...)
+			if m.Sha256 == nil {
+				m.Sha256 = []byte{}
+			}
+			iNdEx = postIndex
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Md5", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHashtree
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthHashtree
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Md5 = append(m.Md5[:0], dAtA[iNdEx:postIndex]...)
+			if m.Md5 == nil {
+				m.Md5 = []byte{}
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipHashtree(dAtA[iNdEx:])
@@ -1420,6 +1821,86 @@ func (m *DirectoryNodeProto) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *SymlinkNodeProto) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowHashtree
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SymlinkNodeProto: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SymlinkNodeProto: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Target", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHashtree
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthHashtree
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Target = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipHashtree(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthHashtree
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func (m *NodeProto) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
@@ -1594,6 +2075,72 @@ func (m *NodeProto) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SymlinkNode", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHashtree
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthHashtree
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.SymlinkNode == nil {
+				m.SymlinkNode = &SymlinkNodeProto{}
+			}
+			if err := m.SymlinkNode.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 7:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Committed", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowHashtree
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthHashtree
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Committed == nil {
+				m.Committed = &types.Timestamp{}
+			}
+			if err := m.Committed.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipHashtree(dAtA[iNdEx:])
@@ -2096,46 +2643,56 @@ var (
 )
 
 func init() {
-	proto.RegisterFile("server/pkg/hashtree/hashtree.proto", fileDescriptor_hashtree_7c4d1e2389fbd556)
-}
-
-var fileDescriptor_hashtree_7c4d1e2389fbd556 = []byte{
-	// 582 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x53, 0x4d, 0x6f, 0xd3, 0x40,
-	0x10, 0x65, 0x6d, 0x27, 0x71, 0x26, 0xa9, 0x08, 0x0b, 0x02, 0xab, 0x42, 0x69, 0x30, 0x02, 0x05,
-	0x04, 0x89, 0x54, 0x10, 0x20, 0x8e, 0x15, 0x54, 0x25, 0x07, 0x40, 0x5b, 0x4e, 0x5c, 0x22, 0x7f,
-	0x8c, 0x6b, 0x13, 0xd7, 0x1b, 0xed, 0x3a, 0x15, 0xe9, 0xef, 0xe0, 0xc0, 0x99, 0x03, 0xff, 0x04,
-	0x89, 0x23, 0x3f, 0x01, 0x95, 0x3f, 0x82, 0xf6, 0xa3, 0x75, 0x0b, 0x3d, 0x44, 0x9a, 0xf7, 0xe6,
-	0xcd, 0xf3, 0xbc, 0xcd, 0x2e, 0x84, 0x12, 0xc5, 0x11, 0x8a, 0xe9, 0x72, 0x71, 0x30, 0xcd, 0x23,
-	0x99, 0xd7, 0x02, 0xf1, 0xac, 0x98, 0x2c, 0x05, 0xaf, 0x39, 0xf5, 0x4f, 0xf1, 0xe6, 0x8d, 0xa4,
-	0x2c, 0xb0, 0xaa, 0xa7, 0xcb, 0x4c, 0xaa, 0x9f, 0xe9, 0x87, 0xdf, 0x08, 0x6c, 0xec, 0x16, 0x25,
-	0xbe, 0xe5, 0x29, 0xbe, 0xd7, 0x13, 0xf7, 0xa0, 0xc3, 0xe3, 0x4f, 0x98, 0xd4, 0x32, 0xf0, 0x46,
-	0xee, 0xb8, 0xb7, 0xdd, 0x9b, 0x28, 0xf9, 0x3b, 0xcd, 0xb1, 0xd3, 0x1e, 0x7d, 0x04, 0x10, 0x97,
-	0x3c, 0x59, 0xcc, 0x05, 0x66, 0x32, 0x68, 0x69, 0xe5, 0x86, 0x56, 0xee, 0x28, 0x9a, 0x61, 0xc6,
-	0xba, 0xb1, 0xad, 0x24, 0x7d, 0x08, 0xd7, 0xf2, 0x48, 0xce, 0x73, 0x8c, 0x52, 0x14, 0xf3, 0x8c,
-	0xf3, 0x1a, 0x45, 0xd0, 0x1e, 0x91, 0xb1, 0xcf, 0xae, 0xe6, 0x91, 0xdc, 0xd3, 0xfc, 0xae, 0xa6,
-	0x67, 0x9e, 0x4f, 0x06, 0xce, 0xcc, 0xf3, 0x9d, 0x81, 0x3b, 0xf3, 0x7c, 0x77, 0xe0, 0x85, 0x5f,
-	0x08, 0xb4, 0xf7, 0xf3, 0x48, 0x60, 0x4a, 0xef, 0x42, 0xdb, 0x98, 0x04, 0x64, 0x44, 0xfe, 0x5d,
-	0xce, 0xb6, 0x94, 0xc8, 0x7e, 0xc2, 0xb9, 0x44, 0x64, 0x5a, 0x74, 0x0b, 0x7a, 0x76, 0x1d, 0x59,
-	0x1c, 0x63, 0xe0, 0x8e, 0xc8, 0xd8, 0x65, 0x60, 0xa8, 0xfd, 0xe2, 0x18, 0x95, 0xc0, 0x48, 0x8d,
-	0xc0, 0x33, 0x02, 0x43, 0x29, 0x41, 0x98, 0x01, 0x7d, 0x55, 0x08, 0x4c, 0x6a, 0x2e, 0xd6, 0xcd,
-	0xf9, 0x6d, 0x82, 0x9f, 0xe4, 0x45, 0x99, 0x0a, 0xac, 0x02, 0x77, 0xe4, 0x8e, 0xbb, 0xec, 0x0c,
-	0xd3, 0x31, 0xb4, 0xa5, 0xce, 0xa1, 0xdd, 0x7a, 0xdb, 0x83, 0xc9, 0xd9, 0xdf, 0x65, 0xf2, 0x31,
-	0xdb, 0x3f, 0x7f, 0x08, 0xe1, 0x0f, 0x02, 0xdd, 0xc6, 0x9f, 0x82, 0x57, 0x45, 0x87, 0xa8, 0xf3,
-	0x77, 0x99, 0xae, 0x15, 0xa7, 0x8c, 0x74, 0xdc, 0x3e, 0xd3, 0x35, 0xbd, 0x03, 0x7d, 0xb9, 0x8a,
-	0x95, 0xf7, 0xf9, 0x80, 0x3d, 0xcb, 0xe9, 0x84, 0x4f, 0xa1, 0x9b, 0x15, 0x25, 0xce, 0x2b, 0x9e,
-	0xa2, 0xdd, 0xe8, 0x56, 0xb3, 0xd1, 0x85, 0x6b, 0xc1, 0xfc, 0xcc, 0x42, 0xfa, 0x1c, 0xfc, 0xb4,
-	0x10, 0x66, 0xa8, 0xa5, 0x87, 0x6e, 0x37, 0x43, 0xff, 0x1f, 0x08, 0xeb, 0xa4, 0x85, 0x50, 0x28,
-	0xfc, 0x4e, 0x60, 0x63, 0x2f, 0x92, 0xf9, 0x07, 0x81, 0x36, 0x4b, 0x00, 0x9d, 0x23, 0x14, 0xb2,
-	0xe0, 0x95, 0x8e, 0xd3, 0x62, 0xa7, 0x90, 0x4e, 0xc1, 0xc9, 0x64, 0xe0, 0xe8, 0x6b, 0xb5, 0xd5,
-	0xd8, 0x5f, 0x18, 0x9f, 0xec, 0xca, 0xd7, 0x55, 0x2d, 0xd6, 0xcc, 0xc9, 0xe4, 0xe6, 0x0c, 0x3a,
-	0x16, 0xd2, 0x01, 0xb8, 0x0b, 0x5c, 0xdb, 0x03, 0x52, 0x25, 0x7d, 0x00, 0xad, 0xa3, 0xa8, 0x5c,
-	0xa1, 0xbd, 0x0f, 0xd7, 0x1b, 0xc3, 0x66, 0x4d, 0xa3, 0x78, 0xe9, 0xbc, 0x20, 0xe1, 0x7d, 0xe8,
-	0xef, 0xac, 0x92, 0x05, 0xd6, 0xe6, 0x5e, 0xd2, 0x9b, 0xd0, 0x8e, 0x35, 0xb6, 0x9e, 0x16, 0x85,
-	0x8f, 0xa1, 0xf5, 0xa6, 0x4a, 0xf1, 0x33, 0xed, 0x03, 0x59, 0xe8, 0x5e, 0x9f, 0x91, 0x85, 0x92,
-	0xf3, 0x2c, 0x93, 0x58, 0xeb, 0xcf, 0x79, 0xcc, 0xa2, 0x9d, 0xbd, 0x9f, 0x27, 0x43, 0xf2, 0xeb,
-	0x64, 0x48, 0x7e, 0x9f, 0x0c, 0xc9, 0xd7, 0x3f, 0xc3, 0x2b, 0x1f, 0x9f, 0x1d, 0x14, 0x75, 0xbe,
-	0x8a, 0x27, 0x09, 0x3f, 0x9c, 0x2e, 0xa3, 0x24, 0x5f, 0xa7, 0x28, 0xce, 0x57, 0x52, 0x24, 0xd3,
-	0x4b, 0x1e, 0x79, 0xdc, 0xd6, 0x8f, 0xf7, 0xc9, 0xdf, 0x00, 0x00, 0x00, 0xff, 0xff, 0x5c, 0x3f,
-	0xaa, 0x91, 0x02, 0x04, 0x00, 0x00,
+	proto.RegisterFile("server/pkg/hashtree/hashtree.proto", fileDescriptor_hashtree_2a259a2d52a3fd09)
+}
+
+var fileDescriptor_hashtree_2a259a2d52a3fd09 = []byte{
+	// 738 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x54, 0xcd, 0x8e, 0xe3, 0x44,
+	0x10, 0xc6, 0x3f, 0x49, 0x9c, 0x4a, 0x22, 0x42, 0xb3, 0x5a, 0xac, 0x08, 0x65, 0x82, 0xd1, 0xa2,
+	0xb0, 0x02, 0x5b, 0x0a, 0xec, 0xb2, 0x02, 0x71, 0x60, 0x04, 0xd1, 0x10, 0x89, 0x1f, 0xf5, 0xec,
+	0x89, 0x4b, 0xe4, 0x9f, 0x72, 0x6c, 0xe2, 0x9f, 0xa8, 0xbb, 0x33, 0x22, 0xfb, 0x1c, 0x1c, 0x78,
+	0x02, 0x1e, 0x83, 0x33, 0x07, 0x0e, 0x3c, 0x02, 0x1a, 0x5e, 0x04, 0xb9, 0xbb, 0x13, 0x67, 0x96,
+	0x99, 0x43, 0xa4, 0xfa, 0xbe, 0xfa, 0xaa, 0xba, 0xeb, 0x73, 0x57, 0xc0, 0xe3, 0xc8, 0x6e, 0x90,
+	0x05, 0xbb, 0xed, 0x26, 0xc8, 0x42, 0x9e, 0x09, 0x86, 0x78, 0x0a, 0xfc, 0x1d, 0xab, 0x45, 0x4d,
+	0x9c, 0x23, 0x9e, 0x3c, 0x8a, 0x8b, 0x1c, 0x2b, 0x11, 0xec, 0x52, 0xde, 0xfc, 0x54, 0x7e, 0x72,
+	0xb1, 0xa9, 0xeb, 0x4d, 0x81, 0x81, 0x44, 0xd1, 0x3e, 0x0d, 0x44, 0x5e, 0x22, 0x17, 0x61, 0xb9,
+	0x53, 0x02, 0xef, 0x2f, 0x13, 0x46, 0xcb, 0xbc, 0xc0, 0xef, 0xeb, 0x04, 0x7f, 0x94, 0x2d, 0x9f,
+	0x40, 0xaf, 0x8e, 0x7e, 0xc6, 0x58, 0x70, 0xd7, 0x9e, 0x59, 0xf3, 0xc1, 0x62, 0xe0, 0x37, 0xfd,
+	0x7e, 0x90, 0x1c, 0x3d, 0xe6, 0xc8, 0x47, 0x00, 0x51, 0x51, 0xc7, 0xdb, 0x35, 0xc3, 0x94, 0xbb,
+	0x1d, 0xa9, 0x1c, 0x49, 0xe5, 0x65, 0x43, 0x53, 0x4c, 0x69, 0x3f, 0xd2, 0x11, 0x27, 0x4f, 0xe1,
+	0xad, 0x2c, 0xe4, 0xeb, 0x0c, 0xc3, 0x04, 0xd9, 0x3a, 0xad, 0x6b, 0x81, 0xcc, 0xed, 0xce, 0x8c,
+	0xb9, 0x43, 0xdf, 0xcc, 0x42, 0x7e, 0x25, 0xf9, 0xa5, 0xa4, 0xc9, 0x57, 0xe0, 0x94, 0x28, 0xc2,
+	0x24, 0x14, 0xa1, 0xdb, 0x93, 0x7d, 0x9f, 0xf8, 0xa7, 0xb1, 0xef, 0xdc, 0xd5, 0xff, 0x4e, 0xeb,
+	0xbe, 0xa9, 0x04, 0x3b, 0xd0, 0x53, 0x19, 0x79, 0x0c, 0x5d, 0x9e, 0x85, 0x8b, 0x67, 0xcf, 0x5d,
+	0x67, 0x66, 0xcc, 0x87, 0x54, 0x23, 0x32, 0x06, 0xab, 0x4c, 0x9e, 0xb9, 0x7d, 0x49, 0x36, 0xe1,
+	0xe4, 0x0b, 0x18, 0xdd, 0x69, 0xd2, 0x48, 0xb6, 0x78, 0x70, 0x8d, 0x99, 0x31, 0xef, 0xd3, 0x26,
+	0x24, 0x8f, 0xa0, 0x73, 0x13, 0x16, 0x7b, 0x74, 0x4d, 0xc9, 0x29, 0xf0, 0xb9, 0xf9, 0xc2, 0x58,
+	0xd9, 0x8e, 0x31, 0x36, 0x57, 0xb6, 0x63, 0x8e, 0xad, 0x95, 0xed, 0x58, 0x63, 0xdb, 0xfb, 0xd5,
+	0x80, 0xee, 0x75, 0x16, 0x32, 0x4c, 0xc8, 0xfb, 0xd0, 0x55, 0xe3, 0xca, 0x5e, 0xaf, 0xd9, 0xa8,
+	0x53, 0x8d, 0x48, 0x9b, 0x61, 0xde, 0x23, 0x52, 0x29, 0x72, 0x01, 0x03, 0x6d, 0x1c, 0xcf, 0x5f,
+	0xa1, 0x6b, 0xcd, 0x8c, 0xb9, 0x45, 0x41, 0x51, 0xd7, 0xf9, 0x2b, 0x6c, 0x04, 0x4a, 0xaa, 0x04,
+	0xb6, 0x12, 0x28, 0xaa, 0x11, 0x78, 0x29, 0x90, 0xaf, 0x73, 0x86, 0xb1, 0xa8, 0xd9, 0xa1, 0xfd,
+	0xd2, 0x13, 0x70, 0xe2, 0x2c, 0x2f, 0x12, 0x86, 0x95, 0x6b, 0xcd, 0xac, 0x79, 0x9f, 0x9e, 0x30,
+	0x99, 0x4b, 0x07, 0x19, 0x26, 0xb2, 0xdb, 0x60, 0x31, 0x6e, 0x3f, 0x81, 0x9a, 0x8f, 0xea, 0xfc,
+	0xb9, 0x09, 0xde, 0x53, 0x18, 0x5f, 0x1f, 0xca, 0x22, 0xaf, 0xb6, 0xed, 0x29, 0x8f, 0xa1, 0x2b,
+	0x42, 0xb6, 0x41, 0xa1, 0x3d, 0xd5, 0xc8, 0xfb, 0xc3, 0x84, 0x7e, 0xab, 0x22, 0x60, 0x57, 0x61,
+	0x89, 0x5a, 0x23, 0xe3, 0x86, 0x6b, 0x0e, 0x95, 0xd6, 0x0c, 0xa9, 0x8c, 0xc9, 0x7b, 0x30, 0xe4,
+	0xfb, 0xa8, 0xb9, 0xc7, 0xb9, 0x19, 0x03, 0xcd, 0x49, 0x37, 0x3e, 0x85, 0x7e, 0x9a, 0x17, 0xb8,
+	0xae, 0xea, 0x04, 0xf5, 0xed, 0xdf, 0x79, 0xe0, 0x01, 0x51, 0x27, 0xd5, 0x90, 0x7c, 0x06, 0x4e,
+	0x92, 0x33, 0x55, 0xd4, 0x91, 0x45, 0xef, 0xb6, 0x45, 0xff, 0x37, 0x8f, 0xf6, 0x92, 0x9c, 0xc9,
+	0xc2, 0x2f, 0x61, 0xc8, 0xd5, 0xcc, 0xaa, 0xb8, 0x2b, 0x8b, 0x27, 0x67, 0x7e, 0xbd, 0xe6, 0x08,
+	0x1d, 0xf0, 0x96, 0x21, 0x2f, 0xa0, 0x1f, 0xd7, 0x65, 0x99, 0x0b, 0x81, 0x89, 0xdb, 0xd3, 0xb5,
+	0x6a, 0x6b, 0xfd, 0xe3, 0xd6, 0xfa, 0x2f, 0x8f, 0x5b, 0x4b, 0x5b, 0xb1, 0xf7, 0xbb, 0x01, 0xa3,
+	0xab, 0x90, 0x67, 0x2f, 0x19, 0x6a, 0x13, 0x5d, 0xe8, 0xdd, 0x20, 0xe3, 0x79, 0x5d, 0x49, 0x1f,
+	0x3b, 0xf4, 0x08, 0x49, 0x00, 0x66, 0xca, 0x5d, 0x53, 0x6e, 0xd3, 0x45, 0x7b, 0xb5, 0x3b, 0xe5,
+	0xfe, 0x92, 0xab, 0x3d, 0x32, 0x53, 0x3e, 0x59, 0x41, 0x6f, 0xc9, 0x1f, 0xda, 0x88, 0x0f, 0xcf,
+	0x37, 0x62, 0xb0, 0x78, 0xbb, 0x6d, 0xd8, 0x0e, 0xd9, 0xae, 0x89, 0xf7, 0x01, 0x0c, 0x2f, 0xf7,
+	0xf1, 0x16, 0x85, 0x5a, 0xf3, 0xe6, 0x45, 0x44, 0x12, 0x1f, 0x5f, 0x84, 0x42, 0xde, 0xc7, 0xd0,
+	0xf9, 0xb6, 0x4a, 0xf0, 0x17, 0x32, 0x04, 0x63, 0x2b, 0x73, 0x43, 0x6a, 0x6c, 0x1b, 0x79, 0x9d,
+	0xa6, 0x1c, 0x85, 0x3c, 0xce, 0xa6, 0x1a, 0x5d, 0x5e, 0xfd, 0x79, 0x3b, 0x35, 0xfe, 0xbe, 0x9d,
+	0x1a, 0xff, 0xdc, 0x4e, 0x8d, 0xdf, 0xfe, 0x9d, 0xbe, 0xf1, 0xd3, 0xf3, 0x4d, 0x2e, 0xb2, 0x7d,
+	0xe4, 0xc7, 0x75, 0x19, 0xec, 0xc2, 0x38, 0x3b, 0x24, 0xc8, 0xce, 0x23, 0xce, 0xe2, 0xe0, 0x9e,
+	0x3f, 0xd5, 0xa8, 0x2b, 0x8d, 0xfe, 0xe4, 0xbf, 0x01, 0x00, 0x8e, 0x65, 0x60, 0xd0, 0x72, 0x05,
+	0x00, 0x00,
 }
@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"testing"
 
 	bolt "github.com/coreos/bbolt"
+	"github.com/gogo/protobuf/types"
 	"github.com/golang/protobuf/proto"
 	"github.com/pachyderm/pachyderm/src/client/pfs"
+	"github.com/pachyderm/pachyderm/src/client/pkg/pbutil"
 	"github.com/pachyderm/pachyderm/src/client/pkg/require"
 )
 
@@ -39,6 +43,7 @@ func TestFmtNodeType(t *testing.T) {
 	require.Equal(t, "directory", fmt.Sprintf("%s", directory))
 	require.Equal(t, "file", fmt.Sprintf("%s", file))
 	require.Equal(t, "none", fmt.Sprintf("%s", none))
+	require.Equal(t, "symlink", fmt.Sprintf("%s", symlink))
 	require.Equal(t, "unknown", fmt.Sprintf("%s", unrecognized))
 }
 
@@ -458,6 +463,140 @@ func TestGlobFile(t *testing.T) {
 	}
 }
 
+func TestGlobPattern(t *testing.T) {
+	for pattern, expected := range map[string]map[string]bool{
+		"/data/**/*.parquet": {
+			"/data/a.parquet":          true,
+			"/data/2019/01/a.parquet":  true,
+			"/data/2019/01/a.csv":      false,
+			"/other/2019/01/a.parquet": false,
+			"/data":                    false,
+		},
+		"/**": {
+			"/a":     true,
+			"/a/b/c": true,
+			"/":      false,
+		},
+		"/{logs,metrics/**}/*.json": {
+			"/logs/a.json":       true,
+			"/metrics/a.json":    true,
+			"/metrics/x/a.json":  true,
+			"/other/a.json":      false,
+			"/logs/x/a.json":     false,
+			"/logs/a.json.gz":    false,
+			"/metrics/x/a.jsonl": false,
+		},
+		"/{a,b{1,2}}/*": {
+			"/a/x":  true,
+			"/b1/x": true,
+			"/b2/x": true,
+			"/b/x":  false,
+		},
+		"/**/*.parquet!/**/_tmp": {
+			"/a.parquet":          true,
+			"/x/a.parquet":        true,
+			"/x/_tmp/a.parquet":   false,
+			"/_tmp/y/z/a.parquet": false,
+			"/x/_tmp2/a.parquet":  true,
+		},
+		"!/*/*.log!/secret": {
+			"/a":            true,
+			"/a/b":          true,
+			"/a/b.log":      false,
+			"/a/b/c.log":    true,
+			"/secret":       false,
+			"/secret/a.txt": false,
+		},
+		"/x/**.log": {
+			"/x/a.log":     true,
+			"/x/y/z/a.log": true,
+			"/x.log":       false,
+			"/y/a.log":     false,
+		},
+		"/[!a]*": {
+			"/b": true,
+			"/a": false,
+		},
+	} {
+		g, err := CompileGlob(pattern)
+		require.NoError(t, err, pattern)
+		for path, match := range expected {
+			require.Equal(t, match, g.Match(path), "%s %s", pattern, path)
+		}
+	}
+
+	for _, pattern := range []string{"/{a,b", "/[ab", "/{a,[b}"} {
+		_, err := CompileGlob(pattern)
+		require.YesError(t, err, pattern)
+		require.Equal(t, MalformedGlob, Code(err), pattern)
+	}
+	_, err := CompileGlob("/{a,b}{c,d}{e,f}{g,h}{i,j}{k,l}{m,n}{o,p}{q,r}{s,t}{u,v}")
+	require.YesError(t, err)
+}
+
+func TestGlobFileRecursive(t *testing.T) {
+	h := newHashTree(t)
+	for _, p := range []string{"/a.parquet", "/2019/01/b.parquet", "/2019/01/c.csv", "/2019/_tmp/d.parquet", "/2020/e.parquet"} {
+		require.NoError(t, h.PutFile(p, obj(`hash:"20c27"`), 1))
+	}
+	require.NoError(t, h.Hash())
+
+	glob := func(pattern string) []string {
+		var paths []string
+		require.NoError(t, h.Glob(pattern, func(path string, _ *NodeProto) error {
+			paths = append(paths, path)
+			return nil
+		}))
+		return paths
+	}
+	require.ElementsEqual(t, i("/a.parquet", "/2019/01/b.parquet", "/2019/_tmp/d.parquet", "/2020/e.parquet"), glob("/**/*.parquet"))
+	require.ElementsEqual(t, i("/2019/01/b.parquet", "/2019/_tmp/d.parquet"), glob("/2019/**/*.parquet"))
+	require.ElementsEqual(t, i("/a.parquet", "/2019/01/b.parquet", "/2020/e.parquet"), glob("/**/*.parquet!/**/_tmp"))
+	require.ElementsEqual(t, i("/2019/01/c.csv", "/2020/e.parquet"), glob("/{2019/01/*.csv,2020/*}"))
+
+	// Trees in the format written by Writer (e.g. output commits' trees) give
+	// the same results
+	require.ElementsEqual(t, i("/a.parquet", "/2019/01/b.parquet", "/2020/e.parquet"), streamedGlob(t, h, "/**/*.parquet!/**/_tmp"))
+}
+
+// streamedGlob writes 'h' in the format written by Writer (e.g. output
+// commits' trees) and globs 'pattern' in the result
+func streamedGlob(t *testing.T, h HashTree, pattern string) []string {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, h.(*dbHashTree).View(func(tx *bolt.Tx) error {
+		return fs(tx).ForEach(func(k, v []byte) error {
+			return w.Write(&MergeNode{k: k, v: v})
+		})
+	}))
+	var paths []string
+	require.NoError(t, Glob([]io.ReadCloser{ioutil.NopCloser(bytes.NewReader(buf.Bytes()))}, pattern, func(path string, _ *NodeProto) error {
+		paths = append(paths, path)
+		return nil
+	}))
+	return paths
+}
+
+// Test that globbing a tree directly and globbing it in the format written by
+// Writer give the same results, including for directories above the pattern's
+// literal prefix
+func TestGlobStreamed(t *testing.T) {
+	h := newHashTree(t)
+	for _, p := range []string{"/data/x/a", "/data/x/b", "/data/y", "/dataset/c", "/other"} {
+		require.NoError(t, h.PutFile(p, obj(`hash:"20c27"`), 1))
+	}
+	require.NoError(t, h.Hash())
+
+	for _, pattern := range []string{"/*", "/**", "/data*", "/data/*", "/data/**", "/data/x/**", "/data/**!/data/x", "/data/x*", "/data/{x,y}"} {
+		var paths []string
+		require.NoError(t, h.Glob(pattern, func(path string, _ *NodeProto) error {
+			paths = append(paths, path)
+			return nil
+		}))
+		require.ElementsEqual(t, streamedGlob(t, h, pattern), paths, pattern)
+	}
+}
+
 // Test that Walk() works
 func TestWalk(t *testing.T) {
 	h := newHashTree(t)
@@ -589,6 +728,268 @@ func TestDiff(t *testing.T) {
 	require.Equal(t, 0, len(oldFiles))
 }
 
+// chunks splits 'h' into 'n' chunks in the format written by Writer, the way
+// output commits' trees are stored
+func chunks(t *testing.T, h HashTree, n int64) []io.ReadCloser {
+	var rs []io.ReadCloser
+	for i := int64(0); i < n; i++ {
+		buf := &bytes.Buffer{}
+		require.NoError(t, WriteDBHashTree(h, NewWriter(buf), NewFilter(n, i)))
+		rs = append(rs, ioutil.NopCloser(buf))
+	}
+	return rs
+}
+
+func TestMergeDBHashTree(t *testing.T) {
+	old := newHashTree(t)
+	for _, p := range []string{"/foo", "/dir/bar", "/dir/sub/baz", "/other/qux"} {
+		require.NoError(t, old.PutFile(p, obj(`hash:"4a2e9"`), 1))
+	}
+	require.NoError(t, old.Hash())
+	new, err := old.Copy()
+	require.NoError(t, err)
+	require.NoError(t, new.PutFile("/dir/sub/baz", obj(`hash:"10ead"`), 1))
+	require.NoError(t, new.DeleteFile("/other"))
+	require.NoError(t, new.Hash())
+
+	mergedOld, err := MergeDBHashTree("", chunks(t, old, 3))
+	require.NoError(t, err)
+	defer mergedOld.Destroy()
+	mergedNew, err := MergeDBHashTree("", chunks(t, new, 3))
+	require.NoError(t, err)
+	defer mergedNew.Destroy()
+	for _, p := range []string{"", "/dir", "/dir/sub/baz"} {
+		require.Equal(t, getT(t, new, p).Hash, getT(t, mergedNew, p).Hash)
+	}
+	newFiles, oldFiles := diffTrees(t, mergedOld, old, "")
+	require.Equal(t, 0, len(newFiles))
+	require.Equal(t, 0, len(oldFiles))
+	newFiles, oldFiles = diffTrees(t, mergedNew, mergedOld, "/")
+	require.Equal(t, []string{"/dir/sub/baz"}, newFiles)
+	require.Equal(t, []string{"/dir/sub/baz", "/other/qux"}, oldFiles)
+}
+
+func TestSymlink(t *testing.T) {
+	h := newHashTree(t)
+	require.NoError(t, h.PutFile("/dir/foo", obj(`hash:"20c27"`), 1))
+	require.NoError(t, h.PutSymlink("/abs", "/dir/foo"))
+	require.NoError(t, h.PutSymlink("/dir/rel", "foo"))
+	require.NoError(t, h.PutSymlink("/link/to/dir", "/dir"))
+	require.NoError(t, h.Hash())
+	require.Equal(t, "/dir/foo", getT(t, h, "/abs").SymlinkNode.Target)
+	require.NotNil(t, getT(t, h, "/link/to").DirNode)
+
+	// Symlinks don't contribute to the size of their parents
+	require.Equal(t, int64(1), getT(t, h, "").SubtreeSize)
+
+	// Retargeting a symlink changes its hash
+	h2, err := h.Copy()
+	require.NoError(t, err)
+	require.NoError(t, h2.PutSymlink("/abs", "/dir/rel"))
+	require.NoError(t, h2.Hash())
+	require.NotEqual(t, getT(t, h, "/abs").Hash, getT(t, h2, "/abs").Hash)
+	require.NotEqual(t, getT(t, h, "").Hash, getT(t, h2, "").Hash)
+
+	// Symlinks can't replace files or directories, or vice versa
+	require.YesError(t, h.PutSymlink("/dir/foo", "/abs"))
+	require.YesError(t, h.PutSymlink("/dir", "/abs"))
+	err = h.PutFile("/abs", obj(`hash:"20c27"`), 1)
+	require.YesError(t, err)
+	require.Equal(t, PathConflict, Code(err))
+
+	for p, expected := range map[string]string{
+		"/abs":             "/dir/foo",
+		"/dir/rel":         "/dir/foo",
+		"/link/to/dir/foo": "/dir/foo",
+		"/link/to/dir/rel": "/dir/foo",
+		"/link/to/dir":     "/dir",
+		"/dir/foo":         "/dir/foo",
+		"/link/../abs":     "/dir/foo",
+	} {
+		resolved, node, err := Resolve(h.Get, p)
+		require.NoError(t, err, p)
+		require.Equal(t, expected, resolved, p)
+		require.Equal(t, base(clean(expected)), node.Name, p)
+	}
+
+	// Symlink cycles are detected
+	require.NoError(t, h.PutSymlink("/loop1", "loop2"))
+	require.NoError(t, h.PutSymlink("/loop2", "/loop1"))
+	_, _, err = Resolve(h.Get, "/loop1")
+	require.YesError(t, err)
+	require.Equal(t, SymlinkLoop, Code(err))
+
+	// Symlinks can be deleted like files
+	require.NoError(t, h.DeleteFile("/loop2"))
+	_, err = h.Get("/loop2")
+	require.Equal(t, PathNotFound, Code(err))
+
+	// Dangling symlinks are not found
+	require.NoError(t, h.PutSymlink("/dangling", "/nothing"))
+	_, _, err = Resolve(h.Get, "/dangling")
+	require.Equal(t, PathNotFound, Code(err))
+}
+
+func TestFileMetadata(t *testing.T) {
+	h := newHashTree(t)
+	require.NoError(t, h.PutFile("/foo", obj(`hash:"20c27"`), 1))
+	require.NoError(t, h.PutFile("/dir/bar", obj(`hash:"ebc57"`), 1))
+	require.NoError(t, h.Hash())
+	h2, err := h.Copy()
+	require.NoError(t, err)
+
+	// Metadata is merged into the file's existing metadata
+	require.NoError(t, h2.PutFileMetadata("/foo", map[string]string{"content-type": "text/plain", "a": "1"}))
+	require.NoError(t, h2.PutFileMetadata("/foo", map[string]string{"a": "2"}))
+	require.NoError(t, h2.Hash())
+	require.Equal(t, map[string]string{"content-type": "text/plain", "a": "2"}, getT(t, h2, "/foo").FileNode.Metadata)
+
+	// but doesn't change any hashes
+	require.Equal(t, getT(t, h, "/foo").Hash, getT(t, h2, "/foo").Hash)
+	require.Equal(t, getT(t, h, "").Hash, getT(t, h2, "").Hash)
+
+	// Appending to the file keeps its metadata
+	require.NoError(t, h2.PutFile("/foo", obj(`hash:"413e7"`), 1))
+	require.NoError(t, h2.Hash())
+	require.Equal(t, "2", getT(t, h2, "/foo").FileNode.Metadata["a"])
+
+	// Metadata can only be set on existing files
+	err = h2.PutFileMetadata("/dir", map[string]string{"a": "1"})
+	require.Equal(t, PathConflict, Code(err))
+	err = h2.PutFileMetadata("/nothing", map[string]string{"a": "1"})
+	require.Equal(t, PathNotFound, Code(err))
+}
+
+func TestFileChecksums(t *testing.T) {
+	h := newHashTree(t)
+	require.NoError(t, h.PutFile("/foo", obj(`hash:"20c27"`), 1))
+	require.NoError(t, h.Hash())
+	hash := getT(t, h, "").Hash
+
+	// Checksums don't change any hashes
+	require.NoError(t, h.PutFileChecksums("/foo", []byte("sha256"), []byte("md5")))
+	require.NoError(t, h.Hash())
+	require.Equal(t, []byte("sha256"), getT(t, h, "/foo").FileNode.Sha256)
+	require.Equal(t, []byte("md5"), getT(t, h, "/foo").FileNode.Md5)
+	require.Equal(t, hash, getT(t, h, "").Hash)
+
+	// Appending to the file invalidates its checksums
+	require.NoError(t, h.PutFile("/foo", obj(`hash:"413e7"`), 1))
+	require.NoError(t, h.Hash())
+	require.Equal(t, 0, len(getT(t, h, "/foo").FileNode.Sha256))
+	require.Equal(t, 0, len(getT(t, h, "/foo").FileNode.Md5))
+
+	// Checksums can only be set on existing files
+	require.NoError(t, h.PutDir("/dir"))
+	err := h.PutFileChecksums("/dir", []byte("sha256"), []byte("md5"))
+	require.Equal(t, PathConflict, Code(err))
+	err = h.PutFileChecksums("/nothing", []byte("sha256"), []byte("md5"))
+	require.Equal(t, PathNotFound, Code(err))
+}
+
+func TestCommitted(t *testing.T) {
+	t1, t2 := &types.Timestamp{Seconds: 1}, &types.Timestamp{Seconds: 2}
+	h := newHashTree(t)
+	unstamped := newHashTree(t)
+	for _, tree := range []HashTree{h, unstamped} {
+		require.NoError(t, tree.PutFile("/foo", obj(`hash:"20c27"`), 1))
+		require.NoError(t, tree.PutFile("/dir/bar", obj(`hash:"ebc57"`), 1))
+	}
+	require.NoError(t, h.SetCommitted(t1))
+	require.NoError(t, h.Hash())
+	require.NoError(t, unstamped.Hash())
+	require.NoError(t, h.PutFile("/dir/bar", obj(`hash:"413e7"`), 1))
+	require.NoError(t, unstamped.PutFile("/dir/bar", obj(`hash:"413e7"`), 1))
+	require.NoError(t, h.SetCommitted(t2))
+	require.NoError(t, h.Hash())
+	require.NoError(t, unstamped.Hash())
+
+	// Only changed nodes and their ancestors are stamped
+	require.Equal(t, t1, getT(t, h, "/foo").Committed)
+	require.Equal(t, t2, getT(t, h, "/dir/bar").Committed)
+	require.Equal(t, t2, getT(t, h, "/dir").Committed)
+	require.Equal(t, t2, getT(t, h, "").Committed)
+	// Committed times don't change any hashes
+	require.Equal(t, getT(t, unstamped, "").Hash, getT(t, h, "").Hash)
+
+	// Merged nodes keep the latest time
+	o1, o2 := NewOrdered("/"), NewOrdered("/")
+	o1.PutDir("/dir")
+	o1.PutFile("/dir/a", []byte("a"), 1, &FileNodeProto{})
+	o1.SetCommitted(t1)
+	o2.PutDir("/dir")
+	o2.PutFile("/dir/b", []byte("b"), 1, &FileNodeProto{})
+	o2.SetCommitted(t2)
+	buf1, buf2 := &bytes.Buffer{}, &bytes.Buffer{}
+	require.NoError(t, o1.Serialize(buf1))
+	require.NoError(t, o2.Serialize(buf2))
+	merged := &bytes.Buffer{}
+	_, err := Merge(NewWriter(merged), []*Reader{NewReader(buf1, nil), NewReader(buf2, nil)})
+	require.NoError(t, err)
+	m, err := MergeDBHashTree("", []io.ReadCloser{ioutil.NopCloser(merged)})
+	require.NoError(t, err)
+	defer m.Destroy()
+	require.Equal(t, t1, getT(t, m, "/dir/a").Committed)
+	require.Equal(t, t2, getT(t, m, "/dir").Committed)
+}
+
+func TestListFrom(t *testing.T) {
+	h := newHashTree(t)
+	for _, p := range []string{"/dir/a", "/dir/b", "/dir/b1", "/dir/b/c", "/dir/d"} {
+		if p == "/dir/b" {
+			continue // "/dir/b" is created as a directory by "/dir/b/c"
+		}
+		require.NoError(t, h.PutFile(p, obj(`hash:"20c27"`), 1))
+	}
+	require.NoError(t, h.Hash())
+	names := func(from string) []string {
+		var result []string
+		require.NoError(t, h.ListFrom("/dir", from, func(node *NodeProto) error {
+			result = append(result, node.Name)
+			return nil
+		}))
+		return result
+	}
+	require.Equal(t, []string{"a", "b", "b1", "d"}, names(""))
+	// Descendants of 'from' and names that have it as a prefix are handled
+	require.Equal(t, []string{"b1", "d"}, names("b"))
+	require.Equal(t, []string{"b", "b1", "d"}, names("a"))
+	// 'from' doesn't need to exist
+	require.Equal(t, []string{"d"}, names("c"))
+	require.Equal(t, 0, len(names("e")))
+
+	err := h.ListFrom("/dir/a", "", func(*NodeProto) error { return nil })
+	require.Equal(t, PathConflict, Code(err))
+}
+
+func TestGetRangeFromIndexAfter(t *testing.T) {
+	idx := &bytes.Buffer{}
+	w := pbutil.NewWriter(idx)
+	for i, p := range []string{"/a", "/dir/b", "/dir/d", "/dir/f", "/z"} {
+		_, err := w.Write(&Index{K: b(p), Offset: uint64(i+1) * 100})
+		require.NoError(t, err)
+	}
+	for _, c := range []struct {
+		after        string
+		offset, size uint64
+	}{
+		{"/dir/a", 100, 400},
+		{"/dir/d", 300, 200},
+		{"/dir/e", 300, 200},
+		{"/dir/g", 400, 100},
+	} {
+		offset, size, err := GetRangeFromIndexAfter(bytes.NewReader(idx.Bytes()), "/dir", c.after)
+		require.NoError(t, err)
+		require.Equal(t, c.offset, offset, c.after)
+		require.Equal(t, c.size, size, c.after)
+	}
+	// A range at the end of the tree has no upper bound
+	offset, size, err := GetRangeFromIndexAfter(bytes.NewReader(idx.Bytes()), "/z", "/z/a")
+	require.NoError(t, err)
+	require.Equal(t, uint64(500), offset)
+	require.Equal(t, uint64(0), size)
+}
+
 func TestChildIterator(t *testing.T) {
 	h := newHashTree(t)
 	require.NoError(t, h.PutFile("a/1", obj(`hash:"23ea6"`), 1))
@@ -3,6 +3,7 @@ package hashtree
 import (
 	"io"
 
+	"github.com/gogo/protobuf/types"
 	"github.com/pachyderm/pachyderm/src/client/pfs"
 )
 
@@ -60,6 +61,10 @@ const (
 	// retroactively, as that would require modifying all of the directory's
 	// children to indicate that they include header data in their parent)
 	HeaderFooterConflict
+
+	// SymlinkLoop is returned when Resolve() follows too many symlinks,
+	// which usually means that they form a cycle.
+	SymlinkLoop
 )
 
 // HashTree is the signature of a hash tree provided by this library. To get a
@@ -73,6 +78,11 @@ type HashTree interface {
 	// List calls f with the files and subdirectories of the directory at 'path'.
 	List(path string, f func(node *NodeProto) error) error
 
+	// ListFrom is like List, but only calls f with the children of 'path'
+	// whose names sort after 'from'. List calls f in the (byte) order of the
+	// children's names, so ListFrom can be used to resume it.
+	ListFrom(path string, from string, f func(node *NodeProto) error) error
+
 	// ListAll is like List but aggregates its results into a slice.
 	ListAll(path string) ([]*NodeProto, error)
 
@@ -146,12 +156,31 @@ type HashTree interface {
 	// the size of the objects removed.
 	PutFileOverwrite(path string, objects []*pfs.Object, overwriteIndex *pfs.OverwriteIndex, sizeDelta int64) error
 
+	// PutFileMetadata sets metadata on the file at 'path', which must already
+	// exist. Keys in 'metadata' replace any existing keys with the same name;
+	// other existing keys are kept. Metadata doesn't affect the file's hash.
+	PutFileMetadata(path string, metadata map[string]string) error
+
+	// PutFileChecksums sets the standard checksums of the file at 'path',
+	// which must already exist. Checksums are cleared by any call that changes
+	// the file's contents, and don't affect the file's hash.
+	PutFileChecksums(path string, sha256 []byte, md5 []byte) error
+
 	// PutDir creates a directory (or does nothing if one exists).
 	PutDir(path string) error
 
+	// PutSymlink creates a symlink at 'path' that points to 'target' (see
+	// SymlinkNodeProto), or changes the target of an existing symlink.
+	PutSymlink(path string, target string) error
+
 	// DeleteFile deletes a regular file or directory (along with its children).
 	DeleteFile(path string) error
 
+	// SetCommitted sets the committed time of every node that has changed
+	// since the last call to Hash() (see NodeProto.Committed). It must be
+	// called before Hash(), which resets the set of changed nodes.
+	SetCommitted(committed *types.Timestamp) error
+
 	// Hash updates all of the hashes and node size metadata, it also checks
 	// for conflicts.
 	Hash() error
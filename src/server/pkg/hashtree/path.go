@@ -85,3 +85,50 @@ func ValidatePath(path string) error {
 
 	return nil
 }
+
+// maxSymlinkHops is the number of symlinks that Resolve follows before it
+// decides that they form a cycle (the same limit as Linux's)
+const maxSymlinkHops = 40
+
+// Resolve returns the path and node that 'p' refers to once any symlinks in
+// it (in its intermediate directories or at 'p' itself) have been followed,
+// using 'get' to look up nodes. Absolute symlink targets are relative to the
+// root of the tree, and relative targets to the symlink's directory; targets
+// that climb above the root stop there.
+func Resolve(get func(path string) (*NodeProto, error), p string) (string, *NodeProto, error) {
+	var hops int
+Restart:
+	for {
+		components := strings.Split(clean(p), "/")
+		resolved := ""
+		node, err := get(resolved)
+		if err != nil {
+			return "", nil, err
+		}
+		for i, component := range components {
+			if component == "" {
+				continue
+			}
+			candidate := join(resolved, component)
+			node, err = get(candidate)
+			if err != nil {
+				return "", nil, err
+			}
+			if node.SymlinkNode == nil {
+				resolved = candidate
+				continue
+			}
+			hops++
+			if hops > maxSymlinkHops {
+				return "", nil, errorf(SymlinkLoop, "too many levels of symlinks in \"%s\"", p)
+			}
+			target := node.SymlinkNode.Target
+			if !strings.HasPrefix(target, "/") {
+				target = path.Join(externalDefault(resolved), target)
+			}
+			p = path.Join(append([]string{"/", target}, components[i+1:]...)...)
+			continue Restart
+		}
+		return externalDefault(resolved), node, nil
+	}
+}
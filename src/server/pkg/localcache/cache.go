@@ -0,0 +1,367 @@
+// Package localcache implements a content-addressed cache of PFS data on
+// local disk, so that clients such as pachctl don't download the same data
+// from pachd over and over again.
+//
+// Data is cached under the object hash or block reference it was read from.
+// The content behind those never changes, so entries never need to be
+// invalidated, they're evicted in least recently used order once the cache
+// grows beyond its size. A cache directory may be shared by any number of
+// processes: entries are written to a temporary file and then renamed into
+// place, so they're never seen partially written. Adding entries and evicting
+// them is serialized with a file lock, and the lock file holds the total size
+// of the cache's entries, so that every process sees the same total.
+package localcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+const (
+	dirEnvVar  = "PACH_CACHE_DIR"
+	sizeEnvVar = "PACH_CACHE_SIZE"
+
+	// DefaultSize is the size of caches created by NewOnUserMachine, unless
+	// it's overridden by $PACH_CACHE_SIZE.
+	DefaultSize = 10 * units.GiB
+
+	lockFile = "lock"
+	tmpDir   = "tmp"
+
+	// lowWatermark is the fraction of its size that the cache is evicted down
+	// to, so that eviction doesn't happen on every write once it's full
+	lowWatermark = 0.9
+	// tmpTTL is how long temporary files are kept before they're assumed to
+	// have been abandoned by a process that crashed
+	tmpTTL = time.Hour
+)
+
+// Stats are the hit and miss statistics of a cache, as seen by this process.
+type Stats struct {
+	// Hits and Misses are the number of reads that were served from the
+	// cache, and that had to go to pachd, respectively
+	Hits   int64
+	Misses int64
+	// HitBytes and MissBytes are the number of bytes read from the cache and
+	// from pachd, respectively
+	HitBytes  int64
+	MissBytes int64
+	// Evictions is the number of entries this process has evicted
+	Evictions int64
+	// Bytes is the number of bytes in the cache as of the last time this
+	// process added to (or opened) it. Other processes sharing the cache may
+	// have changed it since.
+	Bytes int64
+}
+
+// Cache is a content-addressed cache on local disk.
+type Cache struct {
+	dir  string
+	size int64
+
+	// mu protects the fields below it
+	mu    sync.Mutex
+	stats Stats
+}
+
+// New returns a cache that stores up to 'size' bytes in 'dir', creating the
+// directory if it doesn't exist.
+func New(dir string, size int64) (*Cache, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("cache size must be positive (got %d)", size)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, tmpDir), 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		dir:  dir,
+		size: size,
+	}
+	lock, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	if c.stats.Bytes, err = c.readUsed(lock); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewOnUserMachine returns the cache shared by the Pachyderm tools on this
+// machine. It's stored in $PACH_CACHE_DIR (~/.pachyderm/cache by default) and
+// holds up to $PACH_CACHE_SIZE (e.g. "10GB", DefaultSize by default).
+func NewOnUserMachine() (*Cache, error) {
+	dir, ok := os.LookupEnv(dirEnvVar)
+	if !ok {
+		dir = filepath.Join(os.Getenv("HOME"), ".pachyderm", "cache")
+	}
+	size := int64(DefaultSize)
+	if env, ok := os.LookupEnv(sizeEnvVar); ok {
+		var err error
+		if size, err = units.RAMInBytes(env); err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", sizeEnvVar, env, err)
+		}
+	}
+	return New(dir, size)
+}
+
+// Get writes the data cached under 'key' to 'w'. If it isn't cached, 'fetch'
+// is called to write it, and it's added to the cache if fetch succeeds.
+func (c *Cache) Get(key string, w io.Writer, fetch func(w io.Writer) error) error {
+	p := c.path(key)
+	f, err := os.Open(p)
+	if err == nil {
+		defer f.Close()
+		// mark the entry as recently used, this may fail if another process
+		// evicts it, in which case we can still read it through f
+		now := time.Now()
+		os.Chtimes(p, now, now)
+		n, err := io.Copy(w, f)
+		c.record(func(stats *Stats) {
+			stats.Hits++
+			stats.HitBytes += n
+		})
+		return err
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Join(c.dir, tmpDir), "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// tmp has usually been renamed by the time this runs
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	cw := &countWriter{w: io.MultiWriter(tmp, w)}
+	if err := fetch(cw); err != nil {
+		return err
+	}
+	c.record(func(stats *Stats) {
+		stats.Misses++
+		stats.MissBytes += cw.n
+	})
+	if cw.n > c.size {
+		return nil
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return c.add(tmp.Name(), p, cw.n)
+}
+
+// Stats returns the cache's statistics.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *Cache) record(f func(stats *Stats)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f(&c.stats)
+}
+
+// path returns the path of the entry for 'key'. Keys are hashed so that they
+// can contain any characters, and entries are spread across subdirectories so
+// that no directory gets too big.
+func (c *Cache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// lock opens the lock file and locks it. Closing the returned file releases
+// the lock.
+func (c *Cache) lock() (*os.File, error) {
+	lock, err := os.OpenFile(filepath.Join(c.dir, lockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// readUsed returns the number of bytes in the cache, as recorded in the lock
+// file. If the lock file is new, it's computed from the entries on disk.
+// The lock must be held.
+func (c *Cache) readUsed(lock *os.File) (int64, error) {
+	data, err := ioutil.ReadAll(io.NewSectionReader(lock, 0, 64))
+	if err != nil {
+		return 0, err
+	}
+	if used, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+		return used, nil
+	}
+	entries, err := c.entries()
+	if err != nil {
+		return 0, err
+	}
+	var used int64
+	for _, e := range entries {
+		used += e.size
+	}
+	return used, writeUsed(lock, used)
+}
+
+// writeUsed records the number of bytes in the cache in the lock file. The
+// lock must be held.
+func writeUsed(lock *os.File, used int64) error {
+	if err := lock.Truncate(0); err != nil {
+		return err
+	}
+	_, err := lock.WriteAt([]byte(strconv.FormatInt(used, 10)), 0)
+	return err
+}
+
+// add moves 'tmp', which holds 'n' bytes, into place as the entry at 'p', and
+// evicts entries if the cache has grown too big. If another process (or
+// goroutine) has added the same entry in the meantime, 'tmp' is left to be
+// removed by the caller instead, so the entry isn't counted twice.
+func (c *Cache) add(tmp string, p string, n int64) (retErr error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	used, err := c.readUsed(lock)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(p); err == nil {
+		c.stats.Bytes = used
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		return err
+	}
+	used += n
+	if used > c.size {
+		if used, err = c.evict(); err != nil {
+			return err
+		}
+	}
+	c.stats.Bytes = used
+	return writeUsed(lock, used)
+}
+
+type entry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// entries returns the entries in the cache.
+func (c *Cache) entries() ([]entry, error) {
+	var result []entry
+	if err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				// evicted by another process
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			if path != c.dir && filepath.Dir(path) == c.dir && info.Name() == tmpDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Dir(path) == c.dir {
+			// the lock file
+			return nil
+		}
+		result = append(result, entry{path, info.Size(), info.ModTime()})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// evict removes the least recently used entries until the cache is below its
+// low watermark, and returns the number of bytes left in it. The lock and
+// c.mu must be held.
+func (c *Cache) evict() (int64, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return 0, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	var used int64
+	for _, e := range entries {
+		used += e.size
+	}
+	for _, e := range entries {
+		if float64(used) <= lowWatermark*float64(c.size) {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		used -= e.size
+		c.stats.Evictions++
+	}
+	return used, c.removeAbandoned()
+}
+
+// removeAbandoned removes temporary files left behind by processes that
+// crashed while writing them.
+func (c *Cache) removeAbandoned() error {
+	infos, err := ioutil.ReadDir(filepath.Join(c.dir, tmpDir))
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if time.Since(info.ModTime()) > tmpTTL {
+			if err := os.Remove(filepath.Join(c.dir, tmpDir, info.Name())); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,151 @@
+package localcache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pachyderm/pachyderm/src/client/pkg/require"
+)
+
+func newCache(t *testing.T, size int64) (*Cache, string) {
+	dir, err := ioutil.TempDir("", "localcache")
+	require.NoError(t, err)
+	c, err := New(dir, size)
+	require.NoError(t, err)
+	return c, dir
+}
+
+// get reads 'key' from c, fetching 'value' on a miss. It returns whether
+// value had to be fetched.
+func get(t *testing.T, c *Cache, key string, value string) bool {
+	var buf bytes.Buffer
+	fetched := false
+	require.NoError(t, c.Get(key, &buf, func(w io.Writer) error {
+		fetched = true
+		_, err := io.WriteString(w, value)
+		return err
+	}))
+	require.Equal(t, value, buf.String())
+	return fetched
+}
+
+func TestHitMiss(t *testing.T) {
+	c, dir := newCache(t, 1024)
+	defer os.RemoveAll(dir)
+	require.True(t, get(t, c, "a", "foo"))
+	require.False(t, get(t, c, "a", "foo"))
+	require.True(t, get(t, c, "b", "barbaz"))
+	require.Equal(t, Stats{Hits: 1, Misses: 2, HitBytes: 3, MissBytes: 9, Bytes: 9}, c.Stats())
+
+	// the data is shared with other caches using the same directory
+	c2, err := New(dir, 1024)
+	require.NoError(t, err)
+	require.Equal(t, int64(9), c2.Stats().Bytes)
+	require.False(t, get(t, c2, "b", "barbaz"))
+}
+
+func TestFetchError(t *testing.T) {
+	c, dir := newCache(t, 1024)
+	defer os.RemoveAll(dir)
+	require.YesError(t, c.Get("a", ioutil.Discard, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return fmt.Errorf("connection reset")
+	}))
+	// partial data isn't cached
+	require.True(t, get(t, c, "a", "foo"))
+	infos, err := ioutil.ReadDir(filepath.Join(dir, tmpDir))
+	require.NoError(t, err)
+	require.Equal(t, 0, len(infos))
+}
+
+func TestEviction(t *testing.T) {
+	c, dir := newCache(t, 100)
+	defer os.RemoveAll(dir)
+	value := strings.Repeat("x", 30)
+	for _, key := range []string{"a", "b", "c"} {
+		require.True(t, get(t, c, key, value))
+		// make sure the entries' times differ
+		time.Sleep(10 * time.Millisecond)
+	}
+	// reading "a" makes "b" the least recently used entry
+	require.False(t, get(t, c, "a", value))
+	time.Sleep(10 * time.Millisecond)
+	require.True(t, get(t, c, "d", value))
+	require.Equal(t, int64(1), c.Stats().Evictions)
+	require.Equal(t, int64(90), c.Stats().Bytes)
+	require.True(t, get(t, c, "b", value))
+	require.False(t, get(t, c, "a", value))
+
+	// values bigger than the cache aren't cached
+	require.True(t, get(t, c, "e", strings.Repeat("x", 101)))
+	require.True(t, get(t, c, "e", strings.Repeat("x", 101)))
+}
+
+func TestConcurrent(t *testing.T) {
+	_, dir := newCache(t, 1000)
+	defer os.RemoveAll(dir)
+	// several caches simulate several processes sharing the directory
+	var caches []*Cache
+	for i := 0; i < 4; i++ {
+		c, err := New(dir, 1000)
+		require.NoError(t, err)
+		caches = append(caches, c)
+	}
+	var wg sync.WaitGroup
+	for i, c := range caches {
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func(c *Cache, seed int) {
+				defer wg.Done()
+				for k := 0; k < 100; k++ {
+					key := fmt.Sprintf("%d", (seed+k)%50)
+					get(t, c, key, strings.Repeat(key, 10))
+				}
+			}(c, i*4+j)
+		}
+	}
+	wg.Wait()
+	var total int64
+	entries, err := caches[0].entries()
+	require.NoError(t, err)
+	for _, e := range entries {
+		total += e.size
+	}
+	// the caches share one total, so together they stay within the size
+	require.True(t, total <= 1000, "cache holds %d bytes", total)
+	for _, c := range caches {
+		require.True(t, c.Stats().Bytes <= 1000)
+	}
+	c, err := New(dir, 1000)
+	require.NoError(t, err)
+	require.Equal(t, total, c.Stats().Bytes)
+}
+
+func TestConcurrentMiss(t *testing.T) {
+	c, dir := newCache(t, 1024)
+	defer os.RemoveAll(dir)
+	c2, err := New(dir, 1024)
+	require.NoError(t, err)
+	// 'c2' caches "a" while 'c' is fetching it, so it's only counted once
+	var buf bytes.Buffer
+	require.NoError(t, c.Get("a", &buf, func(w io.Writer) error {
+		require.True(t, get(t, c2, "a", "foo"))
+		_, err := io.WriteString(w, "foo")
+		return err
+	}))
+	require.Equal(t, "foo", buf.String())
+	require.Equal(t, int64(3), c.Stats().Bytes)
+	require.Equal(t, int64(3), c2.Stats().Bytes)
+	require.False(t, get(t, c, "a", "foo"))
+	infos, err := ioutil.ReadDir(filepath.Join(dir, tmpDir))
+	require.NoError(t, err)
+	require.Equal(t, 0, len(infos))
+}
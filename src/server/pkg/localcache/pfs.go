@@ -0,0 +1,68 @@
+package localcache
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pachyderm/pachyderm/src/client"
+	"github.com/pachyderm/pachyderm/src/client/pfs"
+)
+
+// GetFile writes the content of a file to 'w', like APIClient.GetFile, but
+// reads the objects and blocks it's made of through 'cache'. Only the file's
+// metadata is read from pachd if its content is cached.
+func GetFile(pachClient *client.APIClient, cache *Cache, repo string, commit string, path string, w io.Writer) error {
+	fileInfo, err := pachClient.InspectFile(repo, commit, path)
+	if err != nil {
+		return err
+	}
+	if fileInfo.FileType != pfs.FileType_FILE {
+		// let pachd resolve symlinks and report errors for directories
+		return pachClient.GetFile(repo, commit, path, 0, 0, w)
+	}
+	var hashes []string
+	for _, object := range fileInfo.Objects {
+		hashes = append(hashes, object.Hash)
+	}
+	if err := GetObjects(pachClient, cache, hashes, w); err != nil {
+		return err
+	}
+	return GetBlocks(pachClient, cache, fileInfo.BlockRefs, w)
+}
+
+// GetObjects writes the content of several objects to 'w', like
+// APIClient.GetObjects, but reads them through 'cache'.
+func GetObjects(pachClient *client.APIClient, cache *Cache, hashes []string, w io.Writer) error {
+	for _, hash := range hashes {
+		hash := hash
+		if err := cache.Get(objectKey(hash), w, func(w io.Writer) error {
+			return pachClient.GetObject(hash, w)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBlocks writes the content referenced by several block refs to 'w', like
+// APIClient.GetBlocks, but reads them through 'cache'.
+func GetBlocks(pachClient *client.APIClient, cache *Cache, blockRefs []*pfs.BlockRef, w io.Writer) error {
+	for _, blockRef := range blockRefs {
+		blockRef := blockRef
+		size := blockRef.Range.Upper - blockRef.Range.Lower
+		if err := cache.Get(blockKey(blockRef), w, func(w io.Writer) error {
+			return pachClient.GetBlocks([]*pfs.BlockRef{blockRef}, 0, size, size, w)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func objectKey(hash string) string {
+	return fmt.Sprintf("object/%s", hash)
+}
+
+func blockKey(blockRef *pfs.BlockRef) string {
+	return fmt.Sprintf("block/%s/%d-%d", blockRef.Block.Hash, blockRef.Range.Lower, blockRef.Range.Upper)
+}
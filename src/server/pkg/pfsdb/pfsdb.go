@@ -16,6 +16,9 @@ const (
 	putFileRecordsPrefix = "/putFileRecords"
 	commitsPrefix        = "/commits"
 	branchesPrefix       = "/branches"
+	commitTagsPrefix     = "/commitTags"
+	prunedCommitsPrefix  = "/prunedCommits"
+	purgeRecordsPrefix   = "/purgeRecords"
 	openCommitsPrefix    = "/openCommits"
 )
 
@@ -77,6 +80,54 @@ func Branches(etcdClient *etcd.Client, etcdPrefix string, repo string) col.Colle
 	)
 }
 
+// CommitTags returns a collection of commit tags
+func CommitTags(etcdClient *etcd.Client, etcdPrefix string, repo string) col.Collection {
+	return col.NewCollection(
+		etcdClient,
+		path.Join(etcdPrefix, commitTagsPrefix, repo),
+		nil,
+		&pfs.CommitTagInfo{},
+		func(key string) error {
+			if uuid.IsUUIDWithoutDashes(key) {
+				return fmt.Errorf("commit tag name cannot be a UUID V4")
+			}
+			return nil
+		},
+		nil,
+	)
+}
+
+// PrunedCommits returns a collection of commits removed by retention policies
+func PrunedCommits(etcdClient *etcd.Client, etcdPrefix string, repo string) col.Collection {
+	return col.NewCollection(
+		etcdClient,
+		path.Join(etcdPrefix, prunedCommitsPrefix, repo),
+		nil,
+		&pfs.PrunedCommitInfo{},
+		nil,
+		nil,
+	)
+}
+
+// PurgeRecords returns a collection of purge records, keyed by
+// PurgeRecordKey
+func PurgeRecords(etcdClient *etcd.Client, etcdPrefix string) col.Collection {
+	return col.NewCollection(
+		etcdClient,
+		path.Join(etcdPrefix, purgeRecordsPrefix),
+		nil,
+		&pfs.PurgeRecord{},
+		nil,
+		nil,
+	)
+}
+
+// PurgeRecordKey returns the key of the purge record with the given index.
+// Keys are zero-padded so that they sort in index order.
+func PurgeRecordKey(index uint64) string {
+	return fmt.Sprintf("%020d", index)
+}
+
 // OpenCommits returns a collection of open commits
 func OpenCommits(etcdClient *etcd.Client, etcdPrefix string) col.Collection {
 	return col.NewCollection(
@@ -0,0 +1,736 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	pachclient "github.com/pachyderm/pachyderm/src/client"
+	"github.com/pachyderm/pachyderm/src/client/limit"
+	"github.com/pachyderm/pachyderm/src/client/pfs"
+	pfsserver "github.com/pachyderm/pachyderm/src/server/pfs"
+	"github.com/pachyderm/pachyderm/src/server/pkg/hashtree"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ChangeType is the type of a Change.
+type ChangeType int
+
+const (
+	// Added means that a file is copied to the destination, where it didn't
+	// exist.
+	Added ChangeType = iota
+	// Modified means that a file is copied to the destination, where its
+	// content differed.
+	Modified
+	// Deleted means that a file is deleted from the destination, because it
+	// doesn't exist in the source.
+	Deleted
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case Added:
+		return "added"
+	case Modified:
+		return "modified"
+	case Deleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// Change is a change that PushDir or PullDir makes to its destination.
+type Change struct {
+	Type ChangeType
+	// Path is the file's path, relative to the local directory and to the
+	// root of the branch (it's slash-separated in either case).
+	Path string
+}
+
+// MirrorOptions configure PushDir and PullDir.
+type MirrorOptions struct {
+	// Delete causes files that don't exist in the source to be deleted from
+	// the destination.
+	Delete bool
+	// DryRun causes the changes to be computed and returned, but not made.
+	DryRun bool
+	// Include, if non-empty, restricts the files that are considered to those
+	// that match at least one of these globs (see hashtree.GlobPattern).
+	// Files that aren't considered are neither transferred nor deleted.
+	Include []string
+	// Exclude excludes the files that match any of these globs, or that are
+	// under a directory that does.
+	Exclude []string
+	// Parallelism is the maximum number of files transferred at once.
+	Parallelism int
+}
+
+// StateFile is the name of the file, at the root of a mirrored directory, in
+// which PushDir and PullDir record the commit that the directory last
+// mirrored for each branch. It's never pushed or pulled.
+//
+// PFS can only diff two commits, not a commit and a local directory, so the
+// state file stands in for the directory's previous commit: the changes since
+// the last push or pull are the files that DiffFile reports between that
+// commit and the branch's head, plus the local files whose size or
+// modification time differ from what was recorded (which are then hashed).
+// Only the first push or pull of a directory has to compare every file, by
+// SHA-256, and files whose SHA-256 PFS doesn't know are transferred once.
+const StateFile = ".pachsync"
+
+// fileState is the state of a file in the commit that a directory last
+// mirrored.
+type fileState struct {
+	// Sha256 is the SHA-256 of the file's content, if it's known
+	Sha256 []byte `json:"sha256,omitempty"`
+	// Synced is set if the local file had the same content as the file in
+	// the commit. Size and ModTime identify that version of the local file.
+	Synced  bool  `json:"synced,omitempty"`
+	Size    int64 `json:"size,omitempty"`
+	ModTime int64 `json:"mtime,omitempty"`
+}
+
+// mirrorState is the state of a branch that a directory last mirrored.
+type mirrorState struct {
+	// Commit is the ID of the commit that the directory last mirrored
+	Commit string `json:"commit"`
+	// Files has an entry for every file in Commit, whether or not it passed
+	// the filters of that push or pull
+	Files map[string]*fileState `json:"files"`
+}
+
+func stateKey(repo string, branch string) string {
+	return repo + "@" + branch
+}
+
+// readState reads the mirror states recorded under 'root', keyed by
+// stateKey. There are none if root doesn't exist or has never been mirrored.
+func readState(root string) (map[string]*mirrorState, error) {
+	result := make(map[string]*mirrorState)
+	data, err := ioutil.ReadFile(filepath.Join(root, StateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return result, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("could not parse %s (delete it to compare every file instead): %v", filepath.Join(root, StateFile), err)
+	}
+	return result, nil
+}
+
+func writeState(root string, states map[string]*mirrorState) error {
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	// write and rename, so that an interrupted write doesn't leave a
+	// truncated state behind
+	tmp := filepath.Join(root, StateFile+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(root, StateFile))
+}
+
+// tempFile returns the path that the new version of the file at 'p' is
+// written to before it's renamed to 'p'.
+func tempFile(p string) string {
+	return filepath.Join(filepath.Dir(p), StateFile+"."+filepath.Base(p)+".tmp")
+}
+
+// isTempFile returns true if the slash-separated path 'p' is a file that
+// writeState or PullDir writes before renaming it, which an interrupted
+// pull may leave behind.
+func isTempFile(p string) bool {
+	base := path.Base(p)
+	return strings.HasPrefix(base, StateFile+".") && strings.HasSuffix(base, ".tmp")
+}
+
+// filter decides which files a mirror considers.
+type filter struct {
+	include []*hashtree.GlobPattern
+	exclude []*hashtree.GlobPattern
+}
+
+func newFilter(opts *MirrorOptions) (*filter, error) {
+	result := &filter{}
+	for _, pattern := range opts.Include {
+		g, err := hashtree.CompileGlob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include glob %q: %v", pattern, err)
+		}
+		result.include = append(result.include, g)
+	}
+	for _, pattern := range opts.Exclude {
+		g, err := hashtree.CompileGlob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude glob %q: %v", pattern, err)
+		}
+		result.exclude = append(result.exclude, g)
+	}
+	return result, nil
+}
+
+func (f *filter) match(p string) bool {
+	if p == StateFile || isTempFile(p) {
+		return false
+	}
+	// check 'p' and each of the directories it's under against the exclusions
+	for prefix := p; prefix != "." && prefix != "/"; prefix = path.Dir(prefix) {
+		for _, g := range f.exclude {
+			if g.Match(prefix) {
+				return false
+			}
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, g := range f.include {
+		if g.Match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// localFile is a regular file under a mirrored directory.
+type localFile struct {
+	size    int64
+	modTime int64
+}
+
+func newLocalFile(info os.FileInfo) localFile {
+	return localFile{size: info.Size(), modTime: info.ModTime().UnixNano()}
+}
+
+// unchangedSince returns true if the local file hasn't been modified since
+// it was recorded in 'state'.
+func (l localFile) unchangedSince(state *fileState) bool {
+	return state != nil && state.Synced && state.Size == l.size && state.ModTime == l.modTime
+}
+
+// localFiles returns the regular files under 'root' that pass 'f', keyed by
+// their slash-separated paths relative to root. If 'mustExist' is false, a
+// root that doesn't exist is treated as empty.
+func localFiles(root string, f *filter, mustExist bool) (map[string]localFile, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		if os.IsNotExist(err) && !mustExist {
+			// pulling into a directory that doesn't exist yet
+			return make(map[string]localFile), nil
+		}
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	result := make(map[string]localFile)
+	if err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if f.match(rel) {
+			result[rel] = newLocalFile(info)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// branchHead returns the head of 'branch', or nil if the branch doesn't exist
+// yet or has no head.
+func branchHead(client *pachclient.APIClient, repo string, branch string) (*pfs.Commit, error) {
+	branchInfo, err := client.InspectBranch(repo, branch)
+	if err != nil {
+		if pfsserver.IsBranchNotFoundErr(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return branchInfo.Head, nil
+}
+
+// remoteFile is a file in the head of a mirrored branch.
+type remoteFile struct {
+	// sha256 is the SHA-256 of the file's content, if it's known
+	sha256 []byte
+	// changed is set if the file may differ from its version in the commit
+	// that the directory last mirrored
+	changed bool
+}
+
+// remoteFiles returns the files in 'head' (which may be nil), keyed by their
+// paths relative to the root of the branch. If 'state' is set, only the files
+// that changed since state.Commit are read, and the rest are taken from the
+// state. Otherwise every file is read and marked as changed.
+func remoteFiles(client *pachclient.APIClient, repo string, head *pfs.Commit, state *mirrorState) (map[string]*remoteFile, error) {
+	result := make(map[string]*remoteFile)
+	if head == nil {
+		return result, nil
+	}
+	relPath := func(fileInfo *pfs.FileInfo) string {
+		rel := path.Clean(fileInfo.File.Path)
+		if len(rel) > 0 && rel[0] == '/' {
+			rel = rel[1:]
+		}
+		return rel
+	}
+	if state != nil {
+		var newFiles, oldFiles []*pfs.FileInfo
+		if head.ID != state.Commit {
+			var err error
+			newFiles, oldFiles, err = client.DiffFile(repo, head.ID, "", repo, state.Commit, "", false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for p, fileState := range state.Files {
+			result[p] = &remoteFile{sha256: fileState.Sha256}
+		}
+		for _, fileInfo := range oldFiles {
+			if fileInfo.FileType == pfs.FileType_FILE {
+				delete(result, relPath(fileInfo))
+			}
+		}
+		for _, fileInfo := range newFiles {
+			if fileInfo.FileType == pfs.FileType_FILE {
+				result[relPath(fileInfo)] = &remoteFile{sha256: fileInfo.Sha256, changed: true}
+			}
+		}
+		return result, nil
+	}
+	if err := client.Walk(repo, head.ID, "", func(fileInfo *pfs.FileInfo) error {
+		if fileInfo.FileType == pfs.FileType_FILE {
+			result[relPath(fileInfo)] = &remoteFile{sha256: fileInfo.Sha256, changed: true}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// hashFile returns the SHA-256 of the file at 'p'.
+func hashFile(p string) ([]byte, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// mirror is a push or pull of a directory.
+type mirror struct {
+	root   string
+	push   bool
+	opts   *MirrorOptions
+	filter *filter
+	// local and remote are the files on either side that pass the filter
+	// (remote is unfiltered, as the state covers every file in the commit)
+	local  map[string]localFile
+	remote map[string]*remoteFile
+	// state is the state of the last push or pull, or nil if there wasn't one
+	state *mirrorState
+	// hashes are the SHA-256s of the files that are known to be the same on
+	// both sides once the mirror is done (they may be nil if unknown)
+	mu     sync.Mutex
+	hashes map[string][]byte
+}
+
+func (m *mirror) setHash(p string, sum []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hashes[p] = sum
+}
+
+// same returns true if the local and remote versions of 'p' have the same
+// content. Files that haven't changed on either side since the last mirror
+// are the same without being read; otherwise the local file is hashed.
+func (m *mirror) same(p string) (bool, error) {
+	local, remote := m.local[p], m.remote[p]
+	var state *fileState
+	if m.state != nil {
+		state = m.state.Files[p]
+	}
+	if !remote.changed && local.unchangedSince(state) {
+		m.setHash(p, remote.sha256)
+		return true, nil
+	}
+	if len(remote.sha256) == 0 {
+		return false, nil
+	}
+	sum, err := hashFile(filepath.Join(m.root, filepath.FromSlash(p)))
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(sum, remote.sha256) {
+		return false, nil
+	}
+	m.setHash(p, sum)
+	return true, nil
+}
+
+// diff returns the changes that make the destination mirror the source.
+func (m *mirror) diff() ([]Change, error) {
+	var result []Change
+	for p := range m.local {
+		if _, ok := m.remote[p]; !ok {
+			if m.push {
+				result = append(result, Change{Added, p})
+			} else if m.opts.Delete {
+				result = append(result, Change{Deleted, p})
+			}
+			continue
+		}
+		same, err := m.same(p)
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			result = append(result, Change{Modified, p})
+		}
+	}
+	for p := range m.remote {
+		if _, ok := m.local[p]; ok || !m.filter.match(p) {
+			continue
+		}
+		if !m.push {
+			result = append(result, Change{Added, p})
+		} else if m.opts.Delete {
+			result = append(result, Change{Deleted, p})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+// newState returns the state of the branch after the changes have been made,
+// at 'commit'. There's no state if the branch is empty (commit is nil).
+func (m *mirror) newState(commit *pfs.Commit, changes []Change) (*mirrorState, error) {
+	if commit == nil {
+		return nil, nil
+	}
+	result := &mirrorState{Commit: commit.ID, Files: make(map[string]*fileState)}
+	deleted := make(map[string]bool)
+	for _, change := range changes {
+		if change.Type == Deleted {
+			deleted[change.Path] = true
+		}
+	}
+	remote := make(map[string]bool)
+	for p := range m.remote {
+		remote[p] = true
+	}
+	if m.push {
+		for p := range m.local {
+			remote[p] = true
+		}
+	}
+	for p := range remote {
+		if m.push && deleted[p] {
+			continue
+		}
+		sum, synced := m.hashes[p]
+		if !synced {
+			// files that weren't considered keep the state they had, as
+			// long as neither side has changed since
+			if m.remote[p] == nil || m.remote[p].changed || m.state == nil {
+				result.Files[p] = &fileState{}
+				if m.remote[p] != nil {
+					result.Files[p].Sha256 = m.remote[p].sha256
+				}
+				continue
+			}
+			state := m.state.Files[p]
+			info, err := os.Stat(filepath.Join(m.root, filepath.FromSlash(p)))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			if err != nil || !newLocalFile(info).unchangedSince(state) {
+				result.Files[p] = &fileState{Sha256: state.Sha256}
+				continue
+			}
+			result.Files[p] = state
+			continue
+		}
+		// pulled files have been rewritten, so they're stat'ed again
+		local, ok := m.local[p]
+		if !m.push {
+			info, err := os.Stat(filepath.Join(m.root, filepath.FromSlash(p)))
+			if err != nil {
+				return nil, err
+			}
+			local, ok = newLocalFile(info), true
+		}
+		if !ok {
+			result.Files[p] = &fileState{Sha256: sum}
+			continue
+		}
+		result.Files[p] = &fileState{Sha256: sum, Synced: true, Size: local.size, ModTime: local.modTime}
+	}
+	return result, nil
+}
+
+// newMirror reads both sides of a push or pull and the state of the last one.
+func newMirror(client *pachclient.APIClient, root string, repo string, head *pfs.Commit, state *mirrorState, push bool, opts *MirrorOptions) (*mirror, error) {
+	f, err := newFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+	local, err := localFiles(root, f, push)
+	if err != nil {
+		return nil, err
+	}
+	if head == nil {
+		state = nil
+	}
+	remote, err := remoteFiles(client, repo, head, state)
+	if err != nil {
+		if state == nil || !pfsserver.IsCommitNotFoundErr(err) {
+			return nil, err
+		}
+		// the commit that was last mirrored has been deleted (e.g. squashed
+		// away), so every file is compared instead
+		state = nil
+		if remote, err = remoteFiles(client, repo, head, nil); err != nil {
+			return nil, err
+		}
+	}
+	return &mirror{
+		root:   root,
+		push:   push,
+		opts:   opts,
+		filter: f,
+		local:  local,
+		remote: remote,
+		state:  state,
+		hashes: make(map[string][]byte),
+	}, nil
+}
+
+// saveState records the state of 'branch' after a push or pull in 'states',
+// and writes them to 'root'.
+func (m *mirror) saveState(states map[string]*mirrorState, repo string, branch string, commit *pfs.Commit, changes []Change) error {
+	state, err := m.newState(commit, changes)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		delete(states, stateKey(repo, branch))
+	} else {
+		states[stateKey(repo, branch)] = state
+	}
+	return writeState(m.root, states)
+}
+
+// hashingReader computes the SHA-256 of what's read through it.
+type hashingReader struct {
+	r    io.Reader
+	hash hash.Hash
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, hash: sha256.New()}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.hash.Write(p[:n])
+	return n, err
+}
+
+// PushDir makes the head of 'branch' mirror the directory 'root', uploading
+// only the files that differ, in a single commit. It returns the changes that
+// it made (or would make, if opts.DryRun is set). No commit is made if there
+// are no changes. See StateFile for how files are compared.
+func PushDir(client *pachclient.APIClient, root string, repo string, branch string, opts *MirrorOptions) ([]Change, error) {
+	states, err := readState(root)
+	if err != nil {
+		return nil, err
+	}
+	head, err := branchHead(client, repo, branch)
+	if err != nil {
+		return nil, err
+	}
+	m, err := newMirror(client, root, repo, head, states[stateKey(repo, branch)], true, opts)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := m.diff()
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		return changes, nil
+	}
+	if len(changes) > 0 {
+		if head, err = m.upload(client, repo, branch, changes); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.saveState(states, repo, branch, head, changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// upload makes 'changes' in a new commit on 'branch', and returns the commit.
+func (m *mirror) upload(client *pachclient.APIClient, repo string, branch string, changes []Change) (_ *pfs.Commit, retErr error) {
+	commit, err := client.StartCommit(repo, branch)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			// don't leave a partial push on the branch
+			client.DeleteCommit(repo, commit.ID)
+		}
+	}()
+	limiter := limit.New(m.opts.Parallelism)
+	var eg errgroup.Group
+	for _, change := range changes {
+		change := change
+		limiter.Acquire()
+		eg.Go(func() (retErr error) {
+			defer limiter.Release()
+			if change.Type == Deleted {
+				return client.DeleteFile(repo, commit.ID, change.Path)
+			}
+			file, err := os.Open(filepath.Join(m.root, filepath.FromSlash(change.Path)))
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := file.Close(); err != nil && retErr == nil {
+					retErr = err
+				}
+			}()
+			r := newHashingReader(file)
+			if _, err := client.PutFileOverwrite(repo, commit.ID, change.Path, r, 0); err != nil {
+				return err
+			}
+			m.setHash(change.Path, r.hash.Sum(nil))
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	if err := client.FinishCommit(repo, commit.ID); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+// replaceFile writes the file at 'path' with 'f', like makeFile, but writes
+// a temporary file first and renames it to 'path', so that a failed or
+// interrupted download doesn't truncate the existing file. The existing
+// file's permissions are kept.
+func (p *Puller) replaceFile(path string, f func(io.Writer) error) (retErr error) {
+	tmp := tempFile(path)
+	defer func() {
+		if retErr != nil {
+			os.Remove(tmp)
+		}
+	}()
+	if err := p.makeFile(tmp, f); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// PullDir makes the directory 'root' mirror the head of 'branch', downloading
+// only the files that differ. It returns the changes that it made (or would
+// make, if opts.DryRun is set). See StateFile for how files are compared.
+func (p *Puller) PullDir(client *pachclient.APIClient, root string, repo string, branch string, opts *MirrorOptions) ([]Change, error) {
+	states, err := readState(root)
+	if err != nil {
+		return nil, err
+	}
+	head, err := branchHead(client, repo, branch)
+	if err != nil {
+		return nil, err
+	}
+	m, err := newMirror(client, root, repo, head, states[stateKey(repo, branch)], false, opts)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := m.diff()
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		return changes, nil
+	}
+	limiter := limit.New(opts.Parallelism)
+	var eg errgroup.Group
+	for _, change := range changes {
+		change := change
+		localPath := filepath.Join(root, filepath.FromSlash(change.Path))
+		if change.Type == Deleted {
+			if err := os.Remove(localPath); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		limiter.Acquire()
+		eg.Go(func() error {
+			defer limiter.Release()
+			h := sha256.New()
+			if err := p.replaceFile(localPath, func(w io.Writer) error {
+				return p.getFile(client, repo, head.ID, change.Path, io.MultiWriter(w, h))
+			}); err != nil {
+				return err
+			}
+			m.setHash(change.Path, h.Sum(nil))
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	if err := m.saveState(states, repo, branch, head, changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
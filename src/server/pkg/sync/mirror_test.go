@@ -0,0 +1,255 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pachyderm/pachyderm/src/client/pfs"
+	"github.com/pachyderm/pachyderm/src/client/pkg/require"
+	"github.com/pachyderm/pachyderm/src/server/pfs/server"
+)
+
+func TestFilter(t *testing.T) {
+	f, err := newFilter(&MirrorOptions{
+		Include: []string{"**.csv", "README"},
+		Exclude: []string{"tmp"},
+	})
+	require.NoError(t, err)
+	require.True(t, f.match("a.csv"))
+	require.True(t, f.match("dir/b.csv"))
+	require.True(t, f.match("README"))
+	require.False(t, f.match("a.txt"))
+	require.False(t, f.match("tmp/c.csv"))
+	require.False(t, f.match(StateFile))
+	require.False(t, f.match(StateFile+".tmp"))
+	require.False(t, f.match("dir/"+StateFile+".b.tmp"))
+
+	_, err = newFilter(&MirrorOptions{Exclude: []string{"["}})
+	require.YesError(t, err)
+}
+
+func remoteContent(content string, changed bool) *remoteFile {
+	sum := sha256.Sum256([]byte(content))
+	return &remoteFile{sha256: sum[:], changed: changed}
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for p, content := range files {
+		p = filepath.Join(root, filepath.FromSlash(p))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+}
+
+func newTestMirror(t *testing.T, root string, remote map[string]*remoteFile, state *mirrorState, push bool, opts *MirrorOptions) *mirror {
+	f, err := newFilter(opts)
+	require.NoError(t, err)
+	local, err := localFiles(root, f, true)
+	require.NoError(t, err)
+	return &mirror{
+		root:   root,
+		push:   push,
+		opts:   opts,
+		filter: f,
+		local:  local,
+		remote: remote,
+		state:  state,
+		hashes: make(map[string][]byte),
+	}
+}
+
+func TestDiff(t *testing.T) {
+	root, err := ioutil.TempDir("", "mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	writeFiles(t, root, map[string]string{
+		"same":      "foo",
+		"dir/size":  "foo",
+		"dir/hash":  "foo",
+		"unhashed":  "foo",
+		"localonly": "foo",
+	})
+	remote := map[string]*remoteFile{
+		"same":       remoteContent("foo", true),
+		"dir/size":   remoteContent("fooo", true),
+		"dir/hash":   remoteContent("bar", true),
+		"unhashed":   &remoteFile{changed: true},
+		"remoteonly": remoteContent("foo", true),
+	}
+
+	m := newTestMirror(t, root, remote, nil, true, &MirrorOptions{})
+	require.Equal(t, 5, len(m.local))
+	changes, err := m.diff()
+	require.NoError(t, err)
+	require.Equal(t, []Change{
+		{Modified, "dir/hash"},
+		{Modified, "dir/size"},
+		{Added, "localonly"},
+		{Modified, "unhashed"},
+	}, changes)
+
+	m = newTestMirror(t, root, remote, nil, false, &MirrorOptions{Delete: true})
+	changes, err = m.diff()
+	require.NoError(t, err)
+	require.Equal(t, []Change{
+		{Modified, "dir/hash"},
+		{Modified, "dir/size"},
+		{Deleted, "localonly"},
+		{Added, "remoteonly"},
+		{Modified, "unhashed"},
+	}, changes)
+
+	// a directory that doesn't exist yet is empty when pulling into it, but
+	// can't be pushed
+	f, err := newFilter(&MirrorOptions{})
+	require.NoError(t, err)
+	local, err := localFiles(filepath.Join(root, "missing"), f, false)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(local))
+	_, err = localFiles(filepath.Join(root, "missing"), f, true)
+	require.YesError(t, err)
+	_, err = localFiles(filepath.Join(root, "same"), f, false)
+	require.YesError(t, err)
+}
+
+func TestDiffState(t *testing.T) {
+	root, err := ioutil.TempDir("", "mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	writeFiles(t, root, map[string]string{
+		"unhashed": "foo",
+		"touched":  "foo",
+		"edited":   "foo",
+	})
+	f, err := newFilter(&MirrorOptions{})
+	require.NoError(t, err)
+	local, err := localFiles(root, f, true)
+	require.NoError(t, err)
+	fooSum := sha256.Sum256([]byte("foo"))
+	state := &mirrorState{Commit: "commit", Files: make(map[string]*fileState)}
+	for p, l := range local {
+		state.Files[p] = &fileState{Sha256: fooSum[:], Synced: true, Size: l.size, ModTime: l.modTime}
+	}
+	// PFS doesn't know the hash of 'unhashed', but neither side has changed
+	state.Files["unhashed"].Sha256 = nil
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "touched"), later, later))
+	writeFiles(t, root, map[string]string{"edited": "bar"})
+	require.NoError(t, os.Chtimes(filepath.Join(root, "edited"), later, later))
+	remote := map[string]*remoteFile{
+		"unhashed": &remoteFile{},
+		"touched":  remoteContent("foo", false),
+		"edited":   remoteContent("foo", false),
+	}
+
+	m := newTestMirror(t, root, remote, state, true, &MirrorOptions{})
+	changes, err := m.diff()
+	require.NoError(t, err)
+	require.Equal(t, []Change{{Modified, "edited"}}, changes)
+
+	// once the change is made, the new state covers every file
+	m.setHash("edited", nil)
+	newState, err := m.newState(&pfs.Commit{ID: "next"}, changes)
+	require.NoError(t, err)
+	require.Equal(t, "next", newState.Commit)
+	require.Equal(t, 3, len(newState.Files))
+	for p := range remote {
+		require.True(t, newState.Files[p].Synced, p)
+	}
+}
+
+func TestPushPullDir(t *testing.T) {
+	c := server.GetPachClient(t)
+	require.NoError(t, c.CreateRepo("repo"))
+	src, err := ioutil.TempDir("", "mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(dst)
+	opts := &MirrorOptions{Parallelism: 4}
+
+	// pushing to a branch that doesn't exist yet creates it
+	writeFiles(t, src, map[string]string{"a": "foo", "dir/b": "bar"})
+	changes, err := PushDir(c, src, "repo", "master", opts)
+	require.NoError(t, err)
+	require.Equal(t, []Change{{Added, "a"}, {Added, "dir/b"}}, changes)
+	changes, err = PushDir(c, src, "repo", "dev", opts)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(changes))
+
+	// pushing again changes nothing, and makes no commit
+	changes, err = PushDir(c, src, "repo", "master", opts)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(changes))
+	commitInfos, err := c.ListCommit("repo", "master", "", 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(commitInfos))
+
+	// only edited files are pushed, not ones that were merely touched
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(filepath.Join(src, "dir", "b"), later, later))
+	writeFiles(t, src, map[string]string{"a": "fooo"})
+	changes, err = PushDir(c, src, "repo", "master", opts)
+	require.NoError(t, err)
+	require.Equal(t, []Change{{Modified, "a"}}, changes)
+
+	// files appended to remotely have no SHA-256, but are only pulled once
+	_, err = c.PutFile("repo", "master", "dir/b", strings.NewReader("buzz"))
+	require.NoError(t, err)
+	fileInfo, err := c.InspectFile("repo", "master", "dir/b")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(fileInfo.Sha256))
+	puller := NewPuller()
+	_, err = puller.PullDir(c, filepath.Join(src, "a"), "repo", "master", opts)
+	require.YesError(t, err)
+	changes, err = puller.PullDir(c, filepath.Join(dst, "data"), "repo", "master", opts)
+	require.NoError(t, err)
+	require.Equal(t, []Change{{Added, "a"}, {Added, "dir/b"}}, changes)
+	data, err := ioutil.ReadFile(filepath.Join(dst, "data", "dir", "b"))
+	require.NoError(t, err)
+	require.Equal(t, "barbuzz", string(data))
+	changes, err = puller.PullDir(c, filepath.Join(dst, "data"), "repo", "master", opts)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(changes))
+	changes, err = PushDir(c, filepath.Join(dst, "data"), "repo", "master", opts)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(changes))
+
+	// the first push from 'src' since the append restores 'dir/b'
+	changes, err = PushDir(c, src, "repo", "master", opts)
+	require.NoError(t, err)
+	require.Equal(t, []Change{{Modified, "dir/b"}}, changes)
+	require.NoError(t, os.Chmod(filepath.Join(dst, "data", "dir", "b"), 0600))
+	changes, err = puller.PullDir(c, filepath.Join(dst, "data"), "repo", "master", opts)
+	require.NoError(t, err)
+	require.Equal(t, []Change{{Modified, "dir/b"}}, changes)
+	// modified files are replaced, keeping their permissions
+	info, err := os.Stat(filepath.Join(dst, "data", "dir", "b"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	infos, err := ioutil.ReadDir(filepath.Join(dst, "data", "dir"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(infos))
+
+	// deletions are only mirrored with Delete, and from a directory that exists
+	require.NoError(t, os.Remove(filepath.Join(src, "a")))
+	changes, err = PushDir(c, src, "repo", "master", opts)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(changes))
+	_, err = PushDir(c, filepath.Join(src, "missing"), "repo", "master", &MirrorOptions{Delete: true})
+	require.YesError(t, err)
+	changes, err = PushDir(c, src, "repo", "master", &MirrorOptions{Delete: true})
+	require.NoError(t, err)
+	require.Equal(t, []Change{{Deleted, "a"}}, changes)
+	changes, err = puller.PullDir(c, filepath.Join(dst, "data"), "repo", "master", &MirrorOptions{Delete: true})
+	require.NoError(t, err)
+	require.Equal(t, []Change{{Deleted, "a"}}, changes)
+	_, err = os.Stat(filepath.Join(dst, "data", "a"))
+	require.True(t, os.IsNotExist(err))
+}
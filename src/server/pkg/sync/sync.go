@@ -15,6 +15,7 @@ import (
 	"github.com/pachyderm/pachyderm/src/client/limit"
 	"github.com/pachyderm/pachyderm/src/client/pfs"
 	"github.com/pachyderm/pachyderm/src/server/pkg/hashtree"
+	"github.com/pachyderm/pachyderm/src/server/pkg/localcache"
 	"github.com/pachyderm/pachyderm/src/server/pkg/obj"
 
 	"golang.org/x/sync/errgroup"
@@ -34,14 +35,32 @@ type Puller struct {
 	wg sync.WaitGroup
 	// size is the total amount this puller has pulled
 	size int64
+	// cache, if set, caches the content that's pulled
+	cache *localcache.Cache
 }
 
 // NewPuller creates a new Puller struct.
 func NewPuller() *Puller {
+	return NewCachedPuller(nil)
+}
+
+// NewCachedPuller creates a new Puller struct which reads file content through
+// 'cache', if it's not nil.
+func NewCachedPuller(cache *localcache.Cache) *Puller {
 	return &Puller{
 		errCh: make(chan error, 1),
 		pipes: make(map[string]bool),
+		cache: cache,
+	}
+}
+
+// getFile writes the content of a file to 'w', through the cache if there is
+// one.
+func (p *Puller) getFile(client *pachclient.APIClient, repo, commit, path string, w io.Writer) error {
+	if p.cache != nil {
+		return localcache.GetFile(client, p.cache, repo, commit, path, w)
 	}
+	return client.GetFile(repo, commit, path, 0, 0, w)
 }
 
 type sizeWriter struct {
@@ -134,6 +153,23 @@ func (p *Puller) makeFile(path string, f func(io.Writer) error) (retErr error) {
 	return nil
 }
 
+// makeSymlink creates a symlink at 'path' for the PFS symlink at 'pfsPath',
+// which points to 'target'. Absolute targets are relative to the root of the
+// commit, so they're rewritten relative to the link's directory.
+func (p *Puller) makeSymlink(path string, pfsPath string, target string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if filepath.IsAbs(target) {
+		var err error
+		target, err = filepath.Rel(filepath.Dir(filepath.Join("/", pfsPath)), target)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Symlink(target, path)
+}
+
 // Pull clones an entire repo at a certain commit.
 // root is the local path you want to clone to.
 // fileInfo is the file/dir we are puuling.
@@ -156,6 +192,8 @@ func (p *Puller) Pull(client *pachclient.APIClient, root string, repo, commit, f
 			statsPath := filepath.Join(statsRoot, basepath)
 			if fileInfo.FileType == pfs.FileType_DIR {
 				statsTree.PutDir(statsPath)
+			} else if fileInfo.FileType == pfs.FileType_SYMLINK {
+				statsTree.PutSymlink(statsPath, fileInfo.SymlinkTarget)
 			} else {
 				var blockRefs []*pfs.BlockRef
 				for _, object := range fileInfo.Objects {
@@ -166,16 +204,24 @@ func (p *Puller) Pull(client *pachclient.APIClient, root string, repo, commit, f
 					blockRefs = append(blockRefs, objectInfo.BlockRef)
 				}
 				blockRefs = append(blockRefs, fileInfo.BlockRefs...)
-				statsTree.PutFile(statsPath, fileInfo.Hash, int64(fileInfo.SizeBytes), &hashtree.FileNodeProto{BlockRefs: blockRefs})
+				statsTree.PutFile(statsPath, fileInfo.Hash, int64(fileInfo.SizeBytes), &hashtree.FileNodeProto{
+					BlockRefs: blockRefs,
+					Metadata:  fileInfo.Metadata,
+					Sha256:    fileInfo.Sha256,
+					Md5:       fileInfo.Md5,
+				})
 			}
 		}
 		path := filepath.Join(root, basepath)
 		if fileInfo.FileType == pfs.FileType_DIR {
 			return os.MkdirAll(path, 0700)
 		}
+		if fileInfo.FileType == pfs.FileType_SYMLINK {
+			return p.makeSymlink(path, fileInfo.File.Path, fileInfo.SymlinkTarget)
+		}
 		if pipes {
 			return p.makePipe(path, func(w io.Writer) error {
-				return client.GetFile(repo, commit, fileInfo.File.Path, 0, 0, w)
+				return p.getFile(client, repo, commit, fileInfo.File.Path, w)
 			})
 		}
 		if emptyFiles {
@@ -185,7 +231,7 @@ func (p *Puller) Pull(client *pachclient.APIClient, root string, repo, commit, f
 			limiter.Acquire()
 			defer limiter.Release()
 			return p.makeFile(path, func(w io.Writer) error {
-				return client.GetFile(repo, commit, fileInfo.File.Path, 0, 0, w)
+				return p.getFile(client, repo, commit, fileInfo.File.Path, w)
 			})
 		})
 		return nil
@@ -227,7 +273,7 @@ func (p *Puller) PullDiff(client *pachclient.APIClient, root string, newRepo, ne
 		}
 		if pipes {
 			if err := p.makePipe(path, func(w io.Writer) error {
-				return client.GetFile(newFile.File.Commit.Repo.Name, newFile.File.Commit.ID, newFile.File.Path, 0, 0, w)
+				return p.getFile(client, newFile.File.Commit.Repo.Name, newFile.File.Commit.ID, newFile.File.Path, w)
 			}); err != nil {
 				return err
 			}
@@ -241,7 +287,7 @@ func (p *Puller) PullDiff(client *pachclient.APIClient, root string, newRepo, ne
 			eg.Go(func() error {
 				defer limiter.Release()
 				return p.makeFile(path, func(w io.Writer) error {
-					return client.GetFile(newFile.File.Commit.Repo.Name, newFile.File.Commit.ID, newFile.File.Path, 0, 0, w)
+					return p.getFile(client, newFile.File.Commit.Repo.Name, newFile.File.Commit.ID, newFile.File.Path, w)
 				})
 			})
 		}
@@ -261,7 +307,7 @@ func (p *Puller) PullDiff(client *pachclient.APIClient, root string, newRepo, ne
 			path := filepath.Join(root, "old", basepath)
 			if pipes {
 				if err := p.makePipe(path, func(w io.Writer) error {
-					return client.GetFile(oldFile.File.Commit.Repo.Name, oldFile.File.Commit.ID, oldFile.File.Path, 0, 0, w)
+					return p.getFile(client, oldFile.File.Commit.Repo.Name, oldFile.File.Commit.ID, oldFile.File.Path, w)
 				}); err != nil {
 					return err
 				}
@@ -271,7 +317,7 @@ func (p *Puller) PullDiff(client *pachclient.APIClient, root string, newRepo, ne
 				eg.Go(func() error {
 					defer limiter.Release()
 					return p.makeFile(path, func(w io.Writer) error {
-						return client.GetFile(oldFile.File.Commit.Repo.Name, oldFile.File.Commit.ID, oldFile.File.Path, 0, 0, w)
+						return p.getFile(client, oldFile.File.Commit.Repo.Name, oldFile.File.Commit.ID, oldFile.File.Path, w)
 					})
 				})
 			}
@@ -285,6 +331,9 @@ func (p *Puller) PullTree(client *pachclient.APIClient, root string, tree hashtr
 	limiter := limit.New(concurrency)
 	var eg errgroup.Group
 	if err := tree.Walk("/", func(path string, node *hashtree.NodeProto) error {
+		if node.SymlinkNode != nil {
+			return p.makeSymlink(filepath.Join(root, path), path, node.SymlinkNode.Target)
+		}
 		if node.FileNode != nil {
 			path := filepath.Join(root, path)
 			var hashes []string
@@ -293,6 +342,9 @@ func (p *Puller) PullTree(client *pachclient.APIClient, root string, tree hashtr
 			}
 			if pipes {
 				return p.makePipe(path, func(w io.Writer) error {
+					if p.cache != nil {
+						return localcache.GetObjects(client, p.cache, hashes, w)
+					}
 					return client.GetObjects(hashes, 0, 0, uint64(node.SubtreeSize), w)
 				})
 			}
@@ -300,6 +352,9 @@ func (p *Puller) PullTree(client *pachclient.APIClient, root string, tree hashtr
 			eg.Go(func() (retErr error) {
 				defer limiter.Release()
 				return p.makeFile(path, func(w io.Writer) error {
+					if p.cache != nil {
+						return localcache.GetObjects(client, p.cache, hashes, w)
+					}
 					return client.GetObjects(hashes, 0, 0, uint64(node.SubtreeSize), w)
 				})
 			})
@@ -94,27 +94,40 @@ func (s byModRev) Less(i, j int) bool {
 
 // NewWatcher watches a given etcd prefix for events.
 func NewWatcher(ctx context.Context, client *etcd.Client, trimPrefix, prefix string, template proto.Message) (Watcher, error) {
-	return newWatcher(ctx, client, []byte(trimPrefix), prefix, false, template)
+	return newWatcher(ctx, client, []byte(trimPrefix), prefix, false, 0, template)
 }
 
 // NewWatcherWithPrev is like NewWatcher, except that the returned events
 // include the previous version of the values.
 func NewWatcherWithPrev(ctx context.Context, client *etcd.Client, trimPrefix, prefix string, template proto.Message) (Watcher, error) {
-	return newWatcher(ctx, client, []byte(trimPrefix), prefix, true, template)
+	return newWatcher(ctx, client, []byte(trimPrefix), prefix, true, 0, template)
 }
 
-func newWatcher(ctx context.Context, client *etcd.Client, trimPrefix []byte, prefix string, withPrev bool, template proto.Message) (Watcher, error) {
+// NewWatcherWithPrevFrom is like NewWatcherWithPrev, except that instead of
+// the current items, the returned events start with the changes made at
+// revision 'rev'. This lets a caller resume a watch where it left off. If
+// 'rev' has been compacted, the watcher returns an error event.
+func NewWatcherWithPrevFrom(ctx context.Context, client *etcd.Client, trimPrefix, prefix string, rev int64, template proto.Message) (Watcher, error) {
+	return newWatcher(ctx, client, []byte(trimPrefix), prefix, true, rev, template)
+}
+
+func newWatcher(ctx context.Context, client *etcd.Client, trimPrefix []byte, prefix string, withPrev bool, fromRev int64, template proto.Message) (Watcher, error) {
 	eventCh := make(chan *Event)
 	done := make(chan struct{})
-	// First list the collection to get the current items
+	// First list the collection to get the current items (unless the caller
+	// is resuming from 'fromRev')
 	// Sort by mod revision--how the items would have been returned if we watched
 	// them from the beginning.
-	resp, err := client.Get(ctx, prefix, etcd.WithPrefix(), etcd.WithSort(etcd.SortByModRevision, etcd.SortAscend))
-	if err != nil {
-		return nil, err
+	resp := &etcd.GetResponse{}
+	nextRevision := fromRev
+	if fromRev == 0 {
+		var err error
+		resp, err = client.Get(ctx, prefix, etcd.WithPrefix(), etcd.WithSort(etcd.SortByModRevision, etcd.SortAscend))
+		if err != nil {
+			return nil, err
+		}
+		nextRevision = resp.Header.Revision + 1
 	}
-
-	nextRevision := resp.Header.Revision + 1
 	etcdWatcher := etcd.NewWatcher(client)
 	// Issue a watch that uses the revision timestamp returned by the
 	// Get request earlier.  That way even if some items are added between
@@ -162,7 +175,9 @@ func newWatcher(ctx context.Context, client *etcd.Client, trimPrefix []byte, pre
 					return err
 				}
 				etcdWatcher = etcd.NewWatcher(client)
-				rch = etcdWatcher.Watch(ctx, prefix, etcd.WithPrefix(), etcd.WithRev(nextRevision))
+				// Resume from 'nextRevision', with the same options otherwise
+				options[1] = etcd.WithRev(nextRevision)
+				rch = etcdWatcher.Watch(ctx, prefix, options...)
 				continue
 			}
 			if err := resp.Err(); err != nil {
@@ -19,6 +19,7 @@ import (
 	"github.com/pachyderm/pachyderm/src/client/limit"
 	"github.com/pachyderm/pachyderm/src/client/pfs"
 	"github.com/pachyderm/pachyderm/src/client/pkg/grpcutil"
+	"github.com/pachyderm/pachyderm/src/client/pkg/pbutil"
 	"github.com/pachyderm/pachyderm/src/client/pps"
 	"github.com/pachyderm/pachyderm/src/server/pkg/ancestry"
 	"github.com/pachyderm/pachyderm/src/server/pkg/backoff"
@@ -202,6 +203,9 @@ func (a *apiServer) validateInput(pachClient *client.APIClient, pipelineName str
 				case len(input.Atom.Glob) == 0:
 					return fmt.Errorf("input must specify a glob")
 				}
+				if _, err := hashtree.CompileGlob(input.Atom.Glob); err != nil {
+					return fmt.Errorf("input %s has an invalid glob: %v", input.Atom.Name, err)
+				}
 				// Note that input.Atom.Commit is empty if a) this is a job b) one of
 				// the job pipeline's input branches has no commits yet
 				if job && input.Atom.Commit != "" {
@@ -231,6 +235,9 @@ func (a *apiServer) validateInput(pachClient *client.APIClient, pipelineName str
 				case len(input.Pfs.Glob) == 0:
 					return fmt.Errorf("input must specify a glob")
 				}
+				if _, err := hashtree.CompileGlob(input.Pfs.Glob); err != nil {
+					return fmt.Errorf("input %s has an invalid glob: %v", input.Pfs.Name, err)
+				}
 				// Note that input.Pfs.Commit is empty if a) this is a job b) one of
 				// the job pipeline's input branches has no commits yet
 				if job && input.Pfs.Commit != "" {
@@ -2453,6 +2460,39 @@ func CollectActiveObjectsAndTags(ctx context.Context, pachClient *client.APIClie
 			}
 		}
 	}
+	// A helper function for adding the datums that an output commit refers
+	// to. A datum's tags (and the objects they refer to) are only active while
+	// some commit still refers to the datum, so that the output of datums that
+	// were deleted or purged is garbage collected.
+	var activeTagsMu sync.Mutex
+	addActiveDatums := func(object *pfs.Object) (retErr error) {
+		if object == nil {
+			return nil
+		}
+		r, err := pachClient.GetObjectReader(object.Hash)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := r.Close(); err != nil && retErr == nil {
+				retErr = err
+			}
+		}()
+		pbr := pbutil.NewReader(r)
+		for {
+			datumHash, err := pbr.ReadBytes()
+			if err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			activeTagsMu.Lock()
+			result.Tags.Add(datumHash)
+			result.Tags.AddString(string(datumHash) + workerpkg.StatsTagSuffix)
+			activeTagsMu.Unlock()
+		}
+	}
 	// A helper function for adding objects that are actually hash trees,
 	// which in turn contain active objects.
 	addActiveTree := func(object *pfs.Object) error {
@@ -2496,6 +2536,9 @@ func CollectActiveObjectsAndTags(ctx context.Context, pachClient *client.APIClie
 				// (bryce) This needs some notion of active blockrefs since these trees do not use objects
 				addActiveObjects(ci.Trees...)
 				addActiveObjects(ci.Datums)
+				if err := addActiveDatums(ci.Datums); err != nil {
+					return err
+				}
 				return addActiveTree(ci.Tree)
 			})
 		}
@@ -2519,7 +2562,9 @@ func CollectActiveObjectsAndTags(ctx context.Context, pachClient *client.APIClie
 			if err != nil {
 				return nil, err
 			}
-			result.Tags.AddString(resp.Tag.Name)
+			if !result.Tags.TestString(resp.Tag.Name) {
+				continue
+			}
 			result.NTags++
 			limiter.Acquire()
 			eg.Go(func() error {
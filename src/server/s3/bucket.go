@@ -0,0 +1,303 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pachyderm/pachyderm/src/client"
+	"github.com/pachyderm/pachyderm/src/client/pfs"
+	"github.com/pachyderm/pachyderm/src/server/pkg/errutil"
+
+	"github.com/gobwas/glob"
+	"github.com/gogo/protobuf/types"
+)
+
+// defaultMaxKeys is the number of keys returned by ListObjects if the client
+// doesn't ask for a specific number (same as S3's default)
+const defaultMaxKeys = 1000
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+var defaultOwner = owner{ID: "pachyderm", DisplayName: "pachyderm"}
+
+type bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Owner   owner    `xml:"Owner"`
+	Buckets []bucket `xml:"Buckets>Bucket"`
+}
+
+type contents struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         uint64 `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+	Owner        *owner `xml:"Owner,omitempty"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listBucketResult struct {
+	XMLName        xml.Name       `xml:"ListBucketResult"`
+	Xmlns          string         `xml:"xmlns,attr"`
+	Name           string         `xml:"Name"`
+	Prefix         string         `xml:"Prefix"`
+	Delimiter      string         `xml:"Delimiter,omitempty"`
+	MaxKeys        int            `xml:"MaxKeys"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	Contents       []contents     `xml:"Contents"`
+	CommonPrefixes []commonPrefix `xml:"CommonPrefixes"`
+
+	// ListObjects (V1) fields
+	Marker     string `xml:"Marker,omitempty"`
+	NextMarker string `xml:"NextMarker,omitempty"`
+
+	// ListObjectsV2 fields
+	KeyCount              int    `xml:"KeyCount,omitempty"`
+	ContinuationToken     string `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string `xml:"StartAfter,omitempty"`
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+}
+
+func (s *server) listBuckets(w http.ResponseWriter, r *http.Request) {
+	pc := s.requestClient(r)
+	repoInfos, err := pc.ListRepo()
+	if err != nil {
+		pfsError(w, r, err, "NoSuchBucket")
+		return
+	}
+	result := &listAllMyBucketsResult{
+		Xmlns: xmlns,
+		Owner: defaultOwner,
+	}
+	for _, repoInfo := range repoInfos {
+		created, err := types.TimestampFromProto(repoInfo.Created)
+		if err != nil {
+			pfsError(w, r, err, "NoSuchBucket")
+			return
+		}
+		for _, branch := range repoInfo.Branches {
+			result.Buckets = append(result.Buckets, bucket{
+				Name:         fmt.Sprintf("%s.%s", branch.Name, repoInfo.Repo.Name),
+				CreationDate: formatTime(created),
+			})
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+func (s *server) headBucket(w http.ResponseWriter, r *http.Request, repo, branch string) {
+	pc := s.requestClient(r)
+	if _, err := pc.InspectBranch(repo, branch); err != nil {
+		pfsError(w, r, err, "NoSuchBucket")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) getBucketLocation(w http.ResponseWriter, r *http.Request, repo, branch string) {
+	pc := s.requestClient(r)
+	if _, err := pc.InspectBranch(repo, branch); err != nil {
+		pfsError(w, r, err, "NoSuchBucket")
+		return
+	}
+	// PFS buckets have no region, which S3 reports as an empty constraint
+	writeXML(w, http.StatusOK, &locationConstraint{Xmlns: xmlns})
+}
+
+// listObjects implements both ListObjects and ListObjectsV2 (selected by
+// passing list-type=2).
+func (s *server) listObjects(w http.ResponseWriter, r *http.Request, repo, branch string) {
+	pc := s.requestClient(r)
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	v2 := query.Get("list-type") == "2"
+	maxKeys := defaultMaxKeys
+	if maxKeysStr := query.Get("max-keys"); maxKeysStr != "" {
+		var err error
+		maxKeys, err = strconv.Atoi(maxKeysStr)
+		if err != nil || maxKeys < 0 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("invalid max-keys %q", maxKeysStr))
+			return
+		}
+	}
+	result := &listBucketResult{
+		Xmlns:     xmlns,
+		Name:      fmt.Sprintf("%s.%s", branch, repo),
+		Prefix:    prefix,
+		Delimiter: delimiter,
+		MaxKeys:   maxKeys,
+	}
+	// 'marker' is the key after which results begin
+	var marker string
+	if v2 {
+		result.StartAfter = query.Get("start-after")
+		marker = result.StartAfter
+		if token := query.Get("continuation-token"); token != "" {
+			decoded, err := base64.StdEncoding.DecodeString(token)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid continuation-token")
+				return
+			}
+			result.ContinuationToken = token
+			marker = string(decoded)
+		}
+	} else {
+		result.Marker = query.Get("marker")
+		marker = result.Marker
+	}
+
+	// Empty branches are valid, empty buckets
+	branchInfo, err := pc.InspectBranch(repo, branch)
+	if err != nil {
+		pfsError(w, r, err, "NoSuchBucket")
+		return
+	}
+	var fileInfos []*pfs.FileInfo
+	if branchInfo.Head != nil {
+		fileInfos, err = listPrefix(pc, repo, branch, prefix, delimiter)
+		if err != nil {
+			pfsError(w, r, err, "NoSuchKey")
+			return
+		}
+	}
+
+	entries := groupByDelimiter(fileInfos, prefix, delimiter)
+	for _, entry := range entries {
+		if entry.key <= marker {
+			continue
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) >= maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		if entry.fileInfo == nil {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry.key})
+		} else {
+			result.Contents = append(result.Contents, fileInfoToContents(entry.key, entry.fileInfo))
+		}
+		marker = entry.key
+	}
+	if result.IsTruncated {
+		if v2 {
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(marker))
+		} else {
+			result.NextMarker = marker
+		}
+	}
+	if v2 {
+		result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// listPrefix returns the FileInfos needed to list the objects under 'prefix'.
+// When listing with the "/" delimiter only the directory containing 'prefix'
+// needs to be listed; otherwise every file under 'prefix' is globbed.
+func listPrefix(pc *client.APIClient, repo, branch, prefix, delimiter string) ([]*pfs.FileInfo, error) {
+	if delimiter == "/" {
+		dir := "/"
+		if i := strings.LastIndex(prefix, "/"); i >= 0 {
+			dir = "/" + prefix[:i]
+		}
+		fileInfos, err := pc.ListFile(repo, branch, dir)
+		if err != nil {
+			// S3 reports a missing "directory" as an empty listing
+			if errutil.IsNotFoundError(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return fileInfos, nil
+	}
+	return pc.GlobFile(repo, branch, "/"+glob.QuoteMeta(prefix)+"**")
+}
+
+// listEntry is a single key in a ListObjects response. fileInfo is nil for
+// common prefixes.
+type listEntry struct {
+	key      string
+	fileInfo *pfs.FileInfo
+}
+
+// groupByDelimiter converts 'fileInfos' into the sorted list of keys under
+// 'prefix', rolling up keys that contain 'delimiter' (after the prefix) into
+// common prefixes.
+func groupByDelimiter(fileInfos []*pfs.FileInfo, prefix, delimiter string) []listEntry {
+	seen := make(map[string]bool)
+	var entries []listEntry
+	for _, fileInfo := range fileInfos {
+		key := strings.TrimPrefix(path.Clean(fileInfo.File.Path), "/")
+		if fileInfo.FileType == pfs.FileType_DIR {
+			if delimiter != "/" {
+				// directories are implicit in S3; their files are listed
+				// individually
+				continue
+			}
+			key += "/"
+		}
+		if key == "" || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix := key[:len(prefix)+i+len(delimiter)]
+				if !seen[commonPrefix] {
+					seen[commonPrefix] = true
+					entries = append(entries, listEntry{key: commonPrefix})
+				}
+				continue
+			}
+		}
+		entries = append(entries, listEntry{key: key, fileInfo: fileInfo})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries
+}
+
+func fileInfoToContents(key string, fileInfo *pfs.FileInfo) contents {
+	result := contents{
+		Key:          key,
+		ETag:         etag(fileInfo),
+		Size:         fileInfo.SizeBytes,
+		StorageClass: "STANDARD",
+		Owner:        &defaultOwner,
+	}
+	if committed, err := types.TimestampFromProto(fileInfo.Committed); err == nil {
+		result.LastModified = formatTime(committed)
+	}
+	return result
+}
+
+// etag returns the (quoted) S3 ETag of a file, which is its MD5 checksum like
+// in S3, or its PFS content hash if PFS doesn't know its checksum
+func etag(fileInfo *pfs.FileInfo) string {
+	if len(fileInfo.Md5) > 0 {
+		return fmt.Sprintf("%q", hex.EncodeToString(fileInfo.Md5))
+	}
+	return fmt.Sprintf("%q", hex.EncodeToString(fileInfo.Hash))
+}
@@ -0,0 +1,183 @@
+package s3
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pachyderm/pachyderm/src/client"
+	"github.com/pachyderm/pachyderm/src/client/pfs"
+	"github.com/pachyderm/pachyderm/src/server/pkg/errutil"
+
+	"github.com/gogo/protobuf/types"
+)
+
+// inspectObject returns the FileInfo of the file at 'key', writing an S3 error
+// and returning nil if it doesn't exist or isn't a regular file.
+func (s *server) inspectObject(w http.ResponseWriter, r *http.Request, pc *client.APIClient, repo, branch, key string) *pfs.FileInfo {
+	fileInfo, err := pc.InspectFile(repo, branch, key)
+	if err != nil {
+		s.objectError(w, r, pc, repo, branch, err)
+		return nil
+	}
+	if fileInfo.FileType != pfs.FileType_FILE {
+		// directories are implicit in S3, so there's no object to return
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", fmt.Sprintf("%s is a directory", key))
+		return nil
+	}
+	return fileInfo
+}
+
+// setObjectHeaders sets the headers common to GetObject and HeadObject.
+func setObjectHeaders(w http.ResponseWriter, fileInfo *pfs.FileInfo) {
+	w.Header().Set("ETag", etag(fileInfo))
+	w.Header().Set("Accept-Ranges", "bytes")
+	contentType := fileInfo.Metadata[pfs.ContentTypeKey]
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if committed, err := types.TimestampFromProto(fileInfo.Committed); err == nil {
+		w.Header().Set("Last-Modified", committed.UTC().Format(http.TimeFormat))
+	}
+}
+
+func (s *server) headObject(w http.ResponseWriter, r *http.Request, repo, branch, key string) {
+	pc := s.requestClient(r)
+	fileInfo := s.inspectObject(w, r, pc, repo, branch, key)
+	if fileInfo == nil {
+		return
+	}
+	setObjectHeaders(w, fileInfo)
+	w.Header().Set("Content-Length", strconv.FormatUint(fileInfo.SizeBytes, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *server) getObject(w http.ResponseWriter, r *http.Request, repo, branch, key string) {
+	pc := s.requestClient(r)
+	fileInfo := s.inspectObject(w, r, pc, repo, branch, key)
+	if fileInfo == nil {
+		return
+	}
+	if checkPreconditions(w, r, fileInfo) {
+		return
+	}
+	size := int64(fileInfo.SizeBytes)
+	offset, length, ok, err := parseRange(r.Header.Get("Range"), size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		writeError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", err.Error())
+		return
+	}
+	if !ok {
+		offset, length = 0, size
+	}
+	// The headers are only written once pachd starts returning the file, so
+	// that an error from GetFile can still be reported in S3's format
+	ow := &objectWriter{w: w, writeHeader: func() {
+		setObjectHeaders(w, fileInfo)
+		status := http.StatusOK
+		if ok {
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(status)
+	}}
+	if length == 0 {
+		ow.start()
+		return
+	}
+	if err := pc.GetFile(repo, branch, key, offset, length, ow); err != nil && !ow.started {
+		s.objectError(w, r, pc, repo, branch, err)
+		return
+	}
+	// Once the body has started there's no way to report an error in S3's
+	// format, so the client will see a short read instead
+	ow.start()
+}
+
+// objectWriter writes an object's body to an http.ResponseWriter, calling
+// 'writeHeader' before the first byte of the body is written.
+type objectWriter struct {
+	w           http.ResponseWriter
+	writeHeader func()
+	started     bool
+}
+
+func (o *objectWriter) start() {
+	if !o.started {
+		o.started = true
+		o.writeHeader()
+	}
+}
+
+func (o *objectWriter) Write(p []byte) (int, error) {
+	o.start()
+	return o.w.Write(p)
+}
+
+// checkPreconditions handles the If-Match and If-None-Match headers on
+// GetObject, returning true if a response has already been written.
+func checkPreconditions(w http.ResponseWriter, r *http.Request, fileInfo *pfs.FileInfo) bool {
+	tag := etag(fileInfo)
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != "*" && !strings.Contains(ifMatch, tag) {
+		writeError(w, r, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		return true
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && (ifNoneMatch == "*" || strings.Contains(ifNoneMatch, tag)) {
+		w.Header().Set("ETag", tag)
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// putObject writes the request body to 'key', overwriting any existing file.
+// If the branch's head is open the write goes into that commit, otherwise
+// pachd creates (and finishes) a new commit on the branch for it.
+func (s *server) putObject(w http.ResponseWriter, r *http.Request, repo, branch, key string) {
+	if strings.HasSuffix(key, "/") {
+		// Some clients create "directories" by putting empty objects ending
+		// in a slash. PFS directories are implicit, so there's nothing to do.
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	pc := s.requestClient(r)
+	var body io.Reader = r.Body
+	if r.Header.Get("X-Amz-Content-Sha256") == streamingPayload ||
+		strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		body = newChunkedReader(r.Body)
+	}
+	var metadata map[string]string
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		metadata = map[string]string{pfs.ContentTypeKey: contentType}
+	}
+	if _, err := pc.PutFileWithMetadata(repo, branch, key, body, true, metadata); err != nil {
+		s.objectError(w, r, pc, repo, branch, err)
+		return
+	}
+	if fileInfo, err := pc.InspectFile(repo, branch, key); err == nil {
+		w.Header().Set("ETag", etag(fileInfo))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteObject deletes 'key' from the branch's head, in the same way as
+// putObject. Like S3, deleting a key that doesn't exist isn't an error.
+func (s *server) deleteObject(w http.ResponseWriter, r *http.Request, repo, branch, key string) {
+	pc := s.requestClient(r)
+	if err := pc.DeleteFile(repo, branch, key); err != nil {
+		if !errutil.IsNotFoundError(err) {
+			pfsError(w, r, err, "NoSuchBucket")
+			return
+		}
+		if _, err := pc.InspectBranch(repo, branch); err != nil {
+			pfsError(w, r, err, "NoSuchBucket")
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,338 @@
+// Package s3 implements a subset of the Amazon S3 API on top of PFS, so that
+// S3 clients (aws-cli, boto, minio, etc.) can read and write Pachyderm data.
+//
+// Buckets are named `branch.repo` and map to the head of that branch, while
+// object keys map to file paths within it. Requests are authenticated by
+// passing a Pachyderm auth token as the S3 access key ID; the secret key is
+// ignored.
+package s3
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pachyderm/pachyderm/src/client"
+	"github.com/pachyderm/pachyderm/src/client/auth"
+	pfsserver "github.com/pachyderm/pachyderm/src/server/pfs"
+	"github.com/pachyderm/pachyderm/src/server/pkg/errutil"
+)
+
+const (
+	// xmlns is the namespace of all S3 API XML documents
+	xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	// timeFormat is the format S3 uses for timestamps in XML bodies
+	timeFormat = "2006-01-02T15:04:05.000Z"
+	// streamingPayload is the x-amz-content-sha256 value sent by SigV4
+	// clients that upload bodies in aws-chunked encoding
+	streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+)
+
+type server struct {
+	address        string
+	pachClient     *client.APIClient
+	pachClientOnce sync.Once
+}
+
+// NewS3Server returns an http.Handler that serves the S3 gateway, proxying
+// requests to the pachd at 'address'.
+func NewS3Server(address string) (http.Handler, error) {
+	return &server{
+		address: address,
+	}, nil
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key := splitPath(r.URL.Path)
+	if bucket == "" {
+		if r.Method == http.MethodGet {
+			s.listBuckets(w, r)
+			return
+		}
+		notImplemented(w, r)
+		return
+	}
+	repo, branch, err := parseBucket(bucket)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidBucketName", err.Error())
+		return
+	}
+	if key == "" {
+		switch r.Method {
+		case http.MethodGet:
+			if _, ok := r.URL.Query()["location"]; ok {
+				s.getBucketLocation(w, r, repo, branch)
+				return
+			}
+			s.listObjects(w, r, repo, branch)
+		case http.MethodHead:
+			s.headBucket(w, r, repo, branch)
+		default:
+			notImplemented(w, r)
+		}
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.getObject(w, r, repo, branch, key)
+	case http.MethodHead:
+		s.headObject(w, r, repo, branch, key)
+	case http.MethodPut:
+		s.putObject(w, r, repo, branch, key)
+	case http.MethodDelete:
+		s.deleteObject(w, r, repo, branch, key)
+	default:
+		notImplemented(w, r)
+	}
+}
+
+// splitPath splits a path-style S3 request path into its bucket and key.
+func splitPath(p string) (bucket string, key string) {
+	p = strings.TrimPrefix(p, "/")
+	parts := strings.SplitN(p, "/", 2)
+	bucket = parts[0]
+	if len(parts) > 1 {
+		key = parts[1]
+	}
+	return bucket, key
+}
+
+// parseBucket splits a bucket name of the form `branch.repo` into its repo
+// and branch.
+func parseBucket(bucket string) (repo string, branch string, err error) {
+	parts := strings.SplitN(bucket, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid bucket name %q, buckets must be of the form \"branch.repo\"", bucket)
+	}
+	return parts[1], parts[0], nil
+}
+
+var (
+	// v4CredentialRe extracts the access key from an AWS SigV4 Authorization
+	// header, e.g. "AWS4-HMAC-SHA256 Credential=<key>/20130524/us-east-1/s3/aws4_request, ..."
+	v4CredentialRe = regexp.MustCompile(`Credential=([^/,\s]+)/`)
+	// v2CredentialRe extracts the access key from an AWS SigV2 Authorization
+	// header, e.g. "AWS <key>:<signature>"
+	v2CredentialRe = regexp.MustCompile(`^AWS ([^:\s]+):`)
+)
+
+// accessKey returns the S3 access key ID used to sign 'r', or "" if the
+// request is anonymous. Both header and query-string (presigned URL) auth are
+// supported, for SigV2 and SigV4.
+func accessKey(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if m := v4CredentialRe.FindStringSubmatch(header); m != nil {
+			return m[1]
+		}
+		if m := v2CredentialRe.FindStringSubmatch(header); m != nil {
+			return m[1]
+		}
+		return ""
+	}
+	query := r.URL.Query()
+	if credential := query.Get("X-Amz-Credential"); credential != "" {
+		return strings.SplitN(credential, "/", 2)[0]
+	}
+	return query.Get("AWSAccessKeyId")
+}
+
+func (s *server) getPachClient() *client.APIClient {
+	s.pachClientOnce.Do(func() {
+		var err error
+		s.pachClient, err = client.NewFromAddress(s.address)
+		if err != nil {
+			panic(fmt.Sprintf("s3 gateway failed to initialize pach client: %v", err))
+		}
+	})
+	return s.pachClient
+}
+
+// requestClient returns a pach client that makes requests on behalf of the
+// caller of 'r', using the S3 access key as a Pachyderm auth token.
+func (s *server) requestClient(r *http.Request) *client.APIClient {
+	// WithCtx copies the client, so setting the token doesn't affect other
+	// requests. Using the request's context cancels its RPCs if the caller
+	// disconnects.
+	pc := s.getPachClient().WithCtx(r.Context())
+	pc.SetAuthToken(accessKey(r))
+	return pc
+}
+
+// s3Error is the XML body of an S3 error response
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	// headers have already been written, so if encoding fails there's
+	// nothing more we can tell the client
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	writeXML(w, status, &s3Error{
+		Code:     code,
+		Message:  message,
+		Resource: r.URL.Path,
+	})
+}
+
+func notImplemented(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotImplemented, "NotImplemented", "This operation is not supported by the Pachyderm S3 gateway")
+}
+
+// pfsError converts an error returned by pachd into an S3 error response.
+// 'notFoundCode' is the S3 error code used if 'err' is a not-found error.
+func pfsError(w http.ResponseWriter, r *http.Request, err error, notFoundCode string) {
+	switch {
+	case auth.IsErrNotAuthorized(err), auth.IsErrNotSignedIn(err), auth.IsErrBadToken(err):
+		writeError(w, r, http.StatusForbidden, "AccessDenied", err.Error())
+	case errutil.IsNotFoundError(err):
+		writeError(w, r, http.StatusNotFound, notFoundCode, err.Error())
+	default:
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+}
+
+// objectError is like pfsError, but distinguishes between a missing key and a
+// missing bucket, which pachd's errors don't do reliably.
+func (s *server) objectError(w http.ResponseWriter, r *http.Request, pc *client.APIClient, repo, branch string, err error) {
+	if pfsserver.IsNoHeadErr(err) {
+		// the bucket exists, but is empty
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", err.Error())
+		return
+	}
+	if errutil.IsNotFoundError(err) {
+		if _, branchErr := pc.InspectBranch(repo, branch); errutil.IsNotFoundError(branchErr) {
+			pfsError(w, r, branchErr, "NoSuchBucket")
+			return
+		}
+		pfsError(w, r, err, "NoSuchKey")
+		return
+	}
+	pfsError(w, r, err, "NoSuchBucket")
+}
+
+// parseRange parses the value of an HTTP Range header against a file of
+// 'size' bytes, returning the offset and length of the requested range. 'ok'
+// is false if the header is absent or specifies something other than a
+// single byte range, in which case the whole file should be served. An error
+// is returned if the range is unsatisfiable.
+func parseRange(header string, size int64) (offset int64, length int64, ok bool, err error) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return 0, 0, false, nil
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+	if strings.Contains(spec, ",") {
+		// multi-range requests aren't supported by S3 either
+		return 0, 0, false, nil
+	}
+	parts := strings.SplitN(strings.TrimSpace(spec), "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false, nil
+	}
+	if parts[0] == "" {
+		// suffix range: the last N bytes
+		n, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return 0, 0, false, nil
+		}
+		if n <= 0 || size == 0 {
+			return 0, 0, false, fmt.Errorf("range %q is not satisfiable for object of size %d", header, size)
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false, nil
+	}
+	if start >= size {
+		return 0, 0, false, fmt.Errorf("range %q is not satisfiable for object of size %d", header, size)
+	}
+	end := size - 1
+	if parts[1] != "" {
+		end, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true, nil
+}
+
+// chunkedReader decodes a body in aws-chunked encoding, which SigV4 clients
+// use for streaming uploads. Each chunk is of the form
+// "<hex size>;chunk-signature=<signature>\r\n<data>\r\n", and the body is
+// terminated by a zero-sized chunk. Chunk signatures aren't verified, as the
+// access key alone is used to authenticate.
+type chunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+}
+
+func newChunkedReader(r io.Reader) *chunkedReader {
+	return &chunkedReader{r: bufio.NewReader(r)}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.remaining == 0 {
+		header, err := c.r.ReadString('\n')
+		if err != nil {
+			return 0, fmt.Errorf("malformed aws-chunked body: %v", err)
+		}
+		header = strings.TrimSpace(header)
+		if i := strings.Index(header, ";"); i >= 0 {
+			header = header[:i]
+		}
+		size, err := strconv.ParseInt(header, 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("malformed aws-chunked chunk size %q", header)
+		}
+		if size == 0 {
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = size
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.remaining == 0 && err == nil {
+		// consume the trailing \r\n
+		if _, err := c.r.Discard(2); err != nil {
+			return n, fmt.Errorf("malformed aws-chunked body: %v", err)
+		}
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
@@ -0,0 +1,297 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/pachyderm/pachyderm/src/client"
+	"github.com/pachyderm/pachyderm/src/client/pfs"
+	"github.com/pachyderm/pachyderm/src/client/pkg/require"
+	pfs_server "github.com/pachyderm/pachyderm/src/server/pfs/server"
+)
+
+func TestParseBucket(t *testing.T) {
+	repo, branch, err := parseBucket("master.images")
+	require.NoError(t, err)
+	require.Equal(t, "images", repo)
+	require.Equal(t, "master", branch)
+
+	// Only the first dot separates the branch from the repo
+	repo, branch, err = parseBucket("master.my.repo")
+	require.NoError(t, err)
+	require.Equal(t, "my.repo", repo)
+	require.Equal(t, "master", branch)
+
+	_, _, err = parseBucket("images")
+	require.YesError(t, err)
+	_, _, err = parseBucket(".images")
+	require.YesError(t, err)
+}
+
+func TestSplitPath(t *testing.T) {
+	bucket, key := splitPath("/master.images/dir/file.png")
+	require.Equal(t, "master.images", bucket)
+	require.Equal(t, "dir/file.png", key)
+	bucket, key = splitPath("/master.images")
+	require.Equal(t, "master.images", bucket)
+	require.Equal(t, "", key)
+	bucket, key = splitPath("/")
+	require.Equal(t, "", bucket)
+	require.Equal(t, "", key)
+}
+
+func TestAccessKey(t *testing.T) {
+	r, err := http.NewRequest("GET", "/master.images", nil)
+	require.NoError(t, err)
+	require.Equal(t, "", accessKey(r))
+
+	r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=token123/20190101/us-east-1/s3/aws4_request, SignedHeaders=host, Signature=abc")
+	require.Equal(t, "token123", accessKey(r))
+
+	r.Header.Set("Authorization", "AWS token456:c2lnbmF0dXJl")
+	require.Equal(t, "token456", accessKey(r))
+
+	r, err = http.NewRequest("GET", "/master.images/file?X-Amz-Credential=token789%2F20190101%2Fus-east-1%2Fs3%2Faws4_request", nil)
+	require.NoError(t, err)
+	require.Equal(t, "token789", accessKey(r))
+}
+
+func TestParseRange(t *testing.T) {
+	offset, length, ok, err := parseRange("", 100)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	offset, length, ok, err = parseRange("bytes=10-19", 100)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(10), offset)
+	require.Equal(t, int64(10), length)
+
+	offset, length, ok, err = parseRange("bytes=90-", 100)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(90), offset)
+	require.Equal(t, int64(10), length)
+
+	offset, length, ok, err = parseRange("bytes=-30", 100)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(70), offset)
+	require.Equal(t, int64(30), length)
+
+	// The end of the range is clamped to the size of the file
+	offset, length, ok, err = parseRange("bytes=50-1000", 100)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(50), offset)
+	require.Equal(t, int64(50), length)
+
+	_, _, _, err = parseRange("bytes=100-", 100)
+	require.YesError(t, err)
+
+	// Multiple ranges fall back to the whole file
+	_, _, ok, err = parseRange("bytes=0-1,5-6", 100)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestChunkedReader(t *testing.T) {
+	body := "5;chunk-signature=aaaa\r\nhello\r\n6;chunk-signature=bbbb\r\n world\r\n0;chunk-signature=cccc\r\n\r\n"
+	data, err := ioutil.ReadAll(newChunkedReader(strings.NewReader(body)))
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(data))
+
+	_, err = ioutil.ReadAll(newChunkedReader(strings.NewReader("5;chunk-signature=aaaa\r\nhel")))
+	require.YesError(t, err)
+}
+
+func fileInfo(path string, fileType pfs.FileType) *pfs.FileInfo {
+	return &pfs.FileInfo{
+		File:     client.NewFile("repo", "master", path),
+		FileType: fileType,
+	}
+}
+
+func keys(entries []listEntry) []string {
+	var result []string
+	for _, entry := range entries {
+		result = append(result, entry.key)
+	}
+	return result
+}
+
+func TestGroupByDelimiter(t *testing.T) {
+	// The result of listing "/" with the "/" delimiter
+	entries := groupByDelimiter([]*pfs.FileInfo{
+		fileInfo("/dir", pfs.FileType_DIR),
+		fileInfo("/a.txt", pfs.FileType_FILE),
+		fileInfo("/b.txt", pfs.FileType_FILE),
+	}, "", "/")
+	require.Equal(t, []string{"a.txt", "b.txt", "dir/"}, keys(entries))
+	require.Nil(t, entries[2].fileInfo)
+
+	// The result of globbing everything, with no delimiter
+	files := []*pfs.FileInfo{
+		fileInfo("/", pfs.FileType_DIR),
+		fileInfo("/a.txt", pfs.FileType_FILE),
+		fileInfo("/dir", pfs.FileType_DIR),
+		fileInfo("/dir/c-1.txt", pfs.FileType_FILE),
+		fileInfo("/dir/c-2.txt", pfs.FileType_FILE),
+	}
+	require.Equal(t, []string{"a.txt", "dir/c-1.txt", "dir/c-2.txt"}, keys(groupByDelimiter(files, "", "")))
+	require.Equal(t, []string{"dir/c-1.txt", "dir/c-2.txt"}, keys(groupByDelimiter(files, "dir/", "")))
+
+	// Delimiters other than "/" are supported too
+	require.Equal(t, []string{"a.txt", "dir/c-"}, keys(groupByDelimiter(files, "", "-")))
+}
+
+// newTestServer returns an S3 gateway backed by an in-process pachd.
+func newTestServer(t *testing.T) (*server, *client.APIClient) {
+	pc := pfs_server.GetPachClient(t)
+	s := &server{pachClient: pc}
+	// don't dial s.address
+	s.pachClientOnce.Do(func() {})
+	return s, pc
+}
+
+func serve(s *server, method, path string, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for key, value := range header {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+// errorCode returns the S3 error code in the body of 'w'
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	result := &s3Error{}
+	require.NoError(t, xml.Unmarshal(w.Body.Bytes(), result))
+	return result.Code
+}
+
+func TestGetObject(t *testing.T) {
+	s, pc := newTestServer(t)
+	require.NoError(t, pc.CreateRepo("repo"))
+	_, err := pc.PutFile("repo", "master", "dir/a.txt", strings.NewReader("hello world"))
+	require.NoError(t, err)
+
+	w := serve(s, "GET", "/master.repo/dir/a.txt", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "hello world", w.Body.String())
+	require.Equal(t, "11", w.Header().Get("Content-Length"))
+	require.True(t, w.Header().Get("ETag") != "")
+
+	w = serve(s, "GET", "/master.repo/dir/a.txt", "", map[string]string{"Range": "bytes=6-"})
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	require.Equal(t, "world", w.Body.String())
+	require.Equal(t, "bytes 6-10/11", w.Header().Get("Content-Range"))
+
+	w = serve(s, "GET", "/master.repo/dir/a.txt", "", map[string]string{"If-None-Match": w.Header().Get("ETag")})
+	require.Equal(t, http.StatusNotModified, w.Code)
+
+	// errors are reported in S3's format, without the object's headers
+	w = serve(s, "GET", "/master.repo/missing.txt", "", nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "NoSuchKey", errorCode(t, w))
+	require.Equal(t, "", w.Header().Get("ETag"))
+	w = serve(s, "GET", "/master.repo/dir", "", nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "NoSuchKey", errorCode(t, w))
+	w = serve(s, "GET", "/master.missing/a.txt", "", nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "NoSuchBucket", errorCode(t, w))
+}
+
+func TestPutObject(t *testing.T) {
+	s, pc := newTestServer(t)
+	require.NoError(t, pc.CreateRepo("repo"))
+
+	w := serve(s, "PUT", "/master.repo/dir/b.txt", "foo", map[string]string{"Content-Type": "text/plain"})
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	require.True(t, etag != "")
+	var buf bytes.Buffer
+	require.NoError(t, pc.GetFile("repo", "master", "dir/b.txt", 0, 0, &buf))
+	require.Equal(t, "foo", buf.String())
+	w = serve(s, "GET", "/master.repo/dir/b.txt", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+
+	// putting a key again overwrites it
+	w = serve(s, "PUT", "/master.repo/dir/b.txt", "barbaz", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotEqual(t, etag, w.Header().Get("ETag"))
+	w = serve(s, "GET", "/master.repo/dir/b.txt", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "barbaz", w.Body.String())
+
+	// aws-chunked bodies are decoded
+	body := "3;chunk-signature=aaaa\r\nqux\r\n0;chunk-signature=bbbb\r\n\r\n"
+	w = serve(s, "PUT", "/master.repo/c.txt", body, map[string]string{"X-Amz-Content-Sha256": streamingPayload})
+	require.Equal(t, http.StatusOK, w.Code)
+	buf.Reset()
+	require.NoError(t, pc.GetFile("repo", "master", "c.txt", 0, 0, &buf))
+	require.Equal(t, "qux", buf.String())
+
+	w = serve(s, "PUT", "/master.missing/a.txt", "foo", nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "NoSuchBucket", errorCode(t, w))
+}
+
+func TestListObjects(t *testing.T) {
+	s, pc := newTestServer(t)
+	require.NoError(t, pc.CreateRepo("repo"))
+	require.NoError(t, pc.CreateBranch("repo", "master", "", nil))
+	list := func(query string) *listBucketResult {
+		w := serve(s, "GET", "/master.repo?"+query, "", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		result := &listBucketResult{}
+		require.NoError(t, xml.Unmarshal(w.Body.Bytes(), result))
+		return result
+	}
+	listKeys := func(result *listBucketResult) []string {
+		var keys []string
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		for _, p := range result.CommonPrefixes {
+			keys = append(keys, p.Prefix)
+		}
+		return keys
+	}
+
+	// an empty branch is an empty bucket
+	require.Equal(t, 0, len(list("").Contents))
+
+	for _, p := range []string{"a.txt", "dir/b.txt", "dir/c.txt"} {
+		_, err := pc.PutFile("repo", "master", p, strings.NewReader("foo"))
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"a.txt", "dir/b.txt", "dir/c.txt"}, listKeys(list("")))
+	require.Equal(t, []string{"a.txt", "dir/"}, listKeys(list("delimiter=/")))
+	require.Equal(t, []string{"dir/b.txt", "dir/c.txt"}, listKeys(list("prefix=dir/&delimiter=/")))
+
+	// listings are paged with markers (V1) and continuation tokens (V2)
+	result := list("max-keys=2")
+	require.Equal(t, []string{"a.txt", "dir/b.txt"}, listKeys(result))
+	require.True(t, result.IsTruncated)
+	require.Equal(t, []string{"dir/c.txt"}, listKeys(list("marker="+url.QueryEscape(result.NextMarker))))
+	result = list("list-type=2&max-keys=2")
+	require.Equal(t, 2, result.KeyCount)
+	require.True(t, result.IsTruncated)
+	result = list("list-type=2&continuation-token=" + url.QueryEscape(result.NextContinuationToken))
+	require.Equal(t, []string{"dir/c.txt"}, listKeys(result))
+	require.False(t, result.IsTruncated)
+
+	w := serve(s, "GET", "/master.missing", "", nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "NoSuchBucket", errorCode(t, w))
+}
@@ -0,0 +1,263 @@
+package cmds
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gogo/protobuf/jsonpb"
+	pachdclient "github.com/pachyderm/pachyderm/src/client"
+	"github.com/pachyderm/pachyderm/src/client/pkg/grpcutil"
+	"github.com/pachyderm/pachyderm/src/client/webhook"
+	"github.com/pachyderm/pachyderm/src/server/pkg/cmdutil"
+	"github.com/pachyderm/pachyderm/src/server/pkg/tabwriter"
+	"github.com/pachyderm/pachyderm/src/server/webhook/pretty"
+	"github.com/spf13/cobra"
+)
+
+const (
+	codestart = "```sh"
+	codeend   = "```"
+)
+
+// parseEventType parses an event type, given either as its proto name (e.g.
+// "JOB_FAILURE") or as it's printed by pachctl (e.g. "job-failure").
+func parseEventType(s string) (webhook.EventType, error) {
+	name := strings.ToUpper(strings.Replace(s, "-", "_", -1))
+	eventType, ok := webhook.EventType_value[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown event type %q (must be one of commit-finished, job-failure or pipeline-failure)", s)
+	}
+	return webhook.EventType(eventType), nil
+}
+
+// Cmds returns a slice containing webhook commands.
+func Cmds(noMetrics *bool) []*cobra.Command {
+	metrics := !*noMetrics
+	raw := false
+	rawFlag := func(cmd *cobra.Command) {
+		cmd.Flags().BoolVar(&raw, "raw", false, "disable pretty printing, print raw json")
+	}
+	marshaller := &jsonpb.Marshaler{
+		Indent:   "  ",
+		OrigName: true,
+	}
+
+	webhookDocs := &cobra.Command{
+		Use:   "webhook",
+		Short: "Docs for webhooks.",
+		Long: `Webhooks notify HTTP endpoints about lifecycle events in Pachyderm.
+
+Each time a commit finishes, a job fails or a pipeline enters the failure
+state, pachd POSTs a JSON description of the event to every webhook whose
+filter matches it. Deliveries that fail are retried with exponential backoff
+for up to 15 minutes, and the recent deliveries of each webhook (and their
+status) can be inspected with list-delivery.
+
+Each delivery carries these headers:
+  X-Pachyderm-Event:     the event type, e.g. JOB_FAILURE
+  X-Pachyderm-Delivery:  the delivery's ID, which is the same across retries
+  X-Pachyderm-Signature: "sha256=" followed by the hex-encoded HMAC-SHA256
+                         of the body, keyed with the webhook's secret
+
+Endpoints should verify the signature before trusting a delivery.`,
+	}
+
+	var secret string
+	var eventTypes []string
+	var repo string
+	var branch string
+	var pipeline string
+	var update bool
+	createWebhook := &cobra.Command{
+		Use:   "create-webhook name url",
+		Short: "Register an HTTP endpoint to be notified about events.",
+		Long: `Register an HTTP endpoint to be notified about events.
+
+If --secret isn't given, a random secret is generated and printed. Only
+cluster admins may manage webhooks if auth is active.
+
+Examples:
+
+` + codestart + `# notify a chat bot whenever a job or pipeline fails
+$ pachctl create-webhook oncall https://example.com/hook --event job-failure --event pipeline-failure
+
+# notify a catalog service when commits on the master branch of repo foo finish
+$ pachctl create-webhook catalog https://example.com/commits --event commit-finished --repo foo --branch master --secret s3cr3t
+` + codeend,
+		Run: cmdutil.RunFixedArgs(2, func(args []string) error {
+			client, err := pachdclient.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			filter := &webhook.Filter{
+				Repo:     repo,
+				Branch:   branch,
+				Pipeline: pipeline,
+			}
+			for _, e := range eventTypes {
+				eventType, err := parseEventType(e)
+				if err != nil {
+					return err
+				}
+				filter.Events = append(filter.Events, eventType)
+			}
+			generated := false
+			if secret == "" {
+				buf := make([]byte, 32)
+				if _, err := rand.Read(buf); err != nil {
+					return fmt.Errorf("could not generate secret: %v", err)
+				}
+				secret = hex.EncodeToString(buf)
+				generated = true
+			}
+			if err := client.CreateWebhook(args[0], args[1], secret, filter, update); err != nil {
+				return err
+			}
+			if generated {
+				fmt.Printf("Deliveries to %s will be signed with the secret: %s\n", args[0], secret)
+			}
+			return nil
+		}),
+	}
+	createWebhook.Flags().StringVar(&secret, "secret", "", "The secret used to sign deliveries.")
+	createWebhook.Flags().StringSliceVarP(&eventTypes, "event", "e", nil, "Only notify the webhook about events of this type (commit-finished, job-failure or pipeline-failure); may be repeated. By default the webhook is notified about every event.")
+	createWebhook.Flags().StringVarP(&repo, "repo", "r", "", "Only notify the webhook about commits in this repo, and about jobs and pipelines that output to it.")
+	createWebhook.Flags().StringVarP(&branch, "branch", "b", "", "Only notify the webhook about commits on this branch (doesn't apply to job and pipeline events).")
+	createWebhook.Flags().StringVarP(&pipeline, "pipeline", "p", "", "Only notify the webhook about this pipeline, its jobs and its output commits.")
+	createWebhook.Flags().BoolVar(&update, "update", false, "Replace the webhook if it already exists.")
+
+	inspectWebhook := &cobra.Command{
+		Use:   "inspect-webhook name",
+		Short: "Return info about a webhook.",
+		Long:  "Return info about a webhook.",
+		Run: cmdutil.RunFixedArgs(1, func(args []string) error {
+			client, err := pachdclient.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			webhookInfo, err := client.InspectWebhook(args[0])
+			if err != nil {
+				return err
+			}
+			if raw {
+				return marshaller.Marshal(os.Stdout, webhookInfo)
+			}
+			pretty.PrintDetailedWebhookInfo(os.Stdout, webhookInfo)
+			return nil
+		}),
+	}
+	rawFlag(inspectWebhook)
+
+	listWebhook := &cobra.Command{
+		Use:   "list-webhook",
+		Short: "Return info about all webhooks.",
+		Long:  "Return info about all webhooks.",
+		Run: cmdutil.RunFixedArgs(0, func(args []string) error {
+			client, err := pachdclient.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			webhookInfos, err := client.ListWebhook()
+			if err != nil {
+				return err
+			}
+			if raw {
+				for _, webhookInfo := range webhookInfos {
+					if err := marshaller.Marshal(os.Stdout, webhookInfo); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			writer := tabwriter.NewWriter(os.Stdout, pretty.WebhookHeader)
+			for _, webhookInfo := range webhookInfos {
+				pretty.PrintWebhookInfo(writer, webhookInfo)
+			}
+			return writer.Flush()
+		}),
+	}
+	rawFlag(listWebhook)
+
+	var all bool
+	deleteWebhook := &cobra.Command{
+		Use:   "delete-webhook name",
+		Short: "Delete a webhook.",
+		Long:  "Delete a webhook, along with the record of its deliveries. Deliveries that are still being retried are abandoned.",
+		Run: cmdutil.RunBoundedArgs(0, 1, func(args []string) error {
+			client, err := pachdclient.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			if len(args) > 0 && all {
+				return fmt.Errorf("cannot use the --all flag with an argument")
+			}
+			if len(args) == 0 && !all {
+				return fmt.Errorf("either a webhook name or the --all flag needs to be provided")
+			}
+			if all {
+				_, err := client.WebhookAPIClient.DeleteWebhook(
+					client.Ctx(),
+					&webhook.DeleteWebhookRequest{All: true},
+				)
+				return grpcutil.ScrubGRPC(err)
+			}
+			return client.DeleteWebhook(args[0])
+		}),
+	}
+	deleteWebhook.Flags().BoolVar(&all, "all", false, "delete all webhooks")
+
+	var limit int64
+	listDelivery := &cobra.Command{
+		Use:   "list-delivery name",
+		Short: "Return the recent deliveries of a webhook.",
+		Long: `Return the recent deliveries of a webhook, most recent first, along with
+their status. Pending deliveries are still being retried. pachd keeps the last
+100 finished deliveries of each webhook.
+
+Examples:
+
+` + codestart + `# return the last 10 deliveries of webhook oncall
+$ pachctl list-delivery oncall --limit 10
+
+# return the events and errors of webhook oncall's deliveries
+$ pachctl list-delivery oncall --raw
+` + codeend,
+		Run: cmdutil.RunFixedArgs(1, func(args []string) error {
+			client, err := pachdclient.NewOnUserMachine(metrics, "user")
+			if err != nil {
+				return err
+			}
+			deliveries, err := client.ListDelivery(args[0], limit)
+			if err != nil {
+				return err
+			}
+			if raw {
+				for _, delivery := range deliveries {
+					if err := marshaller.Marshal(os.Stdout, delivery); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			writer := tabwriter.NewWriter(os.Stdout, pretty.DeliveryHeader)
+			for _, delivery := range deliveries {
+				pretty.PrintDelivery(writer, delivery)
+			}
+			return writer.Flush()
+		}),
+	}
+	listDelivery.Flags().Int64VarP(&limit, "limit", "l", 0, "Return at most this many deliveries (0 means no limit).")
+	rawFlag(listDelivery)
+
+	return []*cobra.Command{
+		webhookDocs,
+		createWebhook,
+		inspectWebhook,
+		listWebhook,
+		deleteWebhook,
+		listDelivery,
+	}
+}
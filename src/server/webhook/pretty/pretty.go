@@ -0,0 +1,112 @@
+package pretty
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/pachyderm/pachyderm/src/client/webhook"
+	"github.com/pachyderm/pachyderm/src/server/pkg/pretty"
+)
+
+const (
+	// WebhookHeader is the header for webhooks.
+	WebhookHeader = "NAME\tURL\tEVENTS\tFILTER\tCREATED\t\n"
+	// DeliveryHeader is the header for deliveries.
+	DeliveryHeader = "ID\tEVENT\tSUBJECT\tCREATED\tATTEMPTS\tSTATUS\tSTATE\t\n"
+)
+
+// PrintWebhookInfo pretty-prints webhook info.
+func PrintWebhookInfo(w io.Writer, webhookInfo *webhook.WebhookInfo) {
+	fmt.Fprintf(w, "%s\t", webhookInfo.Webhook.Name)
+	fmt.Fprintf(w, "%s\t", webhookInfo.URL)
+	fmt.Fprintf(w, "%s\t", events(webhookInfo.Filter))
+	fmt.Fprintf(w, "%s\t", filter(webhookInfo.Filter))
+	fmt.Fprintf(w, "%s\t\n", pretty.Ago(webhookInfo.Created))
+}
+
+// PrintDetailedWebhookInfo pretty-prints detailed webhook info.
+func PrintDetailedWebhookInfo(w io.Writer, webhookInfo *webhook.WebhookInfo) {
+	fmt.Fprintf(w, "Name: %s\n", webhookInfo.Webhook.Name)
+	fmt.Fprintf(w, "URL: %s\n", webhookInfo.URL)
+	fmt.Fprintf(w, "Created: %s\n", pretty.Ago(webhookInfo.Created))
+	fmt.Fprintf(w, "Events: %s\n", events(webhookInfo.Filter))
+	fmt.Fprintf(w, "Filter: %s\n", filter(webhookInfo.Filter))
+}
+
+// PrintDelivery pretty-prints a delivery.
+func PrintDelivery(w io.Writer, delivery *webhook.Delivery) {
+	fmt.Fprintf(w, "%s\t", delivery.ID)
+	fmt.Fprintf(w, "%s\t", eventType(delivery.Event.Type))
+	fmt.Fprintf(w, "%s\t", subject(delivery.Event))
+	fmt.Fprintf(w, "%s\t", pretty.Ago(delivery.Created))
+	fmt.Fprintf(w, "%d\t", delivery.Attempts)
+	if delivery.StatusCode != 0 {
+		fmt.Fprintf(w, "%d\t", delivery.StatusCode)
+	} else {
+		fmt.Fprintf(w, "-\t")
+	}
+	// because STATE is a colorful field it has to be at the end of the line,
+	// otherwise the terminal escape characters will trip up the tabwriter
+	fmt.Fprintf(w, "%s\t\n", deliveryState(delivery.State))
+}
+
+func eventType(eventType webhook.EventType) string {
+	return strings.Replace(strings.ToLower(eventType.String()), "_", "-", -1)
+}
+
+func events(f *webhook.Filter) string {
+	if f == nil || len(f.Events) == 0 {
+		return "all"
+	}
+	var result []string
+	for _, e := range f.Events {
+		result = append(result, eventType(e))
+	}
+	return strings.Join(result, ",")
+}
+
+func filter(f *webhook.Filter) string {
+	if f == nil {
+		return "-"
+	}
+	var result []string
+	if f.Repo != "" {
+		result = append(result, "repo="+f.Repo)
+	}
+	if f.Branch != "" {
+		result = append(result, "branch="+f.Branch)
+	}
+	if f.Pipeline != "" {
+		result = append(result, "pipeline="+f.Pipeline)
+	}
+	if len(result) == 0 {
+		return "-"
+	}
+	return strings.Join(result, ",")
+}
+
+func subject(event *webhook.Event) string {
+	switch {
+	case event.Job != nil:
+		return event.Job.ID
+	case event.Commit != nil:
+		return fmt.Sprintf("%s@%s", event.Commit.Repo.Name, event.Commit.ID)
+	case event.Pipeline != nil:
+		return event.Pipeline.Name
+	}
+	return "-"
+}
+
+func deliveryState(state webhook.DeliveryState) string {
+	switch state {
+	case webhook.DeliveryState_DELIVERY_PENDING:
+		return color.New(color.FgYellow).SprintFunc()("pending")
+	case webhook.DeliveryState_DELIVERY_SUCCESS:
+		return color.New(color.FgGreen).SprintFunc()("success")
+	case webhook.DeliveryState_DELIVERY_FAILURE:
+		return color.New(color.FgRed).SprintFunc()("failure")
+	}
+	return "-"
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	etcd "github.com/coreos/etcd/clientv3"
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	"golang.org/x/net/context"
+
+	"github.com/pachyderm/pachyderm/src/client"
+	"github.com/pachyderm/pachyderm/src/client/auth"
+	"github.com/pachyderm/pachyderm/src/client/webhook"
+	col "github.com/pachyderm/pachyderm/src/server/pkg/collection"
+	"github.com/pachyderm/pachyderm/src/server/pkg/errutil"
+	"github.com/pachyderm/pachyderm/src/server/pkg/log"
+)
+
+const (
+	webhooksPrefix      = "/webhooks"
+	deliveriesPrefix    = "/deliveries"
+	deliveryLocksPrefix = "/deliveryLocks"
+
+	// deliveryTimeout is how long a single delivery attempt may take
+	deliveryTimeout = 30 * time.Second
+)
+
+var validWebhookName = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+type apiServer struct {
+	pachLogger log.Logger
+	etcdClient *etcd.Client
+
+	etcdPrefix    string
+	pfsEtcdPrefix string
+	ppsEtcdPrefix string
+
+	// webhooks is a collection of webhook.WebhookInfos, keyed by name
+	webhooks col.Collection
+
+	httpClient *http.Client
+
+	// pachyderm client (used to check that callers are admins)
+	pachdAddress   string
+	pachClient     *client.APIClient
+	pachClientOnce sync.Once // protects initialization
+}
+
+func (a *apiServer) getPachClient() *client.APIClient {
+	a.pachClientOnce.Do(func() {
+		var err error
+		a.pachClient, err = client.NewFromAddress(a.pachdAddress)
+		if err != nil {
+			panic(fmt.Sprintf("webhook API failed to initialize pach client: %v", err))
+		}
+	})
+	return a.pachClient
+}
+
+func (a *apiServer) LogReq(request interface{}) {
+	a.pachLogger.Log(request, nil, nil, 0)
+}
+
+// NewAPIServer returns an implementation of webhook.APIServer. Besides
+// serving the webhook API, it watches the PFS and PPS collections stored under
+// 'pfsEtcdPrefix' and 'ppsEtcdPrefix' and notifies webhooks about the events
+// they're interested in.
+func NewAPIServer(pachdAddress, etcdAddress, etcdPrefix, pfsEtcdPrefix, ppsEtcdPrefix string) (webhook.APIServer, error) {
+	etcdClient, err := etcd.New(etcd.Config{
+		Endpoints:   []string{etcdAddress},
+		DialOptions: client.DefaultDialOptions(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error constructing etcdClient: %s", err.Error())
+	}
+
+	s := &apiServer{
+		pachLogger:    log.NewLogger("webhook.API"),
+		etcdClient:    etcdClient,
+		etcdPrefix:    etcdPrefix,
+		pfsEtcdPrefix: pfsEtcdPrefix,
+		ppsEtcdPrefix: ppsEtcdPrefix,
+		webhooks: col.NewCollection(
+			etcdClient,
+			path.Join(etcdPrefix, webhooksPrefix),
+			nil,
+			&webhook.WebhookInfo{},
+			nil,
+			nil,
+		),
+		httpClient:   &http.Client{Timeout: deliveryTimeout},
+		pachdAddress: pachdAddress,
+	}
+	go s.resumeDeliveries()
+	go s.watchCommits()
+	go s.watchJobs()
+	go s.watchPipelines()
+	return s, nil
+}
+
+// deliveries returns the collection of the deliveries of 'name', keyed by
+// delivery ID. If 'name' is empty, it returns a collection containing the
+// deliveries of every webhook.
+func (a *apiServer) deliveries(name string) col.Collection {
+	return col.NewCollection(
+		a.etcdClient,
+		path.Join(a.etcdPrefix, deliveriesPrefix, name),
+		nil,
+		&webhook.Delivery{},
+		nil,
+		nil,
+	)
+}
+
+// checkIsAdmin returns an error if auth is active and the caller isn't a
+// cluster admin. Webhooks receive events from every repo and pipeline, so only
+// admins may manage them.
+func (a *apiServer) checkIsAdmin(ctx context.Context, op string) error {
+	pachClient := a.getPachClient().WithCtx(ctx)
+	me, err := pachClient.WhoAmI(pachClient.Ctx(), &auth.WhoAmIRequest{})
+	if err != nil {
+		if auth.IsErrNotActivated(err) {
+			return nil
+		}
+		return fmt.Errorf("error during authorization check: %v", err)
+	}
+	if !me.IsAdmin {
+		return &auth.ErrNotAuthorized{
+			Subject: me.Username,
+			AdminOp: op,
+		}
+	}
+	return nil
+}
+
+func validateWebhook(request *webhook.CreateWebhookRequest) error {
+	if request.Webhook == nil || request.Webhook.Name == "" {
+		return fmt.Errorf("webhook name must be set")
+	}
+	if !validWebhookName.MatchString(request.Webhook.Name) {
+		return fmt.Errorf("webhook name %q may only contain alphanumeric characters, underscores and dashes", request.Webhook.Name)
+	}
+	u, err := url.Parse(request.URL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL %q: %v", request.URL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q: must be an absolute http or https URL", request.URL)
+	}
+	if request.Secret == "" {
+		return fmt.Errorf("webhook secret must be set, it's used to sign deliveries")
+	}
+	if request.Filter != nil {
+		for _, eventType := range request.Filter.Events {
+			if _, ok := webhook.EventType_name[int32(eventType)]; !ok {
+				return fmt.Errorf("invalid event type %d", eventType)
+			}
+		}
+	}
+	return nil
+}
+
+// CreateWebhook implements the protobuf webhook.CreateWebhook RPC
+func (a *apiServer) CreateWebhook(ctx context.Context, request *webhook.CreateWebhookRequest) (response *types.Empty, retErr error) {
+	// Log the request without the webhook's secret, which signs deliveries
+	loggedRequest := proto.Clone(request).(*webhook.CreateWebhookRequest)
+	loggedRequest.Secret = ""
+	a.LogReq(loggedRequest)
+	defer func(start time.Time) { a.pachLogger.Log(loggedRequest, response, retErr, time.Since(start)) }(time.Now())
+
+	if err := a.checkIsAdmin(ctx, "CreateWebhook"); err != nil {
+		return nil, err
+	}
+	if err := validateWebhook(request); err != nil {
+		return nil, err
+	}
+	if _, err := col.NewSTM(ctx, a.etcdClient, func(stm col.STM) error {
+		webhooks := a.webhooks.ReadWrite(stm)
+		webhookInfo := &webhook.WebhookInfo{}
+		if err := webhooks.Get(request.Webhook.Name, webhookInfo); err != nil {
+			if !col.IsErrNotFound(err) {
+				return err
+			}
+			webhookInfo.Created = types.TimestampNow()
+		} else if !request.Update {
+			return fmt.Errorf("webhook %s already exists", request.Webhook.Name)
+		}
+		webhookInfo.Webhook = request.Webhook
+		webhookInfo.URL = request.URL
+		webhookInfo.Secret = request.Secret
+		webhookInfo.Filter = request.Filter
+		return webhooks.Put(request.Webhook.Name, webhookInfo)
+	}); err != nil {
+		return nil, err
+	}
+	return &types.Empty{}, nil
+}
+
+// InspectWebhook implements the protobuf webhook.InspectWebhook RPC
+func (a *apiServer) InspectWebhook(ctx context.Context, request *webhook.InspectWebhookRequest) (response *webhook.WebhookInfo, retErr error) {
+	a.LogReq(request)
+	defer func(start time.Time) { a.pachLogger.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	if err := a.checkIsAdmin(ctx, "InspectWebhook"); err != nil {
+		return nil, err
+	}
+	if request.Webhook == nil {
+		return nil, fmt.Errorf("webhook must be set")
+	}
+	webhookInfo := &webhook.WebhookInfo{}
+	if err := a.webhooks.ReadOnly(ctx).Get(request.Webhook.Name, webhookInfo); err != nil {
+		if col.IsErrNotFound(err) {
+			return nil, fmt.Errorf("webhook %s not found", request.Webhook.Name)
+		}
+		return nil, err
+	}
+	webhookInfo.Secret = ""
+	return webhookInfo, nil
+}
+
+// ListWebhook implements the protobuf webhook.ListWebhook RPC
+func (a *apiServer) ListWebhook(ctx context.Context, request *webhook.ListWebhookRequest) (response *webhook.WebhookInfos, retErr error) {
+	a.LogReq(request)
+	defer func(start time.Time) { a.pachLogger.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	if err := a.checkIsAdmin(ctx, "ListWebhook"); err != nil {
+		return nil, err
+	}
+	webhookInfos, err := a.listWebhook(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, webhookInfo := range webhookInfos {
+		webhookInfo.Secret = ""
+	}
+	return &webhook.WebhookInfos{WebhookInfo: webhookInfos}, nil
+}
+
+func (a *apiServer) listWebhook(ctx context.Context) ([]*webhook.WebhookInfo, error) {
+	var result []*webhook.WebhookInfo
+	webhookInfo := &webhook.WebhookInfo{}
+	if err := a.webhooks.ReadOnly(ctx).List(webhookInfo, col.DefaultOptions, func(string) error {
+		result = append(result, proto.Clone(webhookInfo).(*webhook.WebhookInfo))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteWebhook implements the protobuf webhook.DeleteWebhook RPC
+func (a *apiServer) DeleteWebhook(ctx context.Context, request *webhook.DeleteWebhookRequest) (response *types.Empty, retErr error) {
+	a.LogReq(request)
+	defer func(start time.Time) { a.pachLogger.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	if err := a.checkIsAdmin(ctx, "DeleteWebhook"); err != nil {
+		return nil, err
+	}
+	if !request.All && request.Webhook == nil {
+		return nil, fmt.Errorf("either webhook or all must be set")
+	}
+	if _, err := col.NewSTM(ctx, a.etcdClient, func(stm col.STM) error {
+		webhooks := a.webhooks.ReadWrite(stm)
+		if request.All {
+			webhooks.DeleteAll()
+			a.deliveries("").ReadWrite(stm).DeleteAll()
+			return nil
+		}
+		if err := webhooks.Delete(request.Webhook.Name); err != nil {
+			if col.IsErrNotFound(err) {
+				return fmt.Errorf("webhook %s not found", request.Webhook.Name)
+			}
+			return err
+		}
+		a.deliveries(request.Webhook.Name).ReadWrite(stm).DeleteAll()
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return &types.Empty{}, nil
+}
+
+// ListDelivery implements the protobuf webhook.ListDelivery RPC
+func (a *apiServer) ListDelivery(ctx context.Context, request *webhook.ListDeliveryRequest) (response *webhook.Deliveries, retErr error) {
+	a.LogReq(request)
+	defer func(start time.Time) { a.pachLogger.Log(request, response, retErr, time.Since(start)) }(time.Now())
+
+	if err := a.checkIsAdmin(ctx, "ListDelivery"); err != nil {
+		return nil, err
+	}
+	if request.Webhook == nil {
+		return nil, fmt.Errorf("webhook must be set")
+	}
+	if err := a.webhooks.ReadOnly(ctx).Get(request.Webhook.Name, &webhook.WebhookInfo{}); err != nil {
+		if col.IsErrNotFound(err) {
+			return nil, fmt.Errorf("webhook %s not found", request.Webhook.Name)
+		}
+		return nil, err
+	}
+	response = &webhook.Deliveries{}
+	delivery := &webhook.Delivery{}
+	if err := a.deliveries(request.Webhook.Name).ReadOnly(ctx).List(delivery, col.DefaultOptions, func(string) error {
+		response.Delivery = append(response.Delivery, proto.Clone(delivery).(*webhook.Delivery))
+		if request.Limit > 0 && int64(len(response.Delivery)) >= request.Limit {
+			return errutil.ErrBreak
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
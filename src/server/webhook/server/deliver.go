@@ -0,0 +1,500 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
+	logrus "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/pachyderm/pachyderm/src/client"
+	"github.com/pachyderm/pachyderm/src/client/pfs"
+	"github.com/pachyderm/pachyderm/src/client/pps"
+	"github.com/pachyderm/pachyderm/src/client/webhook"
+	"github.com/pachyderm/pachyderm/src/server/pkg/backoff"
+	col "github.com/pachyderm/pachyderm/src/server/pkg/collection"
+	"github.com/pachyderm/pachyderm/src/server/pkg/dlock"
+	"github.com/pachyderm/pachyderm/src/server/pkg/pfsdb"
+	"github.com/pachyderm/pachyderm/src/server/pkg/ppsdb"
+	"github.com/pachyderm/pachyderm/src/server/pkg/watch"
+)
+
+const (
+	// SignatureHeader is the header that carries the signature of a
+	// delivery: "sha256=" followed by the hex-encoded HMAC-SHA256 of the
+	// request body, keyed with the webhook's secret
+	SignatureHeader = "X-Pachyderm-Signature"
+	// EventHeader is the header that carries the type of a delivery's event
+	EventHeader = "X-Pachyderm-Event"
+	// DeliveryHeader is the header that carries the ID of a delivery
+	DeliveryHeader = "X-Pachyderm-Delivery"
+
+	// maxDeliveries is the number of finished deliveries that are kept for
+	// each webhook
+	maxDeliveries = 100
+)
+
+// permanentError is returned by delivery attempts that won't succeed if
+// they're retried
+type permanentError struct {
+	error
+}
+
+// Sign returns the value of SignatureHeader for a delivery whose body is
+// 'body'.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliveryID returns the ID of the delivery of an event to 'name'. The event
+// is identified by the etcd key and revision of the write that caused it, so
+// every pachd that observes the write computes the same ID.
+func deliveryID(name string, key string, rev int64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d", name, key, rev)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// matches returns true if 'event' passes 'filter'.
+func matches(filter *webhook.Filter, event *webhook.Event) bool {
+	if filter == nil {
+		return true
+	}
+	if len(filter.Events) > 0 {
+		found := false
+		for _, eventType := range filter.Events {
+			if eventType == event.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	// Pipelines' output repos have the same name as the pipeline
+	var repo string
+	switch {
+	case event.Commit != nil:
+		repo = event.Commit.Repo.Name
+	case event.Pipeline != nil:
+		repo = event.Pipeline.Name
+	}
+	if filter.Repo != "" && filter.Repo != repo {
+		return false
+	}
+	if filter.Pipeline != "" && filter.Pipeline != repo {
+		return false
+	}
+	if filter.Branch != "" && event.Type == webhook.EventType_COMMIT_FINISHED {
+		found := false
+		for _, branch := range event.Branches {
+			if branch == filter.Branch {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// watch calls 'f' with each update to the items in 'c', until pachd exits.
+// If the watch fails, it's resumed from the revision of the last update, so
+// that no updates are missed (unless that revision has been compacted).
+func (a *apiServer) watch(name string, c col.Collection, f func(ev *watch.Event) error) {
+	var rev int64
+	backoff.RetryNotify(func() error {
+		var watcher watch.Watcher
+		var err error
+		if rev == 0 {
+			watcher, err = c.ReadOnly(context.Background()).WatchWithPrev()
+		} else {
+			watcher, err = c.ReadOnly(context.Background()).WatchWithPrevFrom(rev)
+		}
+		if err != nil {
+			return err
+		}
+		defer watcher.Close()
+		for {
+			ev, ok := <-watcher.Watch()
+			if !ok {
+				return fmt.Errorf("%s watch closed unexpectedly", name)
+			}
+			switch ev.Type {
+			case watch.EventPut:
+				// Events without a previous value are either new items or the
+				// collection's initial contents, neither of which is a state
+				// transition that webhooks are notified about
+				if ev.PrevValue == nil {
+					continue
+				}
+				// Updates at 'rev' may be seen again if the watch is resumed,
+				// but deliveries are keyed by revision so they're only sent once
+				rev = ev.Rev
+				if err := f(ev); err != nil {
+					logrus.Errorf("error notifying webhooks about %s %s: %v", name, ev.Key, err)
+				}
+			case watch.EventError:
+				if ev.Err == rpctypes.ErrCompacted {
+					logrus.Errorf("webhook %s watch can't resume from compacted revision %d; updates since may be missed", name, rev)
+					rev = 0
+				}
+				return ev.Err
+			}
+		}
+	}, backoff.NewInfiniteBackOff(), func(err error, d time.Duration) error {
+		logrus.Printf("error from webhook %s watch: %v; retrying in %v", name, err, d)
+		return nil
+	})
+}
+
+func (a *apiServer) watchCommits() {
+	// Passing no repo yields a collection containing every repo's commits
+	a.watch("commit", pfsdb.Commits(a.etcdClient, a.pfsEtcdPrefix, ""), func(ev *watch.Event) error {
+		if bytes.Contains(ev.Key, []byte("__index_")) {
+			return nil // secondary index entry, not a commit
+		}
+		var key string
+		commitInfo, prevCommitInfo := &pfs.CommitInfo{}, &pfs.CommitInfo{}
+		if err := ev.Unmarshal(&key, commitInfo); err != nil {
+			return err
+		}
+		if err := ev.UnmarshalPrev(&key, prevCommitInfo); err != nil {
+			return err
+		}
+		if commitInfo.Finished == nil || prevCommitInfo.Finished != nil {
+			return nil
+		}
+		return a.notify("commit/"+key, ev.Rev, func() (*webhook.Event, error) {
+			branches, err := a.branchesAt(commitInfo.Commit)
+			if err != nil {
+				return nil, err
+			}
+			return &webhook.Event{
+				Type:     webhook.EventType_COMMIT_FINISHED,
+				Time:     commitInfo.Finished,
+				Commit:   commitInfo.Commit,
+				Branches: branches,
+			}, nil
+		})
+	})
+}
+
+func (a *apiServer) watchJobs() {
+	a.watch("job", ppsdb.Jobs(a.etcdClient, a.ppsEtcdPrefix), func(ev *watch.Event) error {
+		var key string
+		jobInfo, prevJobInfo := &pps.EtcdJobInfo{}, &pps.EtcdJobInfo{}
+		if err := ev.Unmarshal(&key, jobInfo); err != nil {
+			return err
+		}
+		if err := ev.UnmarshalPrev(&key, prevJobInfo); err != nil {
+			return err
+		}
+		if jobInfo.State != pps.JobState_JOB_FAILURE || prevJobInfo.State == pps.JobState_JOB_FAILURE {
+			return nil
+		}
+		return a.notify("job/"+key, ev.Rev, func() (*webhook.Event, error) {
+			event := &webhook.Event{
+				Type:     webhook.EventType_JOB_FAILURE,
+				Time:     jobInfo.Finished,
+				Commit:   jobInfo.OutputCommit,
+				Job:      jobInfo.Job,
+				Pipeline: jobInfo.Pipeline,
+				Reason:   jobInfo.Reason,
+			}
+			if event.Time == nil {
+				event.Time = types.TimestampNow()
+			}
+			return event, nil
+		})
+	})
+}
+
+func (a *apiServer) watchPipelines() {
+	a.watch("pipeline", ppsdb.Pipelines(a.etcdClient, a.ppsEtcdPrefix), func(ev *watch.Event) error {
+		var key string
+		pipelineInfo, prevPipelineInfo := &pps.EtcdPipelineInfo{}, &pps.EtcdPipelineInfo{}
+		if err := ev.Unmarshal(&key, pipelineInfo); err != nil {
+			return err
+		}
+		if err := ev.UnmarshalPrev(&key, prevPipelineInfo); err != nil {
+			return err
+		}
+		if pipelineInfo.State != pps.PipelineState_PIPELINE_FAILURE || prevPipelineInfo.State == pps.PipelineState_PIPELINE_FAILURE {
+			return nil
+		}
+		return a.notify("pipeline/"+key, ev.Rev, func() (*webhook.Event, error) {
+			return &webhook.Event{
+				Type:     webhook.EventType_PIPELINE_FAILURE,
+				Time:     types.TimestampNow(),
+				Pipeline: client.NewPipeline(key),
+				Reason:   pipelineInfo.Reason,
+			}, nil
+		})
+	})
+}
+
+// branchesAt returns the names of the branches whose head is 'commit'.
+func (a *apiServer) branchesAt(commit *pfs.Commit) ([]string, error) {
+	var result []string
+	branchInfo := &pfs.BranchInfo{}
+	branches := pfsdb.Branches(a.etcdClient, a.pfsEtcdPrefix, commit.Repo.Name)
+	if err := branches.ReadOnly(context.Background()).List(branchInfo, col.DefaultOptions, func(branch string) error {
+		if branchInfo.Head != nil && branchInfo.Head.ID == commit.ID {
+			result = append(result, branch)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// notify records a delivery of the event returned by 'makeEvent' for each
+// webhook that it matches, and starts delivering them. 'key' and 'rev'
+// identify the write that caused the event. Every pachd observes the write,
+// but only the one that records a delivery first sends it.
+func (a *apiServer) notify(key string, rev int64, makeEvent func() (*webhook.Event, error)) error {
+	ctx := context.Background()
+	webhookInfos, err := a.listWebhook(ctx)
+	if err != nil {
+		return err
+	}
+	if len(webhookInfos) == 0 {
+		return nil
+	}
+	event, err := makeEvent()
+	if err != nil {
+		return err
+	}
+	for _, webhookInfo := range webhookInfos {
+		if !matches(webhookInfo.Filter, event) {
+			continue
+		}
+		name := webhookInfo.Webhook.Name
+		delivery := &webhook.Delivery{
+			ID:      deliveryID(name, key, rev),
+			Webhook: webhookInfo.Webhook,
+			Event:   proto.Clone(event).(*webhook.Event),
+			Created: types.TimestampNow(),
+		}
+		delivery.Event.ID = delivery.ID
+		delivery.Event.Webhook = name
+		delivery.Updated = delivery.Created
+		if _, err := col.NewSTM(ctx, a.etcdClient, func(stm col.STM) error {
+			return a.deliveries(name).ReadWrite(stm).Create(delivery.ID, delivery)
+		}); err != nil {
+			if col.IsErrExists(err) {
+				continue // another pachd is sending this delivery
+			}
+			return err
+		}
+		go a.deliver(webhookInfo, delivery)
+	}
+	return nil
+}
+
+// resumeDeliveries resumes the pending deliveries of every webhook, which
+// were left behind by pachds that exited while sending them.
+func (a *apiServer) resumeDeliveries() {
+	var pending []*webhook.Delivery
+	backoff.RetryNotify(func() error {
+		pending = nil
+		delivery := &webhook.Delivery{}
+		return a.deliveries("").ReadOnly(context.Background()).List(delivery, col.DefaultOptions, func(string) error {
+			if delivery.State == webhook.DeliveryState_DELIVERY_PENDING {
+				pending = append(pending, proto.Clone(delivery).(*webhook.Delivery))
+			}
+			return nil
+		})
+	}, backoff.NewInfiniteBackOff(), func(err error, d time.Duration) error {
+		logrus.Printf("error listing pending webhook deliveries: %v; retrying in %v", err, d)
+		return nil
+	})
+	for _, delivery := range pending {
+		webhookInfo := &webhook.WebhookInfo{}
+		if err := a.webhooks.ReadOnly(context.Background()).Get(delivery.Webhook.Name, webhookInfo); err != nil {
+			if !col.IsErrNotFound(err) {
+				logrus.Errorf("error resuming delivery %s to webhook %s: %v", delivery.ID, delivery.Webhook.Name, err)
+			}
+			continue
+		}
+		go a.deliver(webhookInfo, delivery)
+	}
+}
+
+// deliver sends 'delivery' to its webhook, retrying with exponential backoff
+// until it succeeds, fails permanently or times out, and records the outcome
+// of each attempt. Each delivery is sent by one pachd at a time: if another
+// pachd is already sending it (e.g. because this one is resuming it), deliver
+// waits for that pachd to finish or exit.
+func (a *apiServer) deliver(webhookInfo *webhook.WebhookInfo, delivery *webhook.Delivery) {
+	name := webhookInfo.Webhook.Name
+	lock := dlock.NewDLock(a.etcdClient, path.Join(a.etcdPrefix, deliveryLocksPrefix, delivery.ID))
+	ctx, err := lock.Lock(context.Background())
+	if err != nil {
+		logrus.Errorf("error locking delivery %s to webhook %s: %v", delivery.ID, name, err)
+		return
+	}
+	defer func() {
+		if err := lock.Unlock(context.Background()); err != nil {
+			logrus.Errorf("error unlocking delivery %s to webhook %s: %v", delivery.ID, name, err)
+		}
+	}()
+	// Pick up where the last pachd to hold the lock left off
+	if err := a.deliveries(name).ReadOnly(ctx).Get(delivery.ID, delivery); err != nil {
+		if !col.IsErrNotFound(err) {
+			logrus.Errorf("error reading delivery %s to webhook %s: %v", delivery.ID, name, err)
+		}
+		return
+	}
+	if delivery.State != webhook.DeliveryState_DELIVERY_PENDING {
+		return
+	}
+
+	body, err := (&jsonpb.Marshaler{}).MarshalToString(delivery.Event)
+	if err == nil {
+		err = backoff.RetryNotify(func() error {
+			if err := ctx.Err(); err != nil {
+				// The lock was lost, so another pachd may resume the delivery
+				return permanentError{err}
+			}
+			statusCode, err := post(ctx, a.httpClient, webhookInfo, delivery, []byte(body))
+			delivery.Attempts++
+			delivery.StatusCode = int32(statusCode)
+			delivery.Error = ""
+			if err != nil {
+				delivery.Error = err.Error()
+			}
+			if err := a.updateDelivery(delivery); err != nil {
+				if col.IsErrNotFound(err) {
+					// The webhook was deleted
+					return permanentError{err}
+				}
+				logrus.Errorf("error recording attempt to deliver %s to webhook %s: %v", delivery.ID, name, err)
+			}
+			return err
+		}, backoff.NewExponentialBackOff(), func(err error, d time.Duration) error {
+			if _, ok := err.(permanentError); ok {
+				return err
+			}
+			logrus.Printf("error delivering %s to webhook %s: %v; retrying in %v", delivery.ID, name, err, d)
+			return nil
+		})
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		delivery.State = webhook.DeliveryState_DELIVERY_FAILURE
+		delivery.Error = err.Error()
+	} else {
+		delivery.State = webhook.DeliveryState_DELIVERY_SUCCESS
+	}
+	if err := a.updateDelivery(delivery); err != nil {
+		if !col.IsErrNotFound(err) {
+			logrus.Errorf("error recording outcome of delivery %s to webhook %s: %v", delivery.ID, name, err)
+		}
+		return
+	}
+	if err := a.pruneDeliveries(name); err != nil {
+		logrus.Errorf("error pruning deliveries of webhook %s: %v", name, err)
+	}
+}
+
+// post makes one attempt to send 'delivery' to its webhook. It returns the
+// HTTP status code of the response, if there is one.
+func post(ctx context.Context, httpClient *http.Client, webhookInfo *webhook.WebhookInfo, delivery *webhook.Delivery, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", webhookInfo.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, permanentError{err}
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event.Type.String())
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(webhookInfo.Secret, body))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, nil
+	}
+	err = fmt.Errorf("endpoint responded with %s", resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		// Retrying won't fix other client errors
+		return resp.StatusCode, permanentError{err}
+	}
+	return resp.StatusCode, err
+}
+
+// updateDelivery writes 'delivery' back to etcd. It returns a 'not found'
+// error if the delivery (i.e. its webhook) has been deleted.
+func (a *apiServer) updateDelivery(delivery *webhook.Delivery) error {
+	delivery.Updated = types.TimestampNow()
+	_, err := col.NewSTM(context.Background(), a.etcdClient, func(stm col.STM) error {
+		deliveries := a.deliveries(delivery.Webhook.Name).ReadWrite(stm)
+		if err := deliveries.Get(delivery.ID, &webhook.Delivery{}); err != nil {
+			return err
+		}
+		return deliveries.Put(delivery.ID, delivery)
+	})
+	return err
+}
+
+// pruneDeliveries deletes all but the 'maxDeliveries' most recent finished
+// deliveries of the webhook 'name'.
+func (a *apiServer) pruneDeliveries(name string) error {
+	deliveries := a.deliveries(name)
+	var stale []string
+	var finished int
+	delivery := &webhook.Delivery{}
+	if err := deliveries.ReadOnly(context.Background()).List(delivery, col.DefaultOptions, func(id string) error {
+		if delivery.State == webhook.DeliveryState_DELIVERY_PENDING {
+			return nil
+		}
+		finished++
+		if finished > maxDeliveries {
+			stale = append(stale, id)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	_, err := col.NewSTM(context.Background(), a.etcdClient, func(stm col.STM) error {
+		deliveries := deliveries.ReadWrite(stm)
+		for _, id := range stale {
+			if err := deliveries.Delete(id); err != nil && !col.IsErrNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/pachyderm/pachyderm/src/client"
+	"github.com/pachyderm/pachyderm/src/client/pkg/require"
+	"github.com/pachyderm/pachyderm/src/client/webhook"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"type":"JOB_FAILURE"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	require.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), Sign("secret", body))
+	require.NotEqual(t, Sign("secret", body), Sign("other", body))
+}
+
+func TestDeliveryID(t *testing.T) {
+	require.Equal(t, deliveryID("hook", "commit/repo/1", 5), deliveryID("hook", "commit/repo/1", 5))
+	require.NotEqual(t, deliveryID("hook", "commit/repo/1", 5), deliveryID("hook", "commit/repo/1", 6))
+	require.NotEqual(t, deliveryID("hook", "commit/repo/1", 5), deliveryID("hook2", "commit/repo/1", 5))
+}
+
+func TestMatches(t *testing.T) {
+	commitEvent := &webhook.Event{
+		Type:     webhook.EventType_COMMIT_FINISHED,
+		Commit:   client.NewCommit("repo", "1"),
+		Branches: []string{"master", "staging"},
+	}
+	jobEvent := &webhook.Event{
+		Type:     webhook.EventType_JOB_FAILURE,
+		Commit:   client.NewCommit("pipeline", "2"),
+		Job:      client.NewJob("3"),
+		Pipeline: client.NewPipeline("pipeline"),
+	}
+	pipelineEvent := &webhook.Event{
+		Type:     webhook.EventType_PIPELINE_FAILURE,
+		Pipeline: client.NewPipeline("pipeline"),
+	}
+	events := []*webhook.Event{commitEvent, jobEvent, pipelineEvent}
+	for _, test := range []struct {
+		filter   *webhook.Filter
+		expected []bool
+	}{
+		{nil, []bool{true, true, true}},
+		{&webhook.Filter{}, []bool{true, true, true}},
+		{&webhook.Filter{Events: []webhook.EventType{webhook.EventType_JOB_FAILURE, webhook.EventType_PIPELINE_FAILURE}}, []bool{false, true, true}},
+		{&webhook.Filter{Repo: "repo"}, []bool{true, false, false}},
+		{&webhook.Filter{Repo: "pipeline"}, []bool{false, true, true}},
+		{&webhook.Filter{Pipeline: "pipeline"}, []bool{false, true, true}},
+		{&webhook.Filter{Pipeline: "other"}, []bool{false, false, false}},
+		{&webhook.Filter{Branch: "staging"}, []bool{true, true, true}},
+		{&webhook.Filter{Branch: "dev"}, []bool{false, true, true}},
+		{&webhook.Filter{Repo: "repo", Branch: "master", Events: []webhook.EventType{webhook.EventType_COMMIT_FINISHED}}, []bool{true, false, false}},
+	} {
+		for i, event := range events {
+			require.Equal(t, test.expected[i], matches(test.filter, event), "filter: %v, event: %v", test.filter, event)
+		}
+	}
+}
+
+func TestPost(t *testing.T) {
+	var status int
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		header = r.Header
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	webhookInfo := &webhook.WebhookInfo{
+		Webhook: &webhook.Webhook{Name: "hook"},
+		URL:     server.URL,
+		Secret:  "secret",
+	}
+	delivery := &webhook.Delivery{
+		ID:    "1",
+		Event: &webhook.Event{Type: webhook.EventType_PIPELINE_FAILURE},
+	}
+
+	status = http.StatusOK
+	statusCode, err := post(context.Background(), server.Client(), webhookInfo, delivery, []byte("body"))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, statusCode)
+	require.Equal(t, "body", string(body))
+	require.Equal(t, Sign("secret", body), header.Get(SignatureHeader))
+	require.Equal(t, "PIPELINE_FAILURE", header.Get(EventHeader))
+	require.Equal(t, "1", header.Get(DeliveryHeader))
+
+	// Server errors and rate limiting are retried, other client errors aren't
+	status = http.StatusServiceUnavailable
+	statusCode, err = post(context.Background(), server.Client(), webhookInfo, delivery, []byte("body"))
+	require.YesError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, statusCode)
+	_, ok := err.(permanentError)
+	require.False(t, ok)
+
+	status = http.StatusTooManyRequests
+	_, err = post(context.Background(), server.Client(), webhookInfo, delivery, []byte("body"))
+	_, ok = err.(permanentError)
+	require.False(t, ok)
+
+	status = http.StatusNotFound
+	_, err = post(context.Background(), server.Client(), webhookInfo, delivery, []byte("body"))
+	_, ok = err.(permanentError)
+	require.True(t, ok)
+}
@@ -3,6 +3,7 @@ package worker
 import (
 	"bufio"
 	"bytes"
+	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -66,7 +67,8 @@ const (
 
 var (
 	errSpecialFile = errors.New("cannot upload special file")
-	statsTagSuffix = "_stats"
+	// StatsTagSuffix is appended to a datum's tag to get the tag of its stats
+	StatsTagSuffix = "_stats"
 )
 
 // APIServer implements the worker API
@@ -645,6 +647,27 @@ func (a *APIServer) reportUploadStats(start time.Time, stats *pps.ProcessStats,
 	}
 }
 
+// outputSymlinkTarget returns the PFS target of a symlink at 'relPath' in the
+// output directory 'outputPath' that points to 'realPath', and whether
+// 'realPath' is inside the output directory at all. Absolute targets become
+// relative to the root of the output commit.
+func outputSymlinkTarget(outputPath string, relPath string, realPath string) (string, bool) {
+	if !filepath.IsAbs(realPath) {
+		abs := filepath.Join(outputPath, filepath.Dir(relPath), realPath)
+		if abs != outputPath && !strings.HasPrefix(abs, outputPath+string(os.PathSeparator)) {
+			return "", false
+		}
+		return realPath, true
+	}
+	for _, root := range []string{outputPath, filepath.Join(client.PPSInputPrefix, "out")} {
+		rel, err := filepath.Rel(root, realPath)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			return filepath.Join("/", rel), true
+		}
+	}
+	return "", false
+}
+
 func (a *APIServer) uploadOutput(pachClient *client.APIClient, dir string, tag string, logger *taggedLogger, inputs []*Input, stats *pps.ProcessStats, statsTree *hashtree.Ordered) (retErr error) {
 	defer a.reportUploadStats(time.Now(), stats, logger)
 	logger.Logf("starting to upload output")
@@ -710,6 +733,15 @@ func (a *APIServer) uploadOutput(pachClient *client.APIClient, dir string, tag s
 			if err != nil {
 				return err
 			}
+			// Symlinks within the output directory are preserved as
+			// symlinks in the output commit
+			if target, ok := outputSymlinkTarget(outputPath, relPath, realPath); ok {
+				tree.PutSymlink(relPath, target)
+				if statsTree != nil {
+					statsTree.PutSymlink(relPath, target)
+				}
+				return nil
+			}
 			if strings.HasPrefix(realPath, client.PPSInputPrefix) {
 				var pathWithInput string
 				var err error
@@ -769,7 +801,12 @@ func (a *APIServer) uploadOutput(pachClient *client.APIClient, dir string, tag s
 								blockRefs = append(blockRefs, objectInfo.BlockRef)
 							}
 							blockRefs = append(blockRefs, fileInfo.BlockRefs...)
-							n := &hashtree.FileNodeProto{BlockRefs: blockRefs}
+							n := &hashtree.FileNodeProto{
+								BlockRefs: blockRefs,
+								Metadata:  fileInfo.Metadata,
+								Sha256:    fileInfo.Sha256,
+								Md5:       fileInfo.Md5,
+							}
 							tree.PutFile(subRelPath, fileInfo.Hash, int64(fileInfo.SizeBytes), n)
 							if statsTree != nil {
 								statsTree.PutFile(subRelPath, fileInfo.Hash, int64(fileInfo.SizeBytes), n)
@@ -792,7 +829,9 @@ func (a *APIServer) uploadOutput(pachClient *client.APIClient, dir string, tag s
 		}()
 		var size int64
 		h := pfs.NewHash()
-		r := io.TeeReader(f, h)
+		sha256Hash := sha256.New()
+		md5Hash := md5.New()
+		r := io.TeeReader(f, io.MultiWriter(h, sha256Hash, md5Hash))
 		// Write local file to object storage block
 		for {
 			n, err := r.Read(buf)
@@ -819,6 +858,8 @@ func (a *APIServer) uploadOutput(pachClient *client.APIClient, dir string, tag s
 					},
 				},
 			},
+			Sha256: sha256Hash.Sum(nil),
+			Md5:    md5Hash.Sum(nil),
 		}
 		hash := h.Sum(nil)
 		tree.PutFile(relPath, hash, size, n)
@@ -843,6 +884,11 @@ func (a *APIServer) uploadOutput(pachClient *client.APIClient, dir string, tag s
 			retErr = err
 		}
 	}()
+	committed, err := types.TimestampProto(time.Now())
+	if err != nil {
+		return err
+	}
+	tree.SetCommitted(committed)
 	if err := tree.Serialize(w); err != nil {
 		return err
 	}
@@ -1185,7 +1231,7 @@ func (a *APIServer) mergeDatums(ctx context.Context, pachClient *client.APIClien
 				if a.pipelineInfo.EnableStats {
 					var statsTags []*pfs.Tag
 					for _, tag := range tags {
-						statsTags = append(statsTags, client.NewTag(tag.Name+statsTagSuffix))
+						statsTags = append(statsTags, client.NewTag(tag.Name+StatsTagSuffix))
 					}
 					rs, err := a.getHashtrees(ctx, pachClient, objClient, statsTags, hashtree.NewFilter(plan.Merges, merge))
 					if err != nil {
@@ -1875,7 +1921,7 @@ func (a *APIServer) writeStats(pachClient *client.APIClient, objClient obj.Clien
 	outputTree.Serialize(outputBuf)
 	statsBuf := &bytes.Buffer{}
 	statsTree.Ordered().Serialize(statsBuf)
-	objW, err := pachClient.PutObjectAsync([]*pfs.Tag{client.NewTag(tag + statsTagSuffix)})
+	objW, err := pachClient.PutObjectAsync([]*pfs.Tag{client.NewTag(tag + StatsTagSuffix)})
 	if err != nil {
 		return err
 	}
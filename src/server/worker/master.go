@@ -16,6 +16,7 @@ import (
 
 	"github.com/pachyderm/pachyderm/src/client"
 	"github.com/pachyderm/pachyderm/src/client/pfs"
+	"github.com/pachyderm/pachyderm/src/client/pkg/grpcutil"
 	"github.com/pachyderm/pachyderm/src/client/pkg/pbutil"
 	"github.com/pachyderm/pachyderm/src/client/pps"
 	pfsserver "github.com/pachyderm/pachyderm/src/server/pfs"
@@ -658,12 +659,36 @@ func (a *APIServer) waitJob(pachClient *client.APIClient, jobInfo *pps.JobInfo,
 				}
 			}
 		}
+		// failQuota fails the job if its output doesn't fit in the output repo's
+		// quota, as retrying wouldn't help
+		failQuota := func(quotaErr error) error {
+			reason := fmt.Sprintf("job output is too large: %v", grpcutil.ScrubGRPC(quotaErr))
+			if err := a.updateJobState(ctx, jobInfo, statsCommit, pps.JobState_JOB_FAILURE, reason); err != nil {
+				return err
+			}
+			if jobInfo.EnableStats {
+				if _, err := pachClient.PfsAPIClient.FinishCommit(ctx, &pfs.FinishCommitRequest{
+					Commit: statsCommit,
+					Empty:  true,
+				}); err != nil && !pfsserver.IsCommitFinishedErr(err) {
+					return err
+				}
+			}
+			_, err := pachClient.PfsAPIClient.FinishCommit(ctx, &pfs.FinishCommitRequest{
+				Commit: jobInfo.OutputCommit,
+				Empty:  true,
+			})
+			return err
+		}
 		if jobInfo.EnableStats {
 			if _, err = pachClient.PfsAPIClient.FinishCommit(ctx, &pfs.FinishCommitRequest{
 				Commit:    statsCommit,
 				Trees:     statsTrees,
 				SizeBytes: statsSize,
 			}); err != nil {
+				if pfsserver.IsRepoQuotaExceededErr(err) {
+					return failQuota(err)
+				}
 				return err
 			}
 		}
@@ -702,6 +727,9 @@ func (a *APIServer) waitJob(pachClient *client.APIClient, jobInfo *pps.JobInfo,
 			SizeBytes: size,
 			Datums:    datums,
 		})
+		if pfsserver.IsRepoQuotaExceededErr(err) {
+			return failQuota(err)
+		}
 		if err != nil && !pfsserver.IsCommitFinishedErr(err) {
 			if pfsserver.IsCommitNotFoundErr(err) || pfsserver.IsCommitDeletedErr(err) {
 				// output commit was deleted during e.g. FinishCommit, which means this job